@@ -4,31 +4,82 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
-	"strconv"
 	"time"
 
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/redis/go-redis/v9"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	auditGateway "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway/audit"
 	authGateway "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway/auth"
 	authorGateway "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway/author"
 	bookGateway "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway/book"
+	oauthGateway "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway/oauth"
+	apiMiddleware "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	auditHandler "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/audit"
 	authHandler "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/auth"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/author"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/book"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/consent"
+	healthHandler "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/health"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/oidc"
+	bookV2 "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/v2/book"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/version"
 	_ "github.com/will-kerwin/go-microservice-bookstore/docs" // Import the docs
+	v1Docs "github.com/will-kerwin/go-microservice-bookstore/docs/v1"
+	v2Docs "github.com/will-kerwin/go-microservice-bookstore/docs/v2"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/config"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/lifecycle"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/mtls"
 )
 
 const serviceName = "api"
 
+// Config of the api, see pkg/config for where it is loaded from
+type Config struct {
+	Port                 int           `config:"PORT" required:"true"`
+	IdentityAssertionKey string        `config:"IDENTITY_ASSERTION_KEY" required:"true" secret:"true"`
+	IdentityAssertionTtl time.Duration `config:"IDENTITY_ASSERTION_TTL" default:"5m"`
+	// key the access tokens the api issues are signed with
+	JwtSecret string `config:"JWT_SECRET" required:"true" secret:"true"`
+	// Base url of the public api, used in links and as the oidc issuer
+	PublicUrl           string        `config:"PUBLIC_URL"`
+	AccessTokenTtl      time.Duration `config:"ACCESS_TOKEN_TTL" default:"15m"`
+	OAuthAccessTokenTtl time.Duration `config:"OAUTH_ACCESS_TOKEN_TTL" default:"1h"`
+	// where the registry, kafka and redis are
+	Discovery discovery.Config
+	Kafka     infra.KafkaConfig
+	Redis     infra.RedisConfig
+	// how long in-flight requests get to finish when the api stops
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT" default:"25s"`
+	// ip ranges of the proxies in front of the api, eg. 10.0.0.0/8. X-Forwarded-For is only
+	// trusted when the request comes from one of them
+	TrustedProxies []string `config:"TRUSTED_PROXIES"`
+	// the version, zone and tags of the instance, used by callers to choose between instances
+	Metadata discovery.Metadata
+	// tls for the calls to the backends, see pkg/mtls
+	Tls mtls.Config
+	// split traffic between versions of the backends during rollouts, eg. ROUTE_BOOKS=version=1:90;version=2:10
+	RouteAuth  discovery.Route `config:"ROUTE_AUTH"`
+	RouteBooks discovery.Route `config:"ROUTE_BOOKS"`
+}
+
+// The docs of the versioned api are in swagger_v1.go and swagger_v2.go, these cover the
+// endpoints outside of the versions: oauth, oidc and health.
+
 // @title Go Microservice Bookstore API
 // @version 1.0
-// @description This is the api for the go bookstore microservices project
+// @description This is the api for the go bookstore microservices project, the endpoints that are not versioned. See /swagger/v1/index.html and /swagger/v2/index.html for the rest.
 // @termsOfService http://swagger.io/terms/
 
 // @contact.name Will Kerwin
@@ -42,81 +93,256 @@ const serviceName = "api"
 // @BasePath /
 // @schemes http
 func main() {
+	var serviceConfig Config
 
-	port, err := strconv.Atoi(os.Getenv("PORT"))
-	if err != nil {
+	if err := config.New(config.Sources(os.Args[1:])...).Load(context.Background(), &serviceConfig); err != nil {
 		panic(err)
 	}
 
-	// register with consul
-	registryUri := os.Getenv("CONSUL_URI")
-	kafkaUri := os.Getenv("KAFKA_URI")
-	redisUri := os.Getenv("REDIS_URI")
-	regisrty, err := discovery.NewRegistry(registryUri)
+	// stops the api on SIGTERM, ctx is cancelled as it starts stopping
+	lc := lifecycle.New(serviceConfig.ShutdownTimeout)
+	ctx := lc.Context()
+
+	port := serviceConfig.Port
+
+	log.Printf("Loaded config:\n%s\n", config.Dump(&serviceConfig))
+
+	// register with the service registry
+	regisrty, err := discovery.NewRegistry(serviceConfig.Discovery)
 
 	if err != nil {
 		panic(err)
 	}
 
-	ctx := context.Background()
+	// the gateways look up a service on every call, a watched cache keeps that off the registry
+	regisrty = discovery.NewCache(ctx, regisrty)
+
 	instanceID := discovery.GenerateInstanceID(serviceName)
 
-	if err := regisrty.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port)); err != nil {
+	if err := regisrty.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port), serviceConfig.Metadata); err != nil {
 		panic(err)
 	}
 
-	// Setup health check
-	go func() {
-		for {
-			if err := regisrty.HealthCheck(instanceID, serviceName); err != nil {
-				log.Println("Failed to report healthy state: " + err.Error())
-			}
-			time.Sleep(1 * time.Second)
-		}
-	}()
+	// leave discovery first when stopping so no new requests are sent
+	lc.OnStop(lifecycle.Deregister, "discovery", func(ctx context.Context) error {
+		return regisrty.Deregister(ctx, instanceID, serviceName)
+	})
+
+	// kafka and redis are found through the registry or at fixed addresses, see pkg/infra
+	kafkaBrokers, err := infra.Kafka(ctx, regisrty, serviceConfig.Kafka)
+
+	if err != nil {
+		panic(err)
+	}
+
+	redisOptions, err := infra.Redis(ctx, regisrty, serviceConfig.Redis)
+
+	if err != nil {
+		panic(err)
+	}
 
-	// deregister on close
-	defer regisrty.Deregister(ctx, instanceID, serviceName)
+	redisClient := redis.NewClient(redisOptions)
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     redisUri,
-		Password: "",
-		DB:       0,
+	lc.OnStop(lifecycle.CloseClients, "redis", func(context.Context) error {
+		return redisClient.Close()
 	})
 
-	defer redisClient.Close()
+	auditPublisher, err := audit.NewPublisher(kafkaBrokers, serviceName)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// sends the entries still buffered once the server has finished its requests
+	lc.OnStop(lifecycle.CloseClients, "audit publisher", auditPublisher.Close)
 
 	log.Printf("Starting the %s service at port: %d\n", serviceName, port)
 
 	// setup router
 	router := echo.New()
+	// errors returned by handlers and middleware are answered with problem details
+	router.HTTPErrorHandler = problem.Handler
+
+	// the client address is used for lockouts, rate limits and audit entries, so it is only taken
+	// from headers set by a trusted proxy
+	router.IPExtractor, err = ipExtractor(serviceConfig.TrustedProxies)
+
+	if err != nil {
+		panic(err)
+	}
+
+	credentials, err := mtls.New(serviceName, serviceConfig.Tls)
+
+	if err != nil {
+		panic(err)
+	}
+
+	go credentials.Watch(ctx)
+	grpcutil.UseCredentials(credentials)
+
+	grpcutil.UseRouting(serviceConfig.Metadata.Zone, map[string]discovery.Route{
+		"auth":  serviceConfig.RouteAuth,
+		"books": serviceConfig.RouteBooks,
+	})
+
+	identitySigner, err := identity.NewSigner([]byte(serviceConfig.IdentityAssertionKey), serviceName, serviceConfig.IdentityAssertionTtl)
+
+	if err != nil {
+		panic(err)
+	}
+
+	jwtSigner, err := auth.NewJwtSigner([]byte(serviceConfig.JwtSecret))
+
+	if err != nil {
+		panic(err)
+	}
 
 	// setup grpc gateways
-	authorGateway := authorGateway.New(*regisrty)
-	bookGateway := bookGateway.New(*regisrty)
-	authGateway := authGateway.New(*regisrty)
+	authorGateway := authorGateway.New(regisrty)
+	bookGateway := bookGateway.New(regisrty)
+	authGateway := authGateway.New(regisrty)
+	oauthGateway := oauthGateway.New(regisrty)
+	auditGateway := auditGateway.New(regisrty)
+
+	// the api needs redis to check for revoked sessions, it can still answer some requests
+	// without one of the backends
+	checks := health.New(health.DefaultTimeout)
+	// not ready until the router is serving
+	checks.Register("lifecycle", lc)
+	checks.Register("redis", health.Redis(redisClient))
+	checks.RegisterOptional("grpc:auth", grpcutil.HealthChecker("auth", regisrty))
+	checks.RegisterOptional("grpc:books", grpcutil.HealthChecker("books", regisrty))
+
+	// report the health of the service to discovery, the checks keep running while the api
+	// drains so the health endpoints show it is shutting down
+	lc.Go(lifecycle.StopServing, "health checks", func(checksCtx context.Context) {
+		checks.Run(checksCtx, time.Second, func(report health.Report) {
+			// the instance is deregistered once the api starts stopping
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := regisrty.HealthCheck(instanceID, serviceName, report.Status, report.Output()); err != nil {
+				log.Println("Failed to report health: " + err.Error())
+			}
+		})
+	})
 
 	// setup handlers
-	authorHandler := author.New(authorGateway, redisClient, kafkaUri)
-	bookHandler := book.New(bookGateway, redisClient, kafkaUri)
-	authHandler := authHandler.New(authGateway, redisClient, kafkaUri)
+	authorHandler := author.New(authorGateway, redisClient, kafkaBrokers)
+	bookHandler := book.New(bookGateway, redisClient, kafkaBrokers)
+	bookV2Handler := bookV2.New(bookGateway, authorGateway, bookHandler)
+	authHandler := authHandler.New(authGateway, oauthGateway, redisClient, kafkaBrokers, serviceConfig.PublicUrl, serviceConfig.AccessTokenTtl, jwtSigner)
+	auditHandler := auditHandler.New(auditGateway)
+	healthHandler := healthHandler.New(checks)
+	consentHandler := consent.New(oauthGateway)
+	oidcHandler := oidc.New(oauthGateway, authGateway, serviceConfig.PublicUrl, serviceConfig.OAuthAccessTokenTtl, jwtSigner)
 
 	// init handlers
-	authHandler.Register(router)
 	router.GET("/swagger/*", echoSwagger.WrapHandler)
+	router.GET("/swagger/v1/*", echoSwagger.EchoWrapHandler(echoSwagger.InstanceName(v1Docs.SwaggerInfov1.InstanceName())))
+	router.GET("/swagger/v2/*", echoSwagger.EchoWrapHandler(echoSwagger.InstanceName(v2Docs.SwaggerInfov2.InstanceName())))
+	healthHandler.Register(router)
 
-	authRouter := router.Group("")
-	authRouter.Use(echojwt.WithConfig(auth.JwtConfig))
+	authMiddleware := []echo.MiddlewareFunc{
+		apiMiddleware.AuditDenied(auditPublisher),
+		auth.Middleware(authGateway, jwtSigner),
+		apiMiddleware.RejectRevokedSessions(redisClient),
+		apiMiddleware.RejectDisabledUsers(redisClient),
+		apiMiddleware.LogImpersonation,
+		apiMiddleware.RequireMfaEnrollment,
+		apiMiddleware.RestrictDelegatedTokens,
+		apiMiddleware.ForwardIdentity(identitySigner),
+	}
+
+	// the oauth and oidc provider endpoints keep their standard paths outside of the versions
+	oidcHandler.Register(router, router.Group("", authMiddleware...))
 
-	authorHandler.Register(authRouter)
-	bookHandler.Register(authRouter)
+	// each version of the api is a set of groups under its prefix, versionMiddleware runs first
+	// so even rejected requests get the deprecation headers
+	newVersion := func(prefix string, versionMiddleware ...echo.MiddlewareFunc) (public *echo.Group, protected *echo.Group, catalogue *echo.Group) {
+		public = router.Group(prefix, versionMiddleware...)
+		protected = public.Group("", authMiddleware...)
+		catalogue = protected.Group("", apiMiddleware.AuditMutations(auditPublisher))
+
+		return public, protected, catalogue
+	}
+
+	// v1 is frozen as the api was before it was versioned. It is also served at the unversioned
+	// paths, which are deprecated aliases of /v1.
+	v1Versions := []struct {
+		prefix     string
+		middleware echo.MiddlewareFunc
+	}{
+		{prefix: "", middleware: version.Deprecate(version.Unversioned)},
+		{prefix: version.V1, middleware: version.V1Routes.Middleware()},
+	}
+
+	for _, v1 := range v1Versions {
+		public, protected, catalogue := newVersion(v1.prefix, v1.middleware)
+
+		authHandler.Register(public, protected)
+		consentHandler.Register(protected)
+		auditHandler.Register(protected)
+		authorHandler.Register(catalogue)
+		bookHandler.Register(catalogue)
+	}
+
+	// v2 is where the models evolve, routes that have not changed are the same as in v1. Its
+	// errors are problem details.
+	public, protected, catalogue := newVersion(version.V2, problem.Details)
+
+	authHandler.Register(public, protected)
+	consentHandler.Register(protected)
+	auditHandler.Register(protected)
+	authorHandler.Register(catalogue)
+	bookV2Handler.Register(catalogue)
 
 	// middleware
 
+	// errors keep the body they always had outside of /v2
+	router.Use(problem.Legacy)
 	router.Use(middleware.Logger())
 	router.Use(middleware.Recover())
 	router.Use(middleware.CORS())
+	router.Use(middleware.RequestID())
+	router.Use(apiMiddleware.ForwardRequestInfo)
+
+	lc.Serve("http server", func() error {
+		return router.Start(fmt.Sprintf(":%d", port))
+	}, router.Shutdown)
+	lc.MarkReady()
 
-	router.Logger.Fatal(router.Start(fmt.Sprintf(":%d", port)))
+	if err := lc.Wait(); err != nil {
+		log.Fatalf("Stopped the %s service: %s\n", serviceName, err)
+	}
+
+	log.Printf("Stopped the %s service\n", serviceName)
+}
+
+// ipExtractor returns the address the request came from, or the address in X-Forwarded-For
+// when it came through one of the proxies
+func ipExtractor(proxies []string) (echo.IPExtractor, error) {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	// only the proxies listed are trusted, not loopback or private addresses
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	for _, proxy := range proxies {
+		_, ipRange, err := net.ParseCIDR(proxy)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
 
+	return echo.ExtractIPFromXFFHeader(options...), nil
 }
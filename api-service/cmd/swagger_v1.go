@@ -0,0 +1,17 @@
+package main
+
+// @title Go Microservice Bookstore API v1
+// @version 1.0
+// @description Version 1 of the api, frozen as it was before the api was versioned. It is also served without the /v1 prefix until the date in the Sunset header. Errors are answered with {"error": "<message>"}, the problem details of /v2 are not used.
+// @termsOfService http://swagger.io/terms/
+
+// @contact.name Will Kerwin
+// @contact.url http://www.swagger.io/support
+// @contact.email support@swagger.io
+
+// @license.name Apache 2.0
+// @license.url http://www.apache.org/licenses/LICENSE-2.0.html
+
+// @host api-service:8080
+// @BasePath /v1
+// @schemes http
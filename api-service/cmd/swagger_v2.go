@@ -0,0 +1,17 @@
+package main
+
+// @title Go Microservice Bookstore API v2
+// @version 2.0
+// @description Version 2 of the api, where books embed their author.
+// @termsOfService http://swagger.io/terms/
+
+// @contact.name Will Kerwin
+// @contact.url http://www.swagger.io/support
+// @contact.email support@swagger.io
+
+// @license.name Apache 2.0
+// @license.url http://www.apache.org/licenses/LICENSE-2.0.html
+
+// @host api-service:8080
+// @BasePath /v2
+// @schemes http
@@ -1,20 +1,111 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var JwtConfig echojwt.Config = echojwt.Config{
-	NewClaimsFunc: func(c echo.Context) jwt.Claims {
-		return new(models.JwtCustomClaims)
-	},
-	SigningKey: []byte("secret"),
-	ErrorHandler: func(c echo.Context, err error) error {
-		return c.JSON(http.StatusUnauthorized, models.ApiErrorResponse{"error": "user is not authenticated"})
-	},
+// ApiKeyHeader carries an api key as an alternative to a bearer token
+const ApiKeyHeader string = "X-API-Key"
+
+// JwtSigner signs the access tokens the api issues, the middleware checks them with the same key
+type JwtSigner struct {
+	key []byte
+}
+
+func NewJwtSigner(key []byte) (*JwtSigner, error) {
+	if len(key) == 0 {
+		return nil, errors.New("auth: a jwt signing key is required")
+	}
+
+	return &JwtSigner{key: key}, nil
+}
+
+// Sign signs the claims, tokens without an expiry last 72 hours
+func (s *JwtSigner) Sign(claims *models.JwtCustomClaims) (string, error) {
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour * 72))
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString(s.key)
+}
+
+func (s *JwtSigner) config() echojwt.Config {
+	return echojwt.Config{
+		NewClaimsFunc: func(c echo.Context) jwt.Claims {
+			return new(models.JwtCustomClaims)
+		},
+		SigningKey: s.key,
+		ErrorHandler: func(c echo.Context, err error) error {
+			return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "user is not authenticated")
+		},
+	}
+}
+
+// ApiKeyAuthenticator resolves an api key to its owner and the roles the key may act with
+type ApiKeyAuthenticator interface {
+	AuthenticateApiKey(ctx context.Context, secret string) (*gen.AuthenticateApiKeyResponse, error)
+}
+
+// Middleware authenticates a request with either an Authorization: Bearer jwt or an X-API-Key
+// header. Both are stored as the same claims so handlers and role checks treat them alike.
+func Middleware(keys ApiKeyAuthenticator, signer *JwtSigner) echo.MiddlewareFunc {
+	jwtMiddleware := echojwt.WithConfig(signer.config())
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJwt := jwtMiddleware(next)
+
+		return func(c echo.Context) error {
+			secret := c.Request().Header.Get(ApiKeyHeader)
+
+			if secret == "" {
+				return withJwt(c)
+			}
+
+			resp, err := keys.AuthenticateApiKey(c.Request().Context(), secret)
+
+			if err != nil {
+				switch status.Code(err) {
+				case codes.Unauthenticated, codes.InvalidArgument:
+					return problem.New(http.StatusUnauthorized, problem.CodeInvalidApiKey, "api key is not valid")
+				default:
+					return problem.Internal(err)
+				}
+			}
+
+			claims := models.NewJwtClaims(resp.User.Id, resp.User.Username, resp.User.Email, user.StringsToRoles(resp.Roles))
+			claims.ApiKeyID = resp.Key.Id
+			claims.ServiceAccount = resp.User.ServiceAccount
+
+			c.Set("user", &jwt.Token{Claims: claims, Valid: true})
+
+			return next(c)
+		}
+	}
+}
+
+// Claims returns the claims of the authenticated user, or nil outside the auth middleware
+func Claims(c echo.Context) *models.JwtCustomClaims {
+	token, ok := c.Get("user").(*jwt.Token)
+
+	if !ok {
+		return nil
+	}
+
+	claims, _ := token.Claims.(*models.JwtCustomClaims)
+	return claims
 }
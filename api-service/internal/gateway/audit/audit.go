@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+type Gateway struct {
+	registry discovery.Registry
+}
+
+func New(registry discovery.Registry) *Gateway {
+	return &Gateway{
+		registry: registry,
+	}
+}
+
+func (g *Gateway) QueryAuditLog(ctx context.Context, req *gen.QueryAuditLogRequest) (*models.AuditLogPage, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewAuditServiceClient(conn)
+
+	resp, err := client.QueryAuditLog(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtoToAuditLogPage(resp), nil
+}
+
+func (g *Gateway) VerifyAuditLog(ctx context.Context) (*models.AuditLogVerification, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewAuditServiceClient(conn)
+
+	resp, err := client.VerifyAuditLog(ctx, &gen.VerifyAuditLogRequest{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditLogVerification{
+		Valid:        resp.Valid,
+		Checked:      resp.Checked,
+		FirstInvalid: resp.FirstInvalid,
+		Problem:      resp.Problem,
+	}, nil
+}
@@ -2,10 +2,12 @@ package auth
 
 import (
 	"context"
+	"time"
 
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
 	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
 )
 
@@ -19,7 +21,7 @@ func New(registry discovery.Registry) *Gateway {
 	}
 }
 
-func (g *Gateway) LoginUser(ctx context.Context, username string, password string) (*gen.LoginUserResponse, error) {
+func (g *Gateway) LoginUser(ctx context.Context, username string, password string, clientIp string, userAgent string) (*gen.LoginUserResponse, error) {
 
 	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
 	if err != nil {
@@ -30,8 +32,10 @@ func (g *Gateway) LoginUser(ctx context.Context, username string, password strin
 	client := gen.NewUserServiceClient(conn)
 
 	resp, err := client.LoginUser(ctx, &gen.LoginUserRequest{
-		Username: username,
-		Password: password,
+		Username:  username,
+		Password:  password,
+		ClientIp:  clientIp,
+		UserAgent: userAgent,
 	})
 
 	if err != nil {
@@ -61,6 +65,30 @@ func (g *Gateway) GetUser(ctx context.Context, id string) (*user.User, error) {
 	return user.ProtoToUser(resp.User), err
 }
 
+func (g *Gateway) RegisterUser(ctx context.Context, req *models.RegisterUserRequest) (*user.User, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.RegisterUser(ctx, &gen.RegisterUserRequest{
+		Username:  req.Username,
+		Password:  req.Password.Reveal(),
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.ProtoToUser(resp.User), err
+}
+
 func (g *Gateway) ValidateUsernameUnique(ctx context.Context, username string) (bool, error) {
 	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
 	if err != nil {
@@ -80,3 +108,595 @@ func (g *Gateway) ValidateUsernameUnique(ctx context.Context, username string) (
 
 	return resp.IsValid, err
 }
+
+func (g *Gateway) SendEmailVerification(ctx context.Context, userId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.SendEmailVerification(ctx, &gen.SendEmailVerificationRequest{
+		UserId: userId,
+	})
+
+	return err
+}
+
+func (g *Gateway) VerifyEmail(ctx context.Context, token string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.VerifyEmail(ctx, &gen.VerifyEmailRequest{
+		Token: token,
+	})
+
+	return err
+}
+
+func (g *Gateway) RequestPasswordReset(ctx context.Context, email string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.RequestPasswordReset(ctx, &gen.RequestPasswordResetRequest{
+		Email: email,
+	})
+
+	return err
+}
+
+func (g *Gateway) ResetPassword(ctx context.Context, token string, password string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.ResetPassword(ctx, &gen.ResetPasswordRequest{
+		Token:    token,
+		Password: password,
+	})
+
+	return err
+}
+
+func (g *Gateway) VerifyMfa(ctx context.Context, mfaToken string, code string, clientIp string, userAgent string) (*gen.LoginUserResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	return client.VerifyMfa(ctx, &gen.VerifyMfaRequest{
+		MfaToken:  mfaToken,
+		Code:      code,
+		ClientIp:  clientIp,
+		UserAgent: userAgent,
+	})
+}
+
+func (g *Gateway) EnrollMfa(ctx context.Context, userId string) (*gen.EnrollMfaResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	return client.EnrollMfa(ctx, &gen.EnrollMfaRequest{
+		UserId: userId,
+	})
+}
+
+func (g *Gateway) ConfirmMfa(ctx context.Context, userId string, code string) ([]string, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ConfirmMfa(ctx, &gen.ConfirmMfaRequest{
+		UserId: userId,
+		Code:   code,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.RecoveryCodes, nil
+}
+
+func (g *Gateway) DisableMfa(ctx context.Context, userId string, code string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.DisableMfa(ctx, &gen.DisableMfaRequest{
+		UserId: userId,
+		Code:   code,
+	})
+
+	return err
+}
+
+func (g *Gateway) SetRoleMfaRequirement(ctx context.Context, role user.UserRole, required bool) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.SetRoleMfaRequirement(ctx, &gen.SetRoleMfaRequirementRequest{
+		Role:     string(role),
+		Required: required,
+	})
+
+	return err
+}
+
+func (g *Gateway) UnlockUser(ctx context.Context, userId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.UnlockUser(ctx, &gen.UnlockUserRequest{
+		UserId: userId,
+	})
+
+	return err
+}
+
+func (g *Gateway) CreateServiceAccount(ctx context.Context, req *models.CreateServiceAccountRequest) (*user.User, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.CreateServiceAccount(ctx, &gen.CreateServiceAccountRequest{
+		Name:  req.Name,
+		Roles: user.RolesToStrings(req.Roles),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.ProtoToUser(resp.User), nil
+}
+
+func (g *Gateway) CreateApiKey(ctx context.Context, actorId string, userId string, req *models.CreateApiKeyRequest) (*models.CreateApiKeyResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	var expiresAt int64
+
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.Unix()
+	}
+
+	resp, err := client.CreateApiKey(ctx, &gen.CreateApiKeyRequest{
+		UserId:    userId,
+		Name:      req.Name,
+		Scopes:    user.RolesToStrings(req.Scopes),
+		ExpiresAt: expiresAt,
+		ActorId:   actorId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreateApiKeyResponse{
+		Key:    *models.ProtoToApiKey(resp.Key),
+		Secret: resp.Secret,
+	}, nil
+}
+
+func (g *Gateway) ListApiKeys(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ListApiKeys(ctx, &gen.ListApiKeysRequest{
+		UserId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtosToApiKeys(resp.Keys), nil
+}
+
+func (g *Gateway) RevokeApiKey(ctx context.Context, userId string, keyId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.RevokeApiKey(ctx, &gen.RevokeApiKeyRequest{
+		UserId: userId,
+		KeyId:  keyId,
+	})
+
+	return err
+}
+
+func (g *Gateway) AuthenticateApiKey(ctx context.Context, secret string) (*gen.AuthenticateApiKeyResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	return client.AuthenticateApiKey(ctx, &gen.AuthenticateApiKeyRequest{
+		Secret: secret,
+	})
+}
+
+func (g *Gateway) ListFederationProviders(ctx context.Context) ([]string, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ListFederationProviders(ctx, &gen.ListFederationProvidersRequest{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Providers, nil
+}
+
+func (g *Gateway) StartFederatedLogin(ctx context.Context, provider string, redirectUri string, browserBinding string) (string, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.StartFederatedLogin(ctx, &gen.StartFederatedLoginRequest{
+		Provider:       provider,
+		RedirectUri:    redirectUri,
+		BrowserBinding: browserBinding,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return resp.AuthorizationUrl, nil
+}
+
+func (g *Gateway) CompleteFederatedLogin(ctx context.Context, provider string, state string, code string, browserBinding string, clientIp string, userAgent string) (*gen.LoginUserResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	return client.CompleteFederatedLogin(ctx, &gen.CompleteFederatedLoginRequest{
+		Provider:       provider,
+		State:          state,
+		Code:           code,
+		BrowserBinding: browserBinding,
+		ClientIp:       clientIp,
+		UserAgent:      userAgent,
+	})
+}
+
+func (g *Gateway) RefreshSession(ctx context.Context, refreshToken string, clientIp string, userAgent string) (*gen.LoginUserResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	return client.RefreshSession(ctx, &gen.RefreshSessionRequest{
+		RefreshToken: refreshToken,
+		ClientIp:     clientIp,
+		UserAgent:    userAgent,
+	})
+}
+
+func (g *Gateway) ListSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ListSessions(ctx, &gen.ListSessionsRequest{
+		UserId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*models.Session{}
+
+	for _, session := range resp.Sessions {
+		sessions = append(sessions, models.ProtoToSession(session))
+	}
+
+	return sessions, nil
+}
+
+func (g *Gateway) RevokeSession(ctx context.Context, userId string, sessionId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.RevokeSession(ctx, &gen.RevokeSessionRequest{
+		UserId:    userId,
+		SessionId: sessionId,
+	})
+
+	return err
+}
+
+func (g *Gateway) RequestAccountDeletion(ctx context.Context, userId string) (*models.AccountDeletionResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.RequestAccountDeletion(ctx, &gen.RequestAccountDeletionRequest{
+		UserId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AccountDeletionResponse{
+		DeleteAfter: time.Unix(resp.DeleteAfter, 0),
+	}, nil
+}
+
+func (g *Gateway) CancelAccountDeletion(ctx context.Context, userId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.CancelAccountDeletion(ctx, &gen.CancelAccountDeletionRequest{
+		UserId: userId,
+	})
+
+	return err
+}
+
+func (g *Gateway) ExportUserData(ctx context.Context, userId string) (*models.UserDataExport, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ExportUserData(ctx, &gen.ExportUserDataRequest{
+		UserId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtoToUserDataExport(resp), nil
+}
+
+func (g *Gateway) ListUsers(ctx context.Context, req *gen.ListUsersRequest) (*models.UserPage, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ListUsers(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.UserPage{
+		Users:         []*user.User{},
+		NextPageToken: resp.NextPageToken,
+		Total:         resp.Total,
+	}
+
+	for _, u := range resp.Users {
+		page.Users = append(page.Users, user.ProtoToUser(u))
+	}
+
+	return page, nil
+}
+
+func (g *Gateway) DisableUser(ctx context.Context, actorId string, userId string, reason string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.DisableUser(ctx, &gen.DisableUserRequest{
+		UserId:  userId,
+		ActorId: actorId,
+		Reason:  reason,
+	})
+
+	return err
+}
+
+func (g *Gateway) EnableUser(ctx context.Context, actorId string, userId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.EnableUser(ctx, &gen.EnableUserRequest{
+		UserId:  userId,
+		ActorId: actorId,
+	})
+
+	return err
+}
+
+func (g *Gateway) ForcePasswordReset(ctx context.Context, actorId string, userId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	_, err = client.ForcePasswordReset(ctx, &gen.ForcePasswordResetRequest{
+		UserId:  userId,
+		ActorId: actorId,
+	})
+
+	return err
+}
+
+func (g *Gateway) SetUserRoles(ctx context.Context, actorId string, userId string, roles []user.UserRole) (*user.User, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.SetUserRoles(ctx, &gen.SetUserRolesRequest{
+		UserId:  userId,
+		ActorId: actorId,
+		Roles:   user.RolesToStrings(roles),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.ProtoToUser(resp.User), nil
+}
+
+func (g *Gateway) ImpersonateUser(ctx context.Context, actorId string, userId string, reason string) (*user.User, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ImpersonateUser(ctx, &gen.ImpersonateUserRequest{
+		UserId:  userId,
+		ActorId: actorId,
+		Reason:  reason,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.ProtoToUser(resp.User), nil
+}
+
+func (g *Gateway) ListAdminActions(ctx context.Context, userId string) ([]*models.AdminAction, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewUserServiceClient(conn)
+
+	resp, err := client.ListAdminActions(ctx, &gen.ListAdminActionsRequest{
+		TargetId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtosToAdminActions(resp.Actions), nil
+}
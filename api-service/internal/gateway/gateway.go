@@ -19,7 +19,54 @@ type BookGateway interface {
 }
 
 type AuthGateway interface {
-	LoginUser(ctx context.Context, username string, password string) (*gen.LoginUserResponse, error)
+	LoginUser(ctx context.Context, username string, password string, clientIp string, userAgent string) (*gen.LoginUserResponse, error)
 	GetUser(ctx context.Context, id string) (*user.User, error)
+	RegisterUser(ctx context.Context, req *models.RegisterUserRequest) (*user.User, error)
 	ValidateUsernameUnique(ctx context.Context, username string) (bool, error)
+	SendEmailVerification(ctx context.Context, userId string) error
+	VerifyEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	VerifyMfa(ctx context.Context, mfaToken string, code string, clientIp string, userAgent string) (*gen.LoginUserResponse, error)
+	EnrollMfa(ctx context.Context, userId string) (*gen.EnrollMfaResponse, error)
+	ConfirmMfa(ctx context.Context, userId string, code string) ([]string, error)
+	DisableMfa(ctx context.Context, userId string, code string) error
+	SetRoleMfaRequirement(ctx context.Context, role user.UserRole, required bool) error
+	UnlockUser(ctx context.Context, userId string) error
+	CreateServiceAccount(ctx context.Context, req *models.CreateServiceAccountRequest) (*user.User, error)
+	CreateApiKey(ctx context.Context, actorId string, userId string, req *models.CreateApiKeyRequest) (*models.CreateApiKeyResponse, error)
+	ListApiKeys(ctx context.Context, userId string) ([]*models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, userId string, keyId string) error
+	AuthenticateApiKey(ctx context.Context, secret string) (*gen.AuthenticateApiKeyResponse, error)
+	ListFederationProviders(ctx context.Context) ([]string, error)
+	StartFederatedLogin(ctx context.Context, provider string, redirectUri string, browserBinding string) (string, error)
+	CompleteFederatedLogin(ctx context.Context, provider string, state string, code string, browserBinding string, clientIp string, userAgent string) (*gen.LoginUserResponse, error)
+	RefreshSession(ctx context.Context, refreshToken string, clientIp string, userAgent string) (*gen.LoginUserResponse, error)
+	ListSessions(ctx context.Context, userId string) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, userId string, sessionId string) error
+	RequestAccountDeletion(ctx context.Context, userId string) (*models.AccountDeletionResponse, error)
+	CancelAccountDeletion(ctx context.Context, userId string) error
+	ExportUserData(ctx context.Context, userId string) (*models.UserDataExport, error)
+	ListUsers(ctx context.Context, req *gen.ListUsersRequest) (*models.UserPage, error)
+	DisableUser(ctx context.Context, actorId string, userId string, reason string) error
+	EnableUser(ctx context.Context, actorId string, userId string) error
+	ForcePasswordReset(ctx context.Context, actorId string, userId string) error
+	SetUserRoles(ctx context.Context, actorId string, userId string, roles []user.UserRole) (*user.User, error)
+	ImpersonateUser(ctx context.Context, actorId string, userId string, reason string) (*user.User, error)
+	ListAdminActions(ctx context.Context, userId string) ([]*models.AdminAction, error)
+}
+
+type OAuthGateway interface {
+	RegisterClient(ctx context.Context, ownerId string, req *models.RegisterClientRequest) (*models.RegisterClientResponse, error)
+	Authorize(ctx context.Context, req *gen.AuthorizeRequest) (*gen.AuthorizeResponse, error)
+	ExchangeToken(ctx context.Context, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error)
+	ListConsents(ctx context.Context, userId string) ([]*models.OAuthConsent, error)
+	ListClients(ctx context.Context, ownerId string) ([]*models.OAuthClient, error)
+	RevokeConsent(ctx context.Context, userId string, clientId string) error
+	GetJwks(ctx context.Context) ([]*gen.Jwk, error)
+}
+
+type AuditGateway interface {
+	QueryAuditLog(ctx context.Context, req *gen.QueryAuditLogRequest) (*models.AuditLogPage, error)
+	VerifyAuditLog(ctx context.Context) (*models.AuditLogVerification, error)
 }
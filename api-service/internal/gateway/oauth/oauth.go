@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"context"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+type Gateway struct {
+	registry discovery.Registry
+}
+
+func New(registry discovery.Registry) *Gateway {
+	return &Gateway{
+		registry: registry,
+	}
+}
+
+func (g *Gateway) RegisterClient(ctx context.Context, ownerId string, req *models.RegisterClientRequest) (*models.RegisterClientResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	resp, err := client.RegisterClient(ctx, &gen.RegisterClientRequest{
+		Name:         req.Name,
+		RedirectUris: req.RedirectUris,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		Public:       req.Public,
+		OwnerId:      ownerId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RegisterClientResponse{
+		Client:       *models.ProtoToOAuthClient(resp.Client),
+		ClientSecret: resp.ClientSecret,
+	}, nil
+}
+
+func (g *Gateway) Authorize(ctx context.Context, req *gen.AuthorizeRequest) (*gen.AuthorizeResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	return client.Authorize(ctx, req)
+}
+
+func (g *Gateway) ExchangeToken(ctx context.Context, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	return client.ExchangeToken(ctx, req)
+}
+
+func (g *Gateway) ListConsents(ctx context.Context, userId string) ([]*models.OAuthConsent, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	resp, err := client.ListConsents(ctx, &gen.ListConsentsRequest{
+		UserId: userId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtosToOAuthConsents(resp.Consents), nil
+}
+
+func (g *Gateway) ListClients(ctx context.Context, ownerId string) ([]*models.OAuthClient, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	resp, err := client.ListClients(ctx, &gen.ListClientsRequest{
+		OwnerId: ownerId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ProtosToOAuthClients(resp.Clients), nil
+}
+
+func (g *Gateway) RevokeConsent(ctx context.Context, userId string, clientId string) error {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	_, err = client.RevokeConsent(ctx, &gen.RevokeConsentRequest{
+		UserId:   userId,
+		ClientId: clientId,
+	})
+
+	return err
+}
+
+func (g *Gateway) GetJwks(ctx context.Context) ([]*gen.Jwk, error) {
+	conn, err := grpcutil.ServiceConnection(ctx, "auth", g.registry)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := gen.NewOAuthServiceClient(conn)
+
+	resp, err := client.GetJwks(ctx, &gen.GetJwksRequest{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Keys, nil
+}
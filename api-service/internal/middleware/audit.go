@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+)
+
+// ForwardRequestInfo passes the request id, client address and user agent to the backend
+// services called while handling the request, so their audit entries can be tied back to it.
+// The client address is the router's RealIP, which only believes forwarding headers set by a
+// trusted proxy. It must run after the request id middleware.
+func ForwardRequestInfo(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+
+		ctx := audit.OutgoingContext(req.Context(), audit.RequestInfo{
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+			ClientIP:  c.RealIP(),
+			UserAgent: req.UserAgent(),
+		})
+
+		c.SetRequest(req.WithContext(ctx))
+
+		return next(c)
+	}
+}
+
+// responseStatus is the status the request was answered with, or will be once echo handles the error
+func responseStatus(c echo.Context, err error) int {
+	if err != nil {
+		return problem.StatusOf(err)
+	}
+
+	return c.Response().Status
+}
+
+// requestEntry describes the request for an audit entry
+func requestEntry(c echo.Context, category audit.Category, action string, status int) audit.Entry {
+	entry := audit.Entry{
+		Category:  category,
+		Action:    action,
+		Outcome:   audit.Success,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		Details: map[string]string{
+			"method": c.Request().Method,
+			"path":   c.Path(),
+			"status": strconv.Itoa(status),
+		},
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		entry.Outcome = audit.Denied
+	case status >= http.StatusBadRequest:
+		entry.Outcome = audit.Failure
+	}
+
+	if claims := auth.Claims(c); claims != nil {
+		entry.ActorID = claims.Subject
+		entry.ActorName = claims.Username
+
+		if claims.Actor != nil {
+			entry.ImpersonatorID = claims.Actor.Subject
+		}
+	}
+
+	return entry
+}
+
+// AuditDenied records requests refused for a missing or invalid credential or a missing role.
+// It goes before the auth middleware so rejected tokens are seen too.
+func AuditDenied(recorder audit.Recorder) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			status := responseStatus(c, err)
+			if status != http.StatusUnauthorized && status != http.StatusForbidden {
+				return err
+			}
+
+			if recordErr := recorder.Record(c.Request().Context(), requestEntry(c, audit.Authorization, "access", status)); recordErr != nil {
+				log.Printf("Audit denied request: failed: Err: %v\n", recordErr)
+			}
+
+			return err
+		}
+	}
+}
+
+// AuditMutations records every request that changes the catalogue, whether or not it succeeded
+func AuditMutations(recorder audit.Recorder) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			// paths with no route reach the group's catch all, they change nothing
+			if errors.Is(err, echo.ErrNotFound) {
+				return err
+			}
+
+			switch c.Request().Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				return err
+			}
+
+			status := responseStatus(c, err)
+
+			// refusals are already recorded by AuditDenied
+			if status == http.StatusUnauthorized || status == http.StatusForbidden {
+				return err
+			}
+
+			entry := requestEntry(c, audit.Catalogue, c.Request().Method+" "+c.Path(), status)
+			entry.TargetID = c.Param("id")
+
+			if recordErr := recorder.Record(c.Request().Context(), entry); recordErr != nil {
+				log.Printf("Audit catalogue change: failed: Err: %v\n", recordErr)
+			}
+
+			return err
+		}
+	}
+}
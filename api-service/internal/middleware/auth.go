@@ -3,22 +3,64 @@ package middleware
 import (
 	"net/http"
 	"slices"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
-	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/version"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
 )
 
 func UseAdminOrSameUserAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		reqId := c.Param("id")
-		jwt := c.Get("user").(*jwt.Token)
-		claims := jwt.Claims.(*models.JwtCustomClaims)
-		if claims.ID != reqId || slices.Contains(claims.Roles, user.Admin) {
-			return c.JSON(http.StatusUnauthorized, models.ApiErrorResponse{"error": "user id does not match the requested id"})
+		claims := auth.Claims(c)
+		if claims == nil || (claims.Subject != reqId && !slices.Contains(claims.Roles, user.Admin)) {
+			return problem.New(http.StatusForbidden, problem.CodeUserMismatch, "user id does not match the requested id")
 		}
 
-		return nil
+		return next(c)
+	}
+}
+
+// RequireRole only lets through users with the given role
+func RequireRole(role user.UserRole) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := auth.Claims(c)
+			if claims == nil || !slices.Contains(claims.Roles, role) {
+				return problem.New(http.StatusForbidden, problem.CodeRoleRequired, "user does not have the required role")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// RequireMfaEnrollment restricts a token issued to a user whose role requires mfa,
+// until they enrol it can only be used on the mfa enrolment endpoints
+func RequireMfaEnrollment(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := auth.Claims(c)
+		if claims != nil && claims.MfaEnrollmentRequired && !strings.HasPrefix(version.Route(c.Path()), "/auth/mfa/") {
+			return problem.New(http.StatusForbidden, problem.CodeMfaEnrollmentRequired, "mfa enrolment is required")
+		}
+
+		return next(c)
+	}
+}
+
+// RequireInteractiveLogin rejects requests made with an api key, an oauth client's token or
+// an admin's impersonation token, so a leaked or borrowed credential cannot be used to mint
+// further credentials
+func RequireInteractiveLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := auth.Claims(c)
+		if claims == nil || claims.ApiKeyID != "" || claims.ClientID != "" || claims.Actor != nil {
+			return problem.New(http.StatusForbidden, problem.CodeInteractiveLoginRequired, "this endpoint needs a user's own login")
+		}
+
+		return next(c)
 	}
 }
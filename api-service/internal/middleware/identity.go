@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// ForwardIdentity signs an assertion of the authenticated user and attaches it to the grpc
+// calls and kafka messages made while handling the request, so backends know who they act for
+func ForwardIdentity(signer *identity.Signer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := auth.Claims(c)
+			if claims == nil || claims.Subject == "" {
+				return next(c)
+			}
+
+			principal := &identity.Principal{
+				UserID:         claims.Subject,
+				Username:       claims.Username,
+				Roles:          user.RolesToStrings(claims.Roles),
+				ServiceAccount: claims.ServiceAccount,
+				RequestID:      c.Response().Header().Get(echo.HeaderXRequestID),
+			}
+
+			if claims.Actor != nil {
+				principal.ImpersonatorID = claims.Actor.Subject
+			}
+
+			token, err := signer.Sign(principal)
+
+			if err != nil {
+				return problem.Internal(err)
+			}
+
+			req := c.Request()
+			c.SetRequest(req.WithContext(identity.OutgoingContext(req.Context(), principal, token)))
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/version"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+)
+
+// delegatedScopes lists the routes that tokens issued to third party oauth clients may call
+// and the scope each one needs, the same in every version of the api. Routes that are not
+// listed are closed to those tokens.
+var delegatedScopes = map[string]string{
+	"GET /books":            oauth.ScopeBooksRead,
+	"GET /books/:id":        oauth.ScopeBooksRead,
+	"POST /books":           oauth.ScopeBooksWrite,
+	"PATCH /books/:id":      oauth.ScopeBooksWrite,
+	"DELETE /books/:id":     oauth.ScopeBooksWrite,
+	"GET /authors":          oauth.ScopeAuthorsRead,
+	"GET /authors/:id":      oauth.ScopeAuthorsRead,
+	"POST /authors":         oauth.ScopeAuthorsWrite,
+	"DELETE /authors/:id":   oauth.ScopeAuthorsWrite,
+	"GET /auth/users/:id":   oauth.ScopeUserRead,
+	"PATCH /auth/users/:id": oauth.ScopeUserWrite,
+	"GET /oauth/userinfo":   oauth.ScopeOpenId,
+	"POST /oauth/userinfo":  oauth.ScopeOpenId,
+}
+
+// RestrictDelegatedTokens limits tokens issued to oauth clients to the routes their scopes allow,
+// first party tokens and api keys are not affected
+func RestrictDelegatedTokens(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := auth.Claims(c)
+		if claims == nil || claims.ClientID == "" {
+			return next(c)
+		}
+
+		scope, ok := delegatedScopes[c.Request().Method+" "+version.Route(c.Path())]
+
+		if !ok || !oauth.HasScope(claims.Scope, scope) {
+			// RFC 6750 section 3.1
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			return c.JSON(http.StatusForbidden, oauth.ErrorResponse{Code: "insufficient_scope", Description: "the token does not grant access to this endpoint"})
+		}
+
+		return next(c)
+	}
+}
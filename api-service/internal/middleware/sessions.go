@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+)
+
+const RevokedSessionBaseKey string = "RevokedSession:"
+
+// MarkSessionRevoked stops the session's outstanding tokens being accepted. The mark only needs
+// to outlive the tokens, after that the revoked session can no longer be refreshed anyway.
+func MarkSessionRevoked(c echo.Context, client *redis.Client, sessionId string, ttl time.Duration) error {
+	return client.Set(c.Request().Context(), RevokedSessionBaseKey+sessionId, 1, ttl).Err()
+}
+
+// RejectRevokedSessions refuses tokens whose session has been revoked before they expire.
+// If redis cannot be reached the token is accepted, revocation then waits for the token to expire.
+func RejectRevokedSessions(client *redis.Client) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := auth.Claims(c)
+			if claims == nil || claims.SessionID == "" {
+				return next(c)
+			}
+
+			revoked, err := client.Exists(c.Request().Context(), RevokedSessionBaseKey+claims.SessionID).Result()
+
+			if err != nil {
+				log.Printf("Check revoked session: failed: Err: %v\n", err)
+				return next(c)
+			}
+
+			if revoked > 0 {
+				return problem.New(http.StatusUnauthorized, problem.CodeSessionRevoked, "session has been revoked")
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+)
+
+// DisabledUsersKey is a redis set of the ids of disabled users
+const DisabledUsersKey string = "DisabledUsers"
+
+func MarkUserDisabled(c echo.Context, client *redis.Client, userId string) error {
+	return client.SAdd(c.Request().Context(), DisabledUsersKey, userId).Err()
+}
+
+func MarkUserEnabled(c echo.Context, client *redis.Client, userId string) error {
+	return client.SRem(c.Request().Context(), DisabledUsersKey, userId).Err()
+}
+
+// RejectDisabledUsers refuses tokens of users that have been disabled before the tokens expire,
+// along with impersonation tokens of disabled admins. Disabled users cannot refresh their
+// tokens, so if redis cannot be reached the token is accepted until it expires.
+func RejectDisabledUsers(client *redis.Client) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := auth.Claims(c)
+			if claims == nil || claims.Subject == "" {
+				return next(c)
+			}
+
+			ids := []interface{}{claims.Subject}
+
+			if claims.Actor != nil {
+				ids = append(ids, claims.Actor.Subject)
+			}
+
+			disabled, err := client.SMIsMember(c.Request().Context(), DisabledUsersKey, ids...).Result()
+
+			if err != nil {
+				log.Printf("Check disabled user: failed: Err: %v\n", err)
+				return next(c)
+			}
+
+			for _, isDisabled := range disabled {
+				if isDisabled {
+					return problem.New(http.StatusUnauthorized, problem.CodeAccountDisabled, "account has been disabled")
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// LogImpersonation logs every request made by an admin acting as another user
+func LogImpersonation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := auth.Claims(c)
+		if claims != nil && claims.Actor != nil {
+			log.Printf("impersonation: admin %s as user %s: %s %s\n", claims.Actor.Subject, claims.Subject, c.Request().Method, c.Request().URL.Path)
+		}
+
+		return next(c)
+	}
+}
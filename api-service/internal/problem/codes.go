@@ -0,0 +1,45 @@
+package problem
+
+// Codes sent in the code field of a problem. They are part of the api, clients match on them, so
+// once added they are not renamed or reused for something else.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeMalformedBody      = "malformed_body"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeAlreadyExists      = "already_exists"
+	CodeFailedPrecondition = "failed_precondition"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+	CodeNotImplemented     = "not_implemented"
+	CodeUnavailable        = "service_unavailable"
+	CodeTimeout            = "timeout"
+
+	// authentication and authorization
+	CodeInvalidApiKey               = "invalid_api_key"
+	CodeSessionRevoked              = "session_revoked"
+	CodeAccountDisabled             = "account_disabled"
+	CodeRoleRequired                = "role_required"
+	CodeUserMismatch                = "user_mismatch"
+	CodeMfaEnrollmentRequired       = "mfa_enrollment_required"
+	CodeInteractiveLoginRequired    = "interactive_login_required"
+	CodeIdentityProviderFailed      = "identity_provider_failed"
+	CodeIdentityProviderUnavailable = "identity_provider_unavailable"
+
+	// resources
+	CodeBookNotFound   = "book_not_found"
+	CodeAuthorNotFound = "author_not_found"
+	CodeUserNotFound   = "user_not_found"
+	CodeUsernameTaken  = "username_taken"
+	CodeEmailTaken     = "email_taken"
+)
+
+// Codes sent in the code of a field error
+const (
+	FieldRequired = "required"
+	FieldInvalid  = "invalid"
+)
@@ -0,0 +1,260 @@
+// Package problem turns the errors returned by handlers and middleware into RFC 7807 problem
+// details. Handlers return an *Error, a gRPC status error from a gateway or any other error,
+// and Handler writes the response. Only the detail chosen for the client is sent, the error
+// behind a problem is logged instead.
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ContentType = "application/problem+json"
+
+// Error is a problem to answer a request with
+type Error struct {
+	Status int
+	Code   string
+	// sent to the client, so it must not include internal details
+	Detail string
+	Fields []models.FieldError
+	// what went wrong, logged but not sent
+	cause error
+}
+
+func New(status int, code string, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Detail, e.cause)
+	}
+
+	return e.Code + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Wrap returns a copy of the problem caused by err
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.cause = err
+
+	return &wrapped
+}
+
+// Field describes a field that is missing or not valid
+func Field(name string, code string, detail string) models.FieldError {
+	return models.FieldError{Field: name, Code: code, Detail: detail}
+}
+
+// Required returns an error for each field that is empty, fields are given as pairs of name
+// and value
+func Required(fields ...string) []models.FieldError {
+	var missing []models.FieldError
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			missing = append(missing, Field(fields[i], FieldRequired, fields[i]+" is required"))
+		}
+	}
+
+	return missing
+}
+
+// Invalid is a request with fields that are missing or not valid
+func Invalid(fields ...models.FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidationFailed, Detail: "the request has fields that are missing or not valid", Fields: fields}
+}
+
+// MalformedBody is a request body that could not be parsed
+func MalformedBody(err error) *Error {
+	return New(http.StatusBadRequest, CodeMalformedBody, "could not parse body").Wrap(err)
+}
+
+// Internal is an unexpected error, the client is only told the request failed
+func Internal(err error) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, "the request could not be completed").Wrap(err)
+}
+
+// FromGrpc maps the status of an error from a backend service. The message of a status is only
+// passed on for codes the services use to describe what is wrong with a request, the others
+// can carry internal details such as database errors.
+func FromGrpc(err error) *Error {
+	s, ok := status.FromError(err)
+
+	if !ok {
+		return Internal(err)
+	}
+
+	var p *Error
+
+	switch s.Code() {
+	case codes.InvalidArgument, codes.OutOfRange:
+		p = New(http.StatusBadRequest, CodeInvalidRequest, s.Message())
+	case codes.Unauthenticated:
+		p = New(http.StatusUnauthorized, CodeUnauthenticated, s.Message())
+	case codes.PermissionDenied:
+		p = New(http.StatusForbidden, CodeForbidden, s.Message())
+	case codes.NotFound:
+		p = New(http.StatusNotFound, CodeNotFound, "the resource was not found")
+	case codes.AlreadyExists:
+		p = New(http.StatusConflict, CodeAlreadyExists, s.Message())
+	case codes.FailedPrecondition:
+		p = New(http.StatusConflict, CodeFailedPrecondition, s.Message())
+	case codes.Aborted:
+		p = New(http.StatusConflict, CodeConflict, s.Message())
+	case codes.ResourceExhausted:
+		p = New(http.StatusTooManyRequests, CodeRateLimited, s.Message())
+	case codes.Unimplemented:
+		p = New(http.StatusNotImplemented, CodeNotImplemented, "the request is not supported")
+	case codes.Unavailable:
+		p = New(http.StatusServiceUnavailable, CodeUnavailable, "a service needed for the request is unavailable")
+	case codes.DeadlineExceeded, codes.Canceled:
+		p = New(http.StatusGatewayTimeout, CodeTimeout, "the request took too long")
+	default:
+		return Internal(err)
+	}
+
+	return p.Wrap(err)
+}
+
+// From returns the problem to answer a request that failed with err
+func From(err error) *Error {
+	var p *Error
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return fromHttpError(httpError)
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return FromGrpc(err)
+	}
+
+	switch {
+	case errors.Is(err, gateway.ErrNotFound):
+		return New(http.StatusNotFound, CodeNotFound, "the resource was not found").Wrap(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, CodeTimeout, "the request took too long").Wrap(err)
+	}
+
+	return Internal(err)
+}
+
+// fromHttpError maps the errors echo and its middleware return, such as an unknown route or a
+// rate limit
+func fromHttpError(err *echo.HTTPError) *Error {
+	var p *Error
+
+	switch err.Code {
+	case http.StatusNotFound:
+		p = New(err.Code, CodeNotFound, "no route matches the path")
+	case http.StatusMethodNotAllowed:
+		p = New(err.Code, CodeMethodNotAllowed, "the method is not allowed on the route")
+	case http.StatusTooManyRequests:
+		p = New(err.Code, CodeRateLimited, "too many requests, try again later")
+	case http.StatusUnauthorized:
+		p = New(err.Code, CodeUnauthenticated, "user is not authenticated")
+	case http.StatusForbidden:
+		p = New(err.Code, CodeForbidden, "access to the route is denied")
+	default:
+		if err.Code >= http.StatusInternalServerError {
+			return New(err.Code, CodeInternal, "the request could not be completed").Wrap(err)
+		}
+
+		p = New(err.Code, CodeInvalidRequest, http.StatusText(err.Code))
+	}
+
+	return p.Wrap(err)
+}
+
+// StatusOf returns the status a request that failed with err is answered with
+func StatusOf(err error) int {
+	return From(err).Status
+}
+
+// legacyKey marks a request whose errors are answered with models.ApiErrorResponse
+const legacyKey = "problem.legacy"
+
+// Legacy answers the errors of the routes it is used on with the {"error": "..."} body the api
+// sent before problem details, so existing clients are not broken. The api uses it for every
+// route and Details turns it back off for the routes that have moved on.
+func Legacy(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(legacyKey, true)
+		return next(c)
+	}
+}
+
+// Details answers the errors of the routes it is used on with problem details, it must run
+// after Legacy and before any middleware that can fail
+func Details(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(legacyKey, false)
+		return next(c)
+	}
+}
+
+// legacyBody is the message a legacy client is sent, the first invalid field when there are any
+// as the api used to stop at the first
+func legacyBody(p *Error) models.ApiErrorResponse {
+	if len(p.Fields) > 0 {
+		return models.ApiErrorResponse{Error: p.Fields[0].Detail}
+	}
+
+	return models.ApiErrorResponse{Error: p.Detail}
+}
+
+// Handler is the echo error handler, it answers every failed request with a problem, or with
+// the legacy body while Legacy is in effect
+func Handler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := From(err)
+
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: failed: Err: %v\n", c.Request().Method, c.Path(), err)
+	}
+
+	body := models.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(p.Status),
+		Status:    p.Status,
+		Detail:    p.Detail,
+		Instance:  c.Request().URL.Path,
+		Code:      p.Code,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		Errors:    p.Fields,
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else if legacy, _ := c.Get(legacyKey).(bool); legacy {
+		err = c.JSON(p.Status, legacyBody(p))
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, ContentType)
+		err = c.JSON(p.Status, body)
+	}
+
+	if err != nil {
+		log.Printf("Failed to write problem: %s\n", err)
+	}
+}
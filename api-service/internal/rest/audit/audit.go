@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// HTTP Handler for the security audit log, which only admins can read
+type Handler struct {
+	gateway gateway.AuditGateway
+}
+
+func New(gateway gateway.AuditGateway) *Handler {
+	return &Handler{
+		gateway: gateway,
+	}
+}
+
+// Register endpoints for the handler
+func (h *Handler) Register(r *echo.Group) {
+	r.GET("/admin/audit", h.QueryAuditLog, middleware.RequireRole(user.Admin))
+	r.GET("/admin/audit/verify", h.VerifyAuditLog, middleware.RequireRole(user.Admin))
+}
+
+// parseTimeParam reads an optional RFC 3339 query parameter as unix seconds
+func parseTimeParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Unix(), nil
+}
+
+// QueryAuditLog godoc
+// @Summary QueryAuditLog
+// @Description list audit log entries newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Param  actorId query string false "only entries by this user"
+// @Param  targetId query string false "only entries about this user, session, key or book"
+// @Param  category query string false "authentication, authorization, session, credential, admin, account or catalogue"
+// @Param  action query string false "only entries with this action"
+// @Param  outcome query string false "success, failure or denied"
+// @Param  since query string false "RFC 3339 time"
+// @Param  until query string false "RFC 3339 time"
+// @Param  pageSize query int false "entries per page, at most 500"
+// @Param  pageToken query string false "nextPageToken of the previous page"
+// @Success 200 {object} models.AuditLogPage
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /admin/audit [get]
+func (h *Handler) QueryAuditLog(ctx echo.Context) error {
+	params := new(models.AuditLogParams)
+
+	if err := ctx.Bind(params); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "could not parse query").Wrap(err)
+	}
+
+	since, err := parseTimeParam(params.Since)
+
+	if err != nil {
+		return problem.Invalid(problem.Field("since", problem.FieldInvalid, "since must be an RFC 3339 time"))
+	}
+
+	until, err := parseTimeParam(params.Until)
+
+	if err != nil {
+		return problem.Invalid(problem.Field("until", problem.FieldInvalid, "until must be an RFC 3339 time"))
+	}
+
+	page, err := h.gateway.QueryAuditLog(ctx.Request().Context(), &gen.QueryAuditLogRequest{
+		ActorId:   params.ActorID,
+		TargetId:  params.TargetID,
+		Category:  params.Category,
+		Action:    params.Action,
+		Outcome:   params.Outcome,
+		Since:     since,
+		Until:     until,
+		PageSize:  params.PageSize,
+		PageToken: params.PageToken,
+	})
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, page)
+}
+
+// VerifyAuditLog godoc
+// @Summary VerifyAuditLog
+// @Description check no audit log entries have been changed or removed. Admin only.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.AuditLogVerification
+// @Failure 403 {object} models.Problem
+// @Router /admin/audit/verify [get]
+func (h *Handler) VerifyAuditLog(ctx echo.Context) error {
+	result, err := h.gateway.VerifyAuditLog(ctx.Request().Context())
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
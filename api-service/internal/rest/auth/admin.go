@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+// UnlockUser godoc
+// @Summary UnlockUser
+// @Description clear failed login attempts and any lockout on an account. Admin only.
+// @Tags admin
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /admin/users/{id}/unlock [post]
+func (h *Handler) UnlockUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+
+	if err := h.gateway.UnlockUser(ctx.Request().Context(), id); err != nil {
+		return userError(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// parseTimeParam reads an optional RFC 3339 query parameter as unix seconds
+func parseTimeParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Unix(), nil
+}
+
+// ListUsers godoc
+// @Summary ListUsers
+// @Description list users newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Param  role query string false "only users with this role"
+// @Param  status query string false "active, disabled, unverified or pending_deletion"
+// @Param  createdAfter query string false "RFC 3339 time"
+// @Param  createdBefore query string false "RFC 3339 time"
+// @Param  search query string false "part of the username or email"
+// @Param  pageSize query int false "users per page, at most 200"
+// @Param  pageToken query string false "nextPageToken of the previous page"
+// @Success 200 {object} models.UserPage
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /admin/users [get]
+func (h *Handler) ListUsers(ctx echo.Context) error {
+	params := new(models.ListUsersParams)
+
+	if err := ctx.Bind(params); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "could not parse query").Wrap(err)
+	}
+
+	createdAfter, err := parseTimeParam(params.CreatedAfter)
+
+	if err != nil {
+		return problem.Invalid(problem.Field("createdAfter", problem.FieldInvalid, "createdAfter must be an RFC 3339 time"))
+	}
+
+	createdBefore, err := parseTimeParam(params.CreatedBefore)
+
+	if err != nil {
+		return problem.Invalid(problem.Field("createdBefore", problem.FieldInvalid, "createdBefore must be an RFC 3339 time"))
+	}
+
+	page, err := h.gateway.ListUsers(ctx.Request().Context(), &gen.ListUsersRequest{
+		Role:          params.Role,
+		Status:        params.Status,
+		CreatedAfter:  createdAfter,
+		CreatedBefore: createdBefore,
+		Search:        params.Search,
+		PageSize:      params.PageSize,
+		PageToken:     params.PageToken,
+	})
+
+	if err != nil {
+		return userError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, page)
+}
+
+// DisableUser godoc
+// @Summary DisableUser
+// @Description stop a user logging in and reject the tokens they already have. Admin only.
+// @Tags admin
+// @Accept application/json
+// @Param  id path string true "id of the user"
+// @Param  body body models.DisableUserRequest false "reason shown in the admin action log"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 503 {object} models.Problem "the account was disabled but its tokens could not be rejected yet, retry the request"
+// @Router /admin/users/{id}/disable [post]
+func (h *Handler) DisableUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+	req := new(models.DisableUserRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if err := h.gateway.DisableUser(ctx.Request().Context(), auth.Claims(ctx).Subject, id, req.Reason); err != nil {
+		return userError(err)
+	}
+
+	// disabling is idempotent, so the admin can retry until the api instances reject the user's tokens
+	if err := middleware.MarkUserDisabled(ctx, h.redis, id); err != nil {
+		return problem.New(http.StatusServiceUnavailable, problem.CodeUnavailable, "the account was disabled but its tokens are still accepted, retry the request").Wrap(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// EnableUser godoc
+// @Summary EnableUser
+// @Description let a disabled user log in again. Admin only.
+// @Tags admin
+// @Param  id path string true "id of the user"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 503 {object} models.Problem "the account was enabled but its tokens are still rejected, retry the request"
+// @Router /admin/users/{id}/enable [post]
+func (h *Handler) EnableUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+
+	if err := h.gateway.EnableUser(ctx.Request().Context(), auth.Claims(ctx).Subject, id); err != nil {
+		return userError(err)
+	}
+
+	if err := middleware.MarkUserEnabled(ctx, h.redis, id); err != nil {
+		return problem.New(http.StatusServiceUnavailable, problem.CodeUnavailable, "the account was enabled but its tokens are still rejected, retry the request").Wrap(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ForcePasswordReset godoc
+// @Summary ForcePasswordReset
+// @Description log a user out everywhere and email them a reset link, they cannot log in until they choose a new password. Admin only.
+// @Tags admin
+// @Param  id path string true "id of the user"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/users/{id}/password-reset [post]
+func (h *Handler) ForcePasswordReset(ctx echo.Context) error {
+	id := ctx.Param("id")
+
+	sessions, err := h.gateway.ListSessions(ctx.Request().Context(), id)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	if err := h.gateway.ForcePasswordReset(ctx.Request().Context(), auth.Claims(ctx).Subject, id); err != nil {
+		return userError(err)
+	}
+
+	for _, session := range sessions {
+		if err := middleware.MarkSessionRevoked(ctx, h.redis, session.ID, h.accessTokenTtl); err != nil {
+			log.Printf("Force Password Reset: failed to mark session revoked: Err: %v\n", err)
+		}
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// SetUserRoles godoc
+// @Summary SetUserRoles
+// @Description replace the roles of a user, they apply to the user's tokens from the next refresh. Admin only.
+// @Tags admin
+// @Accept application/json
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Param  body body models.SetUserRolesRequest true "every role the user should have"
+// @Success 200 {object} user.User
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/users/{id}/roles [put]
+func (h *Handler) SetUserRoles(ctx echo.Context) error {
+	req := new(models.SetUserRolesRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if req.Roles == nil {
+		return problem.Invalid(problem.Field("roles", problem.FieldRequired, "roles are required"))
+	}
+
+	res, err := h.gateway.SetUserRoles(ctx.Request().Context(), auth.Claims(ctx).Subject, ctx.Param("id"), req.Roles)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// ImpersonateUser godoc
+// @Summary ImpersonateUser
+// @Description get a short lived token to act as a user. The token names the admin, cannot be refreshed or used to create credentials, and every use is logged. Admin only.
+// @Tags admin
+// @Accept application/json
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Param  body body models.ImpersonateUserRequest true "why the user is being impersonated"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/users/{id}/impersonate [post]
+func (h *Handler) ImpersonateUser(ctx echo.Context) error {
+	req := new(models.ImpersonateUserRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("reason", req.Reason); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	admin := auth.Claims(ctx)
+
+	target, err := h.gateway.ImpersonateUser(ctx.Request().Context(), admin.Subject, ctx.Param("id"), req.Reason)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	claims := models.NewJwtClaims(target.ID, target.Username, target.Email, target.Roles)
+	claims.Actor = &models.ActorClaim{Subject: admin.Subject, Username: admin.Username}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(h.accessTokenTtl))
+
+	token, err := h.signer.Sign(claims)
+
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	return ctx.JSON(http.StatusOK, models.LoginResponse{
+		Token:     token,
+		ExpiresIn: int64(h.accessTokenTtl.Seconds()),
+	})
+}
+
+// ListAdminActions godoc
+// @Summary ListAdminActions
+// @Description list the changes admins have made to a user's account, newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 200 {array} models.AdminAction
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /admin/users/{id}/actions [get]
+func (h *Handler) ListAdminActions(ctx echo.Context) error {
+	actions, err := h.gateway.ListAdminActions(ctx.Request().Context(), ctx.Param("id"))
+
+	if err != nil {
+		return userError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, actions)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+// CreateApiKey godoc
+// @Summary CreateApiKey
+// @Description create a named api key for a user, the key is only returned once. Send it in the X-API-Key header. Cannot be called with an api key.
+// @Description Users create keys for themselves, admins can only create keys for service accounts and this is recorded as an admin action.
+// @Tags auth
+// @Accept application/json
+// @Param  id path string true "id of the user"
+// @Param  body body models.CreateApiKeyRequest true "name, optional scopes and expiry"
+// @Produce json
+// @Success 201 {object} models.CreateApiKeyResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id}/keys [post]
+func (h *Handler) CreateApiKey(ctx echo.Context) error {
+	req := new(models.CreateApiKeyRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("name", req.Name); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	// a key acts as its owner without naming who created it, so admins only get one for a service
+	// account, which the auth service checks and records
+	actorId := ""
+
+	if claims := auth.Claims(ctx); claims.Subject != ctx.Param("id") {
+		actorId = claims.Subject
+	}
+
+	resp, err := h.gateway.CreateApiKey(ctx.Request().Context(), actorId, ctx.Param("id"), req)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, resp)
+}
+
+// ListApiKeys godoc
+// @Summary ListApiKeys
+// @Description list the api keys of a user including revoked and expired keys
+// @Tags auth
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 200 {array} models.ApiKey
+// @Failure 403 {object} models.Problem
+// @Router /auth/users/{id}/keys [get]
+func (h *Handler) ListApiKeys(ctx echo.Context) error {
+	keys, err := h.gateway.ListApiKeys(ctx.Request().Context(), ctx.Param("id"))
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, keys)
+}
+
+// RevokeApiKey godoc
+// @Summary RevokeApiKey
+// @Description revoke an api key, it stops working immediately
+// @Tags auth
+// @Param  id path string true "id of the user"
+// @Param  keyId path string true "id of the key"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id}/keys/{keyId} [delete]
+func (h *Handler) RevokeApiKey(ctx echo.Context) error {
+	if err := h.gateway.RevokeApiKey(ctx.Request().Context(), ctx.Param("id"), ctx.Param("keyId")); err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// CreateServiceAccount godoc
+// @Summary CreateServiceAccount
+// @Description create a service account, a principal without a password for integrations. Create keys for it with /auth/users/{id}/keys. Admin only.
+// @Tags admin
+// @Accept application/json
+// @Param  body body models.CreateServiceAccountRequest true "name and roles of the account"
+// @Produce json
+// @Success 201 {object} user.User
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/service-accounts [post]
+func (h *Handler) CreateServiceAccount(ctx echo.Context) error {
+	req := new(models.CreateServiceAccountRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("name", req.Name); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	serviceAccount, err := h.gateway.CreateServiceAccount(ctx.Request().Context(), req)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, serviceAccount)
+}
@@ -2,49 +2,118 @@ package auth
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	"github.com/redis/go-redis/v9"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type Handler struct {
-	gateway  gateway.AuthGateway
-	kafkaUri string
-	redis    *redis.Client
+	gateway      gateway.AuthGateway
+	oauthGateway gateway.OAuthGateway
+	brokers      *discovery.Endpoint
+	redis        *redis.Client
+	// base url of the api, external identity providers redirect back to it
+	publicUrl string
+	// lifetime of issued tokens, sessions are kept alive by refreshing them
+	accessTokenTtl time.Duration
+	signer         *auth.JwtSigner
+	// throttles the endpoints that accept credentials per client, shared by every version of
+	// the api so switching versions does not reset a client's limit. The auth service also
+	// locks accounts and addresses after repeated failures.
+	throttle echo.MiddlewareFunc
 }
 
-func New(authGateway gateway.AuthGateway, redis *redis.Client, kafkaUri string) *Handler {
+func New(authGateway gateway.AuthGateway, oauthGateway gateway.OAuthGateway, redis *redis.Client, brokers *discovery.Endpoint, publicUrl string, accessTokenTtl time.Duration, signer *auth.JwtSigner) *Handler {
 	return &Handler{
-		gateway:  authGateway,
-		kafkaUri: kafkaUri,
-		redis:    redis,
+		gateway:        authGateway,
+		oauthGateway:   oauthGateway,
+		brokers:        brokers,
+		redis:          redis,
+		publicUrl:      publicUrl,
+		accessTokenTtl: accessTokenTtl,
+		signer:         signer,
+		throttle: echoMiddleware.RateLimiter(echoMiddleware.NewRateLimiterMemoryStoreWithConfig(
+			echoMiddleware.RateLimiterMemoryStoreConfig{Rate: rate.Limit(1), Burst: 5, ExpiresIn: 10 * time.Minute},
+		)),
 	}
 }
 
-func (h *Handler) Register(r *echo.Echo) {
-	r.POST("/auth/login", h.Login)
-	r.POST("/auth/users", h.CreateUser)
+// Register public auth endpoints on the public group and the endpoints that need a user on the protected group
+func (h *Handler) Register(r *echo.Group, protected *echo.Group) {
+	throttle := h.throttle
 
-	userSpecificGroup := r.Group("/auth/users/:id")
+	r.POST("/auth/login", h.Login, throttle)
+	r.POST("/auth/login/mfa", h.VerifyMfa, throttle)
+	r.POST("/auth/token/refresh", h.RefreshToken, throttle)
+	r.POST("/auth/users", h.CreateUser)
+	r.POST("/auth/password/forgot", h.ForgotPassword, throttle)
+	r.GET("/auth/password/reset", h.ResetPasswordForm)
+	r.POST("/auth/password/reset", h.ResetPassword)
+	r.GET("/auth/verify", h.VerifyEmail)
+	r.POST("/auth/verify", h.VerifyEmail)
+	r.GET("/auth/federation/providers", h.ListFederationProviders)
+	r.GET("/auth/federation/:provider/login", h.StartFederatedLogin, throttle)
+	r.GET("/auth/federation/:provider/callback", h.CompleteFederatedLogin, throttle)
+
+	protected.POST("/auth/mfa/enroll", h.EnrollMfa)
+	protected.POST("/auth/mfa/confirm", h.ConfirmMfa)
+	protected.POST("/auth/mfa/disable", h.DisableMfa)
+	protected.PUT("/admin/roles/:role/mfa", h.SetRoleMfaRequirement, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/unlock", h.UnlockUser, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/restore", h.RestoreUser, middleware.RequireRole(user.Admin))
+	protected.GET("/admin/users", h.ListUsers, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/disable", h.DisableUser, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/enable", h.EnableUser, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/password-reset", h.ForcePasswordReset, middleware.RequireRole(user.Admin))
+	protected.PUT("/admin/users/:id/roles", h.SetUserRoles, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/users/:id/impersonate", h.ImpersonateUser, middleware.RequireRole(user.Admin), middleware.RequireInteractiveLogin)
+	protected.GET("/admin/users/:id/actions", h.ListAdminActions, middleware.RequireRole(user.Admin))
+	protected.POST("/admin/service-accounts", h.CreateServiceAccount, middleware.RequireRole(user.Admin), middleware.RequireInteractiveLogin)
+
+	userSpecificGroup := protected.Group("/auth/users/:id")
 
 	userSpecificGroup.Use(middleware.UseAdminOrSameUserAuthMiddleware)
 
-	userSpecificGroup.GET("/auth/users/:id", h.GetUser)
-	userSpecificGroup.PATCH("/auth/users/:id", h.UpdateUser)
+	userSpecificGroup.GET("", h.GetUser)
+	userSpecificGroup.PATCH("", h.UpdateUser)
+	userSpecificGroup.DELETE("", h.DeleteUser, middleware.RequireInteractiveLogin)
+	userSpecificGroup.GET("/export", h.ExportUserData, middleware.RequireInteractiveLogin)
+	userSpecificGroup.GET("/keys", h.ListApiKeys)
+	userSpecificGroup.POST("/keys", h.CreateApiKey, middleware.RequireInteractiveLogin)
+	userSpecificGroup.DELETE("/keys/:keyId", h.RevokeApiKey)
+	userSpecificGroup.GET("/sessions", h.ListSessions)
+	userSpecificGroup.DELETE("/sessions/:sessionId", h.RevokeSession)
 }
 
 func (h *Handler) newProducer() (*kafka.Producer, error) {
-	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.kafkaUri})
+	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.brokers.String()})
+}
+
+// userError maps errors from the rpcs that act on a user named in the request
+func userError(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return problem.New(http.StatusNotFound, problem.CodeUserNotFound, "the user was not found").Wrap(err)
+	}
+
+	return problem.FromGrpc(err)
 }
 
 // Login godoc
@@ -53,32 +122,52 @@ func (h *Handler) newProducer() (*kafka.Producer, error) {
 // @Tags auth
 // @Accept application/x-www-form-urlencoded
 // @Produce json
+// @Param  username formData string true "username"
+// @Param  password formData string true "password"
 // @Success 200 {object} models.LoginResponse
-// @Failure 401 {object} models.ApiErrorResponse
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 429 {object} models.Problem
 // @Router /auth/login [post]
 func (h *Handler) Login(ctx echo.Context) error {
 	username := ctx.FormValue("username")
 	password := ctx.FormValue("password")
 
-	user, err := h.gateway.LoginUser(ctx.Request().Context(), username, password)
+	resp, err := h.gateway.LoginUser(ctx.Request().Context(), username, password, ctx.RealIP(), ctx.Request().UserAgent())
 
 	if err != nil {
-		switch status.Code(err) {
-		case codes.Unauthenticated:
-			return ctx.JSON(http.StatusUnauthorized, models.ApiErrorResponse{"error": err.Error()})
-		default:
-			return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		// the account cannot log in yet, such as before its email is verified
+		if status.Code(err) == codes.FailedPrecondition {
+			return problem.New(http.StatusForbidden, problem.CodeForbidden, status.Convert(err).Message()).Wrap(err)
 		}
+
+		return problem.FromGrpc(err)
 	}
 
-	jwt, err := models.BuildJwt(user.Username, user.Email)
+	if resp.MfaRequired {
+		return ctx.JSON(http.StatusOK, models.LoginResponse{MfaRequired: true, MfaToken: resp.MfaToken})
+	}
+
+	return h.issueToken(ctx, resp)
+}
+
+// issueToken signs a short lived jwt for a completed login and returns it with the session's refresh token
+func (h *Handler) issueToken(ctx echo.Context, resp *gen.LoginUserResponse) error {
+	claims := models.NewJwtClaims(resp.Id, resp.Username, resp.Email, user.StringsToRoles(resp.Roles))
+	claims.MfaEnrollmentRequired = resp.MfaEnrollmentRequired
+	claims.SessionID = resp.SessionId
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(h.accessTokenTtl))
+
+	token, err := h.signer.Sign(claims)
 
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
-	return ctx.JSON(http.StatusOK, echo.Map{
-		"token": jwt,
+	return ctx.JSON(http.StatusOK, models.LoginResponse{
+		Token:        token,
+		ExpiresIn:    int64(h.accessTokenTtl.Seconds()),
+		RefreshToken: resp.RefreshToken,
 	})
 }
 
@@ -89,10 +178,10 @@ func (h *Handler) Login(ctx echo.Context) error {
 // @Accept applicaiton/json
 // @Produce json
 // @Param  id path string true "id of the user"
-// @Success 200 {object} models.User
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 404 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Success 200 {object} user.User
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /auth/users/{id} [get]
 func (h *Handler) GetUser(ctx echo.Context) error {
 	id := ctx.Param("id")
@@ -100,19 +189,7 @@ func (h *Handler) GetUser(ctx echo.Context) error {
 	res, err := h.gateway.GetUser(ctx.Request().Context(), id)
 
 	if err != nil {
-		if e, ok := status.FromError(err); ok {
-			switch e.Code() {
-			case codes.NotFound:
-				return ctx.JSON(http.StatusNotFound, models.ApiErrorResponse{"error": err.Error()})
-			default:
-				log.Printf("Get User: failed: Err: %v\n", err)
-				return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
-			}
-		}
-
-		log.Printf("not able to parse error returned %v", err)
-
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return userError(err)
 	}
 
 	return ctx.JSON(http.StatusOK, res)
@@ -120,66 +197,57 @@ func (h *Handler) GetUser(ctx echo.Context) error {
 
 // CreateUser godoc
 // @Summary CreateUser
-// @Description create a new user
+// @Description register a new user
 // @Tags auth
 // @Accept application/json
-// @Param  body body models.CreateUserEvent true "user details"
+// @Param  body body models.RegisterUserRequest true "user details"
 // @Produce json
-// @Success 200 {object} models.User
-// @Failure 401 {object} models.ApiErrorResponse
+// @Success 201 {object} user.User
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
 // @Router /auth/users [post]
 func (h *Handler) CreateUser(ctx echo.Context) error {
-	topicName := "createUser"
+	createReq := new(models.RegisterUserRequest)
 
-	producer, err := h.newProducer()
-
-	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+	if err := ctx.Bind(createReq); err != nil {
+		return problem.MalformedBody(err)
 	}
 
-	defer producer.Close()
-	createReq := new(events.CreateUserEvent)
+	createReq.Username = user.NormaliseUsername(createReq.Username)
+	createReq.Email = user.NormaliseEmail(createReq.Email)
 
-	if err := ctx.Bind(createReq); err != nil {
-		log.Println(err.Error())
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "could not parse body"})
-	}
+	var invalid []models.FieldError
 
 	if createReq.Username == "" {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "email is invalid"})
+		invalid = append(invalid, problem.Field("username", problem.FieldInvalid, "username is invalid"))
 	}
 
-	isValid, err := h.gateway.ValidateUsernameUnique(ctx.Request().Context(), createReq.Username)
-	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+	if createReq.Email == "" || !models.EmailRegex.MatchString(createReq.Email) {
+		invalid = append(invalid, problem.Field("email", problem.FieldInvalid, "email is invalid"))
 	}
 
-	if !isValid {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "username already exists"})
+	if createReq.Password == "" {
+		invalid = append(invalid, problem.Field("password", problem.FieldInvalid, "password is invalid"))
 	}
 
-	if createReq.Email == "" || !models.EmailRegex.MatchString(createReq.Email) {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "email is invalid"})
+	if len(invalid) > 0 {
+		return problem.Invalid(invalid...)
 	}
 
-	encodedEvent, err := json.Marshal(createReq)
+	res, err := h.gateway.RegisterUser(ctx.Request().Context(), createReq)
 
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
-	}
-
-	message := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
-		Value:          encodedEvent,
-	}
+		switch grpcutil.Reason(err) {
+		case grpcutil.ReasonUsernameTaken:
+			return problem.New(http.StatusConflict, problem.CodeUsernameTaken, status.Convert(err).Message()).Wrap(err)
+		case grpcutil.ReasonEmailTaken:
+			return problem.New(http.StatusConflict, problem.CodeEmailTaken, status.Convert(err).Message()).Wrap(err)
+		}
 
-	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
-	producer.Flush(int((1 * time.Second).Milliseconds()))
-
-	return ctx.NoContent(http.StatusCreated)
+	return ctx.JSON(http.StatusCreated, res)
 }
 
 // UpdateUser godoc
@@ -187,45 +255,44 @@ func (h *Handler) CreateUser(ctx echo.Context) error {
 // @Description Update an existing user
 // @Tags auth
 // @Accept application/json
-// @Param  body body models.UpdateUserEvent true "user details"
+// @Param  body body events.UpdateUserEvent true "user details"
 // @Produce json
-// @Success 200 {object} models.User
-// @Failure 401 {object} models.ApiErrorResponse
-// @Router /auth/users/:id [patch]
+// @Success 200 {object} user.User
+// @Failure 401 {object} models.Problem
+// @Router /auth/users/{id} [patch]
 func (h *Handler) UpdateUser(ctx echo.Context) error {
 	topicName := "updateUser"
 
 	producer, err := h.newProducer()
 
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	defer producer.Close()
 	createReq := new(events.UpdateUserEvent)
 
 	if err := ctx.Bind(createReq); err != nil {
-		log.Println(err.Error())
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "could not parse body"})
+		return problem.MalformedBody(err)
 	}
 
 	if createReq.Data.Username == "" {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "email is invalid"})
+		return problem.Invalid(problem.Field("data.username", problem.FieldRequired, "username is required"))
 	}
 
 	isValid, err := h.gateway.ValidateUsernameUnique(ctx.Request().Context(), createReq.Data.Username)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
 	if !isValid {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "username already exists"})
+		return problem.New(http.StatusConflict, problem.CodeUsernameTaken, "username already exists")
 	}
 
 	encodedEvent, err := json.Marshal(createReq)
 
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
@@ -234,7 +301,7 @@ func (h *Handler) UpdateUser(ctx echo.Context) error {
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	producer.Flush(int((1 * time.Second).Milliseconds()))
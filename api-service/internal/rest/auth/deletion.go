@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+)
+
+// DeleteUser godoc
+// @Summary DeleteUser
+// @Description schedule the account for deletion. It is logged out everywhere and erased once the grace period ends, an admin can restore it until then.
+// @Tags auth
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 202 {object} models.AccountDeletionResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id} [delete]
+func (h *Handler) DeleteUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+
+	// the auth service ends the sessions, their ids are needed to stop the tokens already issued
+	sessions, err := h.gateway.ListSessions(ctx.Request().Context(), id)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	resp, err := h.gateway.RequestAccountDeletion(ctx.Request().Context(), id)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	for _, session := range sessions {
+		if err := middleware.MarkSessionRevoked(ctx, h.redis, session.ID, h.accessTokenTtl); err != nil {
+			log.Printf("Delete User: failed to mark session revoked: Err: %v\n", err)
+		}
+	}
+
+	return ctx.JSON(http.StatusAccepted, resp)
+}
+
+// RestoreUser godoc
+// @Summary RestoreUser
+// @Description cancel a scheduled account deletion. Admin only.
+// @Tags admin
+// @Param  id path string true "id of the user"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/users/{id}/restore [post]
+func (h *Handler) RestoreUser(ctx echo.Context) error {
+	if err := h.gateway.CancelAccountDeletion(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return userError(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ExportUserData godoc
+// @Summary ExportUserData
+// @Description download everything held about the user as a json archive
+// @Tags auth
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 200 {object} models.UserDataExport
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id}/export [get]
+func (h *Handler) ExportUserData(ctx echo.Context) error {
+	id := ctx.Param("id")
+
+	export, err := h.gateway.ExportUserData(ctx.Request().Context(), id)
+
+	if err != nil {
+		return userError(err)
+	}
+
+	if export.OAuthConsents, err = h.oauthGateway.ListConsents(ctx.Request().Context(), id); err != nil {
+		return userError(err)
+	}
+
+	if export.OAuthClients, err = h.oauthGateway.ListClients(ctx.Request().Context(), id); err != nil {
+		return userError(err)
+	}
+
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="bookstore-export-%s.json"`, id))
+
+	return ctx.JSONPretty(http.StatusOK, export, "  ")
+}
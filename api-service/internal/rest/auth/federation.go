@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// federationError maps errors from the federated login rpcs, a refused login is forbidden and
+// the provider being down is a bad gateway
+func federationError(err error) error {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.FailedPrecondition:
+		return problem.New(http.StatusForbidden, problem.CodeForbidden, status.Convert(err).Message()).Wrap(err)
+	case codes.Unavailable:
+		return problem.New(http.StatusBadGateway, problem.CodeIdentityProviderUnavailable, "identity provider is unavailable").Wrap(err)
+	default:
+		return problem.FromGrpc(err)
+	}
+}
+
+// federationCallbackUrl is where a provider sends the user back to, it must be registered with the provider
+func (h *Handler) federationCallbackUrl(provider string) string {
+	return h.publicUrl + "/auth/federation/" + url.PathEscape(provider) + "/callback"
+}
+
+// federationCookie holds the value that binds a federated login to the browser that started it
+const federationCookie string = "federation_binding"
+
+// setFederationCookie keeps the binding in the browser until the callback, Lax so it is still
+// sent when the provider redirects back
+func (h *Handler) setFederationCookie(ctx echo.Context, value string, maxAge int) {
+	ctx.SetCookie(&http.Cookie{
+		Name:     federationCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.publicUrl, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ListFederationProviders godoc
+// @Summary ListFederationProviders
+// @Description list the external identity providers users can log in with
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.FederationProvidersResponse
+// @Router /auth/federation/providers [get]
+func (h *Handler) ListFederationProviders(ctx echo.Context) error {
+	providers, err := h.gateway.ListFederationProviders(ctx.Request().Context())
+
+	if err != nil {
+		return federationError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, models.FederationProvidersResponse{Providers: providers})
+}
+
+// StartFederatedLogin godoc
+// @Summary StartFederatedLogin
+// @Description redirect to an external identity provider to log in
+// @Tags auth
+// @Param  provider path string true "name of the provider"
+// @Success 302
+// @Failure 404 {object} models.Problem
+// @Failure 502 {object} models.Problem
+// @Router /auth/federation/{provider}/login [get]
+func (h *Handler) StartFederatedLogin(ctx echo.Context) error {
+	provider := ctx.Param("provider")
+
+	// only needs to be unguessable, a fresh verifier is as good as any
+	binding, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	authorizationUrl, err := h.gateway.StartFederatedLogin(ctx.Request().Context(), provider, h.federationCallbackUrl(provider), binding)
+
+	if err != nil {
+		return federationError(err)
+	}
+
+	h.setFederationCookie(ctx, binding, 0)
+
+	return ctx.Redirect(http.StatusFound, authorizationUrl)
+}
+
+// CompleteFederatedLogin godoc
+// @Summary CompleteFederatedLogin
+// @Description the redirect target for external identity providers, completes the login and issues a token.
+// @Description The account is linked by verified email or created when the provider allows it.
+// @Description The login must be completed in the browser that started it, which holds the federation_binding cookie.
+// @Tags auth
+// @Produce json
+// @Param  provider path string true "name of the provider"
+// @Param  state query string true "state from the login redirect"
+// @Param  code query string true "authorization code from the provider"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /auth/federation/{provider}/callback [get]
+func (h *Handler) CompleteFederatedLogin(ctx echo.Context) error {
+	var binding string
+
+	if cookie, err := ctx.Cookie(federationCookie); err == nil {
+		binding = cookie.Value
+	}
+
+	// the login is over whatever the outcome, a state can only be used once
+	h.setFederationCookie(ctx, "", -1)
+
+	// the provider reports a cancelled or refused login with an error instead of a code, its
+	// text is not passed on as anyone can put it in the link
+	if ctx.QueryParam("error") != "" {
+		return problem.New(http.StatusUnauthorized, problem.CodeIdentityProviderFailed, "the identity provider did not complete the login")
+	}
+
+	state := ctx.QueryParam("state")
+	code := ctx.QueryParam("code")
+
+	if state == "" || code == "" {
+		return problem.Invalid(problem.Required("state", state, "code", code)...)
+	}
+
+	if binding == "" {
+		return problem.New(http.StatusForbidden, problem.CodeForbidden, "the login was not started in this browser")
+	}
+
+	resp, err := h.gateway.CompleteFederatedLogin(ctx.Request().Context(), ctx.Param("provider"), state, code, binding, ctx.RealIP(), ctx.Request().UserAgent())
+
+	if err != nil {
+		return federationError(err)
+	}
+
+	if resp.MfaRequired {
+		return ctx.JSON(http.StatusOK, models.LoginResponse{MfaRequired: true, MfaToken: resp.MfaToken})
+	}
+
+	return h.issueToken(ctx, resp)
+}
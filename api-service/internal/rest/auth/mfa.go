@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// VerifyMfa godoc
+// @Summary VerifyMfa
+// @Description complete a login that requires a second factor with an authenticator or recovery code
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.VerifyMfaRequest true "challenge token from login and code"
+// @Produce json
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Router /auth/login/mfa [post]
+func (h *Handler) VerifyMfa(ctx echo.Context) error {
+	req := new(models.VerifyMfaRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("mfaToken", req.MfaToken, "code", req.Code); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	resp, err := h.gateway.VerifyMfa(ctx.Request().Context(), req.MfaToken, req.Code, ctx.RealIP(), ctx.Request().UserAgent())
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return h.issueToken(ctx, resp)
+}
+
+// EnrollMfa godoc
+// @Summary EnrollMfa
+// @Description start mfa enrolment for the current user, the secret must be confirmed with a code
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.EnrollMfaResponse
+// @Failure 409 {object} models.Problem
+// @Router /auth/mfa/enroll [post]
+func (h *Handler) EnrollMfa(ctx echo.Context) error {
+	claims := auth.Claims(ctx)
+
+	resp, err := h.gateway.EnrollMfa(ctx.Request().Context(), claims.Subject)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, models.EnrollMfaResponse{Secret: resp.Secret, ProvisioningUri: resp.ProvisioningUri})
+}
+
+// ConfirmMfa godoc
+// @Summary ConfirmMfa
+// @Description confirm mfa enrolment with a code from the authenticator. The recovery codes are only shown once.
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.MfaCodeRequest true "authenticator code"
+// @Produce json
+// @Success 200 {object} models.ConfirmMfaResponse
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /auth/mfa/confirm [post]
+func (h *Handler) ConfirmMfa(ctx echo.Context) error {
+	claims := auth.Claims(ctx)
+	req := new(models.MfaCodeRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("code", req.Code); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	recoveryCodes, err := h.gateway.ConfirmMfa(ctx.Request().Context(), claims.Subject, req.Code)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, models.ConfirmMfaResponse{RecoveryCodes: recoveryCodes})
+}
+
+// DisableMfa godoc
+// @Summary DisableMfa
+// @Description turn off mfa for the current user
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.MfaCodeRequest true "authenticator or recovery code"
+// @Produce json
+// @Success 204
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /auth/mfa/disable [post]
+func (h *Handler) DisableMfa(ctx echo.Context) error {
+	claims := auth.Claims(ctx)
+	req := new(models.MfaCodeRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("code", req.Code); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	if err := h.gateway.DisableMfa(ctx.Request().Context(), claims.Subject, req.Code); err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// SetRoleMfaRequirement godoc
+// @Summary SetRoleMfaRequirement
+// @Description require or stop requiring mfa for every user with a role. Admin only.
+// @Tags admin
+// @Accept application/json
+// @Param  role path string true "role name"
+// @Param  body body models.RoleMfaRequirementRequest true "requirement"
+// @Produce json
+// @Success 204
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /admin/roles/{role}/mfa [put]
+func (h *Handler) SetRoleMfaRequirement(ctx echo.Context) error {
+	role := user.UserRole(ctx.Param("role"))
+	req := new(models.RoleMfaRequirementRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if err := h.gateway.SetRoleMfaRequirement(ctx.Request().Context(), role, req.Required); err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
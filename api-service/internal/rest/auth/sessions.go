@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+// RefreshToken godoc
+// @Summary RefreshToken
+// @Description exchange a refresh token for a new token. The refresh token is replaced on every use, using an old one again ends the session.
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.RefreshTokenRequest true "refresh token from login or the last refresh"
+// @Produce json
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Router /auth/token/refresh [post]
+func (h *Handler) RefreshToken(ctx echo.Context) error {
+	req := new(models.RefreshTokenRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("refreshToken", req.RefreshToken); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	resp, err := h.gateway.RefreshSession(ctx.Request().Context(), req.RefreshToken, ctx.RealIP(), ctx.Request().UserAgent())
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return h.issueToken(ctx, resp)
+}
+
+// ListSessions godoc
+// @Summary ListSessions
+// @Description list the devices the user is logged in on
+// @Tags auth
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 200 {array} models.Session
+// @Failure 403 {object} models.Problem
+// @Router /auth/users/{id}/sessions [get]
+func (h *Handler) ListSessions(ctx echo.Context) error {
+	sessions, err := h.gateway.ListSessions(ctx.Request().Context(), ctx.Param("id"))
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	if claims := auth.Claims(ctx); claims != nil {
+		for _, session := range sessions {
+			session.Current = session.ID == claims.SessionID
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary RevokeSession
+// @Description log the user out on a device, the session's token stops working immediately
+// @Tags auth
+// @Param  id path string true "id of the user"
+// @Param  sessionId path string true "id of the session"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id}/sessions/{sessionId} [delete]
+func (h *Handler) RevokeSession(ctx echo.Context) error {
+	sessionId := ctx.Param("sessionId")
+
+	if err := h.gateway.RevokeSession(ctx.Request().Context(), ctx.Param("id"), sessionId); err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	if err := middleware.MarkSessionRevoked(ctx, h.redis, sessionId, h.accessTokenTtl); err != nil {
+		log.Printf("Revoke Session: failed to mark revoked: Err: %v\n", err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
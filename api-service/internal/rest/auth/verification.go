@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// ForgotPassword godoc
+// @Summary ForgotPassword
+// @Description email a password reset link. The response is the same whether or not the email is registered.
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.ForgotPasswordRequest true "account email"
+// @Produce json
+// @Success 202
+// @Failure 400 {object} models.Problem
+// @Router /auth/password/forgot [post]
+func (h *Handler) ForgotPassword(ctx echo.Context) error {
+	req := new(models.ForgotPasswordRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	email := user.NormaliseEmail(req.Email)
+
+	if email == "" || !models.EmailRegex.MatchString(email) {
+		return problem.Invalid(problem.Field("email", problem.FieldInvalid, "email is invalid"))
+	}
+
+	if err := h.gateway.RequestPasswordReset(ctx.Request().Context(), email); err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// resetPasswordPage is linked to from reset emails when no frontend page is configured, it posts
+// the token and new password to ResetPassword
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<h1>Reset your password</h1>
+<form id="reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+<p id="result"></p>
+<script>
+document.getElementById("reset").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = new FormData(event.target);
+  const res = await fetch(location.pathname, {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({token: form.get("token"), password: form.get("password")}),
+  });
+  document.getElementById("result").textContent = res.ok ? "Your password has been reset." : (await res.json()).detail;
+});
+</script>
+</body>
+</html>
+`))
+
+// ResetPasswordForm godoc
+// @Summary ResetPasswordForm
+// @Description the page reset emails link to, a form to choose a new password
+// @Tags auth
+// @Param  token query string true "reset token"
+// @Produce html
+// @Success 200
+// @Router /auth/password/reset [get]
+func (h *Handler) ResetPasswordForm(ctx echo.Context) error {
+	ctx.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	ctx.Response().WriteHeader(http.StatusOK)
+
+	return resetPasswordPage.Execute(ctx.Response(), ctx.QueryParam("token"))
+}
+
+// ResetPassword godoc
+// @Summary ResetPassword
+// @Description set a new password using the token from a reset email
+// @Tags auth
+// @Accept application/json
+// @Param  body body models.ResetPasswordRequest true "reset token and new password"
+// @Produce json
+// @Success 204
+// @Failure 400 {object} models.Problem
+// @Router /auth/password/reset [post]
+func (h *Handler) ResetPassword(ctx echo.Context) error {
+	req := new(models.ResetPasswordRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("token", req.Token, "password", req.Password.Reveal()); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	err := h.gateway.ResetPassword(ctx.Request().Context(), req.Token, req.Password.Reveal())
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// VerifyEmail godoc
+// @Summary VerifyEmail
+// @Description confirm an email address using the token from a verification email
+// @Tags auth
+// @Accept application/json
+// @Param  token query string false "verification token"
+// @Param  body body models.VerifyEmailRequest false "verification token"
+// @Produce json
+// @Success 204
+// @Failure 400 {object} models.Problem
+// @Router /auth/verify [post]
+func (h *Handler) VerifyEmail(ctx echo.Context) error {
+	req := new(models.VerifyEmailRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if missing := problem.Required("token", req.Token); len(missing) > 0 {
+		return problem.Invalid(missing...)
+	}
+
+	err := h.gateway.VerifyEmail(ctx.Request().Context(), req.Token)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
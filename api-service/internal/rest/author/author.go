@@ -11,6 +11,9 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
 
@@ -22,9 +25,9 @@ const GetAuthorsBaseKey string = "Authors"
 
 // HTTP Handler for author endpoints
 type Handler struct {
-	gateway  gateway.AuthorGateway
-	kafkaUri string
-	redis    *redis.Client
+	gateway gateway.AuthorGateway
+	brokers *discovery.Endpoint
+	redis   *redis.Client
 }
 
 func (h *Handler) invalidateAuthorCache(ctx context.Context) {
@@ -45,16 +48,16 @@ func (h *Handler) invalidateAuthorCache(ctx context.Context) {
 }
 
 // Create a new instance of the handler
-func New(gateway gateway.AuthorGateway, redis *redis.Client, kafkaUri string) *Handler {
+func New(gateway gateway.AuthorGateway, redis *redis.Client, brokers *discovery.Endpoint) *Handler {
 	return &Handler{
-		gateway:  gateway,
-		kafkaUri: kafkaUri,
-		redis:    redis,
+		gateway: gateway,
+		brokers: brokers,
+		redis:   redis,
 	}
 }
 
 func (h *Handler) newProducer() (*kafka.Producer, error) {
-	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.kafkaUri})
+	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.brokers.String()})
 }
 
 // Register endpoints for the handler
@@ -72,7 +75,7 @@ func (h *Handler) Register(r *echo.Group) {
 // @Accept applicaiton/json
 // @Produce json
 // @Success 200 {object} []models.Author
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 502 {object} models.Problem
 // @Router /authors [get]
 func (h *Handler) GetAuthors(ctx echo.Context) error {
 
@@ -97,7 +100,7 @@ func (h *Handler) GetAuthors(ctx echo.Context) error {
 	res, err := h.gateway.Get(ctx.Request().Context())
 
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
 	marshaledRes, err := json.Marshal(res)
@@ -118,9 +121,9 @@ func (h *Handler) GetAuthors(ctx echo.Context) error {
 // @Produce json
 // @Param  id path string true "id of the author"
 // @Success 200 {object} models.Author
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 404 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /authors/{id} [get]
 func (h *Handler) GetAuthor(ctx echo.Context) error {
 
@@ -129,19 +132,11 @@ func (h *Handler) GetAuthor(ctx echo.Context) error {
 	res, err := h.gateway.GetById(ctx.Request().Context(), id)
 
 	if err != nil {
-		if e, ok := status.FromError(err); ok {
-			switch e.Code() {
-			case codes.NotFound:
-				return ctx.JSON(http.StatusNotFound, models.ApiErrorResponse{"error": err.Error()})
-			default:
-				log.Printf("GetAuthor failed: Err: %v\n", err)
-				return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
-			}
+		if status.Code(err) == codes.NotFound {
+			return problem.New(http.StatusNotFound, problem.CodeAuthorNotFound, "the author was not found").Wrap(err)
 		}
 
-		log.Printf("not able to parse error returned %v", err)
-
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
 	return ctx.JSON(http.StatusOK, res)
@@ -155,21 +150,35 @@ func (h *Handler) GetAuthor(ctx echo.Context) error {
 // @Produce json
 // @Param  body body models.Author true "author body"
 // @Success 201
-// @Success 400 {object} models.ApiErrorResponse
-// @Success 502 {object} models.ApiErrorResponse
+// @Success 400 {object} models.Problem
+// @Success 502 {object} models.Problem
 // @Router /authors [post]
 func (h *Handler) CreateAuthor(ctx echo.Context) error {
 	topicName := "createAuthor"
 	producer, err := h.newProducer()
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 	defer producer.Close()
 
 	author := new(models.Author)
 
 	if err := ctx.Bind(author); err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "could not parse body"})
+		return problem.MalformedBody(err)
+	}
+
+	var invalid []models.FieldError
+
+	if author.Name == "" {
+		invalid = append(invalid, problem.Field("name", problem.FieldRequired, "name is required"))
+	}
+
+	if author.DateOfBirth.IsZero() {
+		invalid = append(invalid, problem.Field("dateOfBirth", problem.FieldRequired, "dateOfBirth is required"))
+	}
+
+	if len(invalid) > 0 {
+		return problem.Invalid(invalid...)
 	}
 
 	encodedEvent, err := json.Marshal(events.CreateAuthorEvent{
@@ -178,16 +187,17 @@ func (h *Handler) CreateAuthor(ctx echo.Context) error {
 	})
 
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
 		Value:          encodedEvent,
+		Headers:        identity.KafkaHeaders(ctx.Request().Context()),
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	producer.Flush(int((1 * time.Second).Milliseconds()))
@@ -205,8 +215,8 @@ func (h *Handler) CreateAuthor(ctx echo.Context) error {
 // @Produce json
 // @Param  id path string true "id of the author"
 // @Success 202
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /authors/{id} [delete]
 func (h *Handler) DeleteAuthor(ctx echo.Context) error {
 	id := ctx.Param("id")
@@ -214,22 +224,23 @@ func (h *Handler) DeleteAuthor(ctx echo.Context) error {
 	topicName := "deleteAuthor"
 	producer, err := h.newProducer()
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 	defer producer.Close()
 
 	encodedEvent, err := json.Marshal(events.DeleteAuthorEvent{ID: id})
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
 		Value:          encodedEvent,
+		Headers:        identity.KafkaHeaders(ctx.Request().Context()),
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	producer.Flush(int((1 * time.Second).Milliseconds()))
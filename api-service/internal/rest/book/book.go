@@ -14,6 +14,9 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
 	"google.golang.org/grpc/codes"
@@ -24,18 +27,18 @@ const GetBooksBaseKey string = "Books"
 
 // HTTP Handler for book endpoints
 type Handler struct {
-	gateway  gateway.BookGateway
-	kafkaUri string
-	redis    *redis.Client
+	gateway gateway.BookGateway
+	brokers *discovery.Endpoint
+	redis   *redis.Client
 }
 
 // Create a new instance of the handler
-func New(gateway gateway.BookGateway, redist *redis.Client, kafkaUri string) *Handler {
-	return &Handler{gateway: gateway, kafkaUri: kafkaUri, redis: redist}
+func New(gateway gateway.BookGateway, redist *redis.Client, brokers *discovery.Endpoint) *Handler {
+	return &Handler{gateway: gateway, brokers: brokers, redis: redist}
 }
 
 func (h *Handler) newProducer() (*kafka.Producer, error) {
-	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.kafkaUri})
+	return kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": h.brokers.String()})
 }
 
 // Register book endpoints
@@ -106,7 +109,7 @@ func (h *Handler) invalidateBookCache(ctx context.Context) {
 // @Param  authorId path string false "authorId of the book"
 // @Produce json
 // @Success 200 {object} []models.Book
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 502 {object} models.Problem
 // @Router /books [get]
 func (h *Handler) GetBooks(ctx echo.Context) error {
 
@@ -137,7 +140,7 @@ func (h *Handler) GetBooks(ctx echo.Context) error {
 	res, err := h.gateway.Get(ctx.Request().Context(), title, authorId, genre)
 
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
 	marshaledRes, err := json.Marshal(res)
@@ -158,9 +161,9 @@ func (h *Handler) GetBooks(ctx echo.Context) error {
 // @Produce json
 // @Param  id path string true "id of the book"
 // @Success 200 {object} models.Book
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 404 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /books/{id} [get]
 func (h *Handler) GetBook(ctx echo.Context) error {
 	id := ctx.Param("id")
@@ -168,19 +171,11 @@ func (h *Handler) GetBook(ctx echo.Context) error {
 	res, err := h.gateway.GetById(ctx.Request().Context(), id)
 
 	if err != nil {
-		if e, ok := status.FromError(err); ok {
-			switch e.Code() {
-			case codes.NotFound:
-				return ctx.JSON(http.StatusNotFound, models.ApiErrorResponse{"error": err.Error()})
-			default:
-				log.Printf("GetAuthor failed: Err: %v\n", err)
-				return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
-			}
+		if status.Code(err) == codes.NotFound {
+			return problem.New(http.StatusNotFound, problem.CodeBookNotFound, "the book was not found").Wrap(err)
 		}
 
-		log.Printf("not able to parse error returned %v", err)
-
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.FromGrpc(err)
 	}
 
 	return ctx.JSON(http.StatusOK, res)
@@ -194,22 +189,36 @@ func (h *Handler) GetBook(ctx echo.Context) error {
 // @Produce json
 // @Param  body body models.Book true "book body"
 // @Success 201
-// @Success 400 {object} models.ApiErrorResponse
-// @Success 502 {object} models.ApiErrorResponse
+// @Success 400 {object} models.Problem
+// @Success 502 {object} models.Problem
 // @Router /books [post]
 func (h *Handler) CreateBook(ctx echo.Context) error {
 
 	topicName := "createBook"
 	producer, err := h.newProducer()
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 	defer producer.Close()
 
 	book := new(models.Book)
 
 	if err := ctx.Bind(book); err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "could not parse body"})
+		return problem.MalformedBody(err)
+	}
+
+	var invalid []models.FieldError
+
+	if book.Title == "" {
+		invalid = append(invalid, problem.Field("title", problem.FieldRequired, "title is required"))
+	}
+
+	if book.AuthorId == "" {
+		invalid = append(invalid, problem.Field("authorId", problem.FieldRequired, "authorId is required"))
+	}
+
+	if len(invalid) > 0 {
+		return problem.Invalid(invalid...)
 	}
 
 	encodedEvent, err := json.Marshal(events.CreateBookEvent{
@@ -221,16 +230,17 @@ func (h *Handler) CreateBook(ctx echo.Context) error {
 	})
 
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
 		Value:          encodedEvent,
+		Headers:        identity.KafkaHeaders(ctx.Request().Context()),
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	h.invalidateBookCache(ctx.Request().Context())
@@ -248,22 +258,22 @@ func (h *Handler) CreateBook(ctx echo.Context) error {
 // @Param  id path string true "id of the book"
 // @Param  body body models.Book true "body of the book"
 // @Success 202
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /books/{id} [patch]
 func (h *Handler) UpdateBook(ctx echo.Context) error {
 	id := ctx.Param("id")
 	topicName := "updateBook"
 	producer, err := h.newProducer()
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 	defer producer.Close()
 
 	book := new(models.Book)
 
 	if err := ctx.Bind(book); err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": "could not parse body"})
+		return problem.MalformedBody(err)
 	}
 
 	encodedEvent, err := json.Marshal(events.UpdateBookEvent{
@@ -278,16 +288,17 @@ func (h *Handler) UpdateBook(ctx echo.Context) error {
 	})
 
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
 		Value:          encodedEvent,
+		Headers:        identity.KafkaHeaders(ctx.Request().Context()),
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	producer.Flush(int((1 * time.Second).Milliseconds()))
@@ -304,8 +315,8 @@ func (h *Handler) UpdateBook(ctx echo.Context) error {
 // @Produce json
 // @Param  id path string true "id of the book"
 // @Success 202
-// @Failure 400 {object} models.ApiErrorResponse
-// @Failure 502 {object} models.ApiErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 502 {object} models.Problem
 // @Router /books/{id} [delete]
 func (h *Handler) DeleteBook(ctx echo.Context) error {
 	id := ctx.Param("id")
@@ -313,22 +324,23 @@ func (h *Handler) DeleteBook(ctx echo.Context) error {
 	topicName := "deleteBook"
 	producer, err := h.newProducer()
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 	defer producer.Close()
 
 	encodedEvent, err := json.Marshal(events.DeleteBookEvent{ID: id})
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topicName, Partition: kafka.PartitionAny},
 		Value:          encodedEvent,
+		Headers:        identity.KafkaHeaders(ctx.Request().Context()),
 	}
 
 	if err := producer.Produce(message, nil); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.ApiErrorResponse{"error": err.Error()})
+		return problem.Internal(err)
 	}
 
 	producer.Flush(int((1 * time.Second).Milliseconds()))
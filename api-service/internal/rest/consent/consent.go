@@ -0,0 +1,73 @@
+package consent
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTP Handler for the endpoints a user manages the consents they gave oauth clients with
+type Handler struct {
+	gateway gateway.OAuthGateway
+}
+
+// Create a new instance of the handler
+func New(gateway gateway.OAuthGateway) *Handler {
+	return &Handler{gateway: gateway}
+}
+
+// Register consent endpoints
+func (h *Handler) Register(protected *echo.Group) {
+	consentGroup := protected.Group("/auth/users/:id/consents")
+
+	consentGroup.Use(middleware.UseAdminOrSameUserAuthMiddleware)
+
+	consentGroup.GET("", h.ListConsents)
+	consentGroup.DELETE("/:clientId", h.RevokeConsent)
+}
+
+// ListConsents godoc
+// @Summary ListConsents
+// @Description applications the user has granted access to and the scopes granted
+// @Tags oauth
+// @Produce json
+// @Param  id path string true "id of the user"
+// @Success 200 {array} models.OAuthConsent
+// @Failure 403 {object} models.Problem
+// @Router /auth/users/{id}/consents [get]
+func (h *Handler) ListConsents(ctx echo.Context) error {
+	consents, err := h.gateway.ListConsents(ctx.Request().Context(), ctx.Param("id"))
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, consents)
+}
+
+// RevokeConsent godoc
+// @Summary RevokeConsent
+// @Description withdraw an application's access, it must ask for consent again. Access tokens already issued stay valid until they expire.
+// @Tags oauth
+// @Param  id path string true "id of the user"
+// @Param  clientId path string true "client id"
+// @Success 204
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /auth/users/{id}/consents/{clientId} [delete]
+func (h *Handler) RevokeConsent(ctx echo.Context) error {
+	if err := h.gateway.RevokeConsent(ctx.Request().Context(), ctx.Param("id"), ctx.Param("clientId")); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return problem.New(http.StatusNotFound, problem.CodeNotFound, "the consent was not found").Wrap(err)
+		}
+
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
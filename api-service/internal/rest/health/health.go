@@ -0,0 +1,61 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+)
+
+// HTTP Handler for the liveness and readiness probes of the api
+type Handler struct {
+	checks *health.Health
+}
+
+func New(checks *health.Health) *Handler {
+	return &Handler{
+		checks: checks,
+	}
+}
+
+// Register endpoints for the handler
+func (h *Handler) Register(r *echo.Echo) {
+	r.GET("/healthz", h.Healthz)
+	r.GET("/readyz", h.Readyz)
+}
+
+// Healthz godoc
+// @Summary Healthz
+// @Description reports whether the api is live. Failing dependencies do not fail it, only the health checks having stopped.
+// @Tags health
+// @Produce json
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /healthz [get]
+func (h *Handler) Healthz(ctx echo.Context) error {
+	report, ok := h.checks.Live()
+
+	if !ok {
+		return ctx.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// Readyz godoc
+// @Summary Readyz
+// @Description reports whether the api can take traffic, which is when none of its required dependencies are failing
+// @Tags health
+// @Produce json
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /readyz [get]
+func (h *Handler) Readyz(ctx echo.Context) error {
+	report, ok := h.checks.Ready()
+
+	if !ok {
+		return ctx.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	return ctx.JSON(http.StatusOK, report)
+}
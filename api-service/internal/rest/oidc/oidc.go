@@ -0,0 +1,381 @@
+package oidc
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Handler serves the OAuth 2 and OpenID Connect endpoints, the auth service makes every decision
+type Handler struct {
+	gateway        gateway.OAuthGateway
+	authGateway    gateway.AuthGateway
+	issuer         string
+	accessTokenTtl time.Duration
+	signer         *auth.JwtSigner
+}
+
+func New(oauthGateway gateway.OAuthGateway, authGateway gateway.AuthGateway, issuer string, accessTokenTtl time.Duration, signer *auth.JwtSigner) *Handler {
+	return &Handler{
+		gateway:        oauthGateway,
+		authGateway:    authGateway,
+		issuer:         issuer,
+		accessTokenTtl: accessTokenTtl,
+		signer:         signer,
+	}
+}
+
+// Register public provider endpoints on the router and the endpoints that need a user on the protected group
+func (h *Handler) Register(r *echo.Echo, protected *echo.Group) {
+	r.GET("/.well-known/openid-configuration", h.Discovery)
+	r.GET("/oauth/jwks", h.Jwks)
+	r.POST("/oauth/token", h.Token)
+
+	protected.GET("/oauth/authorize", h.Authorize, middleware.RequireInteractiveLogin)
+	protected.POST("/oauth/authorize", h.Authorize, middleware.RequireInteractiveLogin)
+	protected.GET("/oauth/userinfo", h.UserInfo)
+	protected.POST("/oauth/userinfo", h.UserInfo)
+	protected.POST("/oauth/clients", h.RegisterClient, middleware.RequireInteractiveLogin)
+}
+
+// Discovery godoc
+// @Summary Discovery
+// @Description OpenID Connect discovery document
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} oauth.DiscoveryDocument
+// @Router /.well-known/openid-configuration [get]
+func (h *Handler) Discovery(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, oauth.DiscoveryDocument{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + "/oauth/authorize",
+		TokenEndpoint:                     h.issuer + "/oauth/token",
+		UserinfoEndpoint:                  h.issuer + "/oauth/userinfo",
+		JwksUri:                           h.issuer + "/oauth/jwks",
+		RegistrationEndpoint:              h.issuer + "/oauth/clients",
+		ScopesSupported:                   oauth.SupportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               oauth.SupportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{oauth.CodeChallengeS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "preferred_username", "name", "given_name", "family_name", "updated_at", "email", "email_verified"},
+	})
+}
+
+// Jwks godoc
+// @Summary Jwks
+// @Description public keys that verify id tokens
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} oauth.Jwks
+// @Router /oauth/jwks [get]
+func (h *Handler) Jwks(ctx echo.Context) error {
+	keys, err := h.gateway.GetJwks(ctx.Request().Context())
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	jwks := oauth.Jwks{Keys: []oauth.Jwk{}}
+
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, oauth.Jwk{Kty: key.Kty, Use: key.Use, Alg: key.Alg, Kid: key.Kid, N: key.N, E: key.E})
+	}
+
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return ctx.JSON(http.StatusOK, jwks)
+}
+
+// redirectWith adds parameters to a client's redirect uri
+func redirectWith(redirectUri string, params url.Values) string {
+	uri, err := url.Parse(redirectUri)
+
+	if err != nil {
+		return redirectUri
+	}
+
+	query := uri.Query()
+
+	for key := range params {
+		query.Set(key, params.Get(key))
+	}
+
+	uri.RawQuery = query.Encode()
+
+	return uri.String()
+}
+
+// Authorize godoc
+// @Summary Authorize
+// @Description authorization endpoint for the code flow with PKCE, called by the bookstore app for the signed in user.
+// @Description GET inspects the request, the response says whether consent is needed or where to send the user.
+// @Description POST with approve=true or false records the user's decision.
+// @Tags oauth
+// @Produce json
+// @Param response_type query string true "must be code"
+// @Param client_id query string true "client id"
+// @Param redirect_uri query string false "registered redirect uri"
+// @Param scope query string true "space separated scopes"
+// @Param state query string false "returned to the client unchanged"
+// @Param code_challenge query string false "S256 PKCE challenge, required for public clients"
+// @Param code_challenge_method query string false "must be S256"
+// @Param nonce query string false "included in the id token"
+// @Success 200 {object} oauth.AuthorizeResponse
+// @Failure 400 {object} models.Problem
+// @Router /oauth/authorize [get]
+// @Router /oauth/authorize [post]
+func (h *Handler) Authorize(ctx echo.Context) error {
+	params := new(models.AuthorizeParams)
+
+	if err := ctx.Bind(params); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "could not parse the request").Wrap(err)
+	}
+
+	if params.ClientId == "" {
+		return problem.Invalid(problem.Field("client_id", problem.FieldRequired, "client_id is required"))
+	}
+
+	decided := ctx.Request().Method == http.MethodPost
+
+	resp, err := h.gateway.Authorize(ctx.Request().Context(), &gen.AuthorizeRequest{
+		ClientId:            params.ClientId,
+		UserId:              auth.Claims(ctx).Subject,
+		RedirectUri:         params.RedirectUri,
+		Scopes:              oauth.ParseScope(params.Scope),
+		CodeChallenge:       params.CodeChallenge,
+		CodeChallengeMethod: params.CodeChallengeMethod,
+		Nonce:               params.Nonce,
+		ResponseType:        params.ResponseType,
+		Approve:             decided && params.Approve,
+		Deny:                decided && !params.Approve,
+	})
+
+	if err != nil {
+		// the client or redirect uri could not be verified so the user must not be redirected
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.NotFound:
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, status.Convert(err).Message()).Wrap(err)
+		default:
+			return problem.FromGrpc(err)
+		}
+	}
+
+	authorizeResp := oauth.AuthorizeResponse{
+		ConsentRequired: resp.ConsentRequired,
+		ClientId:        resp.Client.ClientId,
+		ClientName:      resp.Client.Name,
+		Scopes:          resp.Scopes,
+	}
+
+	redirectParams := url.Values{}
+
+	if params.State != "" {
+		redirectParams.Set("state", params.State)
+	}
+
+	switch {
+	case resp.Error != nil:
+		redirectParams.Set("error", resp.Error.Code)
+		if resp.Error.Description != "" {
+			redirectParams.Set("error_description", resp.Error.Description)
+		}
+		authorizeResp.RedirectTo = redirectWith(resp.RedirectUri, redirectParams)
+	case resp.Code != "":
+		redirectParams.Set("code", resp.Code)
+		authorizeResp.RedirectTo = redirectWith(resp.RedirectUri, redirectParams)
+	}
+
+	return ctx.JSON(http.StatusOK, authorizeResp)
+}
+
+// tokenError writes an RFC 6749 error response from the token endpoint
+func tokenError(ctx echo.Context, oauthErr *gen.OAuthError, basicAuth bool) error {
+	code := http.StatusBadRequest
+
+	if oauthErr.Code == oauth.ErrInvalidClient {
+		code = http.StatusUnauthorized
+
+		if basicAuth {
+			ctx.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	}
+
+	return ctx.JSON(code, oauth.ErrorResponse{Code: oauthErr.Code, Description: oauthErr.Description})
+}
+
+// Token godoc
+// @Summary Token
+// @Description token endpoint for the authorization_code and client_credentials grants.
+// @Description Confidential clients authenticate with HTTP basic auth or client_id and client_secret in the body.
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code or client_credentials"
+// @Param code formData string false "authorization code"
+// @Param redirect_uri formData string false "redirect uri used in the authorization request"
+// @Param code_verifier formData string false "PKCE verifier"
+// @Param scope formData string false "space separated scopes for client_credentials"
+// @Param client_id formData string false "client id when not using basic auth"
+// @Param client_secret formData string false "client secret when not using basic auth"
+// @Success 200 {object} oauth.TokenResponse
+// @Failure 400 {object} oauth.ErrorResponse
+// @Failure 401 {object} oauth.ErrorResponse
+// @Router /oauth/token [post]
+func (h *Handler) Token(ctx echo.Context) error {
+	// tokens must never be cached, RFC 6749 section 5.1
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ctx.Response().Header().Set("Pragma", "no-cache")
+
+	clientId, clientSecret, basicAuth := ctx.Request().BasicAuth()
+
+	if basicAuth {
+		// credentials are form encoded before being put in the header, RFC 6749 section 2.3.1
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId = ctx.FormValue("client_id")
+		clientSecret = ctx.FormValue("client_secret")
+	}
+
+	if clientId == "" {
+		return tokenError(ctx, &gen.OAuthError{Code: oauth.ErrInvalidClient, Description: "client authentication is required"}, basicAuth)
+	}
+
+	resp, err := h.gateway.ExchangeToken(ctx.Request().Context(), &gen.ExchangeTokenRequest{
+		GrantType:    ctx.FormValue("grant_type"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Code:         ctx.FormValue("code"),
+		RedirectUri:  ctx.FormValue("redirect_uri"),
+		CodeVerifier: ctx.FormValue("code_verifier"),
+		Scopes:       oauth.ParseScope(ctx.FormValue("scope")),
+	})
+
+	if err != nil {
+		log.Printf("Token: failed: Err: %v\n", err)
+		return ctx.JSON(http.StatusInternalServerError, oauth.ErrorResponse{Code: "server_error"})
+	}
+
+	if resp.Error != nil {
+		return tokenError(ctx, resp.Error, basicAuth)
+	}
+
+	// oauth tokens act for the user but never with their roles, what they can do is set by the scopes
+	claims := models.NewJwtClaims(resp.ClientId, "", "", []user.UserRole{})
+
+	if resp.User != nil {
+		claims = models.NewJwtClaims(resp.User.Id, resp.User.Username, resp.User.Email, []user.UserRole{})
+	}
+
+	claims.ClientID = resp.ClientId
+	claims.Scope = oauth.FormatScope(resp.Scopes)
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(h.accessTokenTtl))
+
+	accessToken, err := h.signer.Sign(claims)
+
+	if err != nil {
+		log.Printf("Token: failed to sign: Err: %v\n", err)
+		return ctx.JSON(http.StatusInternalServerError, oauth.ErrorResponse{Code: "server_error"})
+	}
+
+	return ctx.JSON(http.StatusOK, oauth.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(h.accessTokenTtl.Seconds()),
+		Scope:       claims.Scope,
+		IdToken:     resp.IdToken,
+	})
+}
+
+// UserInfo godoc
+// @Summary UserInfo
+// @Description claims about the user the access token was issued for, limited by its scopes
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} oauth.ErrorResponse
+// @Router /oauth/userinfo [get]
+// @Router /oauth/userinfo [post]
+func (h *Handler) UserInfo(ctx echo.Context) error {
+	claims := auth.Claims(ctx)
+
+	if claims.ClientID != "" && claims.Subject == claims.ClientID {
+		// client credentials tokens have no user
+		return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "the token was not issued for a user")
+	}
+
+	// first party tokens see every claim
+	scopes := oauth.SupportedScopes
+
+	if claims.ClientID != "" {
+		scopes = oauth.ParseScope(claims.Scope)
+	}
+
+	u, err := h.authGateway.GetUser(ctx.Request().Context(), claims.Subject)
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return problem.New(http.StatusUnauthorized, problem.CodeUserNotFound, "the user no longer exists").Wrap(err)
+		default:
+			return problem.FromGrpc(err)
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, oauth.UserClaims(u, scopes))
+}
+
+// RegisterClient godoc
+// @Summary RegisterClient
+// @Description register a third party application, the secret is only returned once. Only admins can register clients for the client_credentials grant.
+// @Tags oauth
+// @Accept application/json
+// @Param  body body models.RegisterClientRequest true "client metadata"
+// @Produce json
+// @Success 201 {object} models.RegisterClientResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /oauth/clients [post]
+func (h *Handler) RegisterClient(ctx echo.Context) error {
+	req := new(models.RegisterClientRequest)
+
+	if err := ctx.Bind(req); err != nil {
+		return problem.MalformedBody(err)
+	}
+
+	if req.Name == "" {
+		return problem.Invalid(problem.Field("name", problem.FieldRequired, "name is required"))
+	}
+
+	// registering client credentials clients is checked again in the auth service against the stored roles
+	if slices.Contains(req.GrantTypes, oauth.GrantClientCredentials) && !slices.Contains(auth.Claims(ctx).Roles, user.Admin) {
+		return problem.New(http.StatusForbidden, problem.CodeRoleRequired, "only admins can register clients for the client credentials grant")
+	}
+
+	resp, err := h.gateway.RegisterClient(ctx.Request().Context(), auth.Claims(ctx).Subject, req)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, resp)
+}
@@ -0,0 +1,338 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/middleware"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	issuer      = "https://bookstore.test"
+	clientId    = "reader"
+	redirectUri = "http://localhost:8765/callback"
+	keyId       = "test-key"
+)
+
+// authorizationCode is what the fake provider remembers about an issued code
+type authorizationCode struct {
+	userId    string
+	scopes    []string
+	challenge string
+	nonce     string
+}
+
+// memoryProvider stands in for the auth service's provider with a single public client, the
+// methods the tests do not use panic
+type memoryProvider struct {
+	gateway.OAuthGateway
+	key      *rsa.PrivateKey
+	user     *user.User
+	mu       sync.Mutex
+	issued   int
+	consents map[string][]string
+	codes    map[string]authorizationCode
+}
+
+func (p *memoryProvider) Authorize(ctx context.Context, req *gen.AuthorizeRequest) (*gen.AuthorizeResponse, error) {
+	if req.ClientId != clientId || req.RedirectUri != redirectUri {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown client or redirect uri")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resp := &gen.AuthorizeResponse{Client: &gen.OAuthClient{ClientId: clientId, Name: "Reader"}, Scopes: req.Scopes, RedirectUri: redirectUri}
+
+	switch {
+	case req.Deny:
+		resp.Error = &gen.OAuthError{Code: oauth.ErrAccessDenied}
+		return resp, nil
+	case req.CodeChallenge == "" || req.CodeChallengeMethod != oauth.CodeChallengeS256:
+		resp.Error = &gen.OAuthError{Code: oauth.ErrInvalidRequest, Description: "public clients must use PKCE"}
+		return resp, nil
+	}
+
+	if !oauth.ContainsAll(p.consents[req.UserId], req.Scopes) {
+		if !req.Approve {
+			resp.ConsentRequired = true
+			return resp, nil
+		}
+
+		p.consents[req.UserId] = req.Scopes
+	}
+
+	p.issued++
+	resp.Code = fmt.Sprintf("code-%d", p.issued)
+	p.codes[resp.Code] = authorizationCode{userId: req.UserId, scopes: req.Scopes, challenge: req.CodeChallenge, nonce: req.Nonce}
+
+	return resp, nil
+}
+
+func (p *memoryProvider) ExchangeToken(ctx context.Context, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code, ok := p.codes[req.Code]
+	delete(p.codes, req.Code)
+
+	if req.ClientId != clientId || !ok || !oauth.VerifyCodeChallenge(code.challenge, req.CodeVerifier) {
+		return &gen.ExchangeTokenResponse{Error: &gen.OAuthError{Code: oauth.ErrInvalidGrant}}, nil
+	}
+
+	claims := jwt.MapClaims(oauth.UserClaims(p.user, code.scopes))
+	claims["iss"] = issuer
+	claims["aud"] = clientId
+	claims["nonce"] = code.nonce
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyId
+
+	signed, err := idToken.SignedString(p.key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.ExchangeTokenResponse{
+		ClientId: clientId,
+		User:     user.UserToProto(p.user),
+		Scopes:   code.scopes,
+		IdToken:  signed,
+	}, nil
+}
+
+func (p *memoryProvider) GetJwks(ctx context.Context) ([]*gen.Jwk, error) {
+	return []*gen.Jwk{{
+		Kid: keyId,
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}, nil
+}
+
+// oneUser looks up the single user of the tests
+type oneUser struct {
+	gateway.AuthGateway
+	user *user.User
+}
+
+func (g oneUser) GetUser(ctx context.Context, id string) (*user.User, error) {
+	if id != g.user.ID {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+
+	return g.user, nil
+}
+
+// newApi serves the provider endpoints the way the api does and returns a client for them
+// and a token alice signed in with
+func newApi(t *testing.T) (*oauth.Client, *user.User, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := auth.NewJwtSigner([]byte("test-secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &user.User{ID: "alice-id", Username: "alice", Email: "alice@example.com", EmailVerified: true, Roles: []user.UserRole{}}
+
+	provider := &memoryProvider{key: key, user: alice, consents: map[string][]string{}, codes: map[string]authorizationCode{}}
+
+	router := echo.New()
+	protected := router.Group("", auth.Middleware(nil, signer), middleware.RestrictDelegatedTokens)
+
+	New(provider, oneUser{user: alice}, issuer, time.Minute, signer).Register(router, protected)
+
+	aliceToken, err := signer.Sign(models.NewJwtClaims(alice.ID, alice.Username, alice.Email, alice.Roles))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &oauth.Client{
+		BaseUrl:     issuer,
+		ClientId:    clientId,
+		RedirectUri: redirectUri,
+		HTTPClient:  &http.Client{Transport: oauth.HandlerTransport{Handler: router}},
+	}
+
+	return client, alice, aliceToken
+}
+
+// jwks fetches the published keys through the client's transport
+func jwks(t *testing.T, client *oauth.Client) map[string]*rsa.PublicKey {
+	t.Helper()
+
+	resp, err := client.HTTPClient.Get(issuer + "/oauth/jwks")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var set oauth.Jwks
+
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, jwk := range set.Keys {
+		n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys
+}
+
+func TestAuthorizationCodeFlowThroughTheApi(t *testing.T) {
+	client, alice, aliceToken := newApi(t)
+	ctx := context.Background()
+
+	verifier, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := client.AuthorizeParams("state", oauth.CodeChallenge(verifier), "nonce", []string{oauth.ScopeOpenId, oauth.ScopeEmail})
+
+	// inspecting the request shows the consent screen is needed
+	req, _ := http.NewRequest(http.MethodGet, issuer+"/oauth/authorize?"+params.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+aliceToken)
+
+	resp, err := client.HTTPClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inspected oauth.AuthorizeResponse
+	err = json.NewDecoder(resp.Body).Decode(&inspected)
+	resp.Body.Close()
+
+	if err != nil || !inspected.ConsentRequired || inspected.RedirectTo != "" {
+		t.Fatalf("expected consent to be required, got %+v %v", inspected, err)
+	}
+
+	// approving it redirects with a code which is exchanged with the verifier
+	code, err := client.Authorize(ctx, aliceToken, params)
+
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+
+	token, err := client.ExchangeCode(ctx, code, verifier)
+
+	if err != nil {
+		t.Fatalf("ExchangeCode: %s", err)
+	}
+
+	if token.TokenType != "Bearer" || token.Scope != "openid email" {
+		t.Fatalf("unexpected token response %+v", token)
+	}
+
+	keys := jwks(t, client)
+
+	idToken, err := jwt.Parse(token.IdToken, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys[kid], nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(issuer), jwt.WithAudience(clientId))
+
+	if err != nil {
+		t.Fatalf("id token did not verify against the jwks: %s", err)
+	}
+
+	if claims := idToken.Claims.(jwt.MapClaims); claims["sub"] != alice.ID || claims["nonce"] != "nonce" {
+		t.Fatalf("unexpected id token claims %v", claims)
+	}
+
+	// the access token only reveals what its scopes allow
+	info, err := client.UserInfo(ctx, token.AccessToken)
+
+	if err != nil {
+		t.Fatalf("UserInfo: %s", err)
+	}
+
+	if info["sub"] != alice.ID || info["email"] != alice.Email {
+		t.Fatalf("unexpected userinfo %v", info)
+	}
+	if _, ok := info["preferred_username"]; ok {
+		t.Fatalf("userinfo included the profile without the profile scope: %v", info)
+	}
+
+	// the delegated token cannot approve requests for the user
+	if _, err := client.Authorize(ctx, token.AccessToken, params); err == nil {
+		t.Fatal("an oauth access token was allowed to authorize a client")
+	}
+}
+
+func TestTokenEndpointRejectsWrongVerifier(t *testing.T) {
+	client, _, aliceToken := newApi(t)
+	ctx := context.Background()
+
+	verifier, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := client.Authorize(ctx, aliceToken, client.AuthorizeParams("state", oauth.CodeChallenge(verifier), "", []string{oauth.ScopeOpenId}))
+
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+
+	_, err = client.ExchangeCode(ctx, code, other)
+
+	var oauthErr *oauth.ErrorResponse
+
+	if !errors.As(err, &oauthErr) || oauthErr.Code != oauth.ErrInvalidGrant {
+		t.Fatalf("expected invalid_grant, got %v", err)
+	}
+
+	// the code is spent even though the verifier was wrong
+	if _, err := client.ExchangeCode(ctx, code, verifier); err == nil {
+		t.Fatal("the code was exchanged after a failed attempt")
+	}
+}
@@ -0,0 +1,181 @@
+package book
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/gateway"
+	"github.com/will-kerwin/go-microservice-bookstore/api-service/internal/problem"
+	v1 "github.com/will-kerwin/go-microservice-bookstore/api-service/internal/rest/book"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+	v2 "github.com/will-kerwin/go-microservice-bookstore/pkg/models/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTP Handler for the v2 book endpoints, books are returned with their author embedded.
+// Writes are unchanged from v1.
+type Handler struct {
+	books   gateway.BookGateway
+	authors gateway.AuthorGateway
+	v1      *v1.Handler
+}
+
+// Create a new instance of the handler
+func New(books gateway.BookGateway, authors gateway.AuthorGateway, v1 *v1.Handler) *Handler {
+	return &Handler{books: books, authors: authors, v1: v1}
+}
+
+// Register book endpoints
+func (h *Handler) Register(r *echo.Group) {
+	r.GET("/books", h.GetBooks)
+	r.GET("/books/:id", h.GetBook)
+	r.POST("/books", h.CreateBook)
+	r.PATCH("/books/:id", h.UpdateBook)
+	r.DELETE("/books/:id", h.DeleteBook)
+}
+
+// withAuthors embeds the author of each book, looking up each author once
+func (h *Handler) withAuthors(ctx context.Context, books []*models.Book) ([]*v2.Book, error) {
+	authors := map[string]*models.Author{}
+	res := []*v2.Book{}
+
+	for _, book := range books {
+		author, ok := authors[book.AuthorId]
+
+		if !ok {
+			var err error
+
+			if author, err = h.author(ctx, book.AuthorId); err != nil {
+				return nil, err
+			}
+
+			authors[book.AuthorId] = author
+		}
+
+		res = append(res, v2.NewBook(book, author))
+	}
+
+	return res, nil
+}
+
+// author returns nil when the author does not exist
+func (h *Handler) author(ctx context.Context, id string) (*models.Author, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	author, err := h.authors.GetById(ctx, id)
+
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+
+	return author, err
+}
+
+// GetBooks godoc
+// @Summary Get Books.
+// @Description get the books with their authors, filtered by the query.
+// @Tags books
+// @Accept applicaiton/json
+// @Param  title query string false "title of the book"
+// @Param  genre query string false "genre of the book"
+// @Param  authorId query string false "authorId of the book"
+// @Produce json
+// @Success 200 {object} []v2.Book
+// @Failure 500 {object} models.Problem
+// @Router /books [get]
+func (h *Handler) GetBooks(ctx echo.Context) error {
+	books, err := h.books.Get(ctx.Request().Context(), ctx.QueryParam("title"), ctx.QueryParam("authorId"), ctx.QueryParam("genre"))
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	res, err := h.withAuthors(ctx.Request().Context(), books)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// GetBook godoc
+// @Summary Get book by its object id in hex format.
+// @Description get the book by id with its author.
+// @Tags books
+// @Accept applicaiton/json
+// @Produce json
+// @Param  id path string true "id of the book"
+// @Success 200 {object} v2.Book
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /books/{id} [get]
+func (h *Handler) GetBook(ctx echo.Context) error {
+	book, err := h.books.GetById(ctx.Request().Context(), ctx.Param("id"))
+
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return problem.New(http.StatusNotFound, problem.CodeBookNotFound, "the book was not found").Wrap(err)
+		}
+
+		return problem.FromGrpc(err)
+	}
+
+	author, err := h.author(ctx.Request().Context(), book.AuthorId)
+
+	if err != nil {
+		return problem.FromGrpc(err)
+	}
+
+	return ctx.JSON(http.StatusOK, v2.NewBook(book, author))
+}
+
+// CreateBook godoc
+// @Summary Create a book.
+// @Description creates a book asynchronously.
+// @Tags books
+// @Accept applicaiton/json
+// @Produce json
+// @Param  body body models.Book true "book body"
+// @Success 202
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /books [post]
+func (h *Handler) CreateBook(ctx echo.Context) error {
+	return h.v1.CreateBook(ctx)
+}
+
+// UpdateBook godoc
+// @Summary Update book by its object id in hex format.
+// @Description updates the book asynchronously.
+// @Tags books
+// @Accept applicaiton/json
+// @Produce json
+// @Param  id path string true "id of the book"
+// @Param  body body models.Book true "body of the book"
+// @Success 202
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /books/{id} [patch]
+func (h *Handler) UpdateBook(ctx echo.Context) error {
+	return h.v1.UpdateBook(ctx)
+}
+
+// DeleteBook godoc
+// @Summary Delete book by its object id in hex format.
+// @Description deletes the book asynchronously.
+// @Tags books
+// @Accept applicaiton/json
+// @Produce json
+// @Param  id path string true "id of the book"
+// @Success 202
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /books/{id} [delete]
+func (h *Handler) DeleteBook(ctx echo.Context) error {
+	return h.v1.DeleteBook(ctx)
+}
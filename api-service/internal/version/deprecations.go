@@ -0,0 +1,21 @@
+package version
+
+import "time"
+
+var (
+	// the api was versioned on this date
+	versionedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	// the unversioned paths are kept for six months so clients have time to move to /v1
+	unversionedSunset = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+)
+
+// Unversioned is the deprecation of the paths the api was served at before it was versioned,
+// they are aliases of /v1
+var Unversioned = Deprecation{At: versionedAt, Sunset: unversionedSunset, Successor: V1}
+
+// V1Routes are the routes of /v1 that have a replacement in /v2. They are still served, there
+// is no sunset for them yet.
+var V1Routes = Deprecations{
+	"GET /v1/books":     {At: versionedAt, Successor: V2},
+	"GET /v1/books/:id": {At: versionedAt, Successor: V2},
+}
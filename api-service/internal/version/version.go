@@ -0,0 +1,87 @@
+// Package version holds the versions of the public api and which of their routes are deprecated.
+// Each version is served under its own prefix, /v1 is the api as it was before it was versioned
+// and /v2 is where models can change without breaking existing clients.
+package version
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	V1 string = "/v1"
+	V2 string = "/v2"
+)
+
+var prefixes = []string{V1, V2}
+
+// Route returns the path of a route without its version prefix, so middleware that looks at
+// routes treats /books/:id, /v1/books/:id and /v2/books/:id the same
+func Route(path string) string {
+	for _, prefix := range prefixes {
+		if path == prefix {
+			return "/"
+		}
+
+		if strings.HasPrefix(path, prefix+"/") {
+			return strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	return path
+}
+
+// Deprecation of a route, sent to clients in the Deprecation (RFC 9745), Sunset (RFC 8594) and
+// Link headers
+type Deprecation struct {
+	// when the route was deprecated
+	At time.Time
+	// when the route stops being served, zero when no date has been set
+	Sunset time.Time
+	// the version prefix that replaces the route, linked to as its successor
+	Successor string
+}
+
+func (d Deprecation) write(c echo.Context) {
+	header := c.Response().Header()
+
+	header.Set("Deprecation", "@"+strconv.FormatInt(d.At.Unix(), 10))
+
+	if !d.Sunset.IsZero() {
+		header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+
+	if d.Successor != "" {
+		header.Add("Link", "<"+d.Successor+Route(c.Request().URL.Path)+`>; rel="successor-version"`)
+	}
+}
+
+// Deprecate marks every route it is used on as deprecated
+func Deprecate(d Deprecation) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			d.write(c)
+			return next(c)
+		}
+	}
+}
+
+// Deprecations of single routes, keyed by method and route such as "GET /v1/books/:id"
+type Deprecations map[string]Deprecation
+
+// Middleware marks the routes listed as deprecated, the rest are left alone
+func (d Deprecations) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if deprecation, ok := d[c.Request().Method+" "+c.Path()]; ok {
+				deprecation.write(c)
+			}
+
+			return next(c)
+		}
+	}
+}
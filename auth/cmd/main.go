@@ -3,63 +3,183 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/erasure"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/federation"
+	auditGrpc "github.com/will-kerwin/go-microservice-bookstore/auth/internal/grpc/audit"
 	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/grpc/auth"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/grpc/provider"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/mailer"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/oidc"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/password"
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/config"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/leader"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/lifecycle"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/mtls"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const serviceName string = "auth"
 
+// how often the config is loaded again to pick up changes to the reloadable settings
+const configReloadInterval time.Duration = 30 * time.Second
+
+// Config of the auth service, see pkg/config for where it is loaded from
+type Config struct {
+	Port int `config:"PORT" required:"true"`
+	// DbName is the older name of the setting
+	DbName               string `config:"DB_NAME,DbName" required:"true"`
+	HealthPort           string `config:"HEALTH_PORT"`
+	IdentityAssertionKey string `config:"IDENTITY_ASSERTION_KEY" required:"true" secret:"true"`
+	// Base url of the public api, used to build links sent to users and as the oidc issuer
+	PublicUrl string `config:"PUBLIC_URL"`
+	// page of the frontend where users choose a new password, the reset token is added to it as
+	// a query parameter. The form served by the api is linked to when it is not set
+	PasswordResetUrl string `config:"PASSWORD_RESET_URL" reload:"true"`
+	// grace and required also apply to accounts made before verification was added, so they
+	// lock out existing users who never verified
+	VerificationPolicy      auth.VerificationPolicy `config:"EMAIL_VERIFICATION_POLICY" default:"optional" reload:"true"`
+	VerificationGracePeriod time.Duration           `config:"EMAIL_VERIFICATION_GRACE_PERIOD" default:"168h" reload:"true"`
+	VerificationTokenTtl    time.Duration           `config:"EMAIL_VERIFICATION_TOKEN_TTL" default:"48h" reload:"true"`
+	PasswordResetTokenTtl   time.Duration           `config:"PASSWORD_RESET_TOKEN_TTL" default:"1h" reload:"true"`
+	MfaChallengeTtl         time.Duration           `config:"MFA_CHALLENGE_TTL" default:"5m" reload:"true"`
+	FederationStateTtl      time.Duration           `config:"FEDERATION_STATE_TTL" default:"10m" reload:"true"`
+	SessionTtl              time.Duration           `config:"SESSION_TTL" default:"720h" reload:"true"`
+	DeletionGracePeriod     time.Duration           `config:"ACCOUNT_DELETION_GRACE_PERIOD" default:"720h" reload:"true"`
+	DeletionMode            string                  `config:"ACCOUNT_DELETION_MODE"`
+	PurgeInterval           time.Duration           `config:"ACCOUNT_PURGE_INTERVAL" default:"1h"`
+	FederationConfig        string                  `config:"FEDERATION_CONFIG"`
+	// client secrets of the federation providers by provider name, eg. google=secret,okta=secret
+	FederationClientSecrets map[string]string `config:"FEDERATION_CLIENT_SECRETS" secret:"true"`
+	OAuthCodeTtl            time.Duration     `config:"OAUTH_CODE_TTL" default:"1m"`
+	OidcIdTokenTtl          time.Duration     `config:"OIDC_ID_TOKEN_TTL" default:"1h"`
+	// where the audit log is written, mongo or file
+	AuditSink    string `config:"AUDIT_SINK" default:"mongo"`
+	AuditFile    string `config:"AUDIT_FILE"`
+	AuditHmacKey string `config:"AUDIT_HMAC_KEY" secret:"true"`
+	// where the registry, kafka and mongodb are
+	Discovery discovery.Config
+	Kafka     infra.KafkaConfig
+	Mongo     infra.MongoConfig
+	Mailer    mailer.Config
+	// how passwords are hashed and the rules they must follow
+	PasswordHasher password.HasherConfig
+	PasswordPolicy password.PolicyConfig
+	// how long in-flight requests and messages get to finish when the service stops
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT" default:"25s"`
+	// the version, zone and tags of the instance, used by callers to choose between instances
+	Metadata discovery.Metadata
+	// tls for the grpc server, see pkg/mtls
+	Tls mtls.Config
+}
+
+// handlerConfig picks the settings of the auth handler out of the service config
+func (c Config) handlerConfig(passwordPolicy *password.Policy) auth.Config {
+	return auth.Config{
+		PublicUrl:               c.PublicUrl,
+		PasswordResetUrl:        c.PasswordResetUrl,
+		VerificationPolicy:      c.VerificationPolicy,
+		VerificationGracePeriod: c.VerificationGracePeriod,
+		VerificationTokenTtl:    c.VerificationTokenTtl,
+		PasswordResetTokenTtl:   c.PasswordResetTokenTtl,
+		MfaIssuer:               "Bookstore",
+		MfaChallengeTtl:         c.MfaChallengeTtl,
+		AccountLockout:          auth.DefaultAccountLockout,
+		IpLockout:               auth.DefaultIpLockout,
+		PasswordPolicy:          passwordPolicy,
+		FederationStateTtl:      c.FederationStateTtl,
+		SessionTtl:              c.SessionTtl,
+		DeletionGracePeriod:     c.DeletionGracePeriod,
+	}
+}
+
+// serveHealth serves /healthz and /readyz on the health port when it is set
+func serveHealth(lc *lifecycle.Lifecycle, healthPort string, checks *health.Health) {
+	if healthPort == "" {
+		return
+	}
+
+	lc.ServeHttp("health endpoints", &http.Server{Addr: ":" + healthPort, Handler: checks.Handler()})
+}
+
+// newAuditSink picks where the audit log is written, mongo unless AUDIT_SINK says otherwise
+func newAuditSink(ctx context.Context, client infra.Database, config Config) (audit.Sink, error) {
+	switch config.AuditSink {
+	case "", "mongo":
+		sink := audit.NewMongoSink(func() *mongo.Collection {
+			return client.Database(config.DbName).Collection("auditLog")
+		})
+
+		if err := sink.EnsureIndexes(ctx); err != nil {
+			return nil, err
+		}
+
+		return sink, nil
+	case "file":
+		return audit.NewFileSink(config.AuditFile)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q, expected mongo or file", config.AuditSink)
+	}
+}
+
 func main() {
-	port, err := strconv.Atoi(os.Getenv("PORT"))
+	configStore, err := config.Load[Config](context.Background(), config.New(config.Sources(os.Args[1:])...))
 
 	if err != nil {
 		panic(err)
 	}
 
-	registryUri := os.Getenv("CONSUL_URI")
-	kafkaUri := os.Getenv("KAFKA_URI")
-	registry, err := discovery.NewRegistry(registryUri)
+	serviceConfig := configStore.Get()
+
+	// stops the service on SIGTERM, ctx is cancelled as it starts stopping
+	lc := lifecycle.New(serviceConfig.ShutdownTimeout)
+	ctx := lc.Context()
+	port := serviceConfig.Port
+
+	log.Printf("Loaded config:\n%s\n", config.Dump(&serviceConfig))
+
+	registry, err := discovery.NewRegistry(serviceConfig.Discovery)
 
 	if err != nil {
 		panic(err)
 	}
 
-	ctx := context.Background()
 	instanceID := discovery.GenerateInstanceID(serviceName)
 
-	if err := registry.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port)); err != nil {
+	if err := registry.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port), serviceConfig.Metadata); err != nil {
 		panic(err)
 	}
 
-	go func() {
-		for {
-			if err := registry.HealthCheck(instanceID, serviceName); err != nil {
-				log.Println("Failed to report heathly state: " + err.Error())
-			}
-			time.Sleep(1 * time.Second)
-		}
-	}()
-
-	defer registry.Deregister(ctx, instanceID, serviceName)
+	// leave discovery first when stopping so no new requests are sent
+	lc.OnStop(lifecycle.Deregister, "discovery", func(ctx context.Context) error {
+		return registry.Deregister(ctx, instanceID, serviceName)
+	})
 
 	log.Printf("Starting the %s service at port: %d\n", serviceName, port)
 
-	MONGODB_URI := os.Getenv("MONGODB_URI")
-	clientOptions := options.Client().ApplyURI(MONGODB_URI)
+	// kafka and mongodb are found through the registry or at fixed addresses, see pkg/infra
+	kafkaBrokers, err := infra.Kafka(ctx, registry, serviceConfig.Kafka)
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := infra.Mongo(ctx, registry, serviceConfig.Mongo)
 
 	if err != nil {
 		panic(err)
@@ -73,12 +193,197 @@ func main() {
 
 	log.Println("Connected to mongodb")
 
+	lc.OnStop(lifecycle.CloseClients, "mongodb", client.Disconnect)
+
+	hasher, err := password.New(serviceConfig.PasswordHasher)
+
+	if err != nil {
+		panic(err)
+	}
+
+	passwordPolicy, err := password.NewPolicy(serviceConfig.PasswordPolicy)
+
+	if err != nil {
+		panic(err)
+	}
+
 	// load repos
-	authRespository := db.NewAuthRepository(client)
+	authRespository := db.NewAuthRepository(client, serviceConfig.DbName, hasher)
+	tokenRepository := db.NewTokenRepository(client, serviceConfig.DbName)
+	roleRepository := db.NewRoleRepository(client, serviceConfig.DbName)
+	attemptRepository := db.NewAttemptRepository(client, serviceConfig.DbName)
+	apiKeyRepository := db.NewApiKeyRepository(client, serviceConfig.DbName)
+	oauthRepository := db.NewOAuthRepository(client, serviceConfig.DbName)
+	signingKeyRepository := db.NewSigningKeyRepository(client, serviceConfig.DbName)
+	sessionRepository := db.NewSessionRepository(client, serviceConfig.DbName)
+	federationRepository := db.NewFederationRepository(client, serviceConfig.DbName)
+	adminActionRepository := db.NewAdminActionRepository(client, serviceConfig.DbName)
+
+	if err := authRespository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := tokenRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := attemptRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := apiKeyRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := oauthRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := signingKeyRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := sessionRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := federationRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	if err := adminActionRepository.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	providers, err := federation.LoadFromFile(serviceConfig.FederationConfig, serviceConfig.FederationClientSecrets)
+
+	if err != nil {
+		panic(err)
+	}
+
+	authMailer, err := mailer.New(serviceConfig.Mailer)
+
+	if err != nil {
+		panic(err)
+	}
+
+	deletionMode, err := erasure.ParseMode(serviceConfig.DeletionMode)
+
+	if err != nil {
+		panic(err)
+	}
+
+	purger := erasure.NewPurger(erasure.Repositories{
+		Users:       authRespository,
+		Tokens:      tokenRepository,
+		Sessions:    sessionRepository,
+		ApiKeys:     apiKeyRepository,
+		Federations: federationRepository,
+		OAuth:       oauthRepository,
+	}, kafkaBrokers, erasure.Config{
+		Mode:     deletionMode,
+		Interval: serviceConfig.PurgeInterval,
+		Lease:    10 * time.Minute,
+	})
+
+	// one instance purges at a time, the claims on each account keep it safe if two overlap
+	// while leadership changes hands. Without a backend to elect with every instance purges.
+	leaderBackend, err := leader.ForRegistry(registry)
+
+	if err != nil {
+		log.Printf("Running the account purger on every instance: %s\n", err)
+		lc.Go(lifecycle.StopWorkers, "account purger", func(ctx context.Context) {
+			purger.Run(ctx, 0)
+		})
+	} else {
+		purgerElection := leader.New(leaderBackend, "auth/account-purger", instanceID)
+
+		// the term token fences the accounts a leader claims, a deposed leader cannot remove them
+		lc.Go(lifecycle.StopWorkers, "account purger", func(ctx context.Context) {
+			purgerElection.Run(ctx, func(ctx context.Context, term *leader.Term) {
+				purger.Run(ctx, term.Token)
+			})
+		})
+	}
+	lc.OnStop(lifecycle.CloseClients, "account purger producer", func(context.Context) error {
+		purger.Close()
+		return nil
+	})
+
+	auditSink, err := newAuditSink(ctx, client, serviceConfig)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if closer, ok := auditSink.(io.Closer); ok {
+		lc.OnStop(lifecycle.CloseClients, "audit sink", func(context.Context) error {
+			return closer.Close()
+		})
+	}
+
+	auditLog := audit.NewLog(auditSink, []byte(serviceConfig.AuditHmacKey))
 
 	// load handler
-	authHandler := auth.New(authRespository, kafkaUri, serviceName)
-	authHandler.HandleIngestors(ctx)
+	authHandler := auth.New(authRespository, tokenRepository, roleRepository, attemptRepository, apiKeyRepository, sessionRepository, federationRepository, adminActionRepository, auditLog, providers, authMailer, kafkaBrokers, serviceConfig.handlerConfig(passwordPolicy))
+
+	// the ttls and email verification policy can change without a restart
+	configStore.OnChange(func(changed Config) {
+		authHandler.SetConfig(changed.handlerConfig(passwordPolicy))
+	})
+
+	go configStore.Watch(ctx, configReloadInterval)
+
+	lc.OnStop(lifecycle.CloseClients, "auth producers", func(context.Context) error {
+		authHandler.Close()
+		return nil
+	})
+	providerHandler := provider.New(oauthRepository, authRespository, oidc.NewSigner(signingKeyRepository), provider.Config{
+		Issuer:     serviceConfig.PublicUrl,
+		CodeTtl:    serviceConfig.OAuthCodeTtl,
+		IdTokenTtl: serviceConfig.OidcIdTokenTtl,
+	})
+	auditHandler := auditGrpc.New(auditLog, kafkaBrokers)
+
+	// stopped once the server has finished its requests, before the audit sink is closed
+	lc.Go(lifecycle.StopWorkers, "audit consumer", auditHandler.HandleIngestors)
+
+	checks := health.New(health.DefaultTimeout)
+	// not ready until the grpc server is serving
+	checks.Register("lifecycle", lc)
+	checks.Register("mongodb", health.Mongo(client))
+	auditHandler.RegisterHealthChecks(checks)
+
+	// report the health of the service to discovery, the checks keep running while the service
+	// drains so the health endpoints show it is shutting down
+	lc.Go(lifecycle.StopServing, "health checks", func(checksCtx context.Context) {
+		checks.Run(checksCtx, time.Second, func(report health.Report) {
+			// the instance is deregistered once the service starts stopping
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := registry.HealthCheck(instanceID, serviceName, report.Status, report.Output()); err != nil {
+				log.Println("Failed to report health: " + err.Error())
+			}
+		})
+	})
+
+	serveHealth(lc, serviceConfig.HealthPort, checks)
+
+	credentials, err := mtls.New(serviceName, serviceConfig.Tls)
+
+	if err != nil {
+		panic(err)
+	}
+
+	go credentials.Watch(ctx)
+
+	verifier, err := identity.NewVerifier([]byte(serviceConfig.IdentityAssertionKey))
+
+	if err != nil {
+		panic(err)
+	}
 
 	// create grpc listener
 	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", serviceName, port))
@@ -87,11 +392,23 @@ func main() {
 	}
 
 	// create grpc server and listen
-	grpcServer := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+	grpcServer := grpc.NewServer(
+		credentials.ServerOption(),
+		grpc.ChainUnaryInterceptor(verifier.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(verifier.StreamServerInterceptor()),
+	)
 
 	gen.RegisterUserServiceServer(grpcServer, authHandler)
+	gen.RegisterOAuthServiceServer(grpcServer, providerHandler)
+	gen.RegisterAuditServiceServer(grpcServer, auditHandler)
+	healthpb.RegisterHealthServer(grpcServer, checks.GrpcServer())
 
-	if err := grpcServer.Serve(lis); err != nil {
-		panic(err)
+	lc.ServeGrpc("grpc server", grpcServer, lis)
+	lc.MarkReady()
+
+	if err := lc.Wait(); err != nil {
+		log.Fatalf("Stopped the %s service: %s\n", serviceName, err)
 	}
+
+	log.Printf("Stopped the %s service\n", serviceName)
 }
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoDbAdminActionRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewAdminActionRepository(client infra.Database, dbName string) *MongoDbAdminActionRepository {
+	return &MongoDbAdminActionRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbAdminActionRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("adminActions")
+}
+
+func (r *MongoDbAdminActionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+
+	return err
+}
+
+func (r *MongoDbAdminActionRepository) Record(ctx context.Context, action *authModels.AdminActionDocument) error {
+	action.ID = primitive.NewObjectID()
+	action.CreatedAt = time.Now()
+
+	_, err := r.getCollection().InsertOne(ctx, action)
+
+	return err
+}
+
+// ListByTarget returns the actions taken on a user, newest first
+func (r *MongoDbAdminActionRepository) ListByTarget(ctx context.Context, targetId string) ([]authModels.AdminActionDocument, error) {
+	var actions []authModels.AdminActionDocument = []authModels.AdminActionDocument{}
+
+	targetOid, err := primitive.ObjectIDFromHex(targetId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.getCollection().Find(ctx, bson.M{"targetId": targetOid}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &actions); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeyScheme string = "bks"
+	// last used is only written when it is older than this, so busy keys do not cause a write per request
+	apiKeyLastUsedResolution time.Duration = time.Minute
+)
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func randomKeyPart(size int) (string, error) {
+	bytes := make([]byte, size)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(apiKeyEncoding.EncodeToString(bytes)), nil
+}
+
+// generateApiKey returns a key of the form bks_<prefix>_<secret> and its prefix
+func generateApiKey() (string, string, error) {
+	prefix, err := randomKeyPart(5)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := randomKeyPart(20)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return apiKeyScheme + "_" + prefix + "_" + secret, prefix, nil
+}
+
+// parseApiKeyPrefix returns the prefix of a well formed key
+func parseApiKeyPrefix(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+
+	if len(parts) != 3 || parts[0] != apiKeyScheme || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+type MongoDbApiKeyRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewApiKeyRepository(client infra.Database, dbName string) *MongoDbApiKeyRepository {
+	return &MongoDbApiKeyRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbApiKeyRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("apiKeys")
+}
+
+// EnsureIndexes creates the unique prefix index used to look up keys and the owner index used to list them
+func (r *MongoDbApiKeyRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.getCollection()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}},
+		},
+	})
+
+	return err
+}
+
+// Create issues a new key for the user, the full key is returned and only its hash is stored
+func (r *MongoDbApiKeyRepository) Create(ctx context.Context, userId string, name string, scopes []user.UserRole, expiresAt *time.Time) (*authModels.ApiKeyDocument, string, error) {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, prefix, err := generateApiKey()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyDoc := authModels.ApiKeyDocument{
+		ID:        primitive.NewObjectID(),
+		UserID:    userOid,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	collection := r.getCollection()
+
+	if _, err := collection.InsertOne(ctx, keyDoc); err != nil {
+		return nil, "", err
+	}
+
+	return &keyDoc, key, nil
+}
+
+func (r *MongoDbApiKeyRepository) ListByUser(ctx context.Context, userId string) ([]authModels.ApiKeyDocument, error) {
+	var keys []authModels.ApiKeyDocument = []authModels.ApiKeyDocument{}
+
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.getCollection()
+
+	cursor, err := collection.Find(ctx, bson.M{"userId": userOid}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke marks a key as revoked, the key must belong to the user
+func (r *MongoDbApiKeyRepository) Revoke(ctx context.Context, userId string, keyId string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	keyOid, err := primitive.ObjectIDFromHex(keyId)
+
+	if err != nil {
+		return err
+	}
+
+	collection := r.getCollection()
+
+	filter := bson.M{"_id": keyOid, "userId": userOid, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": keyOid, "userId": userOid})
+
+		if err != nil {
+			return err
+		}
+
+		// revoking an already revoked key is not an error
+		if count == 0 {
+			return mongo.ErrNoDocuments
+		}
+	}
+
+	return nil
+}
+
+// Authenticate finds the active key matching the full key and records that it was used
+func (r *MongoDbApiKeyRepository) Authenticate(ctx context.Context, key string) (*authModels.ApiKeyDocument, error) {
+	var keyDoc authModels.ApiKeyDocument
+
+	prefix, ok := parseApiKeyPrefix(key)
+
+	if !ok {
+		return nil, authModels.ErrInvalidApiKey
+	}
+
+	collection := r.getCollection()
+
+	err := collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&keyDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, authModels.ErrInvalidApiKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if subtle.ConstantTimeCompare([]byte(keyDoc.Hash), []byte(hashToken(key))) != 1 || !keyDoc.IsActive(now) {
+		return nil, authModels.ErrInvalidApiKey
+	}
+
+	if keyDoc.LastUsedAt == nil || now.Sub(*keyDoc.LastUsedAt) >= apiKeyLastUsedResolution {
+		filter := bson.M{"_id": keyDoc.ID}
+		update := bson.M{"$set": bson.M{"lastUsedAt": now}}
+
+		if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+			return nil, err
+		}
+
+		keyDoc.LastUsedAt = &now
+	}
+
+	return &keyDoc, nil
+}
+
+func (r *MongoDbApiKeyRepository) DeleteByUser(ctx context.Context, userId string) error {
+	return deleteByUser(ctx, r.getCollection(), "userId", userId)
+}
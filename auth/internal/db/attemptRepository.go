@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"math"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LockoutPolicy controls when repeated failures lock a key and for how long
+type LockoutPolicy struct {
+	// failures before the key is locked
+	Threshold int
+	// lock duration for the first lock, doubled for each further failure
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// LockoutFor returns how long a key is locked after the given number of failures
+func (p LockoutPolicy) LockoutFor(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lockout := float64(p.BaseLockout) * math.Pow(2, float64(failures-p.Threshold))
+
+	if lockout > float64(p.MaxLockout) {
+		return p.MaxLockout
+	}
+
+	return time.Duration(lockout)
+}
+
+type MongoDbAttemptRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewAttemptRepository(client infra.Database, dbName string) *MongoDbAttemptRepository {
+	return &MongoDbAttemptRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbAttemptRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("loginAttempts")
+}
+
+func (r *MongoDbAttemptRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.getCollection()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	return err
+}
+
+// Get returns the attempts for a key, a zero document is returned when there are none
+func (r *MongoDbAttemptRepository) Get(ctx context.Context, key string) (*authModels.AttemptDocument, error) {
+	var attemptDoc authModels.AttemptDocument
+	collection := r.getCollection()
+
+	err := collection.FindOne(ctx, bson.M{"key": key}).Decode(&attemptDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &authModels.AttemptDocument{Key: key}, nil
+		}
+		return nil, err
+	}
+
+	return &attemptDoc, nil
+}
+
+// RecordFailure counts a failure against the key and locks it once the policy threshold is reached
+func (r *MongoDbAttemptRepository) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*authModels.AttemptDocument, error) {
+	var attemptDoc authModels.AttemptDocument
+	collection := r.getCollection()
+
+	now := time.Now()
+
+	filter := bson.M{"key": key}
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailureAt": now, "expiresAt": now.Add(policy.Window)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&attemptDoc); err != nil {
+		return nil, err
+	}
+
+	lockout := policy.LockoutFor(attemptDoc.Failures)
+
+	if lockout == 0 {
+		return &attemptDoc, nil
+	}
+
+	lockedUntil := now.Add(lockout)
+	attemptDoc.LockedUntil = &lockedUntil
+
+	expiresAt := lockedUntil.Add(policy.Window)
+
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lockedUntil": lockedUntil, "expiresAt": expiresAt}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &attemptDoc, nil
+}
+
+// Reset forgets the failures and any lock on a key
+func (r *MongoDbAttemptRepository) Reset(ctx context.Context, key string) error {
+	collection := r.getCollection()
+
+	_, err := collection.DeleteOne(ctx, bson.M{"key": key})
+
+	return err
+}
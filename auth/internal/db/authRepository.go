@@ -2,54 +2,115 @@ package db
 
 import (
 	"context"
-	"os"
-
+	"encoding/binary"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/password"
 	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
-	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+const (
+	usernameIndexName string = "username_unique"
+	emailIndexName    string = "email_unique"
+)
 
-func authenticatePassword(expected string, actual string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(expected), []byte(actual)) == nil
-}
+// caseInsensitive is the collation used by the unique indexes, queries
+// against username or email must use it for the index to be applied
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
 
 type MongoDbAuthRepository struct {
-	client *mongo.Client
+	client infra.Database
+	dbName string
+	hasher password.Hasher
+	// dummyHash is compared against when a user does not exist so a login for an unknown
+	// username takes as long as one with a wrong password
+	dummyHash func() string
 }
 
-func NewAuthRepository(client *mongo.Client) *MongoDbAuthRepository {
+func NewAuthRepository(client infra.Database, dbName string, hasher password.Hasher) *MongoDbAuthRepository {
+	dummyHash := sync.OnceValue(func() string {
+		hash, _ := hasher.Hash("not a real password")
+		return hash
+	})
+
+	// compute the hash now rather than on the first unknown username
+	go dummyHash()
+
 	return &MongoDbAuthRepository{
-		client: client,
+		client:    client,
+		dbName:    dbName,
+		hasher:    hasher,
+		dummyHash: dummyHash,
 	}
 }
 
 func (r *MongoDbAuthRepository) getCollection() *mongo.Collection {
-	dbName := os.Getenv("DbName")
+	return r.client.Database(r.dbName).Collection("users")
+}
+
+// EnsureIndexes creates the unique, case-insensitive indexes on username and email, and the
+// index the purge job finds accounts due for deletion with. It is safe to call on every startup.
+func (r *MongoDbAuthRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.getCollection()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName(usernameIndexName).SetUnique(true).SetCollation(caseInsensitive),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName(emailIndexName).SetUnique(true).SetCollation(caseInsensitive),
+		},
+		{
+			Keys:    bson.D{{Key: "deleteAfter", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
+
+	return err
+}
+
+// mapWriteError converts duplicate key errors raised by the unique indexes to typed errors
+func mapWriteError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
 
-	return r.client.Database(dbName).Collection("users")
+	switch {
+	case strings.Contains(err.Error(), usernameIndexName):
+		return authModels.ErrUsernameTaken
+	case strings.Contains(err.Error(), emailIndexName):
+		return authModels.ErrEmailTaken
+	default:
+		return err
+	}
 }
 
-func (r *MongoDbAuthRepository) Add(ctx context.Context, user *events.CreateUserEvent) (*user.User, error) {
+func (r *MongoDbAuthRepository) Add(ctx context.Context, user *userModels.User, plaintext string) (*userModels.User, error) {
 
-	hash, err := hashPassword(user.Password)
+	hash, err := r.hasher.Hash(plaintext)
 
 	if err != nil {
 		return nil, err
 	}
 
 	userDoc := authModels.UserDocument{
-		Username:  user.Username,
+		Username:  userModels.NormaliseUsername(user.Username),
 		Password:  hash,
-		Email:     user.Email,
+		Email:     userModels.NormaliseEmail(user.Email),
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
 	}
@@ -59,7 +120,7 @@ func (r *MongoDbAuthRepository) Add(ctx context.Context, user *events.CreateUser
 	insertResult, err := collection.InsertOne(ctx, userDoc)
 
 	if err != nil {
-		return nil, err
+		return nil, mapWriteError(err)
 	}
 
 	userModel := userDoc.ToModel()
@@ -69,7 +130,62 @@ func (r *MongoDbAuthRepository) Add(ctx context.Context, user *events.CreateUser
 
 }
 
-func (r *MongoDbAuthRepository) GetById(ctx context.Context, id string) (*user.User, error) {
+// serviceAccountEmailDomain gives service accounts a unique address that can never receive mail
+const serviceAccountEmailDomain string = "service-accounts.invalid"
+
+// AddServiceAccount creates a principal without a password that can only use api keys
+func (r *MongoDbAuthRepository) AddServiceAccount(ctx context.Context, name string, roles []userModels.UserRole) (*userModels.User, error) {
+	username := userModels.NormaliseUsername(name)
+
+	userDoc := authModels.UserDocument{
+		Username:       username,
+		Email:          userModels.NormaliseEmail(username + "@" + serviceAccountEmailDomain),
+		Roles:          roles,
+		ServiceAccount: true,
+	}
+
+	collection := r.getCollection()
+
+	insertResult, err := collection.InsertOne(ctx, userDoc)
+
+	if err != nil {
+		return nil, mapWriteError(err)
+	}
+
+	userDoc.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+	return userDoc.ToModel(), nil
+}
+
+// AddFederated creates a user who logs in with an external provider. They have no local
+// password and their email address was verified by the provider.
+func (r *MongoDbAuthRepository) AddFederated(ctx context.Context, user *userModels.User) (*userModels.User, error) {
+	now := time.Now()
+
+	userDoc := authModels.UserDocument{
+		Username:        userModels.NormaliseUsername(user.Username),
+		Email:           userModels.NormaliseEmail(user.Email),
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		Roles:           user.Roles,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+	}
+
+	collection := r.getCollection()
+
+	insertResult, err := collection.InsertOne(ctx, userDoc)
+
+	if err != nil {
+		return nil, mapWriteError(err)
+	}
+
+	userDoc.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+	return userDoc.ToModel(), nil
+}
+
+func (r *MongoDbAuthRepository) GetById(ctx context.Context, id string) (*userModels.User, error) {
 	objectId, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
@@ -90,34 +206,85 @@ func (r *MongoDbAuthRepository) GetById(ctx context.Context, id string) (*user.U
 	return userDoc.ToModel(), nil
 }
 
-func (r *MongoDbAuthRepository) Authenticate(ctx context.Context, username string, password string) (*user.User, error) {
+func (r *MongoDbAuthRepository) GetByEmail(ctx context.Context, email string) (*userModels.User, error) {
 	var userDoc authModels.UserDocument
 	collection := r.getCollection()
 
-	filter := bson.M{"username": username}
+	filter := bson.M{"email": userModels.NormaliseEmail(email)}
 
-	err := collection.FindOne(ctx, filter).Decode(&userDoc)
+	err := collection.FindOne(ctx, filter, options.FindOne().SetCollation(caseInsensitive)).Decode(&userDoc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	isAuthenticated := authenticatePassword(userDoc.Password, password)
+	return userDoc.ToModel(), nil
+}
+
+func (r *MongoDbAuthRepository) Authenticate(ctx context.Context, username string, plaintext string) (*userModels.User, error) {
+	var userDoc authModels.UserDocument
+	collection := r.getCollection()
+
+	filter := bson.M{"username": userModels.NormaliseUsername(username)}
+
+	err := collection.FindOne(ctx, filter, options.FindOne().SetCollation(caseInsensitive)).Decode(&userDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			r.hasher.Verify(r.dummyHash(), plaintext)
+			return nil, authModels.ErrUnauthenticated
+		}
+		return nil, err
+	}
+
+	// service accounts and federated users without a local password can't log in with one
+	if userDoc.ServiceAccount || userDoc.Password == "" {
+		r.hasher.Verify(r.dummyHash(), plaintext)
+		return nil, authModels.ErrUnauthenticated
+	}
+
+	isAuthenticated, err := r.hasher.Verify(userDoc.Password, plaintext)
+
+	if err != nil {
+		return nil, err
+	}
 
 	if !isAuthenticated {
 		return nil, authModels.ErrUnauthenticated
 	}
 
+	if r.hasher.NeedsRehash(userDoc.Password) {
+		r.rehash(ctx, userDoc.ID, userDoc.Password, plaintext)
+	}
+
 	return userDoc.ToModel(), nil
 
 }
 
+// rehash replaces an outdated hash after a successful login. The update only applies if
+// the stored hash is unchanged so a concurrent password change is never overwritten.
+func (r *MongoDbAuthRepository) rehash(ctx context.Context, id primitive.ObjectID, oldHash string, plaintext string) {
+	hash, err := r.hasher.Hash(plaintext)
+
+	if err != nil {
+		log.Printf("Failed to rehash password: %s\n", err)
+		return
+	}
+
+	filter := bson.M{"_id": id, "password": oldHash}
+	update := bson.M{"$set": bson.M{"password": hash}}
+
+	if _, err := r.getCollection().UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("Failed to store rehashed password: %s\n", err)
+	}
+}
+
 func (r *MongoDbAuthRepository) ValidateUsernameUnique(ctx context.Context, username string) (bool, error) {
 	collection := r.getCollection()
 
-	filter := bson.M{"username": username}
+	filter := bson.M{"username": userModels.NormaliseUsername(username)}
 
-	count, err := collection.CountDocuments(ctx, filter)
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetCollation(caseInsensitive))
 
 	if err != nil {
 		return false, err
@@ -138,13 +305,13 @@ func (r *MongoDbAuthRepository) Update(ctx context.Context, id string, updateDat
 	updateFields := bson.M{}
 
 	if updateData.FirstName != "" {
-		updateFields["FirstName"] = updateData.FirstName
+		updateFields["firstname"] = updateData.FirstName
 	}
 	if updateData.LastName != "" {
-		updateFields["LastName"] = updateData.LastName
+		updateFields["lastname"] = updateData.LastName
 	}
 	if updateData.Username != "" {
-		updateFields["Username"] = updateData.Username
+		updateFields["username"] = userModels.NormaliseUsername(updateData.Username)
 	}
 
 	filter := bson.M{"_id": objectId}
@@ -152,9 +319,538 @@ func (r *MongoDbAuthRepository) Update(ctx context.Context, id string, updateDat
 
 	_, err = collection.UpdateOne(ctx, filter, update)
 
+	if err != nil {
+		return mapWriteError(err)
+	}
+
+	return nil
+}
+
+func (r *MongoDbAuthRepository) SetPassword(ctx context.Context, id string, plaintext string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := r.hasher.Hash(plaintext)
+
 	if err != nil {
 		return err
 	}
 
+	collection := r.getCollection()
+
+	filter := bson.M{"_id": objectId}
+	update := bson.M{"$set": bson.M{"password": hash}, "$unset": bson.M{"passwordResetRequired": ""}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (r *MongoDbAuthRepository) MarkEmailVerified(ctx context.Context, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	collection := r.getCollection()
+
+	filter := bson.M{"_id": objectId}
+	update := bson.M{"$set": bson.M{"emailVerified": true, "emailVerifiedAt": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (r *MongoDbAuthRepository) SetRoles(ctx context.Context, id string, roles []userModels.UserRole) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	collection := r.getCollection()
+
+	filter := bson.M{"_id": objectId}
+	update := bson.M{"$set": bson.M{"roles": roles}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (r *MongoDbAuthRepository) GetMfaSettings(ctx context.Context, id string) (*authModels.MfaSettings, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var userDoc authModels.UserDocument
+	collection := r.getCollection()
+
+	filter := bson.M{"_id": objectId}
+	projection := bson.M{"mfa": 1}
+
+	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&userDoc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &userDoc.Mfa, nil
+}
+
+func (r *MongoDbAuthRepository) SetPendingMfaSecret(ctx context.Context, id string, secret string) error {
+	return r.updateMfa(ctx, id, bson.M{}, bson.M{"$set": bson.M{"mfa.pendingSecret": secret}})
+}
+
+func (r *MongoDbAuthRepository) EnableMfa(ctx context.Context, id string, secret string, recoveryCodeHashes []string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"mfa.enabled":       true,
+			"mfa.secret":        secret,
+			"mfa.recoveryCodes": recoveryCodeHashes,
+		},
+		"$unset": bson.M{"mfa.pendingSecret": "", "mfa.lastStep": ""},
+	}
+
+	// only the secret that is pending can be enabled, so a concurrent re-enrolment wins
+	return r.updateMfa(ctx, id, bson.M{"mfa.pendingSecret": secret}, update)
+}
+
+func (r *MongoDbAuthRepository) DisableMfa(ctx context.Context, id string) error {
+	return r.updateMfa(ctx, id, bson.M{}, bson.M{"$set": bson.M{"mfa": authModels.MfaSettings{}}})
+}
+
+// UseMfaStep records a time step as used, false is returned if it or a later step was already used
+func (r *MongoDbAuthRepository) UseMfaStep(ctx context.Context, id string, step int64) (bool, error) {
+	err := r.updateMfa(ctx, id, bson.M{"$or": bson.A{
+		bson.M{"mfa.lastStep": bson.M{"$exists": false}},
+		bson.M{"mfa.lastStep": bson.M{"$lt": step}},
+	}}, bson.M{"$set": bson.M{"mfa.lastStep": step}})
+
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// UseRecoveryCode removes a recovery code, false is returned if it was not found
+func (r *MongoDbAuthRepository) UseRecoveryCode(ctx context.Context, id string, recoveryCodeHash string) (bool, error) {
+	err := r.updateMfa(ctx, id, bson.M{"mfa.recoveryCodes": recoveryCodeHash}, bson.M{"$pull": bson.M{"mfa.recoveryCodes": recoveryCodeHash}})
+
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// updateMfa applies an update to a user matching the extra filter, returning
+// mongo.ErrNoDocuments when nothing matched
+func (r *MongoDbAuthRepository) updateMfa(ctx context.Context, id string, filter bson.M, update bson.M) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	collection := r.getCollection()
+
+	filter["_id"] = objectId
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ScheduleDeletion marks the account for deletion after the grace period
+func (r *MongoDbAuthRepository) ScheduleDeletion(ctx context.Context, id string, deleteAfter time.Time) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId, "anonymisedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"deletionRequestedAt": time.Now(), "deleteAfter": deleteAfter}}
+
+	result, err := r.getCollection().UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// CancelDeletion restores an account scheduled for deletion, unless a purge has already started
+func (r *MongoDbAuthRepository) CancelDeletion(ctx context.Context, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":            objectId,
+		"deleteAfter":    bson.M{"$exists": true},
+		"purgeClaimedAt": bson.M{"$exists": false},
+	}
+	update := bson.M{"$unset": bson.M{"deletionRequestedAt": "", "deleteAfter": ""}}
+
+	result, err := r.getCollection().UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ClaimDueDeletion takes one account whose grace period has ended for purging. A claim that is
+// older than the lease is assumed to belong to an instance that stopped and is taken over, as is
+// a claim made in an earlier leader term than fence. nil is returned when no account is due.
+func (r *MongoDbAuthRepository) ClaimDueDeletion(ctx context.Context, lease time.Duration, fence uint64) (*userModels.User, error) {
+	var userDoc authModels.UserDocument
+
+	now := time.Now()
+
+	filter := bson.M{
+		"deleteAfter": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"purgeClaimedAt": bson.M{"$exists": false}},
+			bson.M{"purgeClaimedAt": bson.M{"$lt": now.Add(-lease)}},
+			bson.M{"purgeFence": bson.M{"$lt": int64(fence)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"purgeClaimedAt": now, "purgeFence": int64(fence)}}
+
+	err := r.getCollection().FindOneAndUpdate(ctx, filter, update).Decode(&userDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return userDoc.ToModel(), nil
+}
+
+// RenewPurgeClaim checks the account is still claimed with fence and restarts its lease, otherwise
+// ErrPurgeClaimLost is returned. The purger calls it before each step so an instance that has
+// lost the claim stops before erasing anything else.
+func (r *MongoDbAuthRepository) RenewPurgeClaim(ctx context.Context, id string, fence uint64) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId, "purgeFence": int64(fence)}
+	update := bson.M{"$set": bson.M{"purgeClaimedAt": time.Now()}}
+
+	result, err := r.getCollection().UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return authModels.ErrPurgeClaimLost
+	}
+
+	return nil
+}
+
+// anonymisedEmailDomain gives anonymised accounts a unique address that can never receive mail
+const anonymisedEmailDomain string = "deleted.invalid"
+
+// Anonymise strips everything personal from an account and leaves a tombstone that keeps the id,
+// so records elsewhere that refer to it still resolve to a deleted user. The account must still
+// be claimed with fence, otherwise ErrPurgeClaimLost is returned.
+func (r *MongoDbAuthRepository) Anonymise(ctx context.Context, id string, fence uint64) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"username":      "deleted-" + id,
+			"email":         id + "@" + anonymisedEmailDomain,
+			"firstname":     "",
+			"lastname":      "",
+			"password":      "",
+			"emailVerified": false,
+			"mfa":           authModels.MfaSettings{},
+			"anonymisedAt":  time.Now(),
+		},
+		"$unset": bson.M{
+			"roles":               "",
+			"emailVerifiedAt":     "",
+			"deletionRequestedAt": "",
+			"deleteAfter":         "",
+			"purgeClaimedAt":      "",
+			"purgeFence":          "",
+		},
+	}
+
+	result, err := r.getCollection().UpdateOne(ctx, bson.M{"_id": objectId, "purgeFence": int64(fence)}, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return authModels.ErrPurgeClaimLost
+	}
+
+	return nil
+}
+
+// Delete removes an account that is still claimed with fence, otherwise ErrPurgeClaimLost is
+// returned
+func (r *MongoDbAuthRepository) Delete(ctx context.Context, id string, fence uint64) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := r.getCollection().DeleteOne(ctx, bson.M{"_id": objectId, "purgeFence": int64(fence)})
+
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return authModels.ErrPurgeClaimLost
+	}
+
+	return nil
+}
+
+type UserStatus string
+
+const (
+	UserStatusActive          UserStatus = "active"
+	UserStatusDisabled        UserStatus = "disabled"
+	UserStatusUnverified      UserStatus = "unverified"
+	UserStatusPendingDeletion UserStatus = "pending_deletion"
+)
+
+// UserFilter selects users for List, zero values match everything
+type UserFilter struct {
+	Role          userModels.UserRole
+	Status        UserStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// part of the username or email, ignoring case
+	Search string
+	// id of the last user of the previous page
+	After string
+	Limit int64
+}
+
+// objectIdAt is the lowest object id created at t, the rest of an id after the timestamp is random
+func objectIdAt(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	return id
+}
+
+func (f *UserFilter) query() (bson.M, error) {
+	// anonymised tombstones are not users any more
+	query := bson.M{"anonymisedAt": bson.M{"$exists": false}}
+
+	if f.Role != "" {
+		query["roles"] = f.Role
+	}
+
+	switch f.Status {
+	case "":
+	case UserStatusActive:
+		query["disabled"] = bson.M{"$ne": true}
+		query["deleteAfter"] = bson.M{"$exists": false}
+	case UserStatusDisabled:
+		query["disabled"] = true
+	case UserStatusUnverified:
+		// accounts created before verification was added have no emailVerified field
+		query["emailVerified"] = bson.M{"$ne": true}
+		query["serviceAccount"] = bson.M{"$ne": true}
+	case UserStatusPendingDeletion:
+		query["deleteAfter"] = bson.M{"$exists": true}
+	default:
+		return nil, fmt.Errorf("unknown user status %q", f.Status)
+	}
+
+	// ids start with their creation time so the date filters are ranges over _id
+	created := bson.M{}
+
+	if f.CreatedAfter != nil {
+		created["$gte"] = objectIdAt(*f.CreatedAfter)
+	}
+
+	if f.CreatedBefore != nil {
+		created["$lt"] = objectIdAt(*f.CreatedBefore)
+	}
+
+	if len(created) > 0 {
+		query["_id"] = created
+	}
+
+	if f.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+		query["$or"] = bson.A{bson.M{"username": pattern}, bson.M{"email": pattern}}
+	}
+
+	return query, nil
+}
+
+// List returns a page of the users matching the filter newest first, along with how many
+// match across all pages
+func (r *MongoDbAuthRepository) List(ctx context.Context, filter UserFilter) ([]*userModels.User, int64, error) {
+	var users []*userModels.User = []*userModels.User{}
+
+	query, err := filter.query()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	collection := r.getCollection()
+
+	total, err := collection.CountDocuments(ctx, query)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if filter.After != "" {
+		afterOid, err := primitive.ObjectIDFromHex(filter.After)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// the page cursor narrows the creation date range, it does not replace it
+		query = bson.M{"$and": bson.A{query, bson.M{"_id": bson.M{"$lt": afterOid}}}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(filter.Limit)
+
+	cursor, err := collection.Find(ctx, query, opts)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var userDocs []authModels.UserDocument
+
+	if err := cursor.All(ctx, &userDocs); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range userDocs {
+		users = append(users, userDocs[i].ToModel())
+	}
+
+	return users, total, nil
+}
+
+// SetDisabled disables or re-enables an account, the reason is dropped on enable
+func (r *MongoDbAuthRepository) SetDisabled(ctx context.Context, id string, disabled bool, reason string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$unset": bson.M{"disabled": "", "disabledAt": "", "disabledReason": ""}}
+
+	if disabled {
+		update = bson.M{"$set": bson.M{"disabled": true, "disabledAt": time.Now(), "disabledReason": reason}}
+	}
+
+	result, err := r.getCollection().UpdateOne(ctx, bson.M{"_id": objectId}, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RequirePasswordReset stops the user logging in until SetPassword is next called
+func (r *MongoDbAuthRepository) RequirePasswordReset(ctx context.Context, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := r.getCollection().UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{"$set": bson.M{"passwordResetRequired": true}})
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
 	return nil
 }
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// deleteByUser removes every document in the collection that refers to the user through field,
+// it is used to erase a user's data when their account is purged
+func deleteByUser(ctx context.Context, collection *mongo.Collection, field string, userId string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{field: userOid})
+
+	return err
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDbFederationRepository stores pending external logins and the external identities linked to users
+type MongoDbFederationRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewFederationRepository(client infra.Database, dbName string) *MongoDbFederationRepository {
+	return &MongoDbFederationRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbFederationRepository) getCollection(name string) *mongo.Collection {
+	return r.client.Database(r.dbName).Collection(name)
+}
+
+func (r *MongoDbFederationRepository) states() *mongo.Collection {
+	return r.getCollection("federationStates")
+}
+
+func (r *MongoDbFederationRepository) identities() *mongo.Collection {
+	return r.getCollection("federatedIdentities")
+}
+
+// EnsureIndexes makes an external identity linkable to one user only and expires abandoned logins
+func (r *MongoDbFederationRepository) EnsureIndexes(ctx context.Context) error {
+	if _, err := r.states().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return err
+	}
+
+	_, err := r.identities().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}},
+		},
+	})
+
+	return err
+}
+
+// CreateState stores a pending login and returns the state parameter to send to the provider.
+// The login is bound to the browser that started it by the hash of binding.
+func (r *MongoDbFederationRepository) CreateState(ctx context.Context, stateDoc *authModels.FederationStateDocument, binding string) (string, error) {
+	state, err := generateToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	stateDoc.ID = primitive.NewObjectID()
+	stateDoc.Hash = hashToken(state)
+	stateDoc.BindingHash = hashToken(binding)
+
+	if _, err := r.states().InsertOne(ctx, stateDoc); err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// ConsumeState removes and returns a pending login so each callback can only be completed once,
+// and only by the browser that started it
+func (r *MongoDbFederationRepository) ConsumeState(ctx context.Context, provider string, state string, binding string) (*authModels.FederationStateDocument, error) {
+	var stateDoc authModels.FederationStateDocument
+
+	filter := bson.M{
+		"hash":        hashToken(state),
+		"bindingHash": hashToken(binding),
+		"provider":    provider,
+		"expiresAt":   bson.M{"$gt": time.Now()},
+	}
+
+	err := r.states().FindOneAndDelete(ctx, filter).Decode(&stateDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, authModels.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &stateDoc, nil
+}
+
+func (r *MongoDbFederationRepository) GetIdentity(ctx context.Context, provider string, subject string) (*authModels.FederatedIdentityDocument, error) {
+	var identityDoc authModels.FederatedIdentityDocument
+
+	err := r.identities().FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&identityDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, authModels.ErrIdentityNotLinked
+		}
+		return nil, err
+	}
+
+	return &identityDoc, nil
+}
+
+func (r *MongoDbFederationRepository) LinkIdentity(ctx context.Context, identityDoc *authModels.FederatedIdentityDocument) error {
+	now := time.Now()
+
+	identityDoc.ID = primitive.NewObjectID()
+	identityDoc.LinkedAt = now
+	identityDoc.LastLoginAt = now
+
+	_, err := r.identities().InsertOne(ctx, identityDoc)
+
+	return err
+}
+
+// TouchIdentity records a login and the email address the provider currently reports
+func (r *MongoDbFederationRepository) TouchIdentity(ctx context.Context, id primitive.ObjectID, email string) error {
+	update := bson.M{"$set": bson.M{"lastLoginAt": time.Now(), "email": email}}
+
+	_, err := r.identities().UpdateOne(ctx, bson.M{"_id": id}, update)
+
+	return err
+}
+
+func (r *MongoDbFederationRepository) ListByUser(ctx context.Context, userId string) ([]authModels.FederatedIdentityDocument, error) {
+	var identities []authModels.FederatedIdentityDocument = []authModels.FederatedIdentityDocument{}
+
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.identities().Find(ctx, bson.M{"userId": userOid}, options.Find().SetSort(bson.D{{Key: "linkedAt", Value: 1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (r *MongoDbFederationRepository) DeleteByUser(ctx context.Context, userId string) error {
+	return deleteByUser(ctx, r.identities(), "userId", userId)
+}
@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func generateClientId() (string, error) {
+	bytes := make([]byte, 16)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// HashClientSecret is the form a client secret is stored and compared in
+func HashClientSecret(secret string) string {
+	return hashToken(secret)
+}
+
+// MongoDbOAuthRepository stores oauth clients, consents and authorization codes
+type MongoDbOAuthRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewOAuthRepository(client infra.Database, dbName string) *MongoDbOAuthRepository {
+	return &MongoDbOAuthRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbOAuthRepository) getCollection(name string) *mongo.Collection {
+	return r.client.Database(r.dbName).Collection(name)
+}
+
+func (r *MongoDbOAuthRepository) clients() *mongo.Collection {
+	return r.getCollection("oauthClients")
+}
+
+func (r *MongoDbOAuthRepository) consents() *mongo.Collection {
+	return r.getCollection("oauthConsents")
+}
+
+func (r *MongoDbOAuthRepository) codes() *mongo.Collection {
+	return r.getCollection("oauthCodes")
+}
+
+// EnsureIndexes creates the lookup indexes and a TTL index so expired codes are removed by mongo
+func (r *MongoDbOAuthRepository) EnsureIndexes(ctx context.Context) error {
+	if _, err := r.clients().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "clientId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := r.consents().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "clientId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	_, err := r.codes().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	return err
+}
+
+// CreateClient registers a client, the generated secret is returned for confidential clients
+func (r *MongoDbOAuthRepository) CreateClient(ctx context.Context, clientDoc *authModels.OAuthClientDocument) (string, error) {
+	clientId, err := generateClientId()
+
+	if err != nil {
+		return "", err
+	}
+
+	clientDoc.ID = primitive.NewObjectID()
+	clientDoc.ClientID = clientId
+
+	var secret string
+
+	if !clientDoc.Public {
+		secret, err = generateToken()
+
+		if err != nil {
+			return "", err
+		}
+
+		clientDoc.SecretHash = HashClientSecret(secret)
+	}
+
+	if _, err := r.clients().InsertOne(ctx, clientDoc); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (r *MongoDbOAuthRepository) GetClient(ctx context.Context, clientId string) (*authModels.OAuthClientDocument, error) {
+	var clientDoc authModels.OAuthClientDocument
+
+	err := r.clients().FindOne(ctx, bson.M{"clientId": clientId}).Decode(&clientDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, authModels.ErrClientNotFound
+		}
+		return nil, err
+	}
+
+	return &clientDoc, nil
+}
+
+// GetConsent returns the scopes the user has granted the client, nil when there is no consent
+func (r *MongoDbOAuthRepository) GetConsent(ctx context.Context, userId string, clientId string) (*authModels.ConsentDocument, error) {
+	var consentDoc authModels.ConsentDocument
+
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.consents().FindOne(ctx, bson.M{"userId": userOid, "clientId": clientId}).Decode(&consentDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &consentDoc, nil
+}
+
+// GrantConsent adds scopes to the user's consent for the client
+func (r *MongoDbOAuthRepository) GrantConsent(ctx context.Context, userId string, clientId string, scopes []string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"userId": userOid, "clientId": clientId}
+	update := bson.M{
+		"$addToSet": bson.M{"scopes": bson.M{"$each": scopes}},
+		"$set":      bson.M{"grantedAt": time.Now()},
+	}
+
+	_, err = r.consents().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (r *MongoDbOAuthRepository) ListConsents(ctx context.Context, userId string) ([]authModels.ConsentDocument, error) {
+	var consents []authModels.ConsentDocument = []authModels.ConsentDocument{}
+
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.consents().Find(ctx, bson.M{"userId": userOid})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &consents); err != nil {
+		return nil, err
+	}
+
+	return consents, nil
+}
+
+func (r *MongoDbOAuthRepository) RevokeConsent(ctx context.Context, userId string, clientId string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := r.consents().DeleteOne(ctx, bson.M{"userId": userOid, "clientId": clientId})
+
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	// outstanding codes would otherwise still be redeemable
+	_, err = r.codes().DeleteMany(ctx, bson.M{"userId": userOid, "clientId": clientId, "usedAt": bson.M{"$exists": false}})
+
+	return err
+}
+
+// CreateCode stores an authorization code and returns the plain code
+func (r *MongoDbOAuthRepository) CreateCode(ctx context.Context, codeDoc *authModels.AuthorizationCodeDocument) (string, error) {
+	code, err := generateToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	codeDoc.Hash = hashToken(code)
+
+	if _, err := r.codes().InsertOne(ctx, codeDoc); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ConsumeCode marks an unused, unexpired code as used and returns it.
+// The check and the update are a single operation so a code can only be redeemed once.
+func (r *MongoDbOAuthRepository) ConsumeCode(ctx context.Context, code string) (*authModels.AuthorizationCodeDocument, error) {
+	var codeDoc authModels.AuthorizationCodeDocument
+
+	now := time.Now()
+
+	filter := bson.M{
+		"hash":      hashToken(code),
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"usedAt": now}}
+
+	err := r.codes().FindOneAndUpdate(ctx, filter, update).Decode(&codeDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, authModels.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &codeDoc, nil
+}
+
+func (r *MongoDbOAuthRepository) ListClientsByOwner(ctx context.Context, ownerId string) ([]authModels.OAuthClientDocument, error) {
+	var clients []authModels.OAuthClientDocument = []authModels.OAuthClientDocument{}
+
+	ownerOid, err := primitive.ObjectIDFromHex(ownerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.clients().Find(ctx, bson.M{"ownerId": ownerOid}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteByUser removes the user's consents and codes and the clients they registered
+func (r *MongoDbOAuthRepository) DeleteByUser(ctx context.Context, userId string) error {
+	if err := deleteByUser(ctx, r.consents(), "userId", userId); err != nil {
+		return err
+	}
+
+	if err := deleteByUser(ctx, r.codes(), "userId", userId); err != nil {
+		return err
+	}
+
+	return deleteByUser(ctx, r.clients(), "ownerId", userId)
+}
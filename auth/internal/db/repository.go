@@ -2,15 +2,119 @@ package db
 
 import (
 	"context"
+	"time"
 
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthRepository interface {
-	Add(ctx context.Context, user *events.CreateUserEvent) (*user.User, error)
+	EnsureIndexes(ctx context.Context) error
+	Add(ctx context.Context, user *user.User, password string) (*user.User, error)
+	AddServiceAccount(ctx context.Context, name string, roles []user.UserRole) (*user.User, error)
+	AddFederated(ctx context.Context, user *user.User) (*user.User, error)
 	GetById(ctx context.Context, id string) (*user.User, error)
+	GetByEmail(ctx context.Context, email string) (*user.User, error)
 	Authenticate(ctx context.Context, username string, password string) (*user.User, error)
 	ValidateUsernameUnique(ctx context.Context, username string) (bool, error)
 	Update(ctx context.Context, id string, updateData *events.UpdateUserEventData) error
+	SetPassword(ctx context.Context, id string, password string) error
+	MarkEmailVerified(ctx context.Context, id string) error
+	SetRoles(ctx context.Context, id string, roles []user.UserRole) error
+	GetMfaSettings(ctx context.Context, id string) (*authModels.MfaSettings, error)
+	SetPendingMfaSecret(ctx context.Context, id string, secret string) error
+	EnableMfa(ctx context.Context, id string, secret string, recoveryCodeHashes []string) error
+	DisableMfa(ctx context.Context, id string) error
+	UseMfaStep(ctx context.Context, id string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, id string, recoveryCodeHash string) (bool, error)
+	ScheduleDeletion(ctx context.Context, id string, deleteAfter time.Time) error
+	CancelDeletion(ctx context.Context, id string) error
+	ClaimDueDeletion(ctx context.Context, lease time.Duration, fence uint64) (*user.User, error)
+	RenewPurgeClaim(ctx context.Context, id string, fence uint64) error
+	Anonymise(ctx context.Context, id string, fence uint64) error
+	Delete(ctx context.Context, id string, fence uint64) error
+	List(ctx context.Context, filter UserFilter) ([]*user.User, int64, error)
+	SetDisabled(ctx context.Context, id string, disabled bool, reason string) error
+	RequirePasswordReset(ctx context.Context, id string) error
+}
+
+type RoleRepository interface {
+	GetMfaRequiredRoles(ctx context.Context) ([]user.UserRole, error)
+	SetMfaRequired(ctx context.Context, role user.UserRole, required bool) error
+}
+
+type TokenRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	Create(ctx context.Context, userId string, purpose authModels.TokenPurpose, ttl time.Duration) (string, error)
+	Consume(ctx context.Context, token string, purpose authModels.TokenPurpose) (string, error)
+	Lookup(ctx context.Context, token string, purpose authModels.TokenPurpose) (string, error)
+	Attempt(ctx context.Context, token string, purpose authModels.TokenPurpose, maxAttempts int) (string, error)
+	RevokeAll(ctx context.Context, userId string, purpose authModels.TokenPurpose) error
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type AttemptRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	Get(ctx context.Context, key string) (*authModels.AttemptDocument, error)
+	RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*authModels.AttemptDocument, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type ApiKeyRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	Create(ctx context.Context, userId string, name string, scopes []user.UserRole, expiresAt *time.Time) (*authModels.ApiKeyDocument, string, error)
+	ListByUser(ctx context.Context, userId string) ([]authModels.ApiKeyDocument, error)
+	Revoke(ctx context.Context, userId string, keyId string) error
+	Authenticate(ctx context.Context, key string) (*authModels.ApiKeyDocument, error)
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type OAuthRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	CreateClient(ctx context.Context, client *authModels.OAuthClientDocument) (string, error)
+	GetClient(ctx context.Context, clientId string) (*authModels.OAuthClientDocument, error)
+	GetConsent(ctx context.Context, userId string, clientId string) (*authModels.ConsentDocument, error)
+	GrantConsent(ctx context.Context, userId string, clientId string, scopes []string) error
+	ListConsents(ctx context.Context, userId string) ([]authModels.ConsentDocument, error)
+	RevokeConsent(ctx context.Context, userId string, clientId string) error
+	CreateCode(ctx context.Context, code *authModels.AuthorizationCodeDocument) (string, error)
+	ConsumeCode(ctx context.Context, code string) (*authModels.AuthorizationCodeDocument, error)
+	ListClientsByOwner(ctx context.Context, ownerId string) ([]authModels.OAuthClientDocument, error)
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type SigningKeyRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	List(ctx context.Context) ([]authModels.SigningKeyDocument, error)
+	Create(ctx context.Context, key *authModels.SigningKeyDocument) error
+}
+
+type SessionRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	Create(ctx context.Context, session *authModels.SessionDocument) (string, error)
+	Refresh(ctx context.Context, refreshToken string, ip string, userAgent string) (*authModels.SessionDocument, string, error)
+	ListActive(ctx context.Context, userId string) ([]authModels.SessionDocument, error)
+	Revoke(ctx context.Context, userId string, sessionId string) error
+	RevokeAll(ctx context.Context, userId string) error
+	KnownDevices(ctx context.Context, userId string) ([]string, error)
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type AdminActionRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	Record(ctx context.Context, action *authModels.AdminActionDocument) error
+	ListByTarget(ctx context.Context, targetId string) ([]authModels.AdminActionDocument, error)
+}
+
+type FederationRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	CreateState(ctx context.Context, state *authModels.FederationStateDocument, binding string) (string, error)
+	ConsumeState(ctx context.Context, provider string, state string, binding string) (*authModels.FederationStateDocument, error)
+	GetIdentity(ctx context.Context, provider string, subject string) (*authModels.FederatedIdentityDocument, error)
+	LinkIdentity(ctx context.Context, identity *authModels.FederatedIdentityDocument) error
+	TouchIdentity(ctx context.Context, id primitive.ObjectID, email string) error
+	ListByUser(ctx context.Context, userId string) ([]authModels.FederatedIdentityDocument, error)
+	DeleteByUser(ctx context.Context, userId string) error
 }
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoDbRoleRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewRoleRepository(client infra.Database, dbName string) *MongoDbRoleRepository {
+	return &MongoDbRoleRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbRoleRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("roles")
+}
+
+func (r *MongoDbRoleRepository) GetMfaRequiredRoles(ctx context.Context) ([]user.UserRole, error) {
+	var roles []user.UserRole = []user.UserRole{}
+
+	collection := r.getCollection()
+
+	cursor, err := collection.Find(ctx, bson.M{"mfaRequired": true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var roleDoc authModels.RoleDocument
+
+		if err := cursor.Decode(&roleDoc); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, roleDoc.Role)
+	}
+
+	return roles, nil
+}
+
+func (r *MongoDbRoleRepository) SetMfaRequired(ctx context.Context, role user.UserRole, required bool) error {
+	collection := r.getCollection()
+
+	filter := bson.M{"role": role}
+	update := bson.M{"$set": bson.M{"mfaRequired": required}}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	return err
+}
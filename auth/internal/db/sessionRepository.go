@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoDbSessionRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewSessionRepository(client infra.Database, dbName string) *MongoDbSessionRepository {
+	return &MongoDbSessionRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbSessionRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("sessions")
+}
+
+// EnsureIndexes creates the lookup indexes and a TTL index so expired sessions are removed by mongo
+func (r *MongoDbSessionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.getCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "refreshHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "previousRefreshHash", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "device", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	return err
+}
+
+// Create stores a new session and returns its first refresh token
+func (r *MongoDbSessionRepository) Create(ctx context.Context, sessionDoc *authModels.SessionDocument) (string, error) {
+	refreshToken, err := generateToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	sessionDoc.ID = primitive.NewObjectID()
+	sessionDoc.RefreshHash = hashToken(refreshToken)
+	sessionDoc.CreatedAt = now
+	sessionDoc.LastSeenAt = now
+
+	if _, err := r.getCollection().InsertOne(ctx, sessionDoc); err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// Refresh exchanges a refresh token for a new one and records the client as last seen.
+// A token that was already exchanged revokes the session, as only a copy of it can be presented again.
+func (r *MongoDbSessionRepository) Refresh(ctx context.Context, refreshToken string, ip string, userAgent string) (*authModels.SessionDocument, string, error) {
+	var sessionDoc authModels.SessionDocument
+
+	newRefreshToken, err := generateToken()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	hash := hashToken(refreshToken)
+
+	filter := bson.M{
+		"refreshHash": hash,
+		"revokedAt":   bson.M{"$exists": false},
+		"expiresAt":   bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{
+		"refreshHash":         hashToken(newRefreshToken),
+		"previousRefreshHash": hash,
+		"lastSeenAt":          now,
+		"ip":                  ip,
+		"userAgent":           userAgent,
+	}}
+
+	err = r.getCollection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&sessionDoc)
+
+	if err == nil {
+		return &sessionDoc, newRefreshToken, nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return nil, "", err
+	}
+
+	reuseFilter := bson.M{"previousRefreshHash": hash, "revokedAt": bson.M{"$exists": false}}
+
+	if _, err := r.getCollection().UpdateOne(ctx, reuseFilter, bson.M{"$set": bson.M{"revokedAt": now}}); err != nil {
+		return nil, "", err
+	}
+
+	return nil, "", authModels.ErrInvalidToken
+}
+
+// ListActive returns the sessions of a user that have not expired or been revoked, most recently used first
+func (r *MongoDbSessionRepository) ListActive(ctx context.Context, userId string) ([]authModels.SessionDocument, error) {
+	var sessions []authModels.SessionDocument = []authModels.SessionDocument{}
+
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"userId":    userOid,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	cursor, err := r.getCollection().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke ends a session of the user, it can no longer be refreshed
+func (r *MongoDbSessionRepository) Revoke(ctx context.Context, userId string, sessionId string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	sessionOid, err := primitive.ObjectIDFromHex(sessionId)
+
+	if err != nil {
+		return authModels.ErrSessionNotFound
+	}
+
+	filter := bson.M{"_id": sessionOid, "userId": userOid, "revokedAt": bson.M{"$exists": false}}
+
+	result, err := r.getCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return authModels.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll ends every session of the user
+func (r *MongoDbSessionRepository) RevokeAll(ctx context.Context, userId string) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"userId": userOid, "revokedAt": bson.M{"$exists": false}}
+
+	_, err = r.getCollection().UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+
+	return err
+}
+
+// KnownDevices returns the devices the user has had a session on that mongo has not expired yet
+func (r *MongoDbSessionRepository) KnownDevices(ctx context.Context, userId string) ([]string, error) {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.getCollection().Distinct(ctx, "device", bson.M{"userId": userOid})
+
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []string{}
+
+	for _, value := range values {
+		if device, ok := value.(string); ok {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, nil
+}
+
+func (r *MongoDbSessionRepository) DeleteByUser(ctx context.Context, userId string) error {
+	return deleteByUser(ctx, r.getCollection(), "userId", userId)
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoDbSigningKeyRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewSigningKeyRepository(client infra.Database, dbName string) *MongoDbSigningKeyRepository {
+	return &MongoDbSigningKeyRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbSigningKeyRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("signingKeys")
+}
+
+func (r *MongoDbSigningKeyRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "kid", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	return err
+}
+
+// List returns every signing key, newest first
+func (r *MongoDbSigningKeyRepository) List(ctx context.Context) ([]authModels.SigningKeyDocument, error) {
+	var keys []authModels.SigningKeyDocument = []authModels.SigningKeyDocument{}
+
+	cursor, err := r.getCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (r *MongoDbSigningKeyRepository) Create(ctx context.Context, key *authModels.SigningKeyDocument) error {
+	_, err := r.getCollection().InsertOne(ctx, key)
+
+	return err
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type MongoDbTokenRepository struct {
+	client infra.Database
+	dbName string
+}
+
+func NewTokenRepository(client infra.Database, dbName string) *MongoDbTokenRepository {
+	return &MongoDbTokenRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *MongoDbTokenRepository) getCollection() *mongo.Collection {
+	return r.client.Database(r.dbName).Collection("tokens")
+}
+
+// EnsureIndexes creates the lookup index and a TTL index so expired tokens are removed by mongo
+func (r *MongoDbTokenRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.getCollection()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	return err
+}
+
+// Create issues a new token for the user, the plain token is returned and only its hash is stored
+func (r *MongoDbTokenRepository) Create(ctx context.Context, userId string, purpose authModels.TokenPurpose, ttl time.Duration) (string, error) {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return "", err
+	}
+
+	token, err := generateToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	tokenDoc := authModels.TokenDocument{
+		Hash:      hashToken(token),
+		UserID:    userOid,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	collection := r.getCollection()
+
+	if _, err := collection.InsertOne(ctx, tokenDoc); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Consume marks an unused, unexpired token as used and returns the user it was issued to.
+// The check and the update are a single operation so a token can only be consumed once.
+func (r *MongoDbTokenRepository) Consume(ctx context.Context, token string, purpose authModels.TokenPurpose) (string, error) {
+	var tokenDoc authModels.TokenDocument
+	collection := r.getCollection()
+
+	now := time.Now()
+
+	filter := bson.M{
+		"hash":      hashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"usedAt": now}}
+
+	err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&tokenDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", authModels.ErrInvalidToken
+		}
+		return "", err
+	}
+
+	return tokenDoc.UserID.Hex(), nil
+}
+
+// Lookup returns the user an unused, unexpired token was issued to without consuming it
+func (r *MongoDbTokenRepository) Lookup(ctx context.Context, token string, purpose authModels.TokenPurpose) (string, error) {
+	var tokenDoc authModels.TokenDocument
+	collection := r.getCollection()
+
+	filter := bson.M{
+		"hash":      hashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	err := collection.FindOne(ctx, filter).Decode(&tokenDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", authModels.ErrInvalidToken
+		}
+		return "", err
+	}
+
+	return tokenDoc.UserID.Hex(), nil
+}
+
+// Attempt counts a use of an unused, unexpired token without consuming it and returns
+// the user it was issued to. Once maxAttempts is reached the token is treated as invalid.
+func (r *MongoDbTokenRepository) Attempt(ctx context.Context, token string, purpose authModels.TokenPurpose, maxAttempts int) (string, error) {
+	var tokenDoc authModels.TokenDocument
+	collection := r.getCollection()
+
+	filter := bson.M{
+		"hash":      hashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+		"attempts":  bson.M{"$lt": maxAttempts},
+	}
+	update := bson.M{"$inc": bson.M{"attempts": 1}}
+
+	err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&tokenDoc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", authModels.ErrInvalidToken
+		}
+		return "", err
+	}
+
+	return tokenDoc.UserID.Hex(), nil
+}
+
+// RevokeAll removes any outstanding tokens of a purpose for the user
+func (r *MongoDbTokenRepository) RevokeAll(ctx context.Context, userId string, purpose authModels.TokenPurpose) error {
+	userOid, err := primitive.ObjectIDFromHex(userId)
+
+	if err != nil {
+		return err
+	}
+
+	collection := r.getCollection()
+
+	_, err = collection.DeleteMany(ctx, bson.M{"userId": userOid, "purpose": purpose, "usedAt": bson.M{"$exists": false}})
+
+	return err
+}
+
+func (r *MongoDbTokenRepository) DeleteByUser(ctx context.Context, userId string) error {
+	return deleteByUser(ctx, r.getCollection(), "userId", userId)
+}
@@ -0,0 +1,172 @@
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/producer"
+)
+
+type Mode string
+
+const (
+	// remove the user document along with everything else
+	ModeDelete Mode = "delete"
+	// keep an anonymous tombstone with the same id so references to the user still resolve
+	ModeAnonymise Mode = "anonymise"
+)
+
+type Config struct {
+	Mode     Mode
+	Interval time.Duration
+	// an account claimed by an instance that has not finished purging it within this long is retried
+	Lease time.Duration
+}
+
+// Repositories holds everything the purger erases a user's data from
+type Repositories struct {
+	Users       db.AuthRepository
+	Tokens      db.TokenRepository
+	Sessions    db.SessionRepository
+	ApiKeys     db.ApiKeyRepository
+	Federations db.FederationRepository
+	OAuth       db.OAuthRepository
+}
+
+// Purger erases accounts once their deletion grace period has ended. The auth instance elected
+// leader runs it, accounts are also claimed one at a time so each is purged by a single instance
+// even while leadership changes hands.
+// Failed login counters are keyed by username and expire on their own so they are left alone.
+type Purger struct {
+	repositories        Repositories
+	config              Config
+	userDeletedProducer *producer.Producer[events.UserDeletedEvent]
+}
+
+func NewPurger(repositories Repositories, brokers *discovery.Endpoint, config Config) *Purger {
+	userDeletedProducer, err := producer.New[events.UserDeletedEvent](brokers, "userDeleted")
+	if err != nil {
+		log.Fatalf("Failed to create producer: %s\n", err)
+	}
+
+	return &Purger{
+		repositories:        repositories,
+		config:              config,
+		userDeletedProducer: userDeletedProducer,
+	}
+}
+
+// Close flushes and closes the producer, once Run has returned
+func (p *Purger) Close() {
+	p.userDeletedProducer.Close()
+}
+
+// ParseMode reads the purge mode, an empty value is ModeDelete
+func ParseMode(value string) (Mode, error) {
+	switch Mode(value) {
+	case "", ModeDelete:
+		return ModeDelete, nil
+	case ModeAnonymise:
+		return ModeAnonymise, nil
+	default:
+		return "", fmt.Errorf("unknown account deletion mode %q", value)
+	}
+}
+
+// Run purges due accounts every interval until the context is cancelled. fence is the token of
+// the leader term the purger runs in, accounts it claimed can no longer be removed by it once a
+// later term has claimed them. Without an election every instance runs with the same fence.
+func (p *Purger) Run(ctx context.Context, fence uint64) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.PurgeDue(ctx, fence); err != nil {
+			log.Printf("Failed to purge deleted accounts: %s\n", err)
+		} else if purged > 0 {
+			log.Printf("purged %d deleted accounts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDue purges every account whose grace period has ended and returns how many were purged
+func (p *Purger) PurgeDue(ctx context.Context, fence uint64) (int, error) {
+	purged := 0
+
+	for {
+		user, err := p.repositories.Users.ClaimDueDeletion(ctx, p.config.Lease, fence)
+
+		if err != nil {
+			return purged, err
+		}
+
+		if user == nil {
+			return purged, nil
+		}
+
+		if err := p.purge(ctx, user, fence); err != nil {
+			// the claim lapses after the lease and the account is tried again
+			return purged, fmt.Errorf("purge user %s: %w", user.ID, err)
+		}
+
+		purged++
+	}
+}
+
+func (p *Purger) purge(ctx context.Context, user *userModels.User, fence uint64) error {
+	erasers := []func(ctx context.Context, userId string) error{
+		p.repositories.Sessions.DeleteByUser,
+		p.repositories.ApiKeys.DeleteByUser,
+		p.repositories.Tokens.DeleteByUser,
+		p.repositories.Federations.DeleteByUser,
+		p.repositories.OAuth.DeleteByUser,
+	}
+
+	// the claim is checked before each step as only the user document can be fenced, a purger
+	// from an earlier leader term stops before erasing anything more
+	for _, erase := range erasers {
+		if err := p.repositories.Users.RenewPurgeClaim(ctx, user.ID, fence); err != nil {
+			return err
+		}
+
+		if err := erase(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	anonymised := p.config.Mode == ModeAnonymise
+
+	deletedEvent := events.UserDeletedEvent{
+		ID:         user.ID,
+		DeletedAt:  time.Now(),
+		Anonymised: anonymised,
+	}
+
+	if err := p.repositories.Users.RenewPurgeClaim(ctx, user.ID, fence); err != nil {
+		return err
+	}
+
+	// published before the account is removed so a failure leaves it claimed and it is retried,
+	// consumers may see the event more than once
+	if err := p.userDeletedProducer.Produce(ctx, deletedEvent); err != nil {
+		return fmt.Errorf("publish user deleted event: %w", err)
+	}
+
+	if anonymised {
+		return p.repositories.Users.Anonymise(ctx, user.ID, fence)
+	}
+
+	return p.repositories.Users.Delete(ctx, user.ID, fence)
+}
@@ -0,0 +1,107 @@
+package erasure
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// fencedUsers holds the purge claim of one account with the fence of the term that claimed it
+type fencedUsers struct {
+	db.AuthRepository
+	fence uint64
+}
+
+func (r *fencedUsers) RenewPurgeClaim(ctx context.Context, id string, fence uint64) error {
+	if fence != r.fence {
+		return authModels.ErrPurgeClaimLost
+	}
+
+	return nil
+}
+
+// erasures counts the calls to every repository's DeleteByUser
+type erasures struct {
+	calls int
+}
+
+func (e *erasures) erase() error {
+	e.calls++
+	return nil
+}
+
+type countedSessions struct {
+	db.SessionRepository
+	erased *erasures
+}
+
+func (r countedSessions) DeleteByUser(ctx context.Context, userId string) error {
+	return r.erased.erase()
+}
+
+type countedApiKeys struct {
+	db.ApiKeyRepository
+	erased *erasures
+}
+
+func (r countedApiKeys) DeleteByUser(ctx context.Context, userId string) error {
+	return r.erased.erase()
+}
+
+type countedTokens struct {
+	db.TokenRepository
+	erased *erasures
+}
+
+func (r countedTokens) DeleteByUser(ctx context.Context, userId string) error {
+	return r.erased.erase()
+}
+
+type countedFederations struct {
+	db.FederationRepository
+	erased *erasures
+}
+
+func (r countedFederations) DeleteByUser(ctx context.Context, userId string) error {
+	return r.erased.erase()
+}
+
+type countedOAuth struct {
+	db.OAuthRepository
+	erased *erasures
+}
+
+func (r countedOAuth) DeleteByUser(ctx context.Context, userId string) error {
+	return r.erased.erase()
+}
+
+func TestDeposedPurgerErasesNothing(t *testing.T) {
+	erased := &erasures{}
+
+	// a later leader term has taken the claim over
+	p := &Purger{
+		repositories: Repositories{
+			Users:       &fencedUsers{fence: 8},
+			Sessions:    countedSessions{erased: erased},
+			ApiKeys:     countedApiKeys{erased: erased},
+			Tokens:      countedTokens{erased: erased},
+			Federations: countedFederations{erased: erased},
+			OAuth:       countedOAuth{erased: erased},
+		},
+		config: Config{Mode: ModeDelete},
+	}
+
+	err := p.purge(context.Background(), &userModels.User{ID: "64b7f0c2a1b2c3d4e5f60718"}, 7)
+
+	if !errors.Is(err, authModels.ErrPurgeClaimLost) {
+		t.Fatalf("expected the lost claim to stop the purge, got %v", err)
+	}
+
+	if erased.calls != 0 {
+		t.Fatalf("the deposed purger erased data from %d repositories", erased.calls)
+	}
+}
@@ -0,0 +1,112 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sync"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+)
+
+var ErrFakeLoginNotFound = errors.New("no login pending for this state")
+
+type fakeLogin struct {
+	nonce         string
+	codeChallenge string
+	redirectUri   string
+}
+
+type fakeGrant struct {
+	identity      Identity
+	codeChallenge string
+	redirectUri   string
+}
+
+// FakeProvider is an in memory identity provider for tests and local development. Logins are
+// started like any other provider and an identity is chosen for them with Approve.
+type FakeProvider struct {
+	name   string
+	mu     sync.Mutex
+	logins map[string]fakeLogin
+	codes  map[string]fakeGrant
+}
+
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{
+		name:   name,
+		logins: map[string]fakeLogin{},
+		codes:  map[string]fakeGrant{},
+	}
+}
+
+func (p *FakeProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string, redirectUri string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logins[state] = fakeLogin{
+		nonce:         nonce,
+		codeChallenge: codeChallenge,
+		redirectUri:   redirectUri,
+	}
+
+	params := url.Values{}
+	params.Set("state", state)
+	params.Set("redirect_uri", redirectUri)
+
+	return "https://" + url.PathEscape(p.name) + ".fake-idp.invalid/authorize?" + params.Encode(), nil
+}
+
+// Approve logs the pending login in as identity and returns the code the provider would
+// send to the redirect uri
+func (p *FakeProvider) Approve(state string, identity Identity) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	login, ok := p.logins[state]
+
+	if !ok {
+		return "", ErrFakeLoginNotFound
+	}
+
+	delete(p.logins, state)
+
+	bytes := make([]byte, 16)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(bytes)
+	identity.Nonce = login.nonce
+
+	p.codes[code] = fakeGrant{
+		identity:      identity,
+		codeChallenge: login.codeChallenge,
+		redirectUri:   login.redirectUri,
+	}
+
+	return code, nil
+}
+
+func (p *FakeProvider) Exchange(ctx context.Context, code string, codeVerifier string, redirectUri string) (*Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	grant, ok := p.codes[code]
+
+	if !ok {
+		return nil, &oauth.ErrorResponse{Code: oauth.ErrInvalidGrant}
+	}
+
+	delete(p.codes, code)
+
+	if grant.redirectUri != redirectUri || !oauth.VerifyCodeChallenge(grant.codeChallenge, codeVerifier) {
+		return nil, &oauth.ErrorResponse{Code: oauth.ErrInvalidGrant}
+	}
+
+	identity := grant.identity
+	return &identity, nil
+}
@@ -0,0 +1,179 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// Identity is what an external provider asserts about the user who logged in
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+	Groups            []string
+	// echoed from the authorization request, the caller must compare it
+	Nonce string
+}
+
+// Provider is an external identity provider that users can log in with
+type Provider interface {
+	// AuthCodeURL is where to send the user to log in, the code flow with PKCE is used
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string, redirectUri string) (string, error)
+	// Exchange redeems the code returned to the redirect uri and verifies the identity
+	Exchange(ctx context.Context, code string, codeVerifier string, redirectUri string) (*Identity, error)
+}
+
+type ProviderConfig struct {
+	Name string `json:"name"`
+	// oidc, or fake for an in memory provider that logs in whoever FakeProvider.Approve is
+	// given, for tests and local development
+	Type     string `json:"type"`
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"clientId"`
+	// the secret passed to LoadFromFile for the provider is preferred over clientSecret
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	// claim listing the user's groups, defaults to groups
+	GroupsClaim string `json:"groupsClaim"`
+	// provider groups and the bookstore roles their members get
+	RoleMapping map[string][]user.UserRole `json:"roleMapping"`
+	// create an account when no user is linked or has the verified email
+	ProvisionUsers bool `json:"provisionUsers"`
+}
+
+// Connection is a configured provider
+type Connection struct {
+	Provider Provider
+	Config   ProviderConfig
+}
+
+// MapRoles returns the roles the groups grant
+func (c *Connection) MapRoles(groups []string) []user.UserRole {
+	roles := []user.UserRole{}
+
+	for _, group := range groups {
+		for _, role := range c.Config.RoleMapping[group] {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return roles
+}
+
+// SyncRoles updates a user's roles from their groups. Roles that appear in the mapping are
+// managed by the provider and are added or removed, other roles are left alone.
+func (c *Connection) SyncRoles(current []user.UserRole, groups []string) []user.UserRole {
+	if len(c.Config.RoleMapping) == 0 {
+		return current
+	}
+
+	managed := []user.UserRole{}
+
+	for _, roles := range c.Config.RoleMapping {
+		managed = append(managed, roles...)
+	}
+
+	roles := []user.UserRole{}
+
+	for _, role := range current {
+		if !slices.Contains(managed, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	for _, role := range c.MapRoles(groups) {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// Connections are the configured providers by name
+type Connections map[string]*Connection
+
+func (c Connections) Names() []string {
+	names := []string{}
+
+	for name := range c {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+type fileConfig struct {
+	Providers []ProviderConfig `json:"providers"`
+}
+
+// LoadFromFile reads the providers from a json file of the form {"providers": [...]}, with
+// their client secrets by provider name from secrets so they can be kept out of the file.
+// An empty path configures no providers.
+func LoadFromFile(path string, secrets map[string]string) (Connections, error) {
+	connections := Connections{}
+
+	if path == "" {
+		return connections, nil
+	}
+
+	bytes, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var config fileConfig
+
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return nil, fmt.Errorf("invalid federation config: %w", err)
+	}
+
+	for _, providerConfig := range config.Providers {
+		if providerConfig.Name == "" {
+			return nil, fmt.Errorf("federation provider without a name")
+		}
+		if _, ok := connections[providerConfig.Name]; ok {
+			return nil, fmt.Errorf("federation provider %q is configured twice", providerConfig.Name)
+		}
+
+		if secret, ok := secrets[providerConfig.Name]; ok {
+			providerConfig.ClientSecret = secret
+		}
+		if providerConfig.GroupsClaim == "" {
+			providerConfig.GroupsClaim = "groups"
+		}
+
+		var provider Provider
+
+		switch providerConfig.Type {
+		case "", "oidc":
+			if providerConfig.Issuer == "" || providerConfig.ClientID == "" {
+				return nil, fmt.Errorf("federation provider %q needs an issuer and client id", providerConfig.Name)
+			}
+
+			provider = NewOIDCProvider(providerConfig)
+		case "fake":
+			provider = NewFakeProvider(providerConfig.Name)
+		default:
+			return nil, fmt.Errorf("federation provider %q has unknown type %q", providerConfig.Name, providerConfig.Type)
+		}
+
+		connections[providerConfig.Name] = &Connection{Provider: provider, Config: providerConfig}
+	}
+
+	return connections, nil
+}
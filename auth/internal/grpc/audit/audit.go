@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/ingester"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize int32 = 100
+	maxPageSize     int32 = 500
+)
+
+// Handler serves the audit log, which the auth service owns and writes
+type Handler struct {
+	gen.UnimplementedAuditServiceServer
+	log      *audit.Log
+	ingester *ingester.Ingester[audit.Entry]
+}
+
+func New(auditLog *audit.Log, brokers *discovery.Endpoint) *Handler {
+	entryIngester, err := ingester.New[audit.Entry](brokers, "auth", audit.Topic)
+	if err != nil {
+		log.Fatalf("Failed to create ingester: %s\n", err)
+	}
+
+	return &Handler{
+		log:      auditLog,
+		ingester: entryIngester,
+	}
+}
+
+// RegisterHealthChecks warns when entries from other services stop being recorded, the auth
+// service records its own without the consumer
+func (h *Handler) RegisterHealthChecks(checks *health.Health) {
+	checks.RegisterOptional("kafka:"+h.ingester.Topic(), h.ingester)
+}
+
+// HandleIngestors appends the entries other services publish to the log until the context is
+// done and the consumer has committed the entries it appended
+func (h *Handler) HandleIngestors(ctx context.Context) {
+	if err := h.handleAuditIngestor(ctx); err != nil {
+		log.Fatalf("Failed to ingest: %s\n", err)
+	}
+}
+
+func (h *Handler) handleAuditIngestor(ctx context.Context) error {
+	// an entry that cannot be appended is retried rather than committed past, so none are lost
+	// while the store is down
+	return h.ingester.ConsumeRetrying(ctx, func(ctx context.Context, message ingester.Message[audit.Entry]) error {
+		entry := message.Event
+
+		if err := h.log.Record(ctx, entry); err != nil {
+			return fmt.Errorf("record audit entry %s/%s from %s: %w", entry.Category, entry.Action, entry.Service, err)
+		}
+
+		return nil
+	})
+}
+
+func (h *Handler) QueryAuditLog(ctx context.Context, req *gen.QueryAuditLogRequest) (*gen.QueryAuditLogResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	pageSize := req.PageSize
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	filter := audit.Filter{
+		ActorID:  req.ActorId,
+		TargetID: req.TargetId,
+		Category: audit.Category(req.Category),
+		Action:   req.Action,
+		Outcome:  audit.Outcome(req.Outcome),
+		// one extra entry tells whether there is another page
+		Limit: int64(pageSize) + 1,
+	}
+
+	if req.PageToken != "" {
+		before, err := strconv.ParseInt(req.PageToken, 10, 64)
+
+		if err != nil || before <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "page token is not valid")
+		}
+
+		filter.Before = before
+	}
+
+	if req.Since != 0 {
+		since := time.Unix(req.Since, 0)
+		filter.Since = &since
+	}
+
+	if req.Until != 0 {
+		until := time.Unix(req.Until, 0)
+		filter.Until = &until
+	}
+
+	entries, err := h.log.Query(ctx, filter)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp := &gen.QueryAuditLogResponse{Entries: []*gen.AuditEntry{}}
+
+	if len(entries) > int(pageSize) {
+		entries = entries[:pageSize]
+		resp.NextPageToken = strconv.FormatInt(entries[len(entries)-1].Sequence, 10)
+	}
+
+	for i := range entries {
+		resp.Entries = append(resp.Entries, entries[i].ToProto())
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) VerifyAuditLog(ctx context.Context, req *gen.VerifyAuditLogRequest) (*gen.VerifyAuditLogResponse, error) {
+	result, err := h.log.Verify(ctx)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if !result.Valid {
+		log.Printf("Audit log chain is broken at entry %d: %s\n", result.FirstInvalid, result.Problem)
+	}
+
+	return &gen.VerifyAuditLogResponse{
+		Valid:        result.Valid,
+		Checked:      result.Checked,
+		FirstInvalid: result.FirstInvalid,
+		Problem:      result.Problem,
+	}, nil
+}
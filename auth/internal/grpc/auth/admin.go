@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultUserPageSize int32 = 50
+	maxUserPageSize     int32 = 200
+)
+
+// recordAdminAction adds an entry to the admin action log
+func (h *Handler) recordAdminAction(ctx context.Context, actorId string, action authModels.AdminActionType, targetId string, reason string, details map[string]string) error {
+	actorOid, err := primitive.ObjectIDFromHex(actorId)
+
+	if err != nil {
+		return err
+	}
+
+	targetOid, err := primitive.ObjectIDFromHex(targetId)
+
+	if err != nil {
+		return err
+	}
+
+	return h.adminActions.Record(ctx, &authModels.AdminActionDocument{
+		ActorID:  actorOid,
+		Action:   action,
+		TargetID: targetOid,
+		Reason:   reason,
+		Details:  details,
+	})
+}
+
+// logAdminAction records an action that has already been carried out, a failure to record it
+// cannot undo the action so it is only logged
+func (h *Handler) logAdminAction(ctx context.Context, actorId string, action authModels.AdminActionType, targetId string, reason string, details map[string]string) {
+	if err := h.recordAdminAction(ctx, actorId, action, targetId, reason, details); err != nil {
+		log.Printf("Failed to record admin action %s on %s by %s: %s\n", action, targetId, actorId, err)
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Admin,
+		Action:   string(action),
+		Outcome:  audit.Success,
+		ActorID:  actorId,
+		TargetID: targetId,
+		Reason:   reason,
+		Details:  details,
+	})
+
+	log.Printf("admin %s: %s on account %s", actorId, action, targetId)
+}
+
+// getAdminTarget checks an admin request names an actor and returns the user it acts on
+func (h *Handler) getAdminTarget(ctx context.Context, userId string, actorId string) (*userModels.User, error) {
+	if userId == "" || actorId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user id and actor id are required")
+	}
+
+	if !primitive.IsValidObjectID(actorId) {
+		return nil, status.Errorf(codes.InvalidArgument, "actor id is not valid")
+	}
+
+	// when the api vouched for the caller the actor must be that user, and an admin
+	if principal, ok := identity.FromContext(ctx); ok && (principal.UserID != actorId || !principal.HasRole(string(userModels.Admin))) {
+		return nil, status.Errorf(codes.PermissionDenied, "actor does not match the calling user")
+	}
+
+	return h.getUser(ctx, userId)
+}
+
+func (h *Handler) ListUsers(ctx context.Context, req *gen.ListUsersRequest) (*gen.ListUsersResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	pageSize := req.PageSize
+
+	if pageSize <= 0 {
+		pageSize = defaultUserPageSize
+	}
+
+	if pageSize > maxUserPageSize {
+		pageSize = maxUserPageSize
+	}
+
+	if req.PageToken != "" && !primitive.IsValidObjectID(req.PageToken) {
+		return nil, status.Errorf(codes.InvalidArgument, "page token is not valid")
+	}
+
+	filter := db.UserFilter{
+		Role:   userModels.UserRole(req.Role),
+		Status: db.UserStatus(req.Status),
+		Search: strings.TrimSpace(req.Search),
+		After:  req.PageToken,
+		// one extra user tells whether there is another page
+		Limit: int64(pageSize) + 1,
+	}
+
+	switch filter.Status {
+	case "", db.UserStatusActive, db.UserStatusDisabled, db.UserStatusUnverified, db.UserStatusPendingDeletion:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "status must be one of active, disabled, unverified or pending_deletion")
+	}
+
+	if req.CreatedAfter != 0 {
+		createdAfter := time.Unix(req.CreatedAfter, 0)
+		filter.CreatedAfter = &createdAfter
+	}
+
+	if req.CreatedBefore != 0 {
+		createdBefore := time.Unix(req.CreatedBefore, 0)
+		filter.CreatedBefore = &createdBefore
+	}
+
+	users, total, err := h.repository.List(ctx, filter)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp := &gen.ListUsersResponse{Users: []*gen.User{}, Total: total}
+
+	if len(users) > int(pageSize) {
+		users = users[:pageSize]
+		resp.NextPageToken = users[len(users)-1].ID
+	}
+
+	for _, user := range users {
+		resp.Users = append(resp.Users, userModels.UserToProto(user))
+	}
+
+	return resp, nil
+}
+
+// DisableUser stops the user logging in and ends their sessions, the account is otherwise kept
+func (h *Handler) DisableUser(ctx context.Context, req *gen.DisableUserRequest) (*gen.DisableUserResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID == req.ActorId {
+		return nil, status.Errorf(codes.FailedPrecondition, "admins cannot disable their own account")
+	}
+
+	if err := h.repository.SetDisabled(ctx, user.ID, true, req.Reason); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err := h.sessions.RevokeAll(ctx, user.ID); err != nil {
+		log.Printf("Failed to revoke sessions of disabled account: %s\n", err)
+	}
+
+	h.logAdminAction(ctx, req.ActorId, authModels.DisableUserAction, user.ID, req.Reason, nil)
+
+	return &gen.DisableUserResponse{}, nil
+}
+
+func (h *Handler) EnableUser(ctx context.Context, req *gen.EnableUserRequest) (*gen.EnableUserResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Disabled {
+		return &gen.EnableUserResponse{}, nil
+	}
+
+	if err := h.repository.SetDisabled(ctx, user.ID, false, ""); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	h.logAdminAction(ctx, req.ActorId, authModels.EnableUserAction, user.ID, "", nil)
+
+	return &gen.EnableUserResponse{}, nil
+}
+
+// ForcePasswordReset logs the user out and sends them a reset link, they cannot log in with
+// their current password until they have chosen a new one
+func (h *Handler) ForcePasswordReset(ctx context.Context, req *gen.ForcePasswordResetRequest) (*gen.ForcePasswordResetResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ServiceAccount {
+		return nil, status.Errorf(codes.FailedPrecondition, "service accounts do not have a password")
+	}
+
+	if err := h.repository.RequirePasswordReset(ctx, user.ID); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err := h.sessions.RevokeAll(ctx, user.ID); err != nil {
+		log.Printf("Failed to revoke sessions after forced reset: %s\n", err)
+	}
+
+	if err := h.sendPasswordReset(ctx, user, "An administrator has asked you to choose a new password before you next log in.", "Your current password will not work until you do."); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	h.logAdminAction(ctx, req.ActorId, authModels.ForcePasswordResetAction, user.ID, "", nil)
+
+	return &gen.ForcePasswordResetResponse{}, nil
+}
+
+// SetUserRoles replaces the roles of a user. Tokens already issued keep the old roles until
+// they are refreshed.
+func (h *Handler) SetUserRoles(ctx context.Context, req *gen.SetUserRolesRequest) (*gen.SetUserRolesResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []userModels.UserRole{}
+
+	for _, value := range req.Roles {
+		role := userModels.UserRole(strings.TrimSpace(value))
+
+		if role == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "roles cannot be empty")
+		}
+
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	if user.ID == req.ActorId && !slices.Contains(roles, userModels.Admin) {
+		return nil, status.Errorf(codes.FailedPrecondition, "admins cannot remove their own admin role")
+	}
+
+	if err := h.repository.SetRoles(ctx, user.ID, roles); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	h.logAdminAction(ctx, req.ActorId, authModels.SetRolesAction, user.ID, "", map[string]string{
+		"from": strings.Join(userModels.RolesToStrings(user.Roles), ","),
+		"to":   strings.Join(userModels.RolesToStrings(roles), ","),
+	})
+
+	user.Roles = roles
+
+	return &gen.SetUserRolesResponse{User: userModels.UserToProto(user)}, nil
+}
+
+// ImpersonateUser lets an admin act as a user to investigate a problem they reported. The api
+// issues the token, which names the admin, once the impersonation has been recorded.
+func (h *Handler) ImpersonateUser(ctx context.Context, req *gen.ImpersonateUserRequest) (*gen.ImpersonateUserResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "a reason is required to impersonate a user")
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID == req.ActorId {
+		return nil, status.Errorf(codes.FailedPrecondition, "admins cannot impersonate themselves")
+	}
+
+	// an admin acting as another admin could hide their actions behind the other's name
+	if slices.Contains(user.Roles, userModels.Admin) || user.ServiceAccount {
+		return nil, status.Errorf(codes.PermissionDenied, "admins and service accounts cannot be impersonated")
+	}
+
+	if err := authModels.AccountBlocked(user); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
+	// unlike the other admin actions nothing has happened yet, so no record means no token
+	if err := h.recordAdminAction(ctx, req.ActorId, authModels.ImpersonateAction, user.ID, req.Reason, nil); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Admin,
+		Action:   string(authModels.ImpersonateAction),
+		Outcome:  audit.Success,
+		ActorID:  req.ActorId,
+		TargetID: user.ID,
+		Reason:   req.Reason,
+	})
+
+	log.Printf("admin %s: impersonating account %s", req.ActorId, user.ID)
+
+	return &gen.ImpersonateUserResponse{User: userModels.UserToProto(user)}, nil
+}
+
+func (h *Handler) ListAdminActions(ctx context.Context, req *gen.ListAdminActionsRequest) (*gen.ListAdminActionsResponse, error) {
+	if req == nil || req.TargetId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or target id was empty")
+	}
+
+	if !primitive.IsValidObjectID(req.TargetId) {
+		return nil, status.Errorf(codes.InvalidArgument, "target id is not valid")
+	}
+
+	actionDocs, err := h.adminActions.ListByTarget(ctx, req.TargetId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	actions := []*gen.AdminAction{}
+
+	for _, actionDoc := range actionDocs {
+		actions = append(actions, actionDoc.ToProto())
+	}
+
+	return &gen.ListAdminActionsResponse{Actions: actions}, nil
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// service account names double as usernames so they are kept to a safe character set
+var serviceAccountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{2,62}$`)
+
+func (h *Handler) CreateServiceAccount(ctx context.Context, req *gen.CreateServiceAccountRequest) (*gen.CreateServiceAccountResponse, error) {
+	if req == nil || !serviceAccountNamePattern.MatchString(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "name must be 3 to 63 lower case letters, digits or dashes")
+	}
+
+	user, err := h.repository.AddServiceAccount(ctx, req.Name, userModels.StringsToRoles(req.Roles))
+
+	if err != nil {
+		switch err {
+		case authModels.ErrUsernameTaken, authModels.ErrEmailTaken:
+			return nil, grpcutil.ErrorWithReason(codes.AlreadyExists, grpcutil.ReasonUsernameTaken, authModels.ErrUsernameTaken.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Admin,
+		Action:   "create_service_account",
+		Outcome:  audit.Success,
+		TargetID: user.ID,
+		Details:  map[string]string{"roles": strings.Join(req.Roles, ",")},
+	})
+
+	return &gen.CreateServiceAccountResponse{User: userModels.UserToProto(user)}, nil
+}
+
+// apiKeyOwner returns the user a key is being created for. Users create their own keys, admins
+// can only create keys for service accounts as a key for anyone else would let them act as that
+// user without it being recorded as an impersonation.
+func (h *Handler) apiKeyOwner(ctx context.Context, req *gen.CreateApiKeyRequest) (*userModels.User, error) {
+	if !createdByAdmin(req) {
+		if principal, ok := identity.FromContext(ctx); ok && principal.UserID != req.UserId {
+			return nil, status.Errorf(codes.PermissionDenied, "users can only create api keys for themselves")
+		}
+
+		return h.getUser(ctx, req.UserId)
+	}
+
+	user, err := h.getAdminTarget(ctx, req.UserId, req.ActorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.ServiceAccount {
+		return nil, status.Errorf(codes.PermissionDenied, "admins can only create api keys for service accounts")
+	}
+
+	return user, nil
+}
+
+func createdByAdmin(req *gen.CreateApiKeyRequest) bool {
+	return req.ActorId != "" && req.ActorId != req.UserId
+}
+
+func (h *Handler) CreateApiKey(ctx context.Context, req *gen.CreateApiKeyRequest) (*gen.CreateApiKeyResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "name was empty")
+	}
+
+	var expiresAt *time.Time
+
+	if req.ExpiresAt != 0 {
+		expiry := time.Unix(req.ExpiresAt, 0)
+
+		if !expiry.After(time.Now()) {
+			return nil, status.Errorf(codes.InvalidArgument, "expiry must be in the future")
+		}
+
+		expiresAt = &expiry
+	}
+
+	user, err := h.apiKeyOwner(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a key can be limited to some of the owner's roles but never gain new ones
+	scopes := userModels.StringsToRoles(req.Scopes)
+
+	for _, scope := range scopes {
+		if !slices.Contains(user.Roles, scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "scope %q is not a role of the user", scope)
+		}
+	}
+
+	// a key created by an admin acts as the service account without naming the admin, so
+	// nothing is created unless it has been recorded
+	if createdByAdmin(req) {
+		if err := h.recordAdminAction(ctx, req.ActorId, authModels.CreateApiKeyAction, user.ID, "", map[string]string{"name": req.Name}); err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	keyDoc, secret, err := h.apiKeys.Create(ctx, user.ID, req.Name, scopes, expiresAt)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Credential,
+		Action:   "api_key_created",
+		Outcome:  audit.Success,
+		TargetID: keyDoc.ID.Hex(),
+		ActorID:  req.ActorId,
+		Details:  map[string]string{"userId": user.ID, "name": req.Name},
+	})
+
+	return &gen.CreateApiKeyResponse{Key: keyDoc.ToProto(), Secret: secret}, nil
+}
+
+func (h *Handler) ListApiKeys(ctx context.Context, req *gen.ListApiKeysRequest) (*gen.ListApiKeysResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	keyDocs, err := h.apiKeys.ListByUser(ctx, req.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	keys := []*gen.ApiKey{}
+
+	for i := range keyDocs {
+		keys = append(keys, keyDocs[i].ToProto())
+	}
+
+	return &gen.ListApiKeysResponse{Keys: keys}, nil
+}
+
+func (h *Handler) RevokeApiKey(ctx context.Context, req *gen.RevokeApiKeyRequest) (*gen.RevokeApiKeyResponse, error) {
+	if req == nil || req.UserId == "" || req.KeyId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req, user id or key id was empty")
+	}
+
+	if err := h.apiKeys.Revoke(ctx, req.UserId, req.KeyId); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, "api key not found")
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Credential,
+		Action:   "api_key_revoked",
+		Outcome:  audit.Success,
+		TargetID: req.KeyId,
+		Details:  map[string]string{"userId": req.UserId},
+	})
+
+	return &gen.RevokeApiKeyResponse{}, nil
+}
+
+// AuthenticateApiKey resolves a key to its owner and the roles the key may act with
+func (h *Handler) AuthenticateApiKey(ctx context.Context, req *gen.AuthenticateApiKeyRequest) (resp *gen.AuthenticateApiKeyResponse, err error) {
+	if req == nil || req.Secret == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or secret was empty")
+	}
+
+	// keys are checked on every request, only the failures are worth keeping
+	defer func() {
+		if err != nil {
+			h.recordAuditResult(ctx, audit.Entry{Category: audit.Authentication, Action: "api_key"}, err)
+		}
+	}()
+
+	keyDoc, err := h.apiKeys.Authenticate(ctx, req.Secret)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidApiKey:
+			return nil, status.Errorf(codes.Unauthenticated, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	user, err := h.repository.GetById(ctx, keyDoc.UserID.Hex())
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.Unauthenticated, authModels.ErrInvalidApiKey.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	// keys are kept while the account is disabled or scheduled for deletion so they work again if it is restored
+	if authModels.AccountBlocked(user) != nil {
+		return nil, status.Errorf(codes.Unauthenticated, authModels.ErrInvalidApiKey.Error())
+	}
+
+	return &gen.AuthenticateApiKeyResponse{
+		User:  userModels.UserToProto(user),
+		Key:   keyDoc.ToProto(),
+		Roles: userModels.RolesToStrings(keyDoc.ScopeRoles(user.Roles)),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"log"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordAudit adds an event to the audit log, the request details forwarded by the api fill in
+// anything the entry leaves empty. The event has already happened so a failure is only logged.
+func (h *Handler) recordAudit(ctx context.Context, entry audit.Entry) {
+	entry.Service = "auth"
+	audit.RequestInfoFromContext(ctx).Apply(&entry)
+
+	if err := h.auditLog.Record(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s/%s: %s\n", entry.Category, entry.Action, err)
+	}
+}
+
+// recordAuditResult records the entry with the outcome of a request, err being what the rpc returned
+func (h *Handler) recordAuditResult(ctx context.Context, entry audit.Entry, err error) {
+	entry.Outcome = audit.Success
+
+	if err != nil {
+		entry.Outcome = auditOutcome(err)
+		entry.Reason = status.Convert(err).Message()
+	}
+
+	h.recordAudit(ctx, entry)
+}
+
+// auditOutcome tells a refused request apart from one that failed, such as a wrong password
+func auditOutcome(err error) audit.Outcome {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.ResourceExhausted, codes.FailedPrecondition:
+		return audit.Denied
+	default:
+		return audit.Failure
+	}
+}
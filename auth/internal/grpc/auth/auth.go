@@ -3,67 +3,149 @@ package auth
 import (
 	"context"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/federation"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/mailer"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/password"
 	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
-	"github.com/will-kerwin/go-microservice-bookstore/pkg/ingester"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
 	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/producer"
 	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+type VerificationPolicy string
+
+const (
+	// Unverified users can always log in
+	VerificationOptional VerificationPolicy = "optional"
+	// Unverified users can log in until the grace period after registering ends
+	VerificationGrace VerificationPolicy = "grace"
+	// Users must verify their email before they can log in
+	VerificationRequired VerificationPolicy = "required"
+)
+
+type Config struct {
+	// Base url of the public api, used to build links sent to users
+	PublicUrl string
+	// page where users choose a new password, the api serves one at /auth/password/reset when empty
+	PasswordResetUrl        string
+	VerificationPolicy      VerificationPolicy
+	VerificationGracePeriod time.Duration
+	VerificationTokenTtl    time.Duration
+	PasswordResetTokenTtl   time.Duration
+	// Issuer shown in authenticator apps
+	MfaIssuer       string
+	MfaChallengeTtl time.Duration
+	AccountLockout  db.LockoutPolicy
+	IpLockout       db.LockoutPolicy
+	PasswordPolicy  *password.Policy
+	// how long a login started at an external provider can take to complete
+	FederationStateTtl time.Duration
+	// how long a session lasts from login, refreshing does not extend it
+	SessionTtl time.Duration
+	// how long a deleted account can be restored before it is purged
+	DeletionGracePeriod time.Duration
+}
+
 type Handler struct {
 	gen.UnimplementedUserServiceServer
-	repository         db.AuthRepository
-	createUserIngester ingester.Ingester[events.CreateUserEvent]
+	repository   db.AuthRepository
+	tokens       db.TokenRepository
+	roles        db.RoleRepository
+	attempts     db.AttemptRepository
+	apiKeys      db.ApiKeyRepository
+	sessions     db.SessionRepository
+	federations  db.FederationRepository
+	adminActions db.AdminActionRepository
+	auditLog     audit.Recorder
+	providers    federation.Connections
+	mailer       mailer.Mailer
+	// swapped as a whole when the config is reloaded
+	config                 atomic.Pointer[Config]
+	userRegisteredProducer *producer.Producer[events.UserRegisteredEvent]
+	accountLockedProducer  *producer.Producer[events.AccountLockedEvent]
+	newDeviceProducer      *producer.Producer[events.NewDeviceLoginEvent]
 }
 
-func New(repository db.AuthRepository, addr string, groupID string) *Handler {
-	createUserIngester, err := ingester.New[events.CreateUserEvent](addr, groupID, "createUser")
+func New(repository db.AuthRepository, tokens db.TokenRepository, roles db.RoleRepository, attempts db.AttemptRepository, apiKeys db.ApiKeyRepository, sessions db.SessionRepository, federations db.FederationRepository, adminActions db.AdminActionRepository, auditLog audit.Recorder, providers federation.Connections, mailer mailer.Mailer, brokers *discovery.Endpoint, config Config) *Handler {
+	userRegisteredProducer, err := producer.New[events.UserRegisteredEvent](brokers, "userRegistered")
 	if err != nil {
-		log.Fatalf("Failed to create ingester: %s\n", err)
-		createUserIngester = nil
+		log.Fatalf("Failed to create producer: %s\n", err)
 	}
 
-	return &Handler{
-		repository:         repository,
-		createUserIngester: *createUserIngester,
+	accountLockedProducer, err := producer.New[events.AccountLockedEvent](brokers, "accountLocked")
+	if err != nil {
+		log.Fatalf("Failed to create producer: %s\n", err)
 	}
-}
 
-func (h *Handler) HandleIngestors(ctx context.Context) {
-	go h.handleCreateUserIngester(ctx)
-}
+	newDeviceProducer, err := producer.New[events.NewDeviceLoginEvent](brokers, "newDeviceLogin")
+	if err != nil {
+		log.Fatalf("Failed to create producer: %s\n", err)
+	}
 
-func (h *Handler) handleCreateUserIngester(ctx context.Context) {
-	for {
-		channel, err := h.createUserIngester.Ingest(ctx)
+	handler := &Handler{
+		repository:             repository,
+		tokens:                 tokens,
+		roles:                  roles,
+		attempts:               attempts,
+		apiKeys:                apiKeys,
+		sessions:               sessions,
+		federations:            federations,
+		adminActions:           adminActions,
+		auditLog:               auditLog,
+		providers:              providers,
+		mailer:                 mailer,
+		userRegisteredProducer: userRegisteredProducer,
+		accountLockedProducer:  accountLockedProducer,
+		newDeviceProducer:      newDeviceProducer,
+	}
 
-		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
-		}
+	handler.SetConfig(config)
 
-		for event := range channel {
-			log.Println("Processing create author message")
-			err := h.CreateUser(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
-		}
+	return handler
+}
 
-		// sleep message process every 10 seconds
-		time.Sleep(10 * time.Second)
-	}
+// Close flushes and closes the producers, once the server has stopped taking requests
+func (h *Handler) Close() {
+	h.userRegisteredProducer.Close()
+	h.accountLockedProducer.Close()
+	h.newDeviceProducer.Close()
+}
+
+// SetConfig replaces the config, requests already being handled keep the one they started with
+func (h *Handler) SetConfig(config Config) {
+	h.config.Store(&config)
 }
 
-func (h *Handler) LoginUser(ctx context.Context, req *gen.LoginUserRequest) (*gen.LoginUserResponse, error) {
+func (h *Handler) LoginUser(ctx context.Context, req *gen.LoginUserRequest) (resp *gen.LoginUserResponse, err error) {
 	if req == nil {
 		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
 	}
+
+	// successful logins are recorded when the session starts, after any second factor
+	defer func() {
+		if err != nil {
+			h.recordAuditResult(ctx, audit.Entry{
+				Category:  audit.Authentication,
+				Action:    "login",
+				ActorName: req.Username,
+				IP:        req.ClientIp,
+				UserAgent: req.UserAgent,
+				Details:   map[string]string{"method": "password"},
+			}, err)
+		}
+	}()
+
 	if req.Username == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "username was empty")
 	}
@@ -71,27 +153,85 @@ func (h *Handler) LoginUser(ctx context.Context, req *gen.LoginUserRequest) (*ge
 		return nil, status.Errorf(codes.InvalidArgument, "password was empty")
 	}
 
+	lockedOut, err := h.isLockedOut(ctx, req.Username, req.ClientIp)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if lockedOut {
+		return nil, status.Errorf(codes.ResourceExhausted, authModels.ErrLockedOut.Error())
+	}
+
 	user, err := h.repository.Authenticate(ctx, req.Username, req.Password)
 
 	if err != nil {
-		switch err {
-		case mongo.ErrNoDocuments:
-			return nil, status.Errorf(codes.NotFound, err.Error())
-		case authModels.ErrUnauthenticated:
-			return nil, status.Errorf(codes.Unauthenticated, err.Error())
-		default:
-			return nil, status.Errorf(codes.Unauthenticated, err.Error())
+		if err != authModels.ErrUnauthenticated {
+			return nil, status.Errorf(codes.Internal, err.Error())
 		}
+
+		h.recordLoginFailure(ctx, req.Username, req.ClientIp)
+
+		// unknown usernames and wrong passwords are indistinguishable to the caller
+		return nil, status.Errorf(codes.Unauthenticated, "invalid username or password")
 	}
 
-	if user == nil {
-		return nil, status.Errorf(codes.Unauthenticated, authModels.ErrUserNotFound.Error())
+	if err := h.attempts.Reset(ctx, accountKey(req.Username)); err != nil {
+		log.Printf("Failed to reset login attempts: %s\n", err)
 	}
 
+	// the password is known to be correct but an admin no longer trusts it
+	if user.PasswordResetRequired {
+		return nil, status.Errorf(codes.FailedPrecondition, authModels.ErrPasswordResetRequired.Error())
+	}
+
+	return h.completeLogin(ctx, user, clientInfo{ip: req.ClientIp, userAgent: req.UserAgent, method: "password"})
+}
+
+// completeLogin applies the checks shared by every way of logging in once the user is
+// known, and either starts a session for the user or asks for a second factor
+func (h *Handler) completeLogin(ctx context.Context, user *userModels.User, client clientInfo) (*gen.LoginUserResponse, error) {
+	if err := authModels.AccountBlocked(user); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
+	if !h.canLoginUnverified(user) {
+		return nil, status.Errorf(codes.FailedPrecondition, authModels.ErrEmailNotVerified.Error())
+	}
+
+	if user.MfaEnabled {
+		mfaToken, err := h.tokens.Create(ctx, user.ID, authModels.MfaChallengeToken, h.config.Load().MfaChallengeTtl)
+
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+
+		return &gen.LoginUserResponse{MfaRequired: true, MfaToken: mfaToken}, nil
+	}
+
+	enrollmentRequired, err := h.mfaRequiredForRoles(ctx, user.Roles)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp := loginResponse(user)
+	resp.MfaEnrollmentRequired = enrollmentRequired
+
+	if err := h.startSession(ctx, user, client, resp); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return resp, nil
+}
+
+func loginResponse(user *userModels.User) *gen.LoginUserResponse {
 	return &gen.LoginUserResponse{
+		Id:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
-	}, nil
+		Roles:    userModels.RolesToStrings(user.Roles),
+	}
 }
 
 func (h *Handler) GetUser(ctx context.Context, req *gen.GetUserRequest) (*gen.GetUserResponse, error) {
@@ -115,29 +255,62 @@ func (h *Handler) GetUser(ctx context.Context, req *gen.GetUserRequest) (*gen.Ge
 	return &gen.GetUserResponse{User: userModels.UserToProto(user)}, nil
 }
 
-func (h *Handler) CreateUser(ctx context.Context, req *events.CreateUserEvent) error {
+func (h *Handler) RegisterUser(ctx context.Context, req *gen.RegisterUserRequest) (*gen.RegisterUserResponse, error) {
 	if req == nil {
-		return status.Errorf(codes.InvalidArgument, "req was nil")
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
 	}
-	if req.Username == "" {
-		return status.Errorf(codes.InvalidArgument, "username was empty")
+
+	username := userModels.NormaliseUsername(req.Username)
+	email := userModels.NormaliseEmail(req.Email)
+
+	if username == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username was empty")
 	}
 	if req.Password == "" {
-		return status.Errorf(codes.InvalidArgument, "password was empty")
+		return nil, status.Errorf(codes.InvalidArgument, "password was empty")
+	}
+	if email == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "email was empty")
 	}
-	if req.Email == "" {
-		return status.Errorf(codes.InvalidArgument, "email was empty")
+	if err := h.config.Load().PasswordPolicy.Validate(req.Password, username, email); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
-	log.Printf("Create new user")
+	log.Printf("Register new user")
 
-	_, err := h.repository.Add(ctx, req)
+	user, err := h.repository.Add(ctx, &userModels.User{
+		Username:  username,
+		Email:     email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	}, req.Password)
 
 	if err != nil {
-		return status.Errorf(codes.Internal, err.Error())
+		switch err {
+		case authModels.ErrUsernameTaken:
+			return nil, grpcutil.ErrorWithReason(codes.AlreadyExists, grpcutil.ReasonUsernameTaken, err.Error())
+		case authModels.ErrEmailTaken:
+			return nil, grpcutil.ErrorWithReason(codes.AlreadyExists, grpcutil.ReasonEmailTaken, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	registeredEvent := events.UserRegisteredEvent{
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+	}
+
+	if err := h.userRegisteredProducer.Produce(ctx, registeredEvent); err != nil {
+		log.Printf("Failed to publish user registered event: %s\n", err)
+	}
+
+	if err := h.sendEmailVerification(ctx, user); err != nil {
+		log.Printf("Failed to send verification email: %s\n", err)
 	}
 
-	return nil
+	return &gen.RegisterUserResponse{User: userModels.UserToProto(user)}, nil
 }
 
 func (h *Handler) ValidateUsernameUnique(ctx context.Context, req *gen.ValidateUsernameUniqueRequest) (*gen.ValidateUsernameUniqueResponse, error) {
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (h *Handler) getUser(ctx context.Context, userId string) (*userModels.User, error) {
+	user, err := h.repository.GetById(ctx, userId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return user, nil
+}
+
+// RequestAccountDeletion schedules the account to be purged after the grace period and logs it
+// out everywhere. Until then it cannot be used and can be restored with CancelAccountDeletion.
+func (h *Handler) RequestAccountDeletion(ctx context.Context, req *gen.RequestAccountDeletionRequest) (*gen.RequestAccountDeletionResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	user, err := h.getUser(ctx, req.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// asking again does not push the deletion back
+	if user.DeleteAfter != nil {
+		return &gen.RequestAccountDeletionResponse{DeleteAfter: user.DeleteAfter.Unix()}, nil
+	}
+
+	deleteAfter := time.Now().Add(h.config.Load().DeletionGracePeriod)
+
+	if err := h.repository.ScheduleDeletion(ctx, user.ID, deleteAfter); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err := h.sessions.RevokeAll(ctx, user.ID); err != nil {
+		log.Printf("Failed to revoke sessions of deleted account: %s\n", err)
+	}
+
+	log.Printf("account %s scheduled for deletion after %s", user.ID, deleteAfter.Format(time.RFC3339))
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Account,
+		Action:   "deletion_requested",
+		Outcome:  audit.Success,
+		TargetID: user.ID,
+		Details:  map[string]string{"deleteAfter": deleteAfter.Format(time.RFC3339)},
+	})
+
+	return &gen.RequestAccountDeletionResponse{DeleteAfter: deleteAfter.Unix()}, nil
+}
+
+func (h *Handler) CancelAccountDeletion(ctx context.Context, req *gen.CancelAccountDeletionRequest) (*gen.CancelAccountDeletionResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	if _, err := h.getUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := h.repository.CancelDeletion(ctx, req.UserId); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.FailedPrecondition, "account is not scheduled for deletion")
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	log.Printf("deletion of account %s cancelled", req.UserId)
+
+	h.recordAudit(ctx, audit.Entry{Category: audit.Account, Action: "deletion_cancelled", Outcome: audit.Success, TargetID: req.UserId})
+
+	return &gen.CancelAccountDeletionResponse{}, nil
+}
+
+// ExportUserData collects the personal data held about a user. Password hashes, mfa secrets
+// and token hashes are left out.
+func (h *Handler) ExportUserData(ctx context.Context, req *gen.ExportUserDataRequest) (*gen.ExportUserDataResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	user, err := h.getUser(ctx, req.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessionDocs, err := h.sessions.ListActive(ctx, user.ID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	keyDocs, err := h.apiKeys.ListByUser(ctx, user.ID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	identityDocs, err := h.federations.ListByUser(ctx, user.ID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp := &gen.ExportUserDataResponse{
+		User:                userModels.UserToProto(user),
+		Sessions:            []*gen.Session{},
+		ApiKeys:             []*gen.ApiKey{},
+		FederatedIdentities: []*gen.FederatedIdentity{},
+	}
+
+	for i := range sessionDocs {
+		resp.Sessions = append(resp.Sessions, sessionDocs[i].ToProto())
+	}
+
+	for i := range keyDocs {
+		resp.ApiKeys = append(resp.ApiKeys, keyDocs[i].ToProto())
+	}
+
+	for i := range identityDocs {
+		resp.FederatedIdentities = append(resp.FederatedIdentities, identityDocs[i].ToProto())
+	}
+
+	return resp, nil
+}
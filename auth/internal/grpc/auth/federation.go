@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/federation"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/internal/grpcutil"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// provisioning tries the preferred username and then this many numbered variations of it
+const provisionUsernameAttempts int = 5
+
+func (h *Handler) getConnection(name string) (*federation.Connection, error) {
+	connection, ok := h.providers[name]
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, authModels.ErrProviderNotFound.Error())
+	}
+
+	return connection, nil
+}
+
+func (h *Handler) ListFederationProviders(ctx context.Context, req *gen.ListFederationProvidersRequest) (*gen.ListFederationProvidersResponse, error) {
+	return &gen.ListFederationProvidersResponse{Providers: h.providers.Names()}, nil
+}
+
+func (h *Handler) StartFederatedLogin(ctx context.Context, req *gen.StartFederatedLoginRequest) (*gen.StartFederatedLoginResponse, error) {
+	if req == nil || req.Provider == "" || req.RedirectUri == "" || req.BrowserBinding == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "provider, redirect uri and browser binding are required")
+	}
+
+	connection, err := h.getConnection(req.Provider)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codeVerifier, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	// the nonce only needs to be unguessable, a fresh verifier is as good as any
+	nonce, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	state, err := h.federations.CreateState(ctx, &authModels.FederationStateDocument{
+		Provider:     req.Provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RedirectURI:  req.RedirectUri,
+		ExpiresAt:    time.Now().Add(h.config.Load().FederationStateTtl),
+	}, req.BrowserBinding)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	authorizationUrl, err := connection.Provider.AuthCodeURL(ctx, state, nonce, oauth.CodeChallenge(codeVerifier), req.RedirectUri)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, err.Error())
+	}
+
+	return &gen.StartFederatedLoginResponse{AuthorizationUrl: authorizationUrl}, nil
+}
+
+// CompleteFederatedLogin finishes a login at an external provider. The identity is matched to
+// an existing link, then to a user with the same verified email, and is otherwise provisioned
+// as a new user when the provider allows it.
+func (h *Handler) CompleteFederatedLogin(ctx context.Context, req *gen.CompleteFederatedLoginRequest) (resp *gen.LoginUserResponse, err error) {
+	if req == nil || req.Provider == "" || req.State == "" || req.Code == "" || req.BrowserBinding == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "provider, state, code and browser binding are required")
+	}
+
+	defer func() {
+		if err != nil {
+			h.recordAuditResult(ctx, audit.Entry{
+				Category:  audit.Authentication,
+				Action:    "login",
+				IP:        req.ClientIp,
+				UserAgent: req.UserAgent,
+				Details:   map[string]string{"method": "federated", "provider": req.Provider},
+			}, err)
+		}
+	}()
+
+	connection, err := h.getConnection(req.Provider)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a state from another browser is rejected, otherwise a user could be sent a callback link
+	// that logs them in to someone else's account
+	stateDoc, err := h.federations.ConsumeState(ctx, req.Provider, req.State, req.BrowserBinding)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	identity, err := connection.Provider.Exchange(ctx, req.Code, stateDoc.CodeVerifier, stateDoc.RedirectURI)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	if identity.Nonce != stateDoc.Nonce {
+		return nil, status.Errorf(codes.Unauthenticated, "id token nonce does not match the login")
+	}
+
+	user, err := h.resolveFederatedUser(ctx, connection, identity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := connection.SyncRoles(user.Roles, identity.Groups)
+
+	if !slices.Equal(roles, user.Roles) {
+		if err := h.repository.SetRoles(ctx, user.ID, roles); err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+
+		h.recordAudit(ctx, audit.Entry{
+			Category:  audit.Authorization,
+			Action:    "roles_synced",
+			Outcome:   audit.Success,
+			ActorName: req.Provider,
+			TargetID:  user.ID,
+			Details: map[string]string{
+				"from": strings.Join(userModels.RolesToStrings(user.Roles), ","),
+				"to":   strings.Join(userModels.RolesToStrings(roles), ","),
+			},
+		})
+
+		user.Roles = roles
+	}
+
+	return h.completeLogin(ctx, user, clientInfo{ip: req.ClientIp, userAgent: req.UserAgent, method: "federated:" + req.Provider})
+}
+
+func (h *Handler) resolveFederatedUser(ctx context.Context, connection *federation.Connection, identity *federation.Identity) (*userModels.User, error) {
+	provider := connection.Config.Name
+
+	linked, err := h.federations.GetIdentity(ctx, provider, identity.Subject)
+
+	if err == nil {
+		if err := h.federations.TouchIdentity(ctx, linked.ID, identity.Email); err != nil {
+			log.Printf("Failed to record federated login: %s\n", err)
+		}
+
+		user, err := h.repository.GetById(ctx, linked.UserID.Hex())
+
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+
+		return user, nil
+	}
+
+	if err != authModels.ErrIdentityNotLinked {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	// an unverified email could belong to anyone, it is never used to link or provision
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, status.Errorf(codes.PermissionDenied, "%s: the provider did not supply a verified email", authModels.ErrIdentityNotLinked.Error())
+	}
+
+	user, err := h.repository.GetByEmail(ctx, identity.Email)
+
+	switch {
+	case err == nil:
+		if user.ServiceAccount {
+			return nil, status.Errorf(codes.PermissionDenied, authModels.ErrIdentityNotLinked.Error())
+		}
+
+		if !user.EmailVerified {
+			if err := h.repository.MarkEmailVerified(ctx, user.ID); err != nil {
+				return nil, status.Errorf(codes.Internal, err.Error())
+			}
+
+			user.EmailVerified = true
+		}
+	case err == mongo.ErrNoDocuments && connection.Config.ProvisionUsers:
+		user, err = h.provisionFederatedUser(ctx, connection, identity)
+
+		if err != nil {
+			return nil, err
+		}
+	case err == mongo.ErrNoDocuments:
+		return nil, status.Errorf(codes.PermissionDenied, authModels.ErrIdentityNotLinked.Error())
+	default:
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	userId, err := primitive.ObjectIDFromHex(user.ID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	err = h.federations.LinkIdentity(ctx, &authModels.FederatedIdentityDocument{
+		Provider: provider,
+		Subject:  identity.Subject,
+		UserID:   userId,
+		Email:    userModels.NormaliseEmail(identity.Email),
+	})
+
+	if err != nil {
+		// a concurrent login linked the identity first, log in as whoever it was linked to
+		if mongo.IsDuplicateKeyError(err) {
+			return h.resolveFederatedUser(ctx, connection, identity)
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return user, nil
+}
+
+// provisionFederatedUser creates a user for an identity seen for the first time, trying
+// numbered usernames when the preferred one is taken
+func (h *Handler) provisionFederatedUser(ctx context.Context, connection *federation.Connection, identity *federation.Identity) (*userModels.User, error) {
+	username := identity.PreferredUsername
+
+	if username == "" {
+		username, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	username = userModels.NormaliseUsername(username)
+
+	for attempt := 0; attempt <= provisionUsernameAttempts; attempt++ {
+		candidate := username
+
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s%d", username, attempt+1)
+		}
+
+		user, err := h.repository.AddFederated(ctx, &userModels.User{
+			Username:  candidate,
+			Email:     identity.Email,
+			FirstName: identity.GivenName,
+			LastName:  identity.FamilyName,
+			Roles:     connection.MapRoles(identity.Groups),
+		})
+
+		switch err {
+		case nil:
+			registeredEvent := events.UserRegisteredEvent{
+				ID:       user.ID,
+				Username: user.Username,
+				Email:    user.Email,
+			}
+
+			if err := h.userRegisteredProducer.Produce(ctx, registeredEvent); err != nil {
+				log.Printf("Failed to publish user registered event: %s\n", err)
+			}
+
+			return user, nil
+		case authModels.ErrUsernameTaken:
+			continue
+		case authModels.ErrEmailTaken:
+			return nil, grpcutil.ErrorWithReason(codes.AlreadyExists, grpcutil.ReasonEmailTaken, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return nil, grpcutil.ErrorWithReason(codes.AlreadyExists, grpcutil.ReasonUsernameTaken, authModels.ErrUsernameTaken.Error())
+}
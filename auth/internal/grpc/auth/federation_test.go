@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/federation"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memoryUsers keeps users in memory, the methods the tests do not use panic
+type memoryUsers struct {
+	db.AuthRepository
+	mu    sync.Mutex
+	users map[string]*userModels.User
+}
+
+func (r *memoryUsers) add(user userModels.User) *userModels.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user.ID = primitive.NewObjectID().Hex()
+	r.users[user.ID] = &user
+
+	return &user
+}
+
+func (r *memoryUsers) GetById(ctx context.Context, id string) (*userModels.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	copied := *user
+	return &copied, nil
+}
+
+func (r *memoryUsers) GetByEmail(ctx context.Context, email string) (*userModels.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == userModels.NormaliseEmail(email) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *memoryUsers) SetRoles(ctx context.Context, id string, roles []userModels.UserRole) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[id].Roles = roles
+
+	return nil
+}
+
+// memoryFederations keeps pending logins and linked identities in memory
+type memoryFederations struct {
+	db.FederationRepository
+	mu         sync.Mutex
+	states     map[string]authModels.FederationStateDocument
+	bindings   map[string]string
+	identities []authModels.FederatedIdentityDocument
+}
+
+func (r *memoryFederations) CreateState(ctx context.Context, stateDoc *authModels.FederationStateDocument, binding string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := primitive.NewObjectID().Hex()
+	r.states[state] = *stateDoc
+	r.bindings[state] = binding
+
+	return state, nil
+}
+
+func (r *memoryFederations) ConsumeState(ctx context.Context, provider string, state string, binding string) (*authModels.FederationStateDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stateDoc, ok := r.states[state]
+
+	if !ok || stateDoc.Provider != provider || r.bindings[state] != binding || time.Now().After(stateDoc.ExpiresAt) {
+		return nil, authModels.ErrInvalidToken
+	}
+
+	delete(r.states, state)
+
+	return &stateDoc, nil
+}
+
+func (r *memoryFederations) GetIdentity(ctx context.Context, provider string, subject string) (*authModels.FederatedIdentityDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+
+	return nil, authModels.ErrIdentityNotLinked
+}
+
+func (r *memoryFederations) LinkIdentity(ctx context.Context, identity *authModels.FederatedIdentityDocument) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity.ID = primitive.NewObjectID()
+	r.identities = append(r.identities, *identity)
+
+	return nil
+}
+
+func (r *memoryFederations) TouchIdentity(ctx context.Context, id primitive.ObjectID, email string) error {
+	return nil
+}
+
+// memorySessions starts sessions without storing them
+type memorySessions struct {
+	db.SessionRepository
+}
+
+func (r *memorySessions) KnownDevices(ctx context.Context, userId string) ([]string, error) {
+	return nil, nil
+}
+
+func (r *memorySessions) Create(ctx context.Context, sessionDoc *authModels.SessionDocument) (string, error) {
+	sessionDoc.ID = primitive.NewObjectID()
+	return "refresh-" + sessionDoc.ID.Hex(), nil
+}
+
+type noMfaRoles struct {
+	db.RoleRepository
+}
+
+func (r *noMfaRoles) GetMfaRequiredRoles(ctx context.Context) ([]userModels.UserRole, error) {
+	return nil, nil
+}
+
+type discardAudit struct{}
+
+func (discardAudit) Record(ctx context.Context, entry audit.Entry) error {
+	return nil
+}
+
+// loadFakeProvider configures a fake provider the way the service does, from a federation config file
+func loadFakeProvider(t *testing.T) (federation.Connections, *federation.FakeProvider) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "federation.json")
+	config := `{"providers": [{"name": "corp", "type": "fake", "roleMapping": {"bookstore-admins": ["admin"]}}]}`
+
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	connections, err := federation.LoadFromFile(path, nil)
+
+	if err != nil {
+		t.Fatalf("LoadFromFile: %s", err)
+	}
+
+	fake, ok := connections["corp"].Provider.(*federation.FakeProvider)
+
+	if !ok {
+		t.Fatalf("provider is a %T, expected the fake provider", connections["corp"].Provider)
+	}
+
+	return connections, fake
+}
+
+// federatedLogin starts a login in a browser holding binding, approves it at the fake provider
+// as identity and completes it with the callback binding
+func federatedLogin(t *testing.T, h *Handler, fake *federation.FakeProvider, identity federation.Identity, binding string, callbackBinding string) (*gen.LoginUserResponse, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	redirectUri := "https://bookstore.test/auth/federation/corp/callback"
+
+	start, err := h.StartFederatedLogin(ctx, &gen.StartFederatedLoginRequest{Provider: "corp", RedirectUri: redirectUri, BrowserBinding: binding})
+
+	if err != nil {
+		t.Fatalf("StartFederatedLogin: %s", err)
+	}
+
+	authorizationUrl, err := url.Parse(start.AuthorizationUrl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := authorizationUrl.Query().Get("state")
+
+	if got := authorizationUrl.Query().Get("redirect_uri"); got != redirectUri {
+		t.Fatalf("provider was given redirect uri %q, expected %q", got, redirectUri)
+	}
+
+	code, err := fake.Approve(state, identity)
+
+	if err != nil {
+		t.Fatalf("Approve: %s", err)
+	}
+
+	return h.CompleteFederatedLogin(ctx, &gen.CompleteFederatedLoginRequest{
+		Provider:       "corp",
+		State:          state,
+		Code:           code,
+		BrowserBinding: callbackBinding,
+		ClientIp:       "192.0.2.1",
+		UserAgent:      "test",
+	})
+}
+
+func TestFederatedLoginLinksAccountAndMapsGroups(t *testing.T) {
+	connections, fake := loadFakeProvider(t)
+
+	users := &memoryUsers{users: map[string]*userModels.User{}}
+	federations := &memoryFederations{states: map[string]authModels.FederationStateDocument{}, bindings: map[string]string{}}
+
+	alice := users.add(userModels.User{Username: "alice", Email: "alice@example.com", EmailVerified: true, Roles: []userModels.UserRole{}})
+
+	h := &Handler{
+		repository:  users,
+		federations: federations,
+		sessions:    &memorySessions{},
+		roles:       &noMfaRoles{},
+		auditLog:    discardAudit{},
+		providers:   connections,
+	}
+	h.SetConfig(Config{FederationStateTtl: time.Minute, SessionTtl: time.Hour, VerificationPolicy: VerificationOptional})
+
+	identity := federation.Identity{
+		Subject:       "corp-alice",
+		Email:         "Alice@example.com",
+		EmailVerified: true,
+		Groups:        []string{"bookstore-admins"},
+	}
+
+	// the first login links the identity to the user with the same verified email
+	resp, err := federatedLogin(t, h, fake, identity, "browser", "browser")
+
+	if err != nil {
+		t.Fatalf("CompleteFederatedLogin: %s", err)
+	}
+
+	if resp.Id != alice.ID {
+		t.Fatalf("logged in as %q, expected %q", resp.Id, alice.ID)
+	}
+	if !slices.Contains(resp.Roles, string(userModels.Admin)) {
+		t.Fatalf("roles are %v, expected the admin role from the bookstore-admins group", resp.Roles)
+	}
+	if resp.SessionId == "" || resp.RefreshToken == "" {
+		t.Fatal("no session was started")
+	}
+
+	linked, err := federations.GetIdentity(context.Background(), "corp", "corp-alice")
+
+	if err != nil || linked.UserID.Hex() != alice.ID {
+		t.Fatalf("identity was not linked to alice: %v %v", linked, err)
+	}
+
+	// later logins use the link, and leaving the group takes the role away
+	identity.Email = "alice@corp.example"
+	identity.Groups = nil
+
+	resp, err = federatedLogin(t, h, fake, identity, "browser", "browser")
+
+	if err != nil {
+		t.Fatalf("CompleteFederatedLogin: %s", err)
+	}
+
+	if resp.Id != alice.ID {
+		t.Fatalf("logged in as %q, expected %q", resp.Id, alice.ID)
+	}
+	if slices.Contains(resp.Roles, string(userModels.Admin)) {
+		t.Fatalf("roles are %v, expected the admin role to be removed", resp.Roles)
+	}
+}
+
+func TestFederatedLoginRejectsOtherBrowsers(t *testing.T) {
+	connections, fake := loadFakeProvider(t)
+
+	users := &memoryUsers{users: map[string]*userModels.User{}}
+	users.add(userModels.User{Username: "mallory", Email: "mallory@example.com", EmailVerified: true})
+
+	h := &Handler{
+		repository:  users,
+		federations: &memoryFederations{states: map[string]authModels.FederationStateDocument{}, bindings: map[string]string{}},
+		sessions:    &memorySessions{},
+		roles:       &noMfaRoles{},
+		auditLog:    discardAudit{},
+		providers:   connections,
+	}
+	h.SetConfig(Config{FederationStateTtl: time.Minute, SessionTtl: time.Hour})
+
+	// a callback link for a login started elsewhere must not log the victim in
+	identity := federation.Identity{Subject: "corp-mallory", Email: "mallory@example.com", EmailVerified: true}
+
+	_, err := federatedLogin(t, h, fake, identity, "attacker", "victim")
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected the login to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// accountKey counts failures by the username that was tried, whether or not it exists,
+// so a lockout does not reveal which usernames are registered
+func accountKey(username string) string {
+	return "account:" + strings.ToLower(userModels.NormaliseUsername(username))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// isLockedOut reports whether the account or the client address is currently locked
+func (h *Handler) isLockedOut(ctx context.Context, username string, clientIp string) (bool, error) {
+	keys := []string{accountKey(username)}
+
+	if clientIp != "" {
+		keys = append(keys, ipKey(clientIp))
+	}
+
+	now := time.Now()
+
+	for _, key := range keys {
+		attempts, err := h.attempts.Get(ctx, key)
+
+		if err != nil {
+			return false, err
+		}
+
+		if attempts.IsLocked(now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the client address
+func (h *Handler) recordLoginFailure(ctx context.Context, username string, clientIp string) {
+	attempts, err := h.attempts.RecordFailure(ctx, accountKey(username), h.config.Load().AccountLockout)
+
+	if err != nil {
+		log.Printf("Failed to record login failure: %s\n", err)
+	} else {
+		h.reportAccountLocked(ctx, username, clientIp, attempts)
+	}
+
+	if clientIp == "" {
+		return
+	}
+
+	ipAttempts, err := h.attempts.RecordFailure(ctx, ipKey(clientIp), h.config.Load().IpLockout)
+
+	if err != nil {
+		log.Printf("Failed to record login failure: %s\n", err)
+	} else if ipAttempts.Failures == h.config.Load().IpLockout.Threshold {
+		log.Printf("client %s locked out after %d failed logins", clientIp, ipAttempts.Failures)
+	}
+}
+
+func (h *Handler) reportAccountLocked(ctx context.Context, username string, clientIp string, attempts *authModels.AttemptDocument) {
+	if attempts.LockedUntil == nil {
+		return
+	}
+
+	log.Printf("account %q locked until %s after %d failed logins from %s", username, attempts.LockedUntil.Format(time.RFC3339), attempts.Failures, clientIp)
+
+	lockedEvent := events.AccountLockedEvent{
+		Username:    userModels.NormaliseUsername(username),
+		ClientIP:    clientIp,
+		Failures:    attempts.Failures,
+		LockedUntil: *attempts.LockedUntil,
+	}
+
+	h.recordAudit(ctx, audit.Entry{
+		Category:  audit.Authentication,
+		Action:    "account_locked",
+		Outcome:   audit.Denied,
+		ActorName: lockedEvent.Username,
+		IP:        clientIp,
+		Details: map[string]string{
+			"failures":    strconv.Itoa(attempts.Failures),
+			"lockedUntil": attempts.LockedUntil.Format(time.RFC3339),
+		},
+	})
+
+	if err := h.accountLockedProducer.Produce(ctx, lockedEvent); err != nil {
+		log.Printf("Failed to publish account locked event: %s\n", err)
+	}
+}
+
+func (h *Handler) UnlockUser(ctx context.Context, req *gen.UnlockUserRequest) (*gen.UnlockUserResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	user, err := h.repository.GetById(ctx, req.UserId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if err := h.attempts.Reset(ctx, accountKey(user.Username)); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	log.Printf("account %q unlocked", user.Username)
+
+	h.recordAudit(ctx, audit.Entry{Category: audit.Admin, Action: "unlock", Outcome: audit.Success, TargetID: user.ID})
+
+	return &gen.UnlockUserResponse{}, nil
+}
+
+// DefaultAccountLockout locks an account for a minute after 5 failures, doubling up to a day
+var DefaultAccountLockout = db.LockoutPolicy{
+	Threshold:   5,
+	BaseLockout: time.Minute,
+	MaxLockout:  24 * time.Hour,
+	Window:      time.Hour,
+}
+
+// DefaultIpLockout is looser than the account policy as many users can share an address
+var DefaultIpLockout = db.LockoutPolicy{
+	Threshold:   50,
+	BaseLockout: time.Minute,
+	MaxLockout:  time.Hour,
+	Window:      time.Hour,
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/totp"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	recoveryCodeCount int = 10
+	// attempts allowed against a single challenge before the password has to be entered again
+	mfaChallengeAttempts int = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns the plain codes to show the user once and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	var codes []string
+	var hashes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, 5)
+
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(bytes))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) mfaRequiredForRoles(ctx context.Context, roles []userModels.UserRole) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	requiredRoles, err := h.roles.GetMfaRequiredRoles(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if slices.Contains(requiredRoles, role) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkMfaCode accepts a current TOTP code that has not been used before, or an unused recovery code
+func (h *Handler) checkMfaCode(ctx context.Context, userId string, settings *authModels.MfaSettings, code string) (bool, error) {
+	if step, ok := totp.Validate(settings.Secret, code, time.Now()); ok {
+		return h.repository.UseMfaStep(ctx, userId, step)
+	}
+
+	return h.repository.UseRecoveryCode(ctx, userId, hashRecoveryCode(code))
+}
+
+func (h *Handler) getMfaSettings(ctx context.Context, userId string) (*authModels.MfaSettings, error) {
+	settings, err := h.repository.GetMfaSettings(ctx, userId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return settings, nil
+}
+
+func (h *Handler) VerifyMfa(ctx context.Context, req *gen.VerifyMfaRequest) (resp *gen.LoginUserResponse, err error) {
+	if req == nil || req.MfaToken == "" || req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "mfa token and code are required")
+	}
+
+	var userId string
+
+	defer func() {
+		if err != nil {
+			h.recordAuditResult(ctx, audit.Entry{
+				Category:  audit.Authentication,
+				Action:    "login",
+				ActorID:   userId,
+				IP:        req.ClientIp,
+				UserAgent: req.UserAgent,
+				Details:   map[string]string{"method": "mfa"},
+			}, err)
+		}
+	}()
+
+	userId, err = h.tokens.Attempt(ctx, req.MfaToken, authModels.MfaChallengeToken, mfaChallengeAttempts)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.Unauthenticated, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	settings, err := h.getMfaSettings(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := h.checkMfaCode(ctx, userId, settings, req.Code)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, authModels.ErrInvalidMfaCode.Error())
+	}
+
+	if _, err := h.tokens.Consume(ctx, req.MfaToken, authModels.MfaChallengeToken); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	user, err := h.repository.GetById(ctx, userId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	// the account may have been disabled since the password was checked
+	if err := authModels.AccountBlocked(user); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
+	resp = loginResponse(user)
+
+	if err := h.startSession(ctx, user, clientInfo{ip: req.ClientIp, userAgent: req.UserAgent, method: "mfa"}, resp); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) EnrollMfa(ctx context.Context, req *gen.EnrollMfaRequest) (*gen.EnrollMfaResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	user, err := h.repository.GetById(ctx, req.UserId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if user.MfaEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "mfa is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err := h.repository.SetPendingMfaSecret(ctx, user.ID, secret); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return &gen.EnrollMfaResponse{
+		Secret:          secret,
+		ProvisioningUri: totp.ProvisioningURI(h.config.Load().MfaIssuer, user.Username, secret),
+	}, nil
+}
+
+func (h *Handler) ConfirmMfa(ctx context.Context, req *gen.ConfirmMfaRequest) (*gen.ConfirmMfaResponse, error) {
+	if req == nil || req.UserId == "" || req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user id and code are required")
+	}
+
+	settings, err := h.getMfaSettings(ctx, req.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.PendingSecret == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, authModels.ErrMfaNotEnrolled.Error())
+	}
+
+	if _, ok := totp.Validate(settings.PendingSecret, req.Code, time.Now()); !ok {
+		return nil, status.Errorf(codes.InvalidArgument, authModels.ErrInvalidMfaCode.Error())
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := generateRecoveryCodes()
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err := h.repository.EnableMfa(ctx, req.UserId, settings.PendingSecret, recoveryCodeHashes); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Errorf(codes.Aborted, "enrolment changed while confirming")
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	log.Printf("mfa enabled for user %s", req.UserId)
+
+	h.recordAudit(ctx, audit.Entry{Category: audit.Credential, Action: "mfa_enabled", Outcome: audit.Success, TargetID: req.UserId})
+
+	return &gen.ConfirmMfaResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (h *Handler) DisableMfa(ctx context.Context, req *gen.DisableMfaRequest) (*gen.DisableMfaResponse, error) {
+	if req == nil || req.UserId == "" || req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user id and code are required")
+	}
+
+	settings, err := h.getMfaSettings(ctx, req.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.Enabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "mfa is not enabled")
+	}
+
+	ok, err := h.checkMfaCode(ctx, req.UserId, settings, req.Code)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, authModels.ErrInvalidMfaCode.Error())
+	}
+
+	if err := h.repository.DisableMfa(ctx, req.UserId); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	log.Printf("mfa disabled for user %s", req.UserId)
+
+	h.recordAudit(ctx, audit.Entry{Category: audit.Credential, Action: "mfa_disabled", Outcome: audit.Success, TargetID: req.UserId})
+
+	return &gen.DisableMfaResponse{}, nil
+}
+
+func (h *Handler) SetRoleMfaRequirement(ctx context.Context, req *gen.SetRoleMfaRequirementRequest) (*gen.SetRoleMfaRequirementResponse, error) {
+	if req == nil || req.Role == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or role was empty")
+	}
+
+	if err := h.roles.SetMfaRequired(ctx, userModels.UserRole(req.Role), req.Required); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	log.Printf("mfa requirement for role %s set to %t", req.Role, req.Required)
+
+	h.recordAudit(ctx, audit.Entry{
+		Category: audit.Authorization,
+		Action:   "role_mfa_requirement",
+		Outcome:  audit.Success,
+		TargetID: req.Role,
+		Details:  map[string]string{"required": strconv.FormatBool(req.Required)},
+	})
+
+	return &gen.SetRoleMfaRequirementResponse{}, nil
+}
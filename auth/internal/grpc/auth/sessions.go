@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clientInfo describes where a login came from, as reported by the api service
+type clientInfo struct {
+	ip        string
+	userAgent string
+	// how the user proved who they are, recorded in the audit log
+	method string
+}
+
+// user agent tokens checked in order, more specific products come before the ones they imitate
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"okhttp/", "Android app"},
+	{"Go-http-client/", "Go client"},
+}
+
+var operatingSystems = []struct{ token, name string }{
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"CrOS", "ChromeOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// describeDevice reduces a user agent to a browser and operating system such as "Firefox on Linux".
+// Version numbers are left out so a browser update is not reported as a new device.
+func describeDevice(userAgent string) string {
+	browser := ""
+	for _, candidate := range browsers {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	system := ""
+	for _, candidate := range operatingSystems {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+// startSession records a completed login and adds the session and its refresh token to the response
+func (h *Handler) startSession(ctx context.Context, user *userModels.User, client clientInfo, resp *gen.LoginUserResponse) error {
+	userId, err := primitive.ObjectIDFromHex(user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	device := describeDevice(client.userAgent)
+
+	// checked before the session is stored, otherwise the device is always known
+	knownDevices, err := h.sessions.KnownDevices(ctx, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	sessionDoc := &authModels.SessionDocument{
+		UserID:    userId,
+		Device:    device,
+		UserAgent: client.userAgent,
+		IP:        client.ip,
+		ExpiresAt: time.Now().Add(h.config.Load().SessionTtl),
+	}
+
+	refreshToken, err := h.sessions.Create(ctx, sessionDoc)
+
+	if err != nil {
+		return err
+	}
+
+	resp.SessionId = sessionDoc.ID.Hex()
+	resp.RefreshToken = refreshToken
+
+	h.recordAudit(ctx, audit.Entry{
+		Category:  audit.Authentication,
+		Action:    "login",
+		Outcome:   audit.Success,
+		ActorID:   user.ID,
+		ActorName: user.Username,
+		TargetID:  resp.SessionId,
+		IP:        client.ip,
+		UserAgent: client.userAgent,
+		Details:   map[string]string{"method": client.method, "device": device},
+	})
+
+	// a user's first login is not worth telling them about
+	if len(knownDevices) > 0 && !slices.Contains(knownDevices, device) {
+		h.reportNewDevice(ctx, user, sessionDoc)
+	}
+
+	return nil
+}
+
+func (h *Handler) reportNewDevice(ctx context.Context, user *userModels.User, sessionDoc *authModels.SessionDocument) {
+	newDeviceEvent := events.NewDeviceLoginEvent{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		SessionID: sessionDoc.ID.Hex(),
+		Device:    sessionDoc.Device,
+		UserAgent: sessionDoc.UserAgent,
+		ClientIP:  sessionDoc.IP,
+		LoginAt:   sessionDoc.CreatedAt,
+	}
+
+	if err := h.newDeviceProducer.Produce(ctx, newDeviceEvent); err != nil {
+		log.Printf("Failed to publish new device login event: %s\n", err)
+	}
+}
+
+// RefreshSession exchanges a refresh token for a new one. The user is read again so the
+// response carries their current roles.
+func (h *Handler) RefreshSession(ctx context.Context, req *gen.RefreshSessionRequest) (resp *gen.LoginUserResponse, err error) {
+	if req == nil || req.RefreshToken == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or refresh token was empty")
+	}
+
+	entry := audit.Entry{
+		Category:  audit.Session,
+		Action:    "refresh",
+		IP:        req.ClientIp,
+		UserAgent: req.UserAgent,
+	}
+
+	defer func() {
+		h.recordAuditResult(ctx, entry, err)
+	}()
+
+	sessionDoc, refreshToken, err := h.sessions.Refresh(ctx, req.RefreshToken, req.ClientIp, req.UserAgent)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.Unauthenticated, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	entry.ActorID = sessionDoc.UserID.Hex()
+	entry.TargetID = sessionDoc.ID.Hex()
+
+	user, err := h.repository.GetById(ctx, sessionDoc.UserID.Hex())
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.Unauthenticated, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if err := authModels.AccountBlocked(user); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	enrollmentRequired, err := h.mfaRequiredForRoles(ctx, user.Roles)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp = loginResponse(user)
+	resp.MfaEnrollmentRequired = enrollmentRequired && !user.MfaEnabled
+	resp.SessionId = sessionDoc.ID.Hex()
+	resp.RefreshToken = refreshToken
+
+	return resp, nil
+}
+
+func (h *Handler) ListSessions(ctx context.Context, req *gen.ListSessionsRequest) (*gen.ListSessionsResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	sessionDocs, err := h.sessions.ListActive(ctx, req.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	sessions := []*gen.Session{}
+
+	for i := range sessionDocs {
+		sessions = append(sessions, sessionDocs[i].ToProto())
+	}
+
+	return &gen.ListSessionsResponse{Sessions: sessions}, nil
+}
+
+func (h *Handler) RevokeSession(ctx context.Context, req *gen.RevokeSessionRequest) (resp *gen.RevokeSessionResponse, err error) {
+	if req == nil || req.UserId == "" || req.SessionId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req, user id or session id was empty")
+	}
+
+	defer func() {
+		h.recordAuditResult(ctx, audit.Entry{
+			Category: audit.Session,
+			Action:   "revoke",
+			TargetID: req.SessionId,
+			Details:  map[string]string{"userId": req.UserId},
+		}, err)
+	}()
+
+	if err := h.sessions.Revoke(ctx, req.UserId, req.SessionId); err != nil {
+		switch err {
+		case authModels.ErrSessionNotFound:
+			return nil, status.Errorf(codes.NotFound, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return &gen.RevokeSessionResponse{}, nil
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/mailer"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// canLoginUnverified applies the verification policy to a user who has authenticated
+func (h *Handler) canLoginUnverified(user *userModels.User) bool {
+	if user.EmailVerified {
+		return true
+	}
+
+	switch h.config.Load().VerificationPolicy {
+	case VerificationRequired:
+		return false
+	case VerificationGrace:
+		return time.Since(user.CreatedAt) < h.config.Load().VerificationGracePeriod
+	default:
+		return true
+	}
+}
+
+func (h *Handler) buildLink(path string, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", h.config.Load().PublicUrl, path, url.QueryEscape(token))
+}
+
+// passwordResetLink links to the reset page of the frontend when one is configured, otherwise
+// to the form served by the api
+func (h *Handler) passwordResetLink(token string) string {
+	page, err := url.Parse(h.config.Load().PasswordResetUrl)
+
+	if err != nil || page.String() == "" {
+		return h.buildLink("/auth/password/reset", token)
+	}
+
+	query := page.Query()
+	query.Set("token", token)
+	page.RawQuery = query.Encode()
+
+	return page.String()
+}
+
+func (h *Handler) sendEmailVerification(ctx context.Context, user *userModels.User) error {
+	if err := h.tokens.RevokeAll(ctx, user.ID, authModels.EmailVerificationToken); err != nil {
+		return err
+	}
+
+	token, err := h.tokens.Create(ctx, user.ID, authModels.EmailVerificationToken, h.config.Load().VerificationTokenTtl)
+
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below, it expires in %s.\n\n%s\n",
+			user.Username, h.config.Load().VerificationTokenTtl, h.buildLink("/auth/verify", token)),
+	})
+}
+
+func (h *Handler) SendEmailVerification(ctx context.Context, req *gen.SendEmailVerificationRequest) (*gen.SendEmailVerificationResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	user, err := h.repository.GetById(ctx, req.UserId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, authModels.ErrUserNotFound.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if user.EmailVerified {
+		return nil, status.Errorf(codes.FailedPrecondition, "email address is already verified")
+	}
+
+	if err := h.sendEmailVerification(ctx, user); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return &gen.SendEmailVerificationResponse{}, nil
+}
+
+func (h *Handler) VerifyEmail(ctx context.Context, req *gen.VerifyEmailRequest) (*gen.VerifyEmailResponse, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or token was empty")
+	}
+
+	userId, err := h.tokens.Consume(ctx, req.Token, authModels.EmailVerificationToken)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if err := h.repository.MarkEmailVerified(ctx, userId); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return &gen.VerifyEmailResponse{UserId: userId}, nil
+}
+
+// RequestPasswordReset always succeeds for a well formed request so the response
+// does not reveal which email addresses are registered
+func (h *Handler) RequestPasswordReset(ctx context.Context, req *gen.RequestPasswordResetRequest) (*gen.RequestPasswordResetResponse, error) {
+	if req == nil || req.Email == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or email was empty")
+	}
+
+	user, err := h.repository.GetByEmail(ctx, req.Email)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to look up user for password reset: %s\n", err)
+		}
+		return &gen.RequestPasswordResetResponse{}, nil
+	}
+
+	// service accounts have no password to reset
+	if user.ServiceAccount {
+		return &gen.RequestPasswordResetResponse{}, nil
+	}
+
+	if err := h.sendPasswordReset(ctx, user, "A password reset was requested for your account.", "If you did not request this you can ignore this email."); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return &gen.RequestPasswordResetResponse{}, nil
+}
+
+// sendPasswordReset replaces any outstanding reset link with a new one and emails it to the user
+func (h *Handler) sendPasswordReset(ctx context.Context, user *userModels.User, intro string, outro string) error {
+	if err := h.tokens.RevokeAll(ctx, user.ID, authModels.PasswordResetToken); err != nil {
+		return err
+	}
+
+	token, err := h.tokens.Create(ctx, user.ID, authModels.PasswordResetToken, h.config.Load().PasswordResetTokenTtl)
+
+	if err != nil {
+		return err
+	}
+
+	err = h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n%s Open the link below to choose a new password, it expires in %s.\n\n%s\n\n%s\n",
+			user.Username, intro, h.config.Load().PasswordResetTokenTtl, h.passwordResetLink(token), outro),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send password reset email: %s\n", err)
+	}
+
+	return nil
+}
+
+func (h *Handler) ResetPassword(ctx context.Context, req *gen.ResetPasswordRequest) (resp *gen.ResetPasswordResponse, err error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or token was empty")
+	}
+	if req.Password == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "password was empty")
+	}
+	var userId string
+
+	defer func() {
+		h.recordAuditResult(ctx, audit.Entry{Category: audit.Credential, Action: "password_reset", ActorID: userId, TargetID: userId}, err)
+	}()
+
+	userId, err = h.tokens.Lookup(ctx, req.Token, authModels.PasswordResetToken)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	user, err := h.repository.GetById(ctx, userId)
+
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.InvalidArgument, authModels.ErrInvalidToken.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	// the same rules as registering, the password must not contain the username or email
+	if err := h.config.Load().PasswordPolicy.Validate(req.Password, user.Username, user.Email); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	// the token is consumed before the password is changed so two requests with the same link
+	// cannot both set one. It is only consumed once the password has been accepted so a rejected
+	// password leaves the link usable.
+	if _, err := h.tokens.Consume(ctx, req.Token, authModels.PasswordResetToken); err != nil {
+		switch err {
+		case authModels.ErrInvalidToken:
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	if err := h.repository.SetPassword(ctx, userId, req.Password); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	// the reset link proved ownership of the address
+	if err := h.repository.MarkEmailVerified(ctx, userId); err != nil {
+		log.Printf("Failed to mark email verified after reset: %s\n", err)
+	}
+
+	// whoever knew the old password may still be logged in
+	if err := h.sessions.RevokeAll(ctx, userId); err != nil {
+		log.Printf("Failed to revoke sessions after reset: %s\n", err)
+	}
+
+	return &gen.ResetPasswordResponse{}, nil
+}
@@ -0,0 +1,497 @@
+package provider
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/oidc"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// issuer identifier, the public url of the api
+	Issuer     string
+	CodeTtl    time.Duration
+	IdTokenTtl time.Duration
+}
+
+// Handler is the OAuth 2 authorization server. Protocol errors the client should see are
+// returned in the response, grpc errors are only used when the request cannot be handled.
+type Handler struct {
+	gen.UnimplementedOAuthServiceServer
+	repository db.OAuthRepository
+	users      db.AuthRepository
+	signer     *oidc.Signer
+	config     Config
+}
+
+func New(repository db.OAuthRepository, users db.AuthRepository, signer *oidc.Signer, config Config) *Handler {
+	return &Handler{
+		repository: repository,
+		users:      users,
+		signer:     signer,
+		config:     config,
+	}
+}
+
+func oauthError(code string, description string) *gen.OAuthError {
+	return &gen.OAuthError{Code: code, Description: description}
+}
+
+// validRedirectUri accepts https, http on the loopback interface for development, and
+// private-use schemes such as com.example.app:/callback for native public clients
+func validRedirectUri(raw string, public bool) bool {
+	uri, err := url.Parse(raw)
+
+	if err != nil || !uri.IsAbs() || uri.Fragment != "" {
+		return false
+	}
+
+	switch uri.Scheme {
+	case "https":
+		return uri.Host != ""
+	case "http":
+		return slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, uri.Hostname())
+	default:
+		return public
+	}
+}
+
+func (h *Handler) RegisterClient(ctx context.Context, req *gen.RegisterClientRequest) (*gen.RegisterClientResponse, error) {
+	if req == nil || req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or name was empty")
+	}
+
+	ownerOid, err := primitive.ObjectIDFromHex(req.OwnerId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "owner id is not valid")
+	}
+
+	grantTypes := req.GrantTypes
+
+	if len(grantTypes) == 0 {
+		grantTypes = []string{oauth.GrantAuthorizationCode}
+	}
+
+	if !oauth.ContainsAll(oauth.SupportedGrantTypes, grantTypes) {
+		return nil, status.Errorf(codes.InvalidArgument, "grant types must be %v", oauth.SupportedGrantTypes)
+	}
+	if !oauth.ContainsAll(oauth.SupportedScopes, req.Scopes) || len(req.Scopes) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "scopes must be some of %v", oauth.SupportedScopes)
+	}
+
+	if slices.Contains(grantTypes, oauth.GrantAuthorizationCode) {
+		if len(req.RedirectUris) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "a redirect uri is required for the authorization code grant")
+		}
+
+		for _, uri := range req.RedirectUris {
+			if !validRedirectUri(uri, req.Public) {
+				return nil, status.Errorf(codes.InvalidArgument, "redirect uri %q must be https, loopback http or a private-use scheme for public clients", uri)
+			}
+		}
+	}
+
+	if slices.Contains(grantTypes, oauth.GrantClientCredentials) {
+		if req.Public {
+			return nil, status.Errorf(codes.InvalidArgument, "public clients cannot use the client credentials grant")
+		}
+
+		// client credentials tokens act without a user so only admins may create such clients
+		owner, err := h.users.GetById(ctx, req.OwnerId)
+
+		if err != nil {
+			switch err {
+			case mongo.ErrNoDocuments:
+				return nil, status.Errorf(codes.InvalidArgument, authModels.ErrUserNotFound.Error())
+			default:
+				return nil, status.Errorf(codes.Internal, err.Error())
+			}
+		}
+
+		if !slices.Contains(owner.Roles, userModels.Admin) {
+			return nil, status.Errorf(codes.PermissionDenied, "only admins can register clients for the client credentials grant")
+		}
+	}
+
+	clientDoc := &authModels.OAuthClientDocument{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectUris,
+		GrantTypes:   grantTypes,
+		Scopes:       req.Scopes,
+		Public:       req.Public,
+		OwnerID:      ownerOid,
+	}
+
+	secret, err := h.repository.CreateClient(ctx, clientDoc)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	return &gen.RegisterClientResponse{Client: clientDoc.ToProto(), ClientSecret: secret}, nil
+}
+
+func (h *Handler) getClient(ctx context.Context, clientId string) (*authModels.OAuthClientDocument, error) {
+	client, err := h.repository.GetClient(ctx, clientId)
+
+	if err != nil {
+		switch err {
+		case authModels.ErrClientNotFound:
+			return nil, status.Errorf(codes.NotFound, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return client, nil
+}
+
+func (h *Handler) GetClient(ctx context.Context, req *gen.GetClientRequest) (*gen.GetClientResponse, error) {
+	if req == nil || req.ClientId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or client id was empty")
+	}
+
+	client, err := h.getClient(ctx, req.ClientId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.GetClientResponse{Client: client.ToProto()}, nil
+}
+
+// ListClients returns the clients registered by a user.
+func (h *Handler) ListClients(ctx context.Context, req *gen.ListClientsRequest) (*gen.ListClientsResponse, error) {
+	if req == nil || req.OwnerId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or owner id was empty")
+	}
+
+	clientDocs, err := h.repository.ListClientsByOwner(ctx, req.OwnerId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	clients := []*gen.OAuthClient{}
+
+	for _, clientDoc := range clientDocs {
+		clients = append(clients, clientDoc.ToProto())
+	}
+
+	return &gen.ListClientsResponse{Clients: clients}, nil
+}
+
+// Authorize handles an authorization request from a signed in user. An unknown client or
+// redirect uri is a grpc error as the user must not be redirected, every other problem is
+// returned in the response to be sent to the client's redirect uri.
+func (h *Handler) Authorize(ctx context.Context, req *gen.AuthorizeRequest) (*gen.AuthorizeResponse, error) {
+	if req == nil || req.ClientId == "" || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req, client id or user id was empty")
+	}
+
+	userOid, err := primitive.ObjectIDFromHex(req.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "user id is not valid")
+	}
+
+	client, err := h.getClient(ctx, req.ClientId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	redirectUri := req.RedirectUri
+
+	if redirectUri == "" && len(client.RedirectURIs) == 1 {
+		redirectUri = client.RedirectURIs[0]
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectUri) {
+		return nil, status.Errorf(codes.InvalidArgument, "redirect uri is not registered for the client")
+	}
+
+	resp := &gen.AuthorizeResponse{Client: client.ToProto(), Scopes: req.Scopes, RedirectUri: redirectUri}
+
+	if req.ResponseType != "code" {
+		resp.Error = oauthError(oauth.ErrUnsupportedResponse, "only the code response type is supported")
+		return resp, nil
+	}
+	if req.Deny {
+		resp.Error = oauthError(oauth.ErrAccessDenied, "the user denied the request")
+		return resp, nil
+	}
+	if !slices.Contains(client.GrantTypes, oauth.GrantAuthorizationCode) {
+		resp.Error = oauthError(oauth.ErrUnauthorizedClient, "client is not registered for the authorization code grant")
+		return resp, nil
+	}
+	if len(req.Scopes) == 0 || !oauth.ContainsAll(client.Scopes, req.Scopes) {
+		resp.Error = oauthError(oauth.ErrInvalidScope, "requested scopes are not registered for the client")
+		return resp, nil
+	}
+	if req.CodeChallenge == "" && client.Public {
+		resp.Error = oauthError(oauth.ErrInvalidRequest, "public clients must use PKCE")
+		return resp, nil
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != oauth.CodeChallengeS256 {
+		resp.Error = oauthError(oauth.ErrInvalidRequest, "only the S256 code challenge method is supported")
+		return resp, nil
+	}
+
+	// the token the user signed in with may outlive their account being disabled or deleted
+	user, err := h.users.GetById(ctx, req.UserId)
+
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if err == mongo.ErrNoDocuments || authModels.AccountBlocked(user) != nil {
+		resp.Error = oauthError(oauth.ErrAccessDenied, "the account cannot be used")
+		return resp, nil
+	}
+
+	consent, err := h.repository.GetConsent(ctx, req.UserId, client.ClientID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if consent == nil || !oauth.ContainsAll(consent.Scopes, req.Scopes) {
+		if !req.Approve {
+			resp.ConsentRequired = true
+			return resp, nil
+		}
+
+		if err := h.repository.GrantConsent(ctx, req.UserId, client.ClientID, req.Scopes); err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	code, err := h.repository.CreateCode(ctx, &authModels.AuthorizationCodeDocument{
+		ClientID:             client.ClientID,
+		UserID:               userOid,
+		RedirectURI:          redirectUri,
+		RedirectURIDefaulted: req.RedirectUri == "",
+		Scopes:               req.Scopes,
+		CodeChallenge:        req.CodeChallenge,
+		Nonce:                req.Nonce,
+		ExpiresAt:            time.Now().Add(h.config.CodeTtl),
+	})
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	resp.Code = code
+
+	return resp, nil
+}
+
+// authenticateClient checks the client secret of confidential clients, public clients only identify themselves
+func (h *Handler) authenticateClient(ctx context.Context, clientId string, secret string) (*authModels.OAuthClientDocument, *gen.OAuthError, error) {
+	client, err := h.repository.GetClient(ctx, clientId)
+
+	if err != nil {
+		if err == authModels.ErrClientNotFound {
+			return nil, oauthError(oauth.ErrInvalidClient, "client authentication failed"), nil
+		}
+		return nil, nil, err
+	}
+
+	if !client.Public && subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(db.HashClientSecret(secret))) != 1 {
+		return nil, oauthError(oauth.ErrInvalidClient, "client authentication failed"), nil
+	}
+
+	return client, nil, nil
+}
+
+func (h *Handler) ExchangeToken(ctx context.Context, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error) {
+	if req == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "req was nil")
+	}
+
+	client, clientErr, err := h.authenticateClient(ctx, req.ClientId, req.ClientSecret)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	if clientErr != nil {
+		return &gen.ExchangeTokenResponse{Error: clientErr}, nil
+	}
+
+	if !slices.Contains(oauth.SupportedGrantTypes, req.GrantType) {
+		return &gen.ExchangeTokenResponse{Error: oauthError(oauth.ErrUnsupportedGrantType, "")}, nil
+	}
+	if !slices.Contains(client.GrantTypes, req.GrantType) {
+		return &gen.ExchangeTokenResponse{Error: oauthError(oauth.ErrUnauthorizedClient, "client is not registered for the grant")}, nil
+	}
+
+	switch req.GrantType {
+	case oauth.GrantAuthorizationCode:
+		return h.exchangeAuthorizationCode(ctx, client, req)
+	default:
+		return h.exchangeClientCredentials(client, req)
+	}
+}
+
+func (h *Handler) exchangeAuthorizationCode(ctx context.Context, client *authModels.OAuthClientDocument, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error) {
+	invalidGrant := &gen.ExchangeTokenResponse{Error: oauthError(oauth.ErrInvalidGrant, "code is invalid, expired or was issued to another client")}
+
+	code, err := h.repository.ConsumeCode(ctx, req.Code)
+
+	if err != nil {
+		if err == authModels.ErrInvalidToken {
+			return invalidGrant, nil
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	if code.ClientID != client.ClientID {
+		return invalidGrant, nil
+	}
+
+	// the redirect uri must match when it was sent to authorize (RFC 6749 4.1.3), otherwise it
+	// can be left out
+	if req.RedirectUri != code.RedirectURI && (!code.RedirectURIDefaulted || req.RedirectUri != "") {
+		return invalidGrant, nil
+	}
+
+	// a verifier without a challenge is rejected too, so PKCE cannot be stripped from a request
+	if (code.CodeChallenge == "") != (req.CodeVerifier == "") ||
+		(code.CodeChallenge != "" && !oauth.VerifyCodeChallenge(code.CodeChallenge, req.CodeVerifier)) {
+		return invalidGrant, nil
+	}
+
+	user, err := h.users.GetById(ctx, code.UserID.Hex())
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return invalidGrant, nil
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	// the account may have been disabled or scheduled for deletion since the code was issued
+	if authModels.AccountBlocked(user) != nil {
+		return invalidGrant, nil
+	}
+
+	resp := &gen.ExchangeTokenResponse{
+		ClientId: client.ClientID,
+		User:     userModels.UserToProto(user),
+		Scopes:   code.Scopes,
+	}
+
+	if slices.Contains(code.Scopes, oauth.ScopeOpenId) {
+		resp.IdToken, err = h.signer.SignIdToken(ctx, h.config.Issuer, client.ClientID, user, code.Scopes, code.Nonce, h.config.IdTokenTtl)
+
+		if err != nil {
+			log.Printf("Failed to sign id token: %s\n", err)
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return resp, nil
+}
+
+// userScopes describe a user so they cannot be granted to a client acting on its own behalf
+var userScopes = []string{oauth.ScopeOpenId, oauth.ScopeProfile, oauth.ScopeEmail, oauth.ScopeUserRead, oauth.ScopeUserWrite}
+
+func (h *Handler) exchangeClientCredentials(client *authModels.OAuthClientDocument, req *gen.ExchangeTokenRequest) (*gen.ExchangeTokenResponse, error) {
+	scopes := req.Scopes
+
+	if len(scopes) == 0 {
+		scopes = []string{}
+
+		for _, scope := range client.Scopes {
+			if !slices.Contains(userScopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		if slices.Contains(userScopes, scope) || !slices.Contains(client.Scopes, scope) {
+			return &gen.ExchangeTokenResponse{Error: oauthError(oauth.ErrInvalidScope, "scope "+scope+" cannot be granted to the client")}, nil
+		}
+	}
+
+	return &gen.ExchangeTokenResponse{ClientId: client.ClientID, Scopes: scopes}, nil
+}
+
+func (h *Handler) ListConsents(ctx context.Context, req *gen.ListConsentsRequest) (*gen.ListConsentsResponse, error) {
+	if req == nil || req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req or user id was empty")
+	}
+
+	consentDocs, err := h.repository.ListConsents(ctx, req.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	consents := []*gen.OAuthConsent{}
+
+	for _, consentDoc := range consentDocs {
+		consent := &gen.OAuthConsent{
+			ClientId:  consentDoc.ClientID,
+			Scopes:    consentDoc.Scopes,
+			GrantedAt: consentDoc.GrantedAt.Unix(),
+		}
+
+		if client, err := h.repository.GetClient(ctx, consentDoc.ClientID); err == nil {
+			consent.ClientName = client.Name
+		}
+
+		consents = append(consents, consent)
+	}
+
+	return &gen.ListConsentsResponse{Consents: consents}, nil
+}
+
+func (h *Handler) RevokeConsent(ctx context.Context, req *gen.RevokeConsentRequest) (*gen.RevokeConsentResponse, error) {
+	if req == nil || req.UserId == "" || req.ClientId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "req, user id or client id was empty")
+	}
+
+	if err := h.repository.RevokeConsent(ctx, req.UserId, req.ClientId); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, status.Errorf(codes.NotFound, "consent not found")
+		default:
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
+	}
+
+	return &gen.RevokeConsentResponse{}, nil
+}
+
+func (h *Handler) GetJwks(ctx context.Context, req *gen.GetJwksRequest) (*gen.GetJwksResponse, error) {
+	jwks, err := h.signer.Jwks(ctx)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	keys := []*gen.Jwk{}
+
+	for _, jwk := range jwks {
+		keys = append(keys, &gen.Jwk{Kid: jwk.Kid, Kty: jwk.Kty, Alg: jwk.Alg, Use: jwk.Use, N: jwk.N, E: jwk.E})
+	}
+
+	return &gen.GetJwksResponse{Keys: keys}, nil
+}
@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/oidc"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	userModels "github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryOAuth keeps clients, consents and codes in memory, the methods the tests do not use panic
+type memoryOAuth struct {
+	db.OAuthRepository
+	mu       sync.Mutex
+	clients  map[string]authModels.OAuthClientDocument
+	consents map[string][]string
+	codes    map[string]authModels.AuthorizationCodeDocument
+}
+
+func newMemoryOAuth() *memoryOAuth {
+	return &memoryOAuth{
+		clients:  map[string]authModels.OAuthClientDocument{},
+		consents: map[string][]string{},
+		codes:    map[string]authModels.AuthorizationCodeDocument{},
+	}
+}
+
+func (r *memoryOAuth) CreateClient(ctx context.Context, client *authModels.OAuthClientDocument) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client.ID = primitive.NewObjectID()
+	client.ClientID = client.ID.Hex()
+
+	secret := ""
+
+	if !client.Public {
+		secret = "secret-" + client.ClientID
+		client.SecretHash = db.HashClientSecret(secret)
+	}
+
+	r.clients[client.ClientID] = *client
+
+	return secret, nil
+}
+
+func (r *memoryOAuth) GetClient(ctx context.Context, clientId string) (*authModels.OAuthClientDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[clientId]
+
+	if !ok {
+		return nil, authModels.ErrClientNotFound
+	}
+
+	return &client, nil
+}
+
+func (r *memoryOAuth) GetConsent(ctx context.Context, userId string, clientId string) (*authModels.ConsentDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	scopes, ok := r.consents[userId+"/"+clientId]
+
+	if !ok {
+		return nil, nil
+	}
+
+	return &authModels.ConsentDocument{ClientID: clientId, Scopes: scopes}, nil
+}
+
+func (r *memoryOAuth) GrantConsent(ctx context.Context, userId string, clientId string, scopes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.consents[userId+"/"+clientId] = scopes
+
+	return nil
+}
+
+func (r *memoryOAuth) CreateCode(ctx context.Context, code *authModels.AuthorizationCodeDocument) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value := primitive.NewObjectID().Hex()
+	r.codes[value] = *code
+
+	return value, nil
+}
+
+func (r *memoryOAuth) ConsumeCode(ctx context.Context, value string) (*authModels.AuthorizationCodeDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[value]
+
+	if !ok || code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+		return nil, authModels.ErrInvalidToken
+	}
+
+	now := time.Now()
+	code.UsedAt = &now
+	r.codes[value] = code
+
+	return &code, nil
+}
+
+// memoryKeys lets the signer create its key in memory
+type memoryKeys struct {
+	db.SigningKeyRepository
+	keys []authModels.SigningKeyDocument
+}
+
+func (r *memoryKeys) List(ctx context.Context) ([]authModels.SigningKeyDocument, error) {
+	return r.keys, nil
+}
+
+func (r *memoryKeys) Create(ctx context.Context, key *authModels.SigningKeyDocument) error {
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+// oneUser finds a single user by id
+type oneUser struct {
+	db.AuthRepository
+	user *userModels.User
+}
+
+func (r oneUser) GetById(ctx context.Context, id string) (*userModels.User, error) {
+	if id != r.user.ID {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	copied := *r.user
+	return &copied, nil
+}
+
+const redirectUri = "http://localhost:8765/callback"
+
+// newProvider returns a provider with a public client registered by alice
+func newProvider(t *testing.T) (*Handler, *userModels.User, string) {
+	t.Helper()
+
+	alice := &userModels.User{
+		ID:            primitive.NewObjectID().Hex(),
+		Username:      "alice",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Roles:         []userModels.UserRole{},
+	}
+
+	h := New(newMemoryOAuth(), oneUser{user: alice}, oidc.NewSigner(&memoryKeys{}), Config{
+		Issuer:     "https://bookstore.test",
+		CodeTtl:    time.Minute,
+		IdTokenTtl: time.Hour,
+	})
+
+	resp, err := h.RegisterClient(context.Background(), &gen.RegisterClientRequest{
+		Name:         "reader",
+		RedirectUris: []string{redirectUri},
+		Scopes:       []string{oauth.ScopeOpenId, oauth.ScopeEmail, oauth.ScopeBooksRead},
+		Public:       true,
+		OwnerId:      alice.ID,
+	})
+
+	if err != nil {
+		t.Fatalf("RegisterClient: %s", err)
+	}
+
+	return h, alice, resp.Client.ClientId
+}
+
+// authorize asks for a code as alice, approving the request when approve is set
+func authorize(t *testing.T, h *Handler, alice *userModels.User, clientId string, challenge string, approve bool) *gen.AuthorizeResponse {
+	t.Helper()
+
+	resp, err := h.Authorize(context.Background(), &gen.AuthorizeRequest{
+		ClientId:            clientId,
+		UserId:              alice.ID,
+		RedirectUri:         redirectUri,
+		Scopes:              []string{oauth.ScopeOpenId, oauth.ScopeEmail},
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: oauth.CodeChallengeS256,
+		Nonce:               "nonce",
+		ResponseType:        "code",
+		Approve:             approve,
+	})
+
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+
+	if resp.Error != nil {
+		t.Fatalf("Authorize returned %s: %s", resp.Error.Code, resp.Error.Description)
+	}
+
+	return resp
+}
+
+func exchange(t *testing.T, h *Handler, clientId string, code string, verifier string) *gen.ExchangeTokenResponse {
+	t.Helper()
+
+	resp, err := h.ExchangeToken(context.Background(), &gen.ExchangeTokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		ClientId:     clientId,
+		Code:         code,
+		RedirectUri:  redirectUri,
+		CodeVerifier: verifier,
+	})
+
+	if err != nil {
+		t.Fatalf("ExchangeToken: %s", err)
+	}
+
+	return resp
+}
+
+// publicKeys turns the provider's jwks into keys by kid
+func publicKeys(t *testing.T, h *Handler) map[string]*rsa.PublicKey {
+	t.Helper()
+
+	resp, err := h.GetJwks(context.Background(), &gen.GetJwksRequest{})
+
+	if err != nil {
+		t.Fatalf("GetJwks: %s", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, jwk := range resp.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys
+}
+
+func TestAuthorizationCodeFlowWithPkce(t *testing.T) {
+	h, alice, clientId := newProvider(t)
+
+	verifier, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request asks for consent, approving it issues the code
+	if resp := authorize(t, h, alice, clientId, oauth.CodeChallenge(verifier), false); !resp.ConsentRequired || resp.Code != "" {
+		t.Fatalf("expected consent to be required, got %+v", resp)
+	}
+
+	code := authorize(t, h, alice, clientId, oauth.CodeChallenge(verifier), true).Code
+
+	if code == "" {
+		t.Fatal("no code was issued after consent")
+	}
+
+	token := exchange(t, h, clientId, code, verifier)
+
+	if token.Error != nil {
+		t.Fatalf("exchange returned %s: %s", token.Error.Code, token.Error.Description)
+	}
+
+	if token.User.Id != alice.ID || token.ClientId != clientId {
+		t.Fatalf("token is for user %q and client %q", token.User.Id, token.ClientId)
+	}
+
+	// the id token verifies against the published keys
+	keys := publicKeys(t, h)
+
+	idToken, err := jwt.Parse(token.IdToken, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys[kid], nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer("https://bookstore.test"), jwt.WithAudience(clientId))
+
+	if err != nil {
+		t.Fatalf("id token did not verify: %s", err)
+	}
+
+	claims := idToken.Claims.(jwt.MapClaims)
+
+	if claims["sub"] != alice.ID || claims["nonce"] != "nonce" || claims["email"] != alice.Email {
+		t.Fatalf("unexpected id token claims %v", claims)
+	}
+
+	// the consent is remembered
+	if resp := authorize(t, h, alice, clientId, oauth.CodeChallenge(verifier), false); resp.ConsentRequired || resp.Code == "" {
+		t.Fatalf("expected a code without asking for consent again, got %+v", resp)
+	}
+
+	// a code can only be exchanged once
+	if replay := exchange(t, h, clientId, code, verifier); replay.Error == nil || replay.Error.Code != oauth.ErrInvalidGrant {
+		t.Fatalf("expected the replayed code to be rejected, got %+v", replay)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	h, alice, clientId := newProvider(t)
+
+	verifier, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := oauth.NewCodeVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+	}{
+		{name: "other verifier", verifier: other},
+		{name: "challenge as verifier", verifier: oauth.CodeChallenge(verifier)},
+		{name: "no verifier", verifier: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := authorize(t, h, alice, clientId, oauth.CodeChallenge(verifier), true).Code
+
+			if resp := exchange(t, h, clientId, code, test.verifier); resp.Error == nil || resp.Error.Code != oauth.ErrInvalidGrant {
+				t.Fatalf("expected invalid_grant, got %+v", resp)
+			}
+
+			// the failed attempt used up the code
+			if resp := exchange(t, h, clientId, code, verifier); resp.Error == nil {
+				t.Fatal("the code was exchanged after a failed attempt")
+			}
+		})
+	}
+}
+
+func TestPublicClientsMustUsePkce(t *testing.T) {
+	h, alice, clientId := newProvider(t)
+
+	resp, err := h.Authorize(context.Background(), &gen.AuthorizeRequest{
+		ClientId:     clientId,
+		UserId:       alice.ID,
+		RedirectUri:  redirectUri,
+		Scopes:       []string{oauth.ScopeOpenId},
+		ResponseType: "code",
+		Approve:      true,
+	})
+
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+
+	if resp.Error == nil || resp.Error.Code != oauth.ErrInvalidRequest || resp.Code != "" {
+		t.Fatalf("expected invalid_request without a code, got %+v", resp)
+	}
+}
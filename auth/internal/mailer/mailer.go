@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Config selects the mailer. smtp uses the SMTP_* settings, file writes to MAILER_DIR and memory
+// keeps messages in process.
+type Config struct {
+	Mailer string `config:"MAILER" default:"file"`
+	Dir    string `config:"MAILER_DIR"`
+	SMTP   SMTPConfig
+}
+
+// create the mailer selected by the config
+func New(config Config) (Mailer, error) {
+	switch config.Mailer {
+	case "smtp":
+		if config.SMTP.Host == "" || config.SMTP.Port <= 0 {
+			return nil, errors.New("SMTP_HOST and SMTP_PORT are required for the smtp mailer")
+		}
+
+		return NewSMTPMailer(config.SMTP), nil
+	case "", "file":
+		dir := config.Dir
+
+		if dir == "" {
+			dir = os.TempDir()
+		}
+
+		return NewFileMailer(dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", config.Mailer)
+	}
+}
@@ -0,0 +1,27 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+)
+
+// SignIdToken issues an OIDC id token for the user to the client
+func (s *Signer) SignIdToken(ctx context.Context, issuer string, clientId string, u *user.User, scopes []string, nonce string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims(oauth.UserClaims(u, scopes))
+	claims["iss"] = issuer
+	claims["aud"] = clientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return s.Sign(ctx, claims)
+}
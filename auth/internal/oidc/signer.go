@@ -0,0 +1,190 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/will-kerwin/go-microservice-bookstore/auth/internal/db"
+	authModels "github.com/will-kerwin/go-microservice-bookstore/auth/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/oauth"
+)
+
+const (
+	signingKeyBits int = 2048
+	// keys added by other instances are picked up after this long
+	keyRefreshInterval time.Duration = 5 * time.Minute
+)
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// Signer signs id tokens with RS256. Keys are kept in mongo so every auth instance signs with,
+// and publishes, the same keys. The first instance to start without a key creates one.
+type Signer struct {
+	repository db.SigningKeyRepository
+	mu         sync.Mutex
+	keys       []signingKey
+	loadedAt   time.Time
+}
+
+func NewSigner(repository db.SigningKeyRepository) *Signer {
+	return &Signer{
+		repository: repository,
+	}
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of a public key, used as its key id
+func thumbprint(key *rsa.PublicKey) string {
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{encodeExponent(key.E), "RSA", base64.RawURLEncoding.EncodeToString(key.N.Bytes())})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeExponent(e int) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(e)).Bytes())
+}
+
+func generateSigningKey() (*authModels.SigningKeyDocument, error) {
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &authModels.SigningKeyDocument{
+		Kid:        thumbprint(&key.PublicKey),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func parseSigningKey(doc authModels.SigningKeyDocument) (signingKey, error) {
+	block, _ := pem.Decode([]byte(doc.PrivateKey))
+
+	if block == nil {
+		return signingKey{}, errors.New("signing key is not pem encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+
+	if !ok {
+		return signingKey{}, errors.New("signing key is not an rsa key")
+	}
+
+	return signingKey{kid: doc.Kid, key: key}, nil
+}
+
+// load returns the cached keys, newest first, reloading or creating them when needed
+func (s *Signer) load(ctx context.Context) ([]signingKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) > 0 && time.Since(s.loadedAt) < keyRefreshInterval {
+		return s.keys, nil
+	}
+
+	docs, err := s.repository.List(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(docs) == 0 {
+		doc, err := generateSigningKey()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.repository.Create(ctx, doc); err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, *doc)
+	}
+
+	keys := []signingKey{}
+
+	for _, doc := range docs {
+		key, err := parseSigningKey(doc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	s.keys = keys
+	s.loadedAt = time.Now()
+
+	return keys, nil
+}
+
+// Sign signs the claims with the newest key
+func (s *Signer) Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	keys, err := s.load(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keys[0].kid
+
+	return token.SignedString(keys[0].key)
+}
+
+// Jwks returns the public halves of every key so tokens signed before a new key was added still verify
+func (s *Signer) Jwks(ctx context.Context) ([]oauth.Jwk, error) {
+	keys, err := s.load(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := []oauth.Jwk{}
+
+	for _, key := range keys {
+		jwks = append(jwks, oauth.Jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.key.N.Bytes()),
+			E:   encodeExponent(key.key.E),
+		})
+	}
+
+	return jwks, nil
+}
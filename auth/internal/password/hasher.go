@@ -0,0 +1,93 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Hasher creates and checks password hashes. Hashes are PHC strings so the
+// algorithm and its parameters are stored alongside the hash itself.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash reports whether a hash was made with a different algorithm or
+	// weaker parameters than the hasher would use now
+	NeedsRehash(hash string) bool
+}
+
+// scheme is a single algorithm that recognises its own hashes
+type scheme interface {
+	Hasher
+	Matches(hash string) bool
+}
+
+// Migrating hashes new passwords with the preferred scheme and still verifies hashes
+// made by any of the others, so stored hashes can be upgraded as users log in
+type Migrating struct {
+	preferred scheme
+	schemes   []scheme
+}
+
+func NewMigrating(preferred scheme, others ...scheme) *Migrating {
+	return &Migrating{
+		preferred: preferred,
+		schemes:   append([]scheme{preferred}, others...),
+	}
+}
+
+func (m *Migrating) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *Migrating) Verify(hash string, password string) (bool, error) {
+	for _, s := range m.schemes {
+		if s.Matches(hash) {
+			return s.Verify(hash, password)
+		}
+	}
+
+	return false, ErrUnknownHashFormat
+}
+
+func (m *Migrating) NeedsRehash(hash string) bool {
+	return !m.preferred.Matches(hash) || m.preferred.NeedsRehash(hash)
+}
+
+// HasherConfig selects the algorithm new passwords are hashed with and its parameters
+type HasherConfig struct {
+	// argon2id or bcrypt
+	Algorithm         string `config:"PASSWORD_HASHER" default:"argon2id"`
+	Argon2Memory      uint32 `config:"ARGON2_MEMORY_KIB" default:"19456"`
+	Argon2Iterations  uint32 `config:"ARGON2_ITERATIONS" default:"2"`
+	Argon2Parallelism uint8  `config:"ARGON2_PARALLELISM" default:"1"`
+	BcryptCost        int    `config:"BCRYPT_COST" default:"12"`
+}
+
+// New creates the hasher selected by the config, hashes from the other algorithm are still
+// accepted and rehashed on login
+func New(config HasherConfig) (Hasher, error) {
+	argon := NewArgon2id(Argon2Params{
+		Memory:      config.Argon2Memory,
+		Iterations:  config.Argon2Iterations,
+		Parallelism: config.Argon2Parallelism,
+		SaltLength:  DefaultArgon2Params.SaltLength,
+		KeyLength:   DefaultArgon2Params.KeyLength,
+	})
+	bcrypt, err := NewBcrypt(config.BcryptCost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind := strings.ToLower(config.Algorithm); kind {
+	case "", "argon2id":
+		return NewMigrating(argon, bcrypt), nil
+	case "bcrypt":
+		return NewMigrating(bcrypt, argon), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", kind)
+	}
+}
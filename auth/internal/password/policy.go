@@ -0,0 +1,138 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordTooShort       = errors.New("password is too short")
+	ErrPasswordTooLong        = errors.New("password is too long")
+	ErrPasswordBreached       = errors.New("password has appeared in a data breach, choose another")
+	ErrPasswordMatchesAccount = errors.New("password must not contain the username or email")
+)
+
+// Policy decides whether a new password is acceptable
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// breached holds upper case hex SHA-1 digests of known breached passwords
+	breached map[string]struct{}
+}
+
+// check a password against the policy. identifiers are account details such as the
+// username and email which must not appear in the password.
+func (p *Policy) Validate(password string, identifiers ...string) error {
+	if p == nil {
+		return nil
+	}
+
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		return fmt.Errorf("%w, it must be at least %d characters", ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w, it must be at most %d characters", ErrPasswordTooLong, p.MaxLength)
+	}
+
+	lowered := strings.ToLower(password)
+
+	for _, identifier := range identifiers {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+
+		if len(identifier) >= 3 && strings.Contains(lowered, identifier) {
+			return ErrPasswordMatchesAccount
+		}
+	}
+
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+// IsPolicyError reports whether err was returned because the password was rejected
+func IsPolicyError(err error) bool {
+	return errors.Is(err, ErrPasswordTooShort) ||
+		errors.Is(err, ErrPasswordTooLong) ||
+		errors.Is(err, ErrPasswordBreached) ||
+		errors.Is(err, ErrPasswordMatchesAccount)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// LoadBreachedList reads a list of breached passwords, one per line. Lines may be plain
+// passwords or SHA-1 digests in the Have I Been Pwned format of HASH:COUNT.
+func (p *Policy) LoadBreachedList(path string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if p.breached == nil {
+		p.breached = map[string]struct{}{}
+	}
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if line == "" {
+			continue
+		}
+
+		if digest, _, _ := strings.Cut(line, ":"); isSha1Hex(digest) {
+			p.breached[strings.ToUpper(digest)] = struct{}{}
+			continue
+		}
+
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+func isSha1Hex(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// PolicyConfig sets the rules new passwords must follow
+type PolicyConfig struct {
+	MinLength int `config:"PASSWORD_MIN_LENGTH" default:"8"`
+	MaxLength int `config:"PASSWORD_MAX_LENGTH" default:"64"`
+	// optional file of breached passwords, see LoadBreachedList
+	BreachedList string `config:"PASSWORD_BREACHED_LIST"`
+}
+
+// NewPolicy creates the policy described by the config, loading the breached password list
+func NewPolicy(config PolicyConfig) (*Policy, error) {
+	policy := &Policy{MinLength: config.MinLength, MaxLength: config.MaxLength}
+
+	if config.BreachedList != "" {
+		if err := policy.LoadBreachedList(config.BreachedList); err != nil {
+			return nil, fmt.Errorf("could not load breached password list: %w", err)
+		}
+	}
+
+	return policy, nil
+}
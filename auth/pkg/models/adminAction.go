@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AdminActionType string
+
+const (
+	DisableUserAction        AdminActionType = "disable"
+	EnableUserAction         AdminActionType = "enable"
+	ForcePasswordResetAction AdminActionType = "force_password_reset"
+	SetRolesAction           AdminActionType = "set_roles"
+	ImpersonateAction        AdminActionType = "impersonate"
+	CreateApiKeyAction       AdminActionType = "create_api_key"
+)
+
+// AdminActionDocument records an admin acting on another user's account. Entries are only
+// ever inserted.
+type AdminActionDocument struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	ActorID   primitive.ObjectID `json:"actorId" bson:"actorId"`
+	Action    AdminActionType    `json:"action" bson:"action"`
+	TargetID  primitive.ObjectID `json:"targetId" bson:"targetId"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Details   map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+func (d *AdminActionDocument) ToProto() *gen.AdminAction {
+	return &gen.AdminAction{
+		Id:        d.ID.Hex(),
+		ActorId:   d.ActorID.Hex(),
+		Action:    string(d.Action),
+		TargetId:  d.TargetID.Hex(),
+		Reason:    d.Reason,
+		CreatedAt: d.CreatedAt.Unix(),
+		Details:   d.Details,
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ApiKeyDocument is a long lived credential owned by a user or service account.
+// Keys look like bks_<prefix>_<secret>, the prefix is stored in the clear so keys can be
+// found and recognised, only the SHA-256 hash of the whole key is stored.
+type ApiKeyDocument struct {
+	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"userId" bson:"userId"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"hash" bson:"hash"`
+	Scopes     []user.UserRole    `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// IsActive reports whether the key can still be used at the given time
+func (d *ApiKeyDocument) IsActive(now time.Time) bool {
+	return d.RevokedAt == nil && (d.ExpiresAt == nil || d.ExpiresAt.After(now))
+}
+
+// ScopeRoles limits the owner's roles to the scopes of the key, a key without scopes
+// acts with all of the owner's roles
+func (d *ApiKeyDocument) ScopeRoles(roles []user.UserRole) []user.UserRole {
+	if len(d.Scopes) == 0 {
+		return roles
+	}
+
+	scoped := []user.UserRole{}
+
+	for _, role := range roles {
+		for _, scope := range d.Scopes {
+			if role == scope {
+				scoped = append(scoped, role)
+				break
+			}
+		}
+	}
+
+	return scoped
+}
+
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func (d *ApiKeyDocument) ToProto() *gen.ApiKey {
+	return &gen.ApiKey{
+		Id:         d.ID.Hex(),
+		UserId:     d.UserID.Hex(),
+		Name:       d.Name,
+		Prefix:     d.Prefix,
+		Scopes:     user.RolesToStrings(d.Scopes),
+		CreatedAt:  d.ID.Timestamp().Unix(),
+		ExpiresAt:  unixOrZero(d.ExpiresAt),
+		LastUsedAt: unixOrZero(d.LastUsedAt),
+		RevokedAt:  unixOrZero(d.RevokedAt),
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AttemptDocument counts recent failed logins against an account or a client ip
+type AttemptDocument struct {
+	ID            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	Failures      int                `json:"failures" bson:"failures"`
+	LastFailureAt time.Time          `json:"lastFailureAt" bson:"lastFailureAt"`
+	LockedUntil   *time.Time         `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	// the counter is forgotten after this time, maintained by a TTL index
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// IsLocked reports whether the key is locked at the given time
+func (d *AttemptDocument) IsLocked(now time.Time) bool {
+	return d.LockedUntil != nil && d.LockedUntil.After(now)
+}
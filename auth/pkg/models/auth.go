@@ -1,25 +1,93 @@
 package models
 
 import (
+	"time"
+
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type UserDocument struct {
-	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Username  string             `json:"username"`
-	Password  string             `json:"password"`
-	Email     string             `json:"email"`
-	FirstName string             `json:"firstName,omitempty"`
-	LastName  string             `json:"lastName,omitempty"`
+	ID              primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Username        string             `json:"username"`
+	Password        string             `json:"password"`
+	Email           string             `json:"email"`
+	FirstName       string             `json:"firstName,omitempty"`
+	LastName        string             `json:"lastName,omitempty"`
+	Roles           []user.UserRole    `json:"roles,omitempty" bson:"roles,omitempty"`
+	EmailVerified   bool               `json:"emailVerified" bson:"emailVerified"`
+	EmailVerifiedAt *time.Time         `json:"emailVerifiedAt,omitempty" bson:"emailVerifiedAt,omitempty"`
+	Mfa             MfaSettings        `json:"mfa" bson:"mfa"`
+	// service accounts have no password and can only authenticate with api keys
+	ServiceAccount bool `json:"serviceAccount,omitempty" bson:"serviceAccount,omitempty"`
+	// set while the account is scheduled for deletion, it is purged after DeleteAfter
+	DeletionRequestedAt *time.Time `json:"deletionRequestedAt,omitempty" bson:"deletionRequestedAt,omitempty"`
+	DeleteAfter         *time.Time `json:"deleteAfter,omitempty" bson:"deleteAfter,omitempty"`
+	// set on the tombstone left when an account is anonymised rather than deleted
+	AnonymisedAt *time.Time `json:"anonymisedAt,omitempty" bson:"anonymisedAt,omitempty"`
+	// disabled accounts are kept but cannot log in, the reason is only shown to admins
+	Disabled       bool       `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	DisabledAt     *time.Time `json:"disabledAt,omitempty" bson:"disabledAt,omitempty"`
+	DisabledReason string     `json:"disabledReason,omitempty" bson:"disabledReason,omitempty"`
+	// set by an admin, cleared when the password is next set
+	PasswordResetRequired bool `json:"passwordResetRequired,omitempty" bson:"passwordResetRequired,omitempty"`
+	// when a purge job took the account, another instance retries if it is not finished within a lease
+	PurgeClaimedAt *time.Time `json:"purgeClaimedAt,omitempty" bson:"purgeClaimedAt,omitempty"`
+	// the leader term token of the purge that claimed the account, only that purge may remove it
+	PurgeFence int64 `json:"purgeFence,omitempty" bson:"purgeFence,omitempty"`
+}
+
+// MfaSettings holds the TOTP enrolment of a user
+type MfaSettings struct {
+	Enabled bool   `json:"enabled" bson:"enabled"`
+	Secret  string `json:"secret,omitempty" bson:"secret,omitempty"`
+	// secret generated by an enrolment that has not been confirmed with a code yet
+	PendingSecret string `json:"pendingSecret,omitempty" bson:"pendingSecret,omitempty"`
+	// last accepted time step, codes at or before it are rejected as replays
+	LastStep int64 `json:"lastStep,omitempty" bson:"lastStep,omitempty"`
+	// SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recoveryCodes,omitempty" bson:"recoveryCodes,omitempty"`
+}
+
+// RoleDocument holds the security settings that apply to every user with a role
+type RoleDocument struct {
+	Role        user.UserRole `json:"role" bson:"role"`
+	MfaRequired bool          `json:"mfaRequired" bson:"mfaRequired"`
 }
 
 func (d *UserDocument) ToModel() *user.User {
+	roles := d.Roles
+
+	if roles == nil {
+		roles = []user.UserRole{}
+	}
+
 	return &user.User{
-		ID:        d.ID.Hex(),
-		Username:  d.Username,
-		Email:     d.Email,
-		FirstName: d.FirstName,
-		LastName:  d.LastName,
+		ID:                    d.ID.Hex(),
+		Username:              d.Username,
+		Email:                 d.Email,
+		FirstName:             d.FirstName,
+		LastName:              d.LastName,
+		Roles:                 roles,
+		EmailVerified:         d.EmailVerified,
+		MfaEnabled:            d.Mfa.Enabled,
+		ServiceAccount:        d.ServiceAccount,
+		CreatedAt:             d.ID.Timestamp(),
+		DeleteAfter:           d.DeleteAfter,
+		Disabled:              d.Disabled,
+		PasswordResetRequired: d.PasswordResetRequired,
+	}
+}
+
+// AccountBlocked returns why the account cannot be used at all, whichever way it authenticates
+func AccountBlocked(user *user.User) error {
+	if user.Disabled {
+		return ErrAccountDisabled
 	}
+
+	if user.DeleteAfter != nil {
+		return ErrAccountPendingDeletion
+	}
+
+	return nil
 }
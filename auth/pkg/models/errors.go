@@ -4,3 +4,21 @@ import "errors"
 
 var ErrUnauthenticated = errors.New("not authenticated")
 var ErrUserNotFound = errors.New("user not found")
+var ErrUsernameTaken = errors.New("username already exists")
+var ErrEmailTaken = errors.New("email already exists")
+var ErrInvalidToken = errors.New("token is invalid or has expired")
+var ErrEmailNotVerified = errors.New("email address has not been verified")
+var ErrInvalidMfaCode = errors.New("mfa code is invalid")
+var ErrMfaNotEnrolled = errors.New("mfa enrolment has not been started")
+var ErrLockedOut = errors.New("too many failed login attempts, try again later")
+var ErrInvalidApiKey = errors.New("api key is invalid, revoked or has expired")
+var ErrClientNotFound = errors.New("oauth client not found")
+var ErrProviderNotFound = errors.New("identity provider not found")
+var ErrIdentityNotLinked = errors.New("external identity is not linked to an account")
+var ErrSessionNotFound = errors.New("session not found")
+var ErrAccountPendingDeletion = errors.New("account is scheduled for deletion")
+
+// ErrPurgeClaimLost means another purge, started by a later leader, has taken the account over
+var ErrPurgeClaimLost = errors.New("the account was claimed by another purge")
+var ErrAccountDisabled = errors.New("account has been disabled")
+var ErrPasswordResetRequired = errors.New("password must be reset before logging in")
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FederationStateDocument is a login started with an external provider and waiting for its
+// callback. Only the hash of the state parameter is stored.
+type FederationStateDocument struct {
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Hash         string             `json:"hash" bson:"hash"`
+	Provider     string             `json:"provider" bson:"provider"`
+	Nonce        string             `json:"nonce" bson:"nonce"`
+	CodeVerifier string             `json:"codeVerifier" bson:"codeVerifier"`
+	RedirectURI  string             `json:"redirectUri" bson:"redirectUri"`
+	// hash of the value kept in a cookie by the browser that started the login
+	BindingHash string    `json:"bindingHash" bson:"bindingHash"`
+	ExpiresAt   time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// FederatedIdentityDocument links an account at an external provider to a user
+type FederatedIdentityDocument struct {
+	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Provider    string             `json:"provider" bson:"provider"`
+	Subject     string             `json:"subject" bson:"subject"`
+	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
+	Email       string             `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt    time.Time          `json:"linkedAt" bson:"linkedAt"`
+	LastLoginAt time.Time          `json:"lastLoginAt" bson:"lastLoginAt"`
+}
+
+func (d *FederatedIdentityDocument) ToProto() *gen.FederatedIdentity {
+	return &gen.FederatedIdentity{
+		Provider:    d.Provider,
+		Subject:     d.Subject,
+		Email:       d.Email,
+		LinkedAt:    d.LinkedAt.Unix(),
+		LastLoginAt: d.LastLoginAt.Unix(),
+	}
+}
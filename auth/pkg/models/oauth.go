@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OAuthClientDocument is a third party application registered to use the oauth provider.
+// Only the SHA-256 hash of a confidential client's secret is stored.
+type OAuthClientDocument struct {
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	ClientID     string             `json:"clientId" bson:"clientId"`
+	SecretHash   string             `json:"secretHash,omitempty" bson:"secretHash,omitempty"`
+	Name         string             `json:"name" bson:"name"`
+	RedirectURIs []string           `json:"redirectUris" bson:"redirectUris"`
+	GrantTypes   []string           `json:"grantTypes" bson:"grantTypes"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	Public       bool               `json:"public" bson:"public"`
+	OwnerID      primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+}
+
+func (d *OAuthClientDocument) ToProto() *gen.OAuthClient {
+	return &gen.OAuthClient{
+		ClientId:     d.ClientID,
+		Name:         d.Name,
+		RedirectUris: d.RedirectURIs,
+		GrantTypes:   d.GrantTypes,
+		Scopes:       d.Scopes,
+		Public:       d.Public,
+		OwnerId:      d.OwnerID.Hex(),
+		CreatedAt:    d.ID.Timestamp().Unix(),
+	}
+}
+
+// ConsentDocument records the scopes a user has granted a client
+type ConsentDocument struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	ClientID  string             `json:"clientId" bson:"clientId"`
+	Scopes    []string           `json:"scopes" bson:"scopes"`
+	GrantedAt time.Time          `json:"grantedAt" bson:"grantedAt"`
+}
+
+// AuthorizationCodeDocument is a single-use code from the authorization endpoint, only its hash is stored
+type AuthorizationCodeDocument struct {
+	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Hash        string             `json:"hash" bson:"hash"`
+	ClientID    string             `json:"clientId" bson:"clientId"`
+	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
+	RedirectURI string             `json:"redirectUri" bson:"redirectUri"`
+	// the redirect uri was left out of the authorization request and is the only one the client
+	// registered, so the client does not have to send it when exchanging the code
+	RedirectURIDefaulted bool       `json:"redirectUriDefaulted,omitempty" bson:"redirectUriDefaulted,omitempty"`
+	Scopes               []string   `json:"scopes" bson:"scopes"`
+	CodeChallenge        string     `json:"codeChallenge,omitempty" bson:"codeChallenge,omitempty"`
+	Nonce                string     `json:"nonce,omitempty" bson:"nonce,omitempty"`
+	ExpiresAt            time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt               *time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+}
+
+// SigningKeyDocument is an RSA key used to sign id tokens, shared by every auth instance
+type SigningKeyDocument struct {
+	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Kid        string             `json:"kid" bson:"kid"`
+	PrivateKey string             `json:"privateKey" bson:"privateKey"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionDocument is a completed login on one device. The session is renewed with a refresh
+// token that changes on every use, only the SHA-256 hashes of the current and previous tokens are stored.
+type SessionDocument struct {
+	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
+	RefreshHash string             `json:"refreshHash" bson:"refreshHash"`
+	// a refresh token that has been replaced, presenting it again means it was stolen
+	PreviousRefreshHash string     `json:"previousRefreshHash,omitempty" bson:"previousRefreshHash,omitempty"`
+	Device              string     `json:"device" bson:"device"`
+	UserAgent           string     `json:"userAgent" bson:"userAgent"`
+	IP                  string     `json:"ip" bson:"ip"`
+	CreatedAt           time.Time  `json:"createdAt" bson:"createdAt"`
+	LastSeenAt          time.Time  `json:"lastSeenAt" bson:"lastSeenAt"`
+	ExpiresAt           time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt           *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+func (d *SessionDocument) ToProto() *gen.Session {
+	return &gen.Session{
+		Id:         d.ID.Hex(),
+		UserId:     d.UserID.Hex(),
+		Device:     d.Device,
+		UserAgent:  d.UserAgent,
+		Ip:         d.IP,
+		CreatedAt:  d.CreatedAt.Unix(),
+		LastSeenAt: d.LastSeenAt.Unix(),
+		ExpiresAt:  d.ExpiresAt.Unix(),
+	}
+}
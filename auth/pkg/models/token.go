@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TokenPurpose string
+
+const (
+	EmailVerificationToken TokenPurpose = "emailVerification"
+	PasswordResetToken     TokenPurpose = "passwordReset"
+	MfaChallengeToken      TokenPurpose = "mfaChallenge"
+)
+
+// TokenDocument is a single-use token, only the SHA-256 hash of the token is stored
+type TokenDocument struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Hash      string             `json:"hash" bson:"hash"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	Purpose   TokenPurpose       `json:"purpose" bson:"purpose"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time         `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+}
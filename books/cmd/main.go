@@ -5,66 +5,99 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/will-kerwin/go-microservice-bookstore/books/internal/db"
 	"github.com/will-kerwin/go-microservice-bookstore/books/internal/grpc/author"
 	"github.com/will-kerwin/go-microservice-bookstore/books/internal/grpc/book"
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/config"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/lifecycle"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/mtls"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const serviceName string = "books"
 
+// Config of the books service, see pkg/config for where it is loaded from
+type Config struct {
+	Port int `config:"PORT" required:"true"`
+	// DbName is the older name of the setting
+	DbName               string `config:"DB_NAME,DbName" required:"true"`
+	HealthPort           string `config:"HEALTH_PORT"`
+	IdentityAssertionKey string `config:"IDENTITY_ASSERTION_KEY" required:"true" secret:"true"`
+	// where the registry, kafka and mongodb are
+	Discovery discovery.Config
+	Kafka     infra.KafkaConfig
+	Mongo     infra.MongoConfig
+	// how long in-flight requests and messages get to finish when the service stops
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT" default:"25s"`
+	// the version, zone and tags of the instance, used by callers to choose between instances
+	Metadata discovery.Metadata
+	// tls for the grpc server, see pkg/mtls
+	Tls mtls.Config
+}
+
+// serveHealth serves /healthz and /readyz on the health port when it is set
+func serveHealth(lc *lifecycle.Lifecycle, healthPort string, checks *health.Health) {
+	if healthPort == "" {
+		return
+	}
+
+	lc.ServeHttp("health endpoints", &http.Server{Addr: ":" + healthPort, Handler: checks.Handler()})
+}
+
 func main() {
+	var serviceConfig Config
 
-	port, err := strconv.Atoi(os.Getenv("PORT"))
-	if err != nil {
+	if err := config.New(config.Sources(os.Args[1:])...).Load(context.Background(), &serviceConfig); err != nil {
 		panic(err)
 	}
-	// register with consul
-	registryUri := os.Getenv("CONSUL_URI")
-	kafkaUri := os.Getenv("KAFKA_URI")
-	regisrty, err := discovery.NewRegistry(registryUri)
+
+	// stops the service on SIGTERM, ctx is cancelled as it starts stopping
+	lc := lifecycle.New(serviceConfig.ShutdownTimeout)
+	ctx := lc.Context()
+
+	port := serviceConfig.Port
+
+	log.Printf("Loaded config:\n%s\n", config.Dump(&serviceConfig))
+
+	// register with the service registry
+	regisrty, err := discovery.NewRegistry(serviceConfig.Discovery)
 
 	if err != nil {
 		panic(err)
 	}
 
-	ctx := context.Background()
 	instanceID := discovery.GenerateInstanceID(serviceName)
 
-	if err := regisrty.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port)); err != nil {
+	if err := regisrty.Register(ctx, instanceID, serviceName, fmt.Sprintf("%s:%d", serviceName, port), serviceConfig.Metadata); err != nil {
 		panic(err)
 	}
 
-	// Setup health check
-	go func() {
-		for {
-			if err := regisrty.HealthCheck(instanceID, serviceName); err != nil {
-				log.Println("Failed to report healthy state: " + err.Error())
-			}
-			time.Sleep(1 * time.Second)
-		}
-	}()
-
-	// deregister on close
-	defer regisrty.Deregister(ctx, instanceID, serviceName)
+	// leave discovery first when stopping so no new requests are sent
+	lc.OnStop(lifecycle.Deregister, "discovery", func(ctx context.Context) error {
+		return regisrty.Deregister(ctx, instanceID, serviceName)
+	})
 
 	log.Printf("Starting the %s service at port: %d\n", serviceName, port)
 
 	// load mongodb connection
+	// kafka and mongodb are found through the registry or at fixed addresses, see pkg/infra
+	kafkaBrokers, err := infra.Kafka(ctx, regisrty, serviceConfig.Kafka)
 
-	MONGODB_URI := os.Getenv("MONGODB_URI")
-	clientOptions := options.Client().ApplyURI(MONGODB_URI)
+	if err != nil {
+		panic(err)
+	}
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	client, err := infra.Mongo(ctx, regisrty, serviceConfig.Mongo)
 
 	if err != nil {
 		panic(err)
@@ -78,16 +111,56 @@ func main() {
 
 	log.Println("Connected to mongodb")
 
+	lc.OnStop(lifecycle.CloseClients, "mongodb", client.Disconnect)
+
+	verifier, err := identity.NewVerifier([]byte(serviceConfig.IdentityAssertionKey))
+
+	if err != nil {
+		panic(err)
+	}
+
 	// load repo and handler
-	authorRepository := db.NewAuthorRepository(client)
-	bookRepository := db.NewBookRepository(client)
+	authorRepository := db.NewAuthorRepository(client, serviceConfig.DbName)
+	bookRepository := db.NewBookRepository(client, serviceConfig.DbName)
+
+	authorHandler := author.New(authorRepository, kafkaBrokers, serviceName, verifier)
+	bookHandler := book.New(bookRepository, kafkaBrokers, serviceName, verifier)
+
+	// handle ingestors, stopped once the server has finished its requests
+	lc.Go(lifecycle.StopWorkers, "author consumers", authorHandler.HandleIngestors)
+	lc.Go(lifecycle.StopWorkers, "book consumers", bookHandler.HandleIngestors)
+
+	checks := health.New(health.DefaultTimeout)
+	// not ready until the grpc server is serving
+	checks.Register("lifecycle", lc)
+	checks.Register("mongodb", health.Mongo(client))
+	authorHandler.RegisterHealthChecks(checks)
+	bookHandler.RegisterHealthChecks(checks)
+
+	// report the health of the service to discovery, the checks keep running while the service
+	// drains so the health endpoints show it is shutting down
+	lc.Go(lifecycle.StopServing, "health checks", func(checksCtx context.Context) {
+		checks.Run(checksCtx, time.Second, func(report health.Report) {
+			// the instance is deregistered once the service starts stopping
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := regisrty.HealthCheck(instanceID, serviceName, report.Status, report.Output()); err != nil {
+				log.Println("Failed to report health: " + err.Error())
+			}
+		})
+	})
 
-	authorHandler := author.New(authorRepository, kafkaUri, serviceName)
-	bookHandler := book.New(bookRepository, kafkaUri, serviceName)
+	serveHealth(lc, serviceConfig.HealthPort, checks)
 
-	// handle ingestors
-	authorHandler.HandleIngestors(ctx)
-	bookHandler.HandleIngestors(ctx)
+	credentials, err := mtls.New(serviceName, serviceConfig.Tls)
+
+	if err != nil {
+		panic(err)
+	}
+
+	go credentials.Watch(ctx)
 
 	// create grpc listener
 	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", serviceName, port))
@@ -96,12 +169,22 @@ func main() {
 	}
 
 	// create grpc server and listen
-	grpcServer := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+	grpcServer := grpc.NewServer(
+		credentials.ServerOption(),
+		grpc.ChainUnaryInterceptor(verifier.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(verifier.StreamServerInterceptor()),
+	)
 
 	gen.RegisterAuthorServiceServer(grpcServer, authorHandler)
 	gen.RegisterBookServiceServer(grpcServer, bookHandler)
+	healthpb.RegisterHealthServer(grpcServer, checks.GrpcServer())
 
-	if err := grpcServer.Serve(lis); err != nil {
-		panic(err)
+	lc.ServeGrpc("grpc server", grpcServer, lis)
+	lc.MarkReady()
+
+	if err := lc.Wait(); err != nil {
+		log.Fatalf("Stopped the %s service: %s\n", serviceName, err)
 	}
+
+	log.Printf("Stopped the %s service\n", serviceName)
 }
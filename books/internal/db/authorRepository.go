@@ -2,9 +2,9 @@ package db
 
 import (
 	"context"
-	"os"
 
 	booksModels "github.com/will-kerwin/go-microservice-bookstore/books/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,18 +12,19 @@ import (
 )
 
 type MongoDbAuthorRepository struct {
-	client *mongo.Client
+	client infra.Database
+	dbName string
 }
 
-func NewAuthorRepository(client *mongo.Client) *MongoDbAuthorRepository {
+func NewAuthorRepository(client infra.Database, dbName string) *MongoDbAuthorRepository {
 	return &MongoDbAuthorRepository{
 		client: client,
+		dbName: dbName,
 	}
 }
 
 func (r *MongoDbAuthorRepository) getCollection() *mongo.Collection {
-	dbName := os.Getenv("DbName")
-	return r.client.Database(dbName).Collection("authors")
+	return r.client.Database(r.dbName).Collection("authors")
 }
 
 func (r *MongoDbAuthorRepository) Add(ctx context.Context, author *models.Author) (*models.Author, error) {
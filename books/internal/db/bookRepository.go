@@ -2,9 +2,9 @@ package db
 
 import (
 	"context"
-	"os"
 
 	booksModels "github.com/will-kerwin/go-microservice-bookstore/books/pkg/models"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/infra"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,18 +13,19 @@ import (
 )
 
 type MongoDbBookRepository struct {
-	client *mongo.Client
+	client infra.Database
+	dbName string
 }
 
-func NewBookRepository(client *mongo.Client) *MongoDbBookRepository {
+func NewBookRepository(client infra.Database, dbName string) *MongoDbBookRepository {
 	return &MongoDbBookRepository{
 		client: client,
+		dbName: dbName,
 	}
 }
 
 func (r *MongoDbBookRepository) getCollection() *mongo.Collection {
-	dbName := os.Getenv("DbName")
-	return r.client.Database(dbName).Collection("books")
+	return r.client.Database(r.dbName).Collection("books")
 }
 
 func (r *MongoDbBookRepository) Add(ctx context.Context, book *models.Book) (*models.Book, error) {
@@ -7,6 +7,9 @@ import (
 
 	"github.com/will-kerwin/go-microservice-bookstore/books/internal/db"
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/ingester"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
@@ -20,17 +23,18 @@ type Handler struct {
 	repository           db.AuthorRepository
 	createAuthorIngester ingester.Ingester[events.CreateAuthorEvent]
 	deleteAuthorIngester ingester.Ingester[events.DeleteAuthorEvent]
+	verifier             *identity.Verifier
 }
 
-func New(repository db.AuthorRepository, addr string, groupID string) *Handler {
+func New(repository db.AuthorRepository, brokers *discovery.Endpoint, groupID string, verifier *identity.Verifier) *Handler {
 
-	createAuthorIngester, err := ingester.New[events.CreateAuthorEvent](addr, groupID, "createAuthor")
+	createAuthorIngester, err := ingester.New[events.CreateAuthorEvent](brokers, groupID, "createAuthor")
 	if err != nil {
 		log.Fatalf("Failed to create ingester: %s\n", err)
 		createAuthorIngester = nil
 	}
 
-	deleteAuthorIngester, err := ingester.New[events.DeleteAuthorEvent](addr, groupID, "deleteAuthor")
+	deleteAuthorIngester, err := ingester.New[events.DeleteAuthorEvent](brokers, groupID, "deleteAuthor")
 	if err != nil {
 		log.Fatalf("Failed to create ingester: %s\n", err)
 		deleteAuthorIngester = nil
@@ -40,58 +44,56 @@ func New(repository db.AuthorRepository, addr string, groupID string) *Handler {
 		repository:           repository,
 		createAuthorIngester: *createAuthorIngester,
 		deleteAuthorIngester: *deleteAuthorIngester,
+		verifier:             verifier,
 	}
 }
 
-func (h *Handler) HandleIngestors(ctx context.Context) {
-
-	go h.handleCreateAuthorIngester(ctx)
-	go h.handleDeleteAuthorIngester(ctx)
-
+// RegisterHealthChecks fails the service when one of its consumers stops
+func (h *Handler) RegisterHealthChecks(checks *health.Health) {
+	checks.Register("kafka:"+h.createAuthorIngester.Topic(), &h.createAuthorIngester)
+	checks.Register("kafka:"+h.deleteAuthorIngester.Topic(), &h.deleteAuthorIngester)
 }
 
-func (h *Handler) handleCreateAuthorIngester(ctx context.Context) {
+// HandleIngestors handles author messages until the context is done and the consumers have
+// committed the messages they handled
+func (h *Handler) HandleIngestors(ctx context.Context) {
+	if err := ingester.RunAll(ctx, h.handleCreateAuthorIngester, h.handleDeleteAuthorIngester); err != nil {
+		log.Fatalf("Failed to ingest: %s\n", err)
+	}
+}
 
-	for {
-		channel, err := h.createAuthorIngester.Ingest(ctx)
+func (h *Handler) handleCreateAuthorIngester(ctx context.Context) error {
+	return h.createAuthorIngester.Consume(ctx, func(ctx context.Context, message ingester.Message[events.CreateAuthorEvent]) {
+		log.Println("Processing create author message")
 
+		eventCtx, err := h.verifier.ContextFromKafka(ctx, message.Headers, message.ProducedAt)
 		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
+			log.Printf("Dropping create author message: %s\n", err)
+			return
 		}
 
-		for event := range channel {
-			log.Println("Processing create author message")
-			err := h.CreateAuthor(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
+		err = h.CreateAuthor(eventCtx, &message.Event)
+		if err != nil {
+			log.Fatalf("Failed to put rating: %s\n", err)
 		}
-
-		// sleep message process every 10 seconds
-		time.Sleep(10 * time.Second)
-	}
-
+	})
 }
 
-func (h *Handler) handleDeleteAuthorIngester(ctx context.Context) {
-	for {
-		channel, err := h.deleteAuthorIngester.Ingest(ctx)
+func (h *Handler) handleDeleteAuthorIngester(ctx context.Context) error {
+	return h.deleteAuthorIngester.Consume(ctx, func(ctx context.Context, message ingester.Message[events.DeleteAuthorEvent]) {
+		log.Println("Processing delete author message")
 
+		eventCtx, err := h.verifier.ContextFromKafka(ctx, message.Headers, message.ProducedAt)
 		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
+			log.Printf("Dropping delete author message: %s\n", err)
+			return
 		}
 
-		for event := range channel {
-			log.Println("Processing delete author message")
-			err := h.DeleteAuthor(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
+		err = h.DeleteAuthor(eventCtx, &message.Event)
+		if err != nil {
+			log.Fatalf("Failed to put rating: %s\n", err)
 		}
-
-		// sleep message process every 10 seconds
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
 func (h *Handler) GetAuthors(ctx context.Context, req *gen.GetAuthorsRequest) (*gen.GetAuthorsResponse, error) {
@@ -144,7 +146,7 @@ func (h *Handler) CreateAuthor(ctx context.Context, req *events.CreateAuthorEven
 		return status.Errorf(codes.InvalidArgument, "name was empty")
 	}
 
-	log.Println("Create new author")
+	log.Printf("Create new author for %s", identity.Describe(ctx))
 
 	dob := req.DateOfBirth
 
@@ -167,7 +169,7 @@ func (h *Handler) DeleteAuthor(ctx context.Context, req *events.DeleteAuthorEven
 		return status.Errorf(codes.InvalidArgument, "req was nil, or id was empty")
 	}
 
-	log.Printf("request get author with id: %s", req.ID)
+	log.Printf("Delete author %s for %s", req.ID, identity.Describe(ctx))
 
 	err := h.repository.Delete(ctx, req.ID)
 
@@ -3,10 +3,12 @@ package book
 import (
 	"context"
 	"log"
-	"time"
 
 	"github.com/will-kerwin/go-microservice-bookstore/books/internal/db"
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/ingester"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/events"
@@ -21,23 +23,24 @@ type Handler struct {
 	createBookIngester ingester.Ingester[events.CreateBookEvent]
 	deleteBookIngester ingester.Ingester[events.DeleteBookEvent]
 	updateBookIngester ingester.Ingester[events.UpdateBookEvent]
+	verifier           *identity.Verifier
 }
 
-func New(repository db.BookRepository, addr string, groupID string) *Handler {
+func New(repository db.BookRepository, brokers *discovery.Endpoint, groupID string, verifier *identity.Verifier) *Handler {
 
-	createBookIngester, err := ingester.New[events.CreateBookEvent](addr, groupID, "createBook")
+	createBookIngester, err := ingester.New[events.CreateBookEvent](brokers, groupID, "createBook")
 	if err != nil {
 		log.Fatalf("Failed to create ingester: %s\n", err)
 		createBookIngester = nil
 	}
 
-	deleteBookIngester, err := ingester.New[events.DeleteBookEvent](addr, groupID, "deleteBook")
+	deleteBookIngester, err := ingester.New[events.DeleteBookEvent](brokers, groupID, "deleteBook")
 	if err != nil {
 		log.Fatalf("Failed to create ingester: %s\n", err)
 		deleteBookIngester = nil
 	}
 
-	updateBookIngester, err := ingester.New[events.UpdateBookEvent](addr, groupID, "updateBook")
+	updateBookIngester, err := ingester.New[events.UpdateBookEvent](brokers, groupID, "updateBook")
 	if err != nil {
 		log.Fatalf("Failed to create ingester: %s\n", err)
 		updateBookIngester = nil
@@ -48,77 +51,75 @@ func New(repository db.BookRepository, addr string, groupID string) *Handler {
 		createBookIngester: *createBookIngester,
 		updateBookIngester: *updateBookIngester,
 		deleteBookIngester: *deleteBookIngester,
+		verifier:           verifier,
 	}
 
 }
 
+// RegisterHealthChecks fails the service when one of its consumers stops
+func (h *Handler) RegisterHealthChecks(checks *health.Health) {
+	checks.Register("kafka:"+h.createBookIngester.Topic(), &h.createBookIngester)
+	checks.Register("kafka:"+h.updateBookIngester.Topic(), &h.updateBookIngester)
+	checks.Register("kafka:"+h.deleteBookIngester.Topic(), &h.deleteBookIngester)
+}
+
+// HandleIngestors handles book messages until the context is done and the consumers have
+// committed the messages they handled
 func (h *Handler) HandleIngestors(ctx context.Context) {
-	go h.handleCreateBookIngestor(ctx)
-	go h.handleDeleteBookIngestor(ctx)
-	go h.handleUpdateBookIngestor(ctx)
+	if err := ingester.RunAll(ctx, h.handleCreateBookIngestor, h.handleDeleteBookIngestor, h.handleUpdateBookIngestor); err != nil {
+		log.Fatalf("Failed to ingest: %s\n", err)
+	}
 }
 
-func (h *Handler) handleCreateBookIngestor(ctx context.Context) {
-	for {
-		channel, err := h.createBookIngester.Ingest(ctx)
+func (h *Handler) handleCreateBookIngestor(ctx context.Context) error {
+	return h.createBookIngester.Consume(ctx, func(ctx context.Context, message ingester.Message[events.CreateBookEvent]) {
+		log.Println("Processing create book message")
 
+		eventCtx, err := h.verifier.ContextFromKafka(ctx, message.Headers, message.ProducedAt)
 		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
+			log.Printf("Dropping create book message: %s\n", err)
+			return
 		}
 
-		for event := range channel {
-			log.Println("Processing create book message")
-			err := h.CreateBook(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
+		err = h.CreateBook(eventCtx, &message.Event)
+		if err != nil {
+			log.Fatalf("Failed to put rating: %s\n", err)
 		}
-
-		// sleep message process every 10 seconds
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
-func (h *Handler) handleUpdateBookIngestor(ctx context.Context) {
-
-	for {
-		channel, err := h.updateBookIngester.Ingest(ctx)
+func (h *Handler) handleUpdateBookIngestor(ctx context.Context) error {
+	return h.updateBookIngester.Consume(ctx, func(ctx context.Context, message ingester.Message[events.UpdateBookEvent]) {
+		log.Println("Processing update book message")
 
+		eventCtx, err := h.verifier.ContextFromKafka(ctx, message.Headers, message.ProducedAt)
 		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
+			log.Printf("Dropping update book message: %s\n", err)
+			return
 		}
 
-		for event := range channel {
-			log.Println("Processing update book message")
-			err := h.UpdateBook(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
+		err = h.UpdateBook(eventCtx, &message.Event)
+		if err != nil {
+			log.Fatalf("Failed to put rating: %s\n", err)
 		}
-
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
-func (h *Handler) handleDeleteBookIngestor(ctx context.Context) {
-	for {
-		channel, err := h.deleteBookIngester.Ingest(ctx)
+func (h *Handler) handleDeleteBookIngestor(ctx context.Context) error {
+	return h.deleteBookIngester.Consume(ctx, func(ctx context.Context, message ingester.Message[events.DeleteBookEvent]) {
+		log.Println("Processing delete book message")
 
+		eventCtx, err := h.verifier.ContextFromKafka(ctx, message.Headers, message.ProducedAt)
 		if err != nil {
-			log.Fatalf("Failed to ingest: %s\n", err)
+			log.Printf("Dropping delete book message: %s\n", err)
+			return
 		}
 
-		for event := range channel {
-			log.Println("Processing delete book message")
-			err := h.DeleteBook(ctx, &event)
-			if err != nil {
-				log.Fatalf("Failed to put rating: %s\n", err)
-			}
+		err = h.DeleteBook(eventCtx, &message.Event)
+		if err != nil {
+			log.Fatalf("Failed to put rating: %s\n", err)
 		}
-
-		// sleep message process every 10 seconds
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
 func (h *Handler) GetBooks(ctx context.Context, req *gen.GetBooksRequest) (*gen.GetBooksResponse, error) {
@@ -180,7 +181,7 @@ func (h *Handler) CreateBook(ctx context.Context, req *events.CreateBookEvent) e
 		return status.Errorf(codes.InvalidArgument, "name was empty")
 	}
 
-	log.Println("Create new book")
+	log.Printf("Create new book for %s", identity.Describe(ctx))
 
 	book := &models.Book{
 		Title:    req.Title,
@@ -200,6 +201,7 @@ func (h *Handler) CreateBook(ctx context.Context, req *events.CreateBookEvent) e
 }
 
 func (h *Handler) UpdateBook(ctx context.Context, req *events.UpdateBookEvent) error {
+	log.Printf("Update book %s for %s", req.ID, identity.Describe(ctx))
 
 	err := h.repository.Update(ctx, req.ID, &req.Data)
 
@@ -215,7 +217,7 @@ func (h *Handler) DeleteBook(ctx context.Context, req *events.DeleteBookEvent) e
 		return status.Errorf(codes.InvalidArgument, "req was nil, or id was empty")
 	}
 
-	log.Printf("request get author with id: %s", req.ID)
+	log.Printf("Delete book %s for %s", req.ID, identity.Describe(ctx))
 
 	err := h.repository.Delete(ctx, req.ID)
 
@@ -24,91 +24,120 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/auth/login": {
-            "post": {
-                "description": "login to the api",
-                "consumes": [
-                    "application/x-www-form-urlencoded"
-                ],
+        "/.well-known/openid-configuration": {
+            "get": {
+                "description": "OpenID Connect discovery document",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "oauth"
                 ],
-                "summary": "Login",
+                "summary": "Discovery",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.LoginResponse"
-                        }
-                    },
-                    "401": {
-                        "description": "Unauthorized",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.DiscoveryDocument"
                         }
                     }
                 }
             }
         },
-        "/auth/users": {
-            "post": {
-                "description": "create a new user",
-                "consumes": [
-                    "application/json"
-                ],
+        "/healthz": {
+            "get": {
+                "description": "reports whether the api is live. Failing dependencies do not fail it, only the health checks having stopped.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "health"
                 ],
-                "summary": "CreateUser",
+                "summary": "Healthz",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/health.Report"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "503": {
+                        "description": "Service Unavailable",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/health.Report"
                         }
                     }
                 }
             }
         },
-        "/auth/users/{id}": {
+        "/oauth/authorize": {
             "get": {
-                "description": "get the user by id from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+                "description": "authorization endpoint for the code flow with PKCE, called by the bookstore app for the signed in user.\nGET inspects the request, the response says whether consent is needed or where to send the user.\nPOST with approve=true or false records the user's decision.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "oauth"
                 ],
-                "summary": "Get user by its object id in hex format.",
+                "summary": "Authorize",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "id of the user",
-                        "name": "id",
-                        "in": "path",
+                        "description": "must be code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "client id",
+                        "name": "client_id",
+                        "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "registered redirect uri",
+                        "name": "redirect_uri",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "space separated scopes",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "returned to the client unchanged",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256 PKCE challenge, required for public clients",
+                        "name": "code_challenge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "must be S256",
+                        "name": "code_challenge_method",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "included in the id token",
+                        "name": "nonce",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/oauth.AuthorizeResponse"
                         }
                     },
                     "400": {
@@ -116,79 +145,117 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
-                    },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
-                    },
-                    "502": {
-                        "description": "Bad Gateway",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
                     }
                 }
-            }
-        },
-        "/authors": {
-            "get": {
-                "description": "get the authors from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+            },
+            "post": {
+                "description": "authorization endpoint for the code flow with PKCE, called by the bookstore app for the signed in user.\nGET inspects the request, the response says whether consent is needed or where to send the user.\nPOST with approve=true or false records the user's decision.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "authors"
+                    "oauth"
+                ],
+                "summary": "Authorize",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "must be code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "client id",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "registered redirect uri",
+                        "name": "redirect_uri",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "space separated scopes",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "returned to the client unchanged",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256 PKCE challenge, required for public clients",
+                        "name": "code_challenge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "must be S256",
+                        "name": "code_challenge_method",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "included in the id token",
+                        "name": "nonce",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get Authors.",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Author"
-                            }
+                            "$ref": "#/definitions/oauth.AuthorizeResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/oauth/clients": {
             "post": {
-                "description": "creates an author asynchronously.",
+                "description": "register a third party application, the secret is only returned once. Only admins can register clients for the client_credentials grant.",
                 "consumes": [
-                    "applicaiton/json"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "authors"
+                    "oauth"
                 ],
-                "summary": "Create an author.",
+                "summary": "RegisterClient",
                 "parameters": [
                     {
-                        "description": "author body",
+                        "description": "client metadata",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Author"
+                            "$ref": "#/definitions/models.RegisterClientRequest"
                         }
                     }
                 ],
                 "responses": {
                     "201": {
-                        "description": "Created"
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.RegisterClientResponse"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -196,8 +263,8 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
@@ -205,320 +272,191 @@ const docTemplate = `{
                 }
             }
         },
-        "/authors/{id}": {
+        "/oauth/jwks": {
             "get": {
-                "description": "get the author by id from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+                "description": "public keys that verify id tokens",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "authors"
-                ],
-                "summary": "Get Author by its object id in hex format.",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "id of the author",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "oauth"
                 ],
+                "summary": "Jwks",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Author"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
-                    },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
-                    },
-                    "502": {
-                        "description": "Bad Gateway",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.Jwks"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "delete the author by id from database.",
+            }
+        },
+        "/oauth/token": {
+            "post": {
+                "description": "token endpoint for the authorization_code and client_credentials grants.\nConfidential clients authenticate with HTTP basic auth or client_id and client_secret in the body.",
                 "consumes": [
-                    "applicaiton/json"
+                    "application/x-www-form-urlencoded"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "authors"
+                    "oauth"
                 ],
-                "summary": "Delete Author by its object id in hex format.",
+                "summary": "Token",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "id of the author",
-                        "name": "id",
-                        "in": "path",
+                        "description": "authorization_code or client_credentials",
+                        "name": "grant_type",
+                        "in": "formData",
                         "required": true
-                    }
-                ],
-                "responses": {
-                    "202": {
-                        "description": "Accepted"
                     },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "formData"
                     },
-                    "502": {
-                        "description": "Bad Gateway",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
-                    }
-                }
-            }
-        },
-        "/books": {
-            "get": {
-                "description": "get the books from database with filters.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "books"
-                ],
-                "summary": "Get Books.",
-                "parameters": [
                     {
                         "type": "string",
-                        "description": "title of the book",
-                        "name": "title",
-                        "in": "path"
+                        "description": "redirect uri used in the authorization request",
+                        "name": "redirect_uri",
+                        "in": "formData"
                     },
                     {
                         "type": "string",
-                        "description": "genre of the book",
-                        "name": "genre",
-                        "in": "path"
+                        "description": "PKCE verifier",
+                        "name": "code_verifier",
+                        "in": "formData"
                     },
                     {
                         "type": "string",
-                        "description": "authorId of the book",
-                        "name": "authorId",
-                        "in": "path"
+                        "description": "space separated scopes for client_credentials",
+                        "name": "scope",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client id when not using basic auth",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client secret when not using basic auth",
+                        "name": "client_secret",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Book"
-                            }
+                            "$ref": "#/definitions/oauth.TokenResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
-                        }
-                    }
-                }
-            },
-            "post": {
-                "description": "creates a book asynchronously.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "books"
-                ],
-                "summary": "Create an book.",
-                "parameters": [
-                    {
-                        "description": "book body",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.Book"
-                        }
-                    }
-                ],
-                "responses": {
-                    "201": {
-                        "description": "Created"
-                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.ErrorResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/books/{id}": {
+        "/oauth/userinfo": {
             "get": {
-                "description": "get the book by id from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+                "description": "claims about the user the access token was issued for, limited by its scopes",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "books"
-                ],
-                "summary": "Get book by its object id in hex format.",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "id of the book",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "oauth"
                 ],
+                "summary": "UserInfo",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Book"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.ErrorResponse"
                         }
                     }
                 }
             },
-            "delete": {
-                "description": "delete the book by id from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+            "post": {
+                "description": "claims about the user the access token was issued for, limited by its scopes",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "books"
-                ],
-                "summary": "Delete book by its object id in hex format.",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "id of the book",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "oauth"
                 ],
+                "summary": "UserInfo",
                 "responses": {
-                    "202": {
-                        "description": "Accepted"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/models.ApiErrorResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/oauth.ErrorResponse"
                         }
                     }
                 }
-            },
-            "patch": {
-                "description": "Update the book by id from database.",
-                "consumes": [
-                    "applicaiton/json"
-                ],
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "reports whether the api can take traffic, which is when none of its required dependencies are failing",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "books"
-                ],
-                "summary": "Update book by its object id in hex format.",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "id of the book",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "body of the book",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.Book"
-                        }
-                    }
+                    "health"
                 ],
+                "summary": "Readyz",
                 "responses": {
-                    "202": {
-                        "description": "Accepted"
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/health.Report"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "503": {
+                        "description": "Service Unavailable",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiErrorResponse"
+                            "$ref": "#/definitions/health.Report"
                         }
                     }
                 }
@@ -526,71 +464,292 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "health.CheckResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/health.Status"
+                }
+            }
+        },
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "checkedAt": {
+                    "type": "string"
+                },
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/health.CheckResult"
+                    }
+                },
+                "status": {
+                    "$ref": "#/definitions/health.Status"
+                }
+            }
+        },
+        "health.Status": {
+            "type": "string",
+            "enum": [
+                "pass",
+                "warn",
+                "fail"
+            ],
+            "x-enum-varnames": [
+                "Pass",
+                "Warn",
+                "Fail"
+            ]
+        },
         "models.ApiErrorResponse": {
             "type": "object",
-            "additionalProperties": true
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
         },
-        "models.Author": {
+        "models.OAuthClient": {
             "type": "object",
             "properties": {
-                "_id": {
+                "clientId": {
                     "type": "string"
                 },
-                "dateOfBirth": {
+                "createdAt": {
                     "type": "string"
                 },
+                "grantTypes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "name": {
                     "type": "string"
+                },
+                "ownerId": {
+                    "type": "string"
+                },
+                "public": {
+                    "type": "boolean"
+                },
+                "redirectUris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         },
-        "models.Book": {
+        "models.RegisterClientRequest": {
             "type": "object",
             "properties": {
-                "_id": {
+                "grantTypes": {
+                    "description": "authorization_code (the default) and client_credentials, which is admin only",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
                     "type": "string"
                 },
-                "authorId": {
+                "public": {
+                    "description": "public clients such as mobile apps cannot keep a secret and must use PKCE",
+                    "type": "boolean"
+                },
+                "redirectUris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.RegisterClientResponse": {
+            "type": "object",
+            "properties": {
+                "client": {
+                    "$ref": "#/definitions/models.OAuthClient"
+                },
+                "clientSecret": {
+                    "description": "only shown once, empty for public clients",
+                    "type": "string"
+                }
+            }
+        },
+        "oauth.AuthorizeResponse": {
+            "type": "object",
+            "properties": {
+                "clientId": {
                     "type": "string"
                 },
-                "genre": {
+                "clientName": {
                     "type": "string"
                 },
-                "imageUrl": {
+                "consentRequired": {
+                    "type": "boolean"
+                },
+                "redirectTo": {
+                    "description": "the client redirect uri with either a code or an error, set once the request is decided",
                     "type": "string"
                 },
-                "synopsis": {
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "oauth.DiscoveryDocument": {
+            "type": "object",
+            "properties": {
+                "authorization_endpoint": {
                     "type": "string"
                 },
-                "title": {
+                "claims_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "code_challenge_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "grant_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id_token_signing_alg_values_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "issuer": {
+                    "type": "string"
+                },
+                "jwks_uri": {
+                    "type": "string"
+                },
+                "registration_endpoint": {
+                    "type": "string"
+                },
+                "response_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "subject_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "token_endpoint": {
+                    "type": "string"
+                },
+                "token_endpoint_auth_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "userinfo_endpoint": {
                     "type": "string"
                 }
             }
         },
-        "models.LoginResponse": {
+        "oauth.ErrorResponse": {
             "type": "object",
             "properties": {
-                "token": {
+                "error": {
+                    "type": "string"
+                },
+                "error_description": {
                     "type": "string"
                 }
             }
         },
-        "models.User": {
+        "oauth.Jwk": {
             "type": "object",
             "properties": {
-                "_id": {
+                "alg": {
                     "type": "string"
                 },
-                "email": {
+                "e": {
                     "type": "string"
                 },
-                "firstName": {
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                }
+            }
+        },
+        "oauth.Jwks": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/oauth.Jwk"
+                    }
+                }
+            }
+        },
+        "oauth.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer"
+                },
+                "id_token": {
                     "type": "string"
                 },
-                "lastName": {
+                "scope": {
                     "type": "string"
                 },
-                "username": {
+                "token_type": {
                     "type": "string"
                 }
             }
@@ -605,7 +764,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{"http"},
 	Title:            "Go Microservice Bookstore API",
-	Description:      "This is the api for the go bookstore microservices project",
+	Description:      "This is the api for the go bookstore microservices project, the endpoints that are not versioned. See /swagger/v1/index.html and /swagger/v2/index.html for the rest.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -2,20 +2,81 @@ package grpcutil
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/health"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/mtls"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// credentials used by ServiceConnection, connections are plain text until UseCredentials is called
+var transportCredentials *mtls.Credentials
+
+// UseCredentials makes ServiceConnection connect with the service's mtls certificate
+func UseCredentials(credentials *mtls.Credentials) {
+	transportCredentials = credentials
+}
+
+// zone of the calling service and the routes splitting traffic to services, set by UseRouting
+var (
+	localZone string
+	routes    map[string]discovery.Route
+)
+
+// UseRouting makes ServiceConnection split traffic to services by their routes, such as between
+// versions during a rollout, and prefer instances in the same zone
+func UseRouting(zone string, serviceRoutes map[string]discovery.Route) {
+	localZone = zone
+	routes = serviceRoutes
+}
+
 // ServiceConnection attemps to select a random service instance and returns a gRPC connection to it.
+// The route to the service, if any, picks which version the instance is from before the zone is considered.
 func ServiceConnection(ctx context.Context, serviceName string, registry discovery.Registry) (*grpc.ClientConn, error) {
-	addrs, err := registry.Discover(ctx, serviceName)
+	instances, err := registry.Discover(ctx, serviceName, routes[serviceName], discovery.PreferZone(localZone))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return grpc.NewClient(addrs[rand.Intn(len(addrs))], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if len(instances) == 0 {
+		return nil, discovery.ErrNotFound
+	}
+
+	dialOption := grpc.WithTransportCredentials(insecure.NewCredentials())
+
+	if transportCredentials != nil {
+		dialOption = transportCredentials.DialOption(serviceName)
+	}
+
+	return grpc.NewClient(instances[rand.Intn(len(instances))].Address, dialOption)
+}
+
+// HealthChecker checks an instance of a service reports it is serving over grpc.health.v1
+func HealthChecker(serviceName string, registry discovery.Registry) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		conn, err := ServiceConnection(ctx, serviceName, registry)
+
+		if err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+
+		if err != nil {
+			return err
+		}
+
+		if response.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", serviceName, response.Status)
+		}
+
+		return nil
+	})
 }
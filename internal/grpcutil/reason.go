@@ -0,0 +1,40 @@
+package grpcutil
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain names the bookstore services as the source of the reasons on their errors
+const ErrorDomain = "bookstore"
+
+// reasons attached to errors so callers can tell them apart without matching the message
+const (
+	ReasonUsernameTaken = "USERNAME_TAKEN"
+	ReasonEmailTaken    = "EMAIL_TAKEN"
+)
+
+// ErrorWithReason returns a grpc error carrying a machine readable reason in an ErrorInfo detail
+func ErrorWithReason(code codes.Code, reason string, message string) error {
+	st := status.New(code, message)
+
+	withReason, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain})
+
+	if err != nil {
+		return st.Err()
+	}
+
+	return withReason.Err()
+}
+
+// Reason returns the reason attached to a grpc error by ErrorWithReason, or "" when it has none
+func Reason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == ErrorDomain {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
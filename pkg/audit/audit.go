@@ -0,0 +1,117 @@
+// Package audit is an append-only log of security relevant events. Entries are chained by
+// hash so removing or editing one is detected by Verify.
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Outcome string
+
+const (
+	Success Outcome = "success"
+	// the request was understood but failed, such as a wrong password
+	Failure Outcome = "failure"
+	// the caller was not allowed to do it
+	Denied Outcome = "denied"
+)
+
+type Category string
+
+const (
+	Authentication Category = "authentication"
+	Authorization  Category = "authorization"
+	Session        Category = "session"
+	Credential     Category = "credential"
+	Admin          Category = "admin"
+	Account        Category = "account"
+	Catalogue      Category = "catalogue"
+)
+
+// Entry is one audited event. Sequence, PrevHash and Hash are set by the Log.
+type Entry struct {
+	Sequence int64     `json:"sequence" bson:"sequence"`
+	Time     time.Time `json:"time" bson:"time"`
+	// the service that observed the event
+	Service  string   `json:"service" bson:"service"`
+	Category Category `json:"category" bson:"category"`
+	Action   string   `json:"action" bson:"action"`
+	Outcome  Outcome  `json:"outcome" bson:"outcome"`
+	// who did it, the name is kept as well as the id so failed logins for unknown users are useful
+	ActorID   string `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	ActorName string `json:"actorName,omitempty" bson:"actorName,omitempty"`
+	// set when an admin was impersonating the actor
+	ImpersonatorID string `json:"impersonatorId,omitempty" bson:"impersonatorId,omitempty"`
+	// what it was done to, such as a user, session or book id
+	TargetID  string            `json:"targetId,omitempty" bson:"targetId,omitempty"`
+	IP        string            `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string            `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	RequestID string            `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	Reason    string            `json:"reason,omitempty" bson:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	// hash of the previous entry, empty for the first
+	PrevHash string `json:"prevHash" bson:"prevHash"`
+	Hash     string `json:"hash" bson:"hash"`
+}
+
+// Filter selects entries for Query, zero values match everything
+type Filter struct {
+	ActorID  string
+	TargetID string
+	Category Category
+	Action   string
+	Outcome  Outcome
+	Since    *time.Time
+	Until    *time.Time
+	// only entries before this sequence, for the next page
+	Before int64
+	Limit  int64
+}
+
+// Recorder records entries, either into a Log or by handing them to the service that owns it
+type Recorder interface {
+	Record(ctx context.Context, entry Entry) error
+}
+
+// Sink stores the entries of a Log. Sinks only ever add entries.
+type Sink interface {
+	// Head returns the entry with the highest sequence, nil when the sink is empty
+	Head(ctx context.Context) (*Entry, error)
+	// Append stores an entry, ErrSequenceTaken means another writer stored that sequence first
+	Append(ctx context.Context, entry *Entry) error
+	// Query returns the entries matching the filter, newest first
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+	// Scan calls fn with every entry from a sequence onwards in order
+	Scan(ctx context.Context, from int64, fn func(entry *Entry) error) error
+}
+
+var ErrSequenceTaken = errors.New("audit sequence already written")
+
+// errStopScan ends a Scan early without it being reported as a failure
+var errStopScan = errors.New("audit: stop scan")
+
+// matches reports whether an entry passes the filter, for sinks that cannot query natively
+func (f *Filter) matches(entry *Entry) bool {
+	switch {
+	case f.ActorID != "" && entry.ActorID != f.ActorID:
+		return false
+	case f.TargetID != "" && entry.TargetID != f.TargetID:
+		return false
+	case f.Category != "" && entry.Category != f.Category:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.Outcome != "" && entry.Outcome != f.Outcome:
+		return false
+	case f.Since != nil && entry.Time.Before(*f.Since):
+		return false
+	case f.Until != nil && !entry.Time.Before(*f.Until):
+		return false
+	case f.Before != 0 && entry.Sequence >= f.Before:
+		return false
+	}
+
+	return true
+}
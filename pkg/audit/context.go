@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpc metadata keys the api uses to pass details of the original request to backend services
+const (
+	RequestIDKey string = "x-request-id"
+	ClientIPKey  string = "x-client-ip"
+	UserAgentKey string = "x-user-agent"
+)
+
+// RequestInfo describes the request an event happened in
+type RequestInfo struct {
+	RequestID string
+	ClientIP  string
+	UserAgent string
+	// the actor is only read from the verified identity assertion, never sent as metadata
+	ActorID        string
+	ActorName      string
+	ImpersonatorID string
+}
+
+func (i RequestInfo) pairs() []string {
+	var pairs []string
+
+	for key, value := range map[string]string{
+		RequestIDKey: i.RequestID,
+		ClientIPKey:  i.ClientIP,
+		UserAgentKey: i.UserAgent,
+	} {
+		if value != "" {
+			pairs = append(pairs, key, value)
+		}
+	}
+
+	return pairs
+}
+
+// OutgoingContext adds the request info to the metadata of grpc calls made with the context
+func OutgoingContext(ctx context.Context, info RequestInfo) context.Context {
+	pairs := info.pairs()
+
+	if len(pairs) == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// RequestInfoFromContext reads the request info a grpc server was called with, and the actor
+// from the principal the identity interceptor verified
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info := RequestInfo{}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		last := func(key string) string {
+			values := md.Get(key)
+
+			if len(values) == 0 {
+				return ""
+			}
+
+			return values[len(values)-1]
+		}
+
+		info.RequestID = last(RequestIDKey)
+		info.ClientIP = last(ClientIPKey)
+		info.UserAgent = last(UserAgentKey)
+	}
+
+	if principal, ok := identity.FromContext(ctx); ok {
+		info.ActorID = principal.UserID
+		info.ActorName = principal.Username
+		info.ImpersonatorID = principal.ImpersonatorID
+
+		if info.RequestID == "" {
+			info.RequestID = principal.RequestID
+		}
+	}
+
+	return info
+}
+
+// Apply fills the fields of the entry that have not been set from the request info
+func (i RequestInfo) Apply(entry *Entry) {
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+
+	fill(&entry.RequestID, i.RequestID)
+	fill(&entry.IP, i.ClientIP)
+	fill(&entry.UserAgent, i.UserAgent)
+	fill(&entry.ActorID, i.ActorID)
+	fill(&entry.ActorName, i.ActorName)
+	fill(&entry.ImpersonatorID, i.ImpersonatorID)
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink writes entries as JSON lines to a file opened for appending. Only one process may
+// write to a file, each instance should be given its own.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	head *Entry
+}
+
+// NewFileSink opens or creates the file and reads the last entry in it
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &FileSink{path: path, file: file}
+
+	err = sink.Scan(context.Background(), 1, func(entry *Entry) error {
+		last := *entry
+		sink.head = &last
+		return nil
+	})
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+func (s *FileSink) Head(ctx context.Context) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.head, nil
+}
+
+func (s *FileSink) Append(ctx context.Context, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head != nil && entry.Sequence <= s.head.Sequence {
+		return ErrSequenceTaken
+	}
+
+	encoded, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	last := *entry
+	s.head = &last
+
+	return nil
+}
+
+// Query reads the whole file, it is meant for small deployments and development
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	matched := []Entry{}
+
+	err := s.Scan(ctx, 1, func(entry *Entry) error {
+		if filter.matches(entry) {
+			matched = append(matched, *entry)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+
+	for i := len(matched) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && int64(len(entries)) == filter.Limit {
+			break
+		}
+
+		entries = append(entries, matched[i])
+	}
+
+	return entries, nil
+}
+
+func (s *FileSink) Scan(ctx context.Context, from int64, fn func(entry *Entry) error) error {
+	file, err := os.Open(s.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var entry Entry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return err
+		}
+
+		if entry.Sequence < from {
+			continue
+		}
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
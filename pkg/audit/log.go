@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+)
+
+// how many times Record retries when other writers keep taking the next sequence
+const maxAppendAttempts = 10
+
+// Log appends entries to a sink, linking each to the one before it
+type Log struct {
+	sink Sink
+	// optional, with a key the chain cannot be recomputed by someone who can only edit the sink
+	key []byte
+	mu  sync.Mutex
+	// the last entry written, loaded from the sink on first use
+	head   *Entry
+	loaded bool
+}
+
+func NewLog(sink Sink, key []byte) *Log {
+	return &Log{
+		sink: sink,
+		key:  key,
+	}
+}
+
+func (l *Log) newHash() hash.Hash {
+	if len(l.key) > 0 {
+		return hmac.New(sha256.New, l.key)
+	}
+
+	return sha256.New()
+}
+
+// computeHash hashes an entry with its hash field left out
+func (l *Log) computeHash(entry *Entry) (string, error) {
+	unhashed := *entry
+	unhashed.Hash = ""
+	unhashed.Time = unhashed.Time.UTC()
+
+	encoded, err := json.Marshal(unhashed)
+
+	if err != nil {
+		return "", err
+	}
+
+	h := l.newHash()
+	h.Write(encoded)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Record appends the entry to the end of the chain. Several logs may share a sink, a log that
+// loses the race for a sequence reloads the head and tries again.
+func (l *Log) Record(ctx context.Context, entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	// stores such as mongo keep milliseconds, the hash must survive a round trip
+	entry.Time = entry.Time.UTC().Truncate(time.Millisecond)
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if !l.loaded {
+			head, err := l.sink.Head(ctx)
+
+			if err != nil {
+				return err
+			}
+
+			l.head = head
+			l.loaded = true
+		}
+
+		entry.Sequence = 1
+		entry.PrevHash = ""
+
+		if l.head != nil {
+			entry.Sequence = l.head.Sequence + 1
+			entry.PrevHash = l.head.Hash
+		}
+
+		hash, err := l.computeHash(&entry)
+
+		if err != nil {
+			return err
+		}
+
+		entry.Hash = hash
+
+		err = l.sink.Append(ctx, &entry)
+
+		if err == ErrSequenceTaken {
+			l.loaded = false
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		l.head = &entry
+
+		return nil
+	}
+
+	return fmt.Errorf("audit: gave up appending after %d attempts", maxAppendAttempts)
+}
+
+func (l *Log) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return l.sink.Query(ctx, filter)
+}
+
+// VerifyResult describes the first break in the chain, if there is one
+type VerifyResult struct {
+	Valid   bool
+	Checked int64
+	// sequence of the first entry that does not match, zero when valid
+	FirstInvalid int64
+	Problem      string
+}
+
+// Verify walks the whole chain checking every entry follows the one before it and still has
+// the hash it was written with
+func (l *Log) Verify(ctx context.Context) (*VerifyResult, error) {
+	result := &VerifyResult{Valid: true}
+
+	var previous *Entry
+
+	fail := func(entry *Entry, problem string) error {
+		result.Valid = false
+		result.FirstInvalid = entry.Sequence
+		result.Problem = problem
+		return errStopScan
+	}
+
+	err := l.sink.Scan(ctx, 1, func(entry *Entry) error {
+		result.Checked++
+
+		expectedSequence, expectedPrevHash := int64(1), ""
+
+		if previous != nil {
+			expectedSequence, expectedPrevHash = previous.Sequence+1, previous.Hash
+		}
+
+		if entry.Sequence != expectedSequence {
+			return fail(entry, fmt.Sprintf("expected sequence %d, entries are missing", expectedSequence))
+		}
+
+		if entry.PrevHash != expectedPrevHash {
+			return fail(entry, "previous hash does not match the entry before it")
+		}
+
+		hash, err := l.computeHash(entry)
+
+		if err != nil {
+			return err
+		}
+
+		if !hmac.Equal([]byte(hash), []byte(entry.Hash)) {
+			return fail(entry, "entry has been modified")
+		}
+
+		current := *entry
+		previous = &current
+
+		return nil
+	})
+
+	if err != nil && err != errStopScan {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// memorySink keeps entries in a slice the tests can edit behind the log's back
+type memorySink struct {
+	entries []Entry
+}
+
+func (s *memorySink) Head(ctx context.Context) (*Entry, error) {
+	if len(s.entries) == 0 {
+		return nil, nil
+	}
+
+	head := s.entries[len(s.entries)-1]
+	return &head, nil
+}
+
+func (s *memorySink) Append(ctx context.Context, entry *Entry) error {
+	if len(s.entries) > 0 && entry.Sequence <= s.entries[len(s.entries)-1].Sequence {
+		return ErrSequenceTaken
+	}
+
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *memorySink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return nil, nil
+}
+
+func (s *memorySink) Scan(ctx context.Context, from int64, fn func(entry *Entry) error) error {
+	for i := range s.entries {
+		if s.entries[i].Sequence < from {
+			continue
+		}
+
+		entry := s.entries[i]
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordedLog returns a log holding three entries
+func recordedLog(t *testing.T, key []byte) (*Log, *memorySink) {
+	t.Helper()
+
+	sink := &memorySink{}
+	log := NewLog(sink, key)
+
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		if err := log.Record(context.Background(), Entry{Category: Authentication, Action: "login", Outcome: Success, ActorID: actor}); err != nil {
+			t.Fatalf("Record: %s", err)
+		}
+	}
+
+	return log, sink
+}
+
+func TestVerifyAcceptsUntouchedLog(t *testing.T) {
+	log, _ := recordedLog(t, []byte("key"))
+
+	result, err := log.Verify(context.Background())
+
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	if !result.Valid || result.Checked != 3 {
+		t.Fatalf("expected 3 valid entries, got %+v", result)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name         string
+		tamper       func(sink *memorySink)
+		firstInvalid int64
+		problem      string
+	}{
+		{
+			name:         "edited entry",
+			tamper:       func(sink *memorySink) { sink.entries[1].ActorID = "mallory" },
+			firstInvalid: 2,
+			problem:      "modified",
+		},
+		{
+			name:         "deleted entry",
+			tamper:       func(sink *memorySink) { sink.entries = append(sink.entries[:1], sink.entries[2:]...) },
+			firstInvalid: 3,
+			problem:      "missing",
+		},
+		{
+			name: "relinked entry",
+			tamper: func(sink *memorySink) {
+				sink.entries[2].PrevHash = sink.entries[0].Hash
+			},
+			firstInvalid: 3,
+			problem:      "previous hash",
+		},
+		{
+			name: "entry rehashed without the key",
+			tamper: func(sink *memorySink) {
+				sink.entries[1].ActorID = "mallory"
+				hash, _ := NewLog(sink, nil).computeHash(&sink.entries[1])
+				sink.entries[1].Hash = hash
+			},
+			firstInvalid: 2,
+			problem:      "modified",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log, sink := recordedLog(t, []byte("key"))
+
+			test.tamper(sink)
+
+			result, err := log.Verify(context.Background())
+
+			if err != nil {
+				t.Fatalf("Verify: %s", err)
+			}
+
+			if result.Valid {
+				t.Fatal("expected the tampering to be detected")
+			}
+
+			if result.FirstInvalid != test.firstInvalid || !strings.Contains(result.Problem, test.problem) {
+				t.Fatalf("expected entry %d to be reported as %q, got %+v", test.firstInvalid, test.problem, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoSink stores entries in a collection, a unique index on sequence lets several
+// instances append to the same chain. The collection is looked up for each call so it follows a
+// client that is replaced.
+type MongoSink struct {
+	collection func() *mongo.Collection
+}
+
+func NewMongoSink(collection func() *mongo.Collection) *MongoSink {
+	return &MongoSink{
+		collection: collection,
+	}
+}
+
+func (s *MongoSink) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sequence", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "sequence", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "sequence", Value: -1}},
+		},
+	})
+
+	return err
+}
+
+func (s *MongoSink) Head(ctx context.Context) (*Entry, error) {
+	var entry Entry
+
+	err := s.collection().FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})).Decode(&entry)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (s *MongoSink) Append(ctx context.Context, entry *Entry) error {
+	_, err := s.collection().InsertOne(ctx, entry)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSequenceTaken
+	}
+
+	return err
+}
+
+func (s *MongoSink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	var entries []Entry = []Entry{}
+
+	query := bson.M{}
+
+	if filter.ActorID != "" {
+		query["actorId"] = filter.ActorID
+	}
+
+	if filter.TargetID != "" {
+		query["targetId"] = filter.TargetID
+	}
+
+	if filter.Category != "" {
+		query["category"] = filter.Category
+	}
+
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+
+	if filter.Outcome != "" {
+		query["outcome"] = filter.Outcome
+	}
+
+	times := bson.M{}
+
+	if filter.Since != nil {
+		times["$gte"] = *filter.Since
+	}
+
+	if filter.Until != nil {
+		times["$lt"] = *filter.Until
+	}
+
+	if len(times) > 0 {
+		query["time"] = times
+	}
+
+	if filter.Before != 0 {
+		query["sequence"] = bson.M{"$lt": filter.Before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: -1}}).SetLimit(filter.Limit)
+
+	cursor, err := s.collection().Find(ctx, query, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *MongoSink) Scan(ctx context.Context, from int64, fn func(entry *Entry) error) error {
+	cursor, err := s.collection().Find(ctx, bson.M{"sequence": bson.M{"$gte": from}}, options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}))
+
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry Entry
+
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+)
+
+func (e *Entry) ToProto() *gen.AuditEntry {
+	return &gen.AuditEntry{
+		Sequence:       e.Sequence,
+		Time:           e.Time.UnixMilli(),
+		Service:        e.Service,
+		Category:       string(e.Category),
+		Action:         e.Action,
+		Outcome:        string(e.Outcome),
+		ActorId:        e.ActorID,
+		ActorName:      e.ActorName,
+		ImpersonatorId: e.ImpersonatorID,
+		TargetId:       e.TargetID,
+		Ip:             e.IP,
+		UserAgent:      e.UserAgent,
+		RequestId:      e.RequestID,
+		Reason:         e.Reason,
+		Details:        e.Details,
+		PrevHash:       e.PrevHash,
+		Hash:           e.Hash,
+	}
+}
+
+func ProtoToEntry(e *gen.AuditEntry) *Entry {
+	return &Entry{
+		Sequence:       e.Sequence,
+		Time:           time.UnixMilli(e.Time).UTC(),
+		Service:        e.Service,
+		Category:       Category(e.Category),
+		Action:         e.Action,
+		Outcome:        Outcome(e.Outcome),
+		ActorID:        e.ActorId,
+		ActorName:      e.ActorName,
+		ImpersonatorID: e.ImpersonatorId,
+		TargetID:       e.TargetId,
+		IP:             e.Ip,
+		UserAgent:      e.UserAgent,
+		RequestID:      e.RequestId,
+		Reason:         e.Reason,
+		Details:        e.Details,
+		PrevHash:       e.PrevHash,
+		Hash:           e.Hash,
+	}
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/producer"
+)
+
+// Topic carries entries from other services to the service that owns the log
+const Topic string = "auditLog"
+
+const (
+	// how long Record waits for room in the buffer before giving up on an entry
+	enqueueTimeout time.Duration = 2 * time.Second
+	// the longest the publisher waits between attempts to send an entry kafka refused
+	maxRetryDelay time.Duration = 30 * time.Second
+)
+
+var (
+	ErrPublisherFull   = errors.New("audit: publisher buffer is full")
+	ErrPublisherClosed = errors.New("audit: publisher is closed")
+)
+
+// Publisher sends entries to the audit topic in the background so requests are not held up
+// waiting for kafka. Entries kafka refuses are retried until they are sent or the publisher is
+// closed. Record waits a short while for room when the buffer is full, then returns an error.
+type Publisher struct {
+	producer *producer.Producer[Entry]
+	service  string
+	entries  chan Entry
+	// closed once the buffered entries have been sent after Close
+	done chan struct{}
+	// closed when Close gives up waiting, so an entry being retried is abandoned
+	abort     chan struct{}
+	abortOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+}
+
+func NewPublisher(brokers *discovery.Endpoint, service string) (*Publisher, error) {
+	entryProducer, err := producer.New[Entry](brokers, Topic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{
+		producer: entryProducer,
+		service:  service,
+		entries:  make(chan Entry, 256),
+		done:     make(chan struct{}),
+		abort:    make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	for entry := range p.entries {
+		select {
+		case <-p.abort:
+			log.Printf("Audit publisher closed, dropped entry %s/%s by %s\n", entry.Category, entry.Action, entry.ActorID)
+		default:
+			p.publish(entry)
+		}
+	}
+}
+
+// publish sends the entry, waiting longer between each attempt kafka refuses
+func (p *Publisher) publish(entry Entry) {
+	delay := time.Second
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := p.producer.Produce(ctx, entry)
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Failed to publish audit entry %s/%s, retrying in %s: %s\n", entry.Category, entry.Action, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-p.abort:
+			log.Printf("Audit publisher closed, dropped entry %s/%s by %s\n", entry.Category, entry.Action, entry.ActorID)
+			return
+		}
+
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+func (p *Publisher) Record(ctx context.Context, entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if entry.Service == "" {
+		entry.Service = p.service
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.entries <- entry:
+		return nil
+	case <-timer.C:
+		return ErrPublisherFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops taking entries, sends the ones already buffered and closes the producer. Entries
+// still buffered when the context is done are dropped and logged.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.entries)
+	}
+
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.abortOnce.Do(func() { close(p.abort) })
+		return ctx.Err()
+	}
+
+	p.producer.Close()
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Source provides raw settings by key, later sources passed to a Loader override earlier ones
+type Source interface {
+	// name of the source, used in errors
+	Name() string
+	// Load reads every setting the source has, keys normalised with NormaliseKey
+	Load(ctx context.Context) (map[string]string, error)
+}
+
+// Loader layers sources and decodes them into a typed config struct. The fields of the struct
+// are set from the key in their config tag, such as `config:"DB_NAME,DbName"`, where the names
+// after the first are older names still accepted. A field can also have:
+//
+//	default:"..."     used when no source sets the key
+//	required:"true"   it is an error when no source sets the key
+//	secret:"true"     the value is redacted by Dump
+//	reload:"true"     a Store applies changes to it without a restart
+type Loader struct {
+	sources []Source
+}
+
+func New(sources ...Source) *Loader {
+	return &Loader{sources: sources}
+}
+
+// Sources returns the sources each service loads from, lowest precedence first:
+//
+//   - a YAML file at CONFIG_FILE or --config, when set
+//   - the consul KV store under CONFIG_KV_PREFIX, when set, at CONSUL_URI
+//   - the environment
+//   - command line flags such as --db-name=books
+func Sources(args []string) []Source {
+	flags := Flags(args)
+	// the file and KV store are found before the rest are loaded, from the flags or environment
+	bootstrap, _ := flags.Load(context.Background())
+
+	lookup := func(key string) string {
+		if value, ok := bootstrap[key]; ok {
+			return value
+		}
+
+		return os.Getenv(key)
+	}
+
+	var sources []Source
+
+	path := lookup("CONFIG_FILE")
+
+	if path == "" {
+		path = bootstrap["CONFIG"]
+	}
+
+	if path != "" {
+		sources = append(sources, File(path))
+	}
+
+	if prefix := lookup("CONFIG_KV_PREFIX"); prefix != "" {
+		sources = append(sources, ConsulKV(lookup("CONSUL_URI"), prefix))
+	}
+
+	return append(sources, Env(), flags)
+}
+
+// Load reads every source and decodes the settings into target, a pointer to a struct. Every
+// missing or invalid setting is returned together in a *ValidationError.
+func (l *Loader) Load(ctx context.Context, target any) error {
+	values, err := l.values(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return decode(values, target)
+}
+
+// values reads the settings of every source, kept apart so an older name set by one source
+// still overrides the current name set by an earlier one
+func (l *Loader) values(ctx context.Context) ([]map[string]string, error) {
+	var values []map[string]string
+
+	for _, source := range l.sources {
+		loaded, err := source.Load(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config from %s: %w", source.Name(), err)
+		}
+
+		values = append(values, loaded)
+	}
+
+	return values, nil
+}
+
+// NormaliseKey makes keys from every source comparable, upper casing them and turning -, . and /
+// into _, so db-name, db.name and DB_NAME are the same key
+func NormaliseKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(strings.TrimSpace(key)))
+}
+
+// ValidationError lists every setting that is missing or could not be parsed
+type ValidationError struct {
+	Missing []string
+	Invalid []string
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+
+	if len(e.Invalid) > 0 {
+		problems = append(problems, "invalid "+strings.Join(e.Invalid, ", "))
+	}
+
+	return "config: " + strings.Join(problems, "; ")
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a setting of a config struct
+type field struct {
+	// the key of the setting followed by the older names it is accepted under
+	keys     []string
+	value    reflect.Value
+	fallback string
+	required bool
+	secret   bool
+	reload   bool
+}
+
+func (f field) key() string {
+	return f.keys[0]
+}
+
+// fields walks a config struct, going into nested structs that have no config tag of their own
+func fields(value reflect.Value) []field {
+	var found []field
+
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+
+		if !structField.IsExported() {
+			continue
+		}
+
+		tag, ok := structField.Tag.Lookup("config")
+
+		if !ok {
+			if structField.Type.Kind() == reflect.Struct && structField.Type != durationType {
+				found = append(found, fields(value.Field(i))...)
+			}
+
+			continue
+		}
+
+		var keys []string
+
+		for _, key := range strings.Split(tag, ",") {
+			keys = append(keys, NormaliseKey(key))
+		}
+
+		found = append(found, field{
+			keys:     keys,
+			value:    value.Field(i),
+			fallback: structField.Tag.Get("default"),
+			required: structField.Tag.Get("required") == "true",
+			secret:   structField.Tag.Get("secret") == "true",
+			reload:   structField.Tag.Get("reload") == "true",
+		})
+	}
+
+	return found
+}
+
+func structValue(target any) (reflect.Value, error) {
+	value := reflect.ValueOf(target)
+
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("config: expected a pointer to a struct, got %T", target)
+	}
+
+	return value.Elem(), nil
+}
+
+// decode sets the fields of target from the values of each source, collecting every missing
+// and invalid setting
+func decode(values []map[string]string, target any) error {
+	value, err := structValue(target)
+
+	if err != nil {
+		return err
+	}
+
+	validation := &ValidationError{}
+
+	for _, field := range fields(value) {
+		raw, ok := lookup(values, field.keys)
+
+		if !ok || raw == "" {
+			if field.required {
+				validation.Missing = append(validation.Missing, field.key())
+				continue
+			}
+
+			raw = field.fallback
+		}
+
+		if err := set(field.value, raw); err != nil {
+			validation.Invalid = append(validation.Invalid, fmt.Sprintf("%s: %s", field.key(), err))
+		}
+	}
+
+	if len(validation.Missing) > 0 || len(validation.Invalid) > 0 {
+		return validation
+	}
+
+	return nil
+}
+
+// lookup finds the first of the keys set by the last source that sets any of them
+func lookup(values []map[string]string, keys []string) (string, bool) {
+	for i := len(values) - 1; i >= 0; i-- {
+		for _, key := range keys {
+			if value, ok := values[i][key]; ok {
+				return value, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// set parses raw into a field, an empty raw value leaves the field at its zero value
+func set(value reflect.Value, raw string) error {
+	if value.CanAddr() {
+		if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return unmarshaler.UnmarshalText([]byte(raw))
+		}
+	}
+
+	if raw == "" {
+		value.SetZero()
+		return nil
+	}
+
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+
+		if err != nil {
+			return err
+		}
+
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+
+		if err != nil {
+			return err
+		}
+
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+
+		var items []string
+
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+
+		for i, item := range items {
+			slice.Index(i).SetString(item)
+		}
+
+		value.Set(slice)
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String || value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+
+		entries := reflect.MakeMap(value.Type())
+
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+
+			key, item, ok := strings.Cut(pair, "=")
+
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("entry %q is not in the form key=value", pair)
+			}
+
+			mapKey := reflect.ValueOf(strings.TrimSpace(key)).Convert(value.Type().Key())
+			entries.SetMapIndex(mapKey, reflect.ValueOf(strings.TrimSpace(item)).Convert(value.Type().Elem()))
+		}
+
+		value.Set(entries)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+
+	return nil
+}
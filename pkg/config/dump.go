@@ -0,0 +1,73 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/redact"
+)
+
+// Dump lists the settings of a loaded config struct as KEY=value lines for logging, with the
+// value of secret settings replaced
+func Dump(target any) string {
+	value, err := structValue(target)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	var lines []string
+
+	for _, field := range fields(value) {
+		lines = append(lines, fmt.Sprintf("%s=%s", field.key(), display(field)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func display(field field) string {
+	if field.secret {
+		if field.value.IsZero() {
+			return ""
+		}
+
+		return redact.Placeholder
+	}
+
+	if marshaler, ok := field.value.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+
+		if err != nil {
+			return err.Error()
+		}
+
+		return string(text)
+	}
+
+	if field.value.Kind() == reflect.Map {
+		items := make([]string, 0, field.value.Len())
+
+		for _, key := range field.value.MapKeys() {
+			items = append(items, key.String()+"="+field.value.MapIndex(key).String())
+		}
+
+		sort.Strings(items)
+
+		return strings.Join(items, ",")
+	}
+
+	if field.value.Kind() == reflect.Slice {
+		items := make([]string, field.value.Len())
+
+		for i := range items {
+			items[i] = field.value.Index(i).String()
+		}
+
+		return strings.Join(items, ",")
+	}
+
+	return fmt.Sprint(field.value.Interface())
+}
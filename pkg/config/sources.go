@@ -0,0 +1,156 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v3"
+)
+
+type sourceFunc struct {
+	name string
+	load func(ctx context.Context) (map[string]string, error)
+}
+
+func (s sourceFunc) Name() string {
+	return s.name
+}
+
+func (s sourceFunc) Load(ctx context.Context) (map[string]string, error) {
+	return s.load(ctx)
+}
+
+// Env reads the environment of the process
+func Env() Source {
+	return sourceFunc{name: "the environment", load: func(context.Context) (map[string]string, error) {
+		values := map[string]string{}
+
+		for _, entry := range os.Environ() {
+			if key, value, ok := strings.Cut(entry, "="); ok {
+				values[NormaliseKey(key)] = value
+			}
+		}
+
+		return values, nil
+	}}
+}
+
+// Flags reads --key=value and --key value arguments, a flag without a value is true
+func Flags(args []string) Source {
+	return sourceFunc{name: "flags", load: func(context.Context) (map[string]string, error) {
+		values := map[string]string{}
+
+		for i := 0; i < len(args); i++ {
+			if !strings.HasPrefix(args[i], "-") {
+				continue
+			}
+
+			key, value, ok := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+
+			if !ok {
+				if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+					value = args[i+1]
+					i++
+				} else {
+					value = "true"
+				}
+			}
+
+			values[NormaliseKey(key)] = value
+		}
+
+		return values, nil
+	}}
+}
+
+// File reads a YAML file, nested keys are joined with _ so
+//
+//	db:
+//	  name: books
+//
+// sets DB_NAME, and lists are joined with commas
+func File(path string) Source {
+	return sourceFunc{name: path, load: func(context.Context) (map[string]string, error) {
+		bytes, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var document map[string]any
+
+		if err := yaml.Unmarshal(bytes, &document); err != nil {
+			return nil, err
+		}
+
+		values := map[string]string{}
+		flatten("", document, values)
+
+		return values, nil
+	}}
+}
+
+func flatten(prefix string, value any, values map[string]string) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, nested := range value {
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+
+			flatten(key, nested, values)
+		}
+	case []any:
+		items := make([]string, len(value))
+
+		for i, item := range value {
+			items[i] = fmt.Sprint(item)
+		}
+
+		values[NormaliseKey(prefix)] = strings.Join(items, ",")
+	case nil:
+		values[NormaliseKey(prefix)] = ""
+	default:
+		values[NormaliseKey(prefix)] = fmt.Sprint(value)
+	}
+}
+
+// ConsulKV reads the keys under prefix in the consul KV store at address, so config/auth/session-ttl
+// with the prefix config/auth sets SESSION_TTL
+func ConsulKV(address string, prefix string) Source {
+	return sourceFunc{name: "consul KV " + prefix, load: func(ctx context.Context) (map[string]string, error) {
+		config := consul.DefaultConfig()
+		config.Address = address
+
+		client, err := consul.NewClient(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := strings.Trim(prefix, "/") + "/"
+		pairs, _, err := client.KV().List(prefix, (&consul.QueryOptions{}).WithContext(ctx))
+
+		if err != nil {
+			return nil, err
+		}
+
+		values := map[string]string{}
+
+		for _, pair := range pairs {
+			key := strings.TrimPrefix(pair.Key, prefix)
+
+			// folders have no value of their own
+			if key == "" || strings.HasSuffix(key, "/") {
+				continue
+			}
+
+			values[NormaliseKey(key)] = string(pair.Value)
+		}
+
+		return values, nil
+	}}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Store holds a loaded config and keeps it up to date. Changes to settings tagged reload:"true"
+// are applied as they are found, changes to the rest are logged and need a restart.
+type Store[T any] struct {
+	loader    *Loader
+	mu        sync.RWMutex
+	current   T
+	listeners []func(T)
+	// values of settings waiting for a restart, so each change is only logged once
+	pending map[string]any
+}
+
+// Load loads a config of type T into a new store
+func Load[T any](ctx context.Context, loader *Loader) (*Store[T], error) {
+	store := &Store[T]{loader: loader, pending: map[string]any{}}
+
+	if err := loader.Load(ctx, &store.current); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Get returns the current config
+func (s *Store[T]) Get() T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+// OnChange calls listener with the new config each time a reload changes it
+func (s *Store[T]) OnChange(listener func(T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, listener)
+}
+
+// Reload loads the config again and applies the reloadable settings that changed, returning
+// their keys. A config that no longer loads is left as it was.
+func (s *Store[T]) Reload(ctx context.Context) ([]string, error) {
+	var loaded T
+
+	if err := s.loader.Load(ctx, &loaded); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	next := s.current
+	nextFields := fields(reflect.ValueOf(&next).Elem())
+	loadedFields := fields(reflect.ValueOf(&loaded).Elem())
+
+	var changed []string
+
+	for i, field := range nextFields {
+		value := loadedFields[i].value.Interface()
+
+		if reflect.DeepEqual(field.value.Interface(), value) {
+			delete(s.pending, field.key())
+			continue
+		}
+
+		if !field.reload {
+			if pending, ok := s.pending[field.key()]; !ok || !reflect.DeepEqual(pending, value) {
+				log.Printf("Config %s changed, restart to apply it\n", field.key())
+				s.pending[field.key()] = value
+			}
+
+			continue
+		}
+
+		field.value.Set(loadedFields[i].value)
+		changed = append(changed, field.key())
+	}
+
+	s.current = next
+	listeners := s.listeners
+
+	s.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	log.Printf("Config reloaded %v\n", changed)
+
+	for _, listener := range listeners {
+		listener(next)
+	}
+
+	return changed, nil
+}
+
+// Watch reloads the config every interval until the context is done
+func (s *Store[T]) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Reload(ctx); err != nil {
+				log.Printf("Failed to reload config: %s\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// how long to wait before watching a service again after its watch could not be started
+const cacheRetryInterval time.Duration = 5 * time.Second
+
+// Cache keeps the instances of each service it is asked for up to date with a watch, so looking
+// a service up does not go to the registry. When the registry cannot be reached the last
+// instances it gave are kept. Registering and health checks go straight to the registry.
+type Cache struct {
+	registry Registry
+	// lifetime of the watches
+	ctx      context.Context
+	mu       sync.Mutex
+	services map[string]*cachedService
+}
+
+type cachedService struct {
+	// closed once the first instances arrive or the first watch fails
+	ready     chan struct{}
+	readyOnce sync.Once
+	instances []Instance
+	// false until the watch has sent instances, lookups go to the registry until then
+	known       bool
+	updatedAt   time.Time
+	subscribers []chan []Instance
+}
+
+func NewCache(ctx context.Context, registry Registry) *Cache {
+	return &Cache{
+		registry: registry,
+		ctx:      ctx,
+		services: map[string]*cachedService{},
+	}
+}
+
+// Unwrap returns the registry the cache looks services up in
+func (cache *Cache) Unwrap() Registry {
+	return cache.registry
+}
+
+func (cache *Cache) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error {
+	return cache.registry.Register(ctx, instanceID, serviceName, hostPort, metadata)
+}
+
+func (cache *Cache) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return cache.registry.Deregister(ctx, instanceID, serviceName)
+}
+
+func (cache *Cache) HealthCheck(instanceID string, serviceName string, status Status, output string) error {
+	return cache.registry.HealthCheck(instanceID, serviceName, status, output)
+}
+
+// Discover returns the cached instances, starting a watch the first time a service is asked for
+func (cache *Cache) Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error) {
+	service := cache.service(serviceName)
+
+	select {
+	case <-service.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cache.mu.Lock()
+	instances, known := cloneInstances(service.instances), service.known
+	cache.mu.Unlock()
+
+	if !known {
+		return cache.registry.Discover(ctx, serviceName, selectors...)
+	}
+
+	instances = Select(instances, selectors...)
+
+	if len(instances) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return instances, nil
+}
+
+// Watch sends the cached instances and then each change to them until the context is done. A
+// watcher that falls behind only gets the latest instances.
+func (cache *Cache) Watch(ctx context.Context, serviceName string) (<-chan []Instance, error) {
+	service := cache.service(serviceName)
+
+	select {
+	case <-service.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	ch := make(chan []Instance, 1)
+
+	if service.known {
+		ch <- cloneInstances(service.instances)
+	}
+
+	service.subscribers = append(service.subscribers, ch)
+
+	go func() {
+		<-ctx.Done()
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		service.subscribers = slices.DeleteFunc(service.subscribers, func(subscriber chan []Instance) bool {
+			return subscriber == ch
+		})
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// UpdatedAt returns when the instances of a service last changed, zero when they are not cached
+func (cache *Cache) UpdatedAt(serviceName string) time.Time {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	service, ok := cache.services[serviceName]
+
+	if !ok {
+		return time.Time{}
+	}
+
+	return service.updatedAt
+}
+
+// service returns the cache entry of a service, creating it and starting its watch if needed
+func (cache *Cache) service(serviceName string) *cachedService {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	service, ok := cache.services[serviceName]
+
+	if !ok {
+		service = &cachedService{ready: make(chan struct{})}
+		cache.services[serviceName] = service
+
+		go cache.watch(serviceName, service)
+	}
+
+	return service
+}
+
+// watch keeps a service's entry up to date, starting the watch again if it cannot be started or
+// stops before the cache is done
+func (cache *Cache) watch(serviceName string, service *cachedService) {
+	for {
+		updates, err := cache.registry.Watch(cache.ctx, serviceName)
+
+		if err != nil {
+			log.Printf("Failed to watch %s, using the registry directly: %s\n", serviceName, err)
+			service.readyOnce.Do(func() { close(service.ready) })
+		} else {
+			for instances := range updates {
+				cache.update(service, instances)
+			}
+		}
+
+		select {
+		case <-cache.ctx.Done():
+			return
+		case <-time.After(cacheRetryInterval):
+		}
+	}
+}
+
+func (cache *Cache) update(service *cachedService, instances []Instance) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if service.known && sameInstances(service.instances, instances) {
+		return
+	}
+
+	service.instances = cloneInstances(instances)
+	service.known = true
+	service.updatedAt = time.Now()
+	service.readyOnce.Do(func() { close(service.ready) })
+
+	for _, ch := range service.subscribers {
+		// replace instances the subscriber has not read yet
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- cloneInstances(instances)
+	}
+}
@@ -0,0 +1,216 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// consul meta keys the metadata fields are kept under, the rest of the meta is the instance's own
+const (
+	versionMetaKey  string = "version"
+	zoneMetaKey     string = "zone"
+	buildShaMetaKey string = "build_sha"
+)
+
+// longest wait between attempts to reach consul while watching
+const maxWatchBackoff time.Duration = 30 * time.Second
+
+// define a consul registy
+type ConsulRegistry struct {
+	client *consul.Client
+}
+
+// create a new instance of the registry with the provided address
+// Return the pointer to an isntance and error if any
+func NewConsulRegistry(address string) (*ConsulRegistry, error) {
+	config := consul.DefaultConfig()
+	config.Address = address
+
+	client, err := consul.NewClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsulRegistry{client: client}, nil
+}
+
+// create a service record in discovery
+func (registry *ConsulRegistry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error {
+	host, port, err := splitHostPort(hostPort)
+
+	if err != nil {
+		return err
+	}
+
+	meta := map[string]string{}
+
+	for key, value := range metadata.Meta {
+		meta[key] = value
+	}
+
+	for key, value := range map[string]string{versionMetaKey: metadata.Version, zoneMetaKey: metadata.Zone, buildShaMetaKey: metadata.BuildSha} {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+
+	err = registry.client.Agent().ServiceRegister(&consul.AgentServiceRegistration{
+		Address: host,
+		Port:    port,
+		ID:      instanceID,
+		Name:    serviceName,
+		Tags:    metadata.Tags,
+		Meta:    meta,
+		Check:   &consul.AgentServiceCheck{CheckID: instanceID, TTL: "5s"},
+	})
+
+	return err
+}
+
+// Client returns the consul client, for using other parts of consul such as its locks
+func (registry *ConsulRegistry) Client() *consul.Client {
+	return registry.client
+}
+
+// deregister a service record from discovery
+func (registry *ConsulRegistry) Deregister(ctx context.Context, instanceID string, _ string) error {
+	err := registry.client.Agent().ServiceDeregister(instanceID)
+	return err
+}
+
+// Health Check push to update status of service instance
+func (registry *ConsulRegistry) HealthCheck(instanceID string, _ string, status Status, output string) error {
+	err := registry.client.Agent().UpdateTTL(instanceID, output, string(status))
+	return err
+}
+
+// Discover the active instances of a given service name that match the selectors
+
+func (registry *ConsulRegistry) Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error) {
+	instances, _, err := registry.healthy(ctx, serviceName, 0, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instances = Select(instances, selectors...)
+
+	if len(instances) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return instances, nil
+}
+
+// healthy returns the healthy instances, blocking until they differ from waitIndex when it is
+// set. Stale answers can come from any consul server rather than the leader.
+func (registry *ConsulRegistry) healthy(ctx context.Context, serviceName string, waitIndex uint64, allowStale bool) ([]Instance, uint64, error) {
+	options := (&consul.QueryOptions{WaitIndex: waitIndex, WaitTime: 5 * time.Minute, AllowStale: allowStale}).WithContext(ctx)
+
+	enteries, meta, err := registry.client.Health().Service(serviceName, "", false, options)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	instaces := []Instance{}
+
+	for _, entry := range enteries {
+		// instances that warn are degraded but still serving
+		if status := entry.Checks.AggregatedStatus(); status != consul.HealthPassing && status != consul.HealthWarning {
+			continue
+		}
+
+		meta := map[string]string{}
+
+		for key, value := range entry.Service.Meta {
+			meta[key] = value
+		}
+
+		instance := Instance{
+			ID:      entry.Service.ID,
+			Address: fmt.Sprintf("%s:%d", entry.Service.Address, entry.Service.Port),
+			Metadata: Metadata{
+				Version:  meta[versionMetaKey],
+				Zone:     meta[zoneMetaKey],
+				BuildSha: meta[buildShaMetaKey],
+				Tags:     entry.Service.Tags,
+				Meta:     meta,
+			},
+		}
+
+		delete(meta, versionMetaKey)
+		delete(meta, zoneMetaKey)
+		delete(meta, buildShaMetaKey)
+
+		instaces = append(instaces, instance)
+	}
+
+	return instaces, meta.LastIndex, nil
+}
+
+// Watch uses consul blocking queries so changes are seen as soon as they happen. While consul
+// cannot be reached nothing is sent, so watchers keep the last addresses they were given.
+func (registry *ConsulRegistry) Watch(ctx context.Context, serviceName string) (<-chan []Instance, error) {
+	instances, index, err := registry.healthy(ctx, serviceName, 0, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []Instance, 1)
+	ch <- instances
+
+	go func() {
+		defer close(ch)
+
+		backoff := time.Second
+
+		for {
+			next, nextIndex, err := registry.healthy(ctx, serviceName, index, true)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				log.Printf("Failed to watch %s, retrying in %s: %s\n", serviceName, backoff, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+
+				backoff = min(backoff*2, maxWatchBackoff)
+				continue
+			}
+
+			backoff = time.Second
+
+			// the index going backwards means consul was restarted, start again from the beginning
+			if nextIndex < index {
+				nextIndex = 0
+			}
+
+			if nextIndex != index && !sameInstances(instances, next) {
+				select {
+				case ch <- next:
+				case <-ctx.Done():
+					return
+				}
+
+				instances = next
+			}
+
+			index = nextIndex
+		}
+	}()
+
+	return ch, nil
+}
@@ -4,91 +4,229 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"net"
 	"strconv"
 	"strings"
 	"time"
-
-	consul "github.com/hashicorp/consul/api"
 )
 
-// define a consul registy
-type Registry struct {
-	client *consul.Client
+// define a service registry, services register themselves in it and look each other up
+type Registry interface {
+	// create a service record in discovery
+	Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error
+	// deregister a service record from discovery
+	Deregister(ctx context.Context, instanceID string, serviceName string) error
+	// report the health of an instance, with output explaining it
+	HealthCheck(instanceID string, serviceName string, status Status, output string) error
+	// Discover the active instances of a given service name that match the selectors
+	Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error)
+	// Watch sends the instances of a service each time they change, starting with the current
+	// ones. The channel is closed when the context is done.
+	Watch(ctx context.Context, serviceName string) (<-chan []Instance, error)
 }
 
-// create a new instance of the registry with the provided address
-// Return the pointer to an isntance and error if any
-func NewRegistry(address string) (*Registry, error) {
-	config := consul.DefaultConfig()
-	config.Address = address
+var ErrNotFound = errors.New("no healthy instances of the service were found")
 
-	client, err := consul.NewClient(config)
+// Status of an instance, failing instances are not discovered
+type Status string
 
-	if err != nil {
-		return nil, err
-	}
+const (
+	Pass Status = "pass"
+	// working but degraded, such as when an optional dependency is down
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
 
-	return &Registry{client: client}, nil
-}
+type Backend string
+
+const (
+	// a consul agent, the default
+	Consul Backend = "consul"
+	// a fixed list of addresses per service from configuration
+	Static Backend = "static"
+	// DNS SRV records, such as those kubernetes creates for services
+	Dns Backend = "dns"
+	// held in the process, for running every service in one process
+	Memory Backend = "memory"
+)
 
-func GenerateInstanceID(serviceName string) string {
-	return fmt.Sprintf("%s-%d", serviceName, rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+// Config selects the registry, services load it along with the rest of their config
+type Config struct {
+	Backend Backend `config:"DISCOVERY_BACKEND" default:"consul"`
+	// address of the consul agent
+	ConsulAddress string `config:"CONSUL_URI"`
+	// instances of each service for the static backend, such as
+	// auth=auth:8081;books=books-1:8082|version=1,books-2:8082|version=2|tag=canary
+	Static string `config:"DISCOVERY_STATIC"`
+	// the SRV records looked up are _<DnsPortName>._tcp.<service>.<DnsDomain>, the service
+	// name itself is looked up when no port name is set
+	DnsPortName string `config:"DISCOVERY_DNS_PORT_NAME"`
+	DnsDomain   string `config:"DISCOVERY_DNS_DOMAIN"`
+	// how often DNS is looked up again when watching
+	PollInterval time.Duration `config:"DISCOVERY_POLL_INTERVAL" default:"10s"`
 }
 
-// create a service record in discovery
-func (registry *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string) error {
-	hpParts := strings.Split(hostPort, ":")
+// parseStaticServices reads the instances of each service from DISCOVERY_STATIC
+func parseStaticServices(static string) (map[string][]Instance, error) {
+	services := map[string][]Instance{}
 
-	if len(hpParts) != 2 {
-		return errors.New("invalid host:port format provided. Eg. localhost:8081")
-	}
+	for _, service := range strings.Split(static, ";") {
+		if service = strings.TrimSpace(service); service == "" {
+			continue
+		}
 
-	port, err := strconv.Atoi(hpParts[1])
+		name, specs, ok := strings.Cut(service, "=")
+		name = strings.TrimSpace(name)
 
-	if err != nil {
-		return err
-	}
+		if !ok || name == "" {
+			return nil, fmt.Errorf("DISCOVERY_STATIC entry %q is not in the form service=host:port,host:port", service)
+		}
 
-	host := hpParts[0]
+		for _, spec := range strings.Split(specs, ",") {
+			if spec = strings.TrimSpace(spec); spec == "" {
+				continue
+			}
 
-	err = registry.client.Agent().ServiceRegister(&consul.AgentServiceRegistration{
-		Address: host,
-		Port:    port,
-		ID:      instanceID,
-		Name:    serviceName,
-		Check:   &consul.AgentServiceCheck{CheckID: instanceID, TTL: "5s"},
-	})
+			instance, err := parseStaticInstance(spec)
+
+			if err != nil {
+				return nil, err
+			}
+
+			services[name] = append(services[name], instance)
+		}
+	}
 
-	return err
+	return services, nil
 }
 
-// deregister a service record from discovery
-func (registry *Registry) Deregister(ctx context.Context, instanceID string, _ string) error {
-	err := registry.client.Agent().ServiceDeregister(instanceID)
-	return err
+// create the registry selected by the config
+func NewRegistry(config Config) (Registry, error) {
+	switch config.Backend {
+	case Consul, "":
+		if config.ConsulAddress == "" {
+			return nil, errors.New("CONSUL_URI is required for the consul discovery backend")
+		}
+
+		return NewConsulRegistry(config.ConsulAddress)
+	case Static:
+		services, err := parseStaticServices(config.Static)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewStaticRegistry(services), nil
+	case Dns:
+		if config.PollInterval <= 0 {
+			return nil, fmt.Errorf("DISCOVERY_POLL_INTERVAL %s is not a positive duration", config.PollInterval)
+		}
+
+		return NewDnsRegistry(config.DnsPortName, config.DnsDomain, config.PollInterval), nil
+	case Memory:
+		// every registry built from config in a process is the same one so services can find each other
+		return sharedMemoryRegistry, nil
+	default:
+		return nil, fmt.Errorf("unknown DISCOVERY_BACKEND %q, expected consul, static, dns or memory", config.Backend)
+	}
 }
 
-// Health Check push to update status of service instance
-func (registry *Registry) HealthCheck(instanceID string, _ string) error {
-	err := registry.client.Agent().UpdateTTL(instanceID, "", "pass")
-	return err
+func GenerateInstanceID(serviceName string) string {
+	return fmt.Sprintf("%s-%d", serviceName, rand.New(rand.NewSource(time.Now().UnixNano())).Int())
 }
 
-// Discover a list of active addresses of a given service name
+// parseStaticInstance reads host:port followed by |key=value metadata, where version, zone,
+// build and tag (which can repeat) are known and other keys are added to Meta
+func parseStaticInstance(spec string) (Instance, error) {
+	parts := strings.Split(spec, "|")
+	addr := strings.TrimSpace(parts[0])
 
-func (registry *Registry) Discover(ctx context.Context, serviceName string) ([]string, error) {
-	enteries, _, err := registry.client.Health().Service(serviceName, "", true, nil)
+	if _, _, err := splitHostPort(addr); err != nil {
+		return Instance{}, err
+	}
 
-	if err != nil {
-		return nil, err
+	instance := Instance{ID: addr, Address: addr, Metadata: Metadata{Meta: map[string]string{}}}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if !ok || key == "" {
+			return Instance{}, fmt.Errorf("DISCOVERY_STATIC metadata %q of %s is not in the form key=value", part, addr)
+		}
+
+		switch key {
+		case "version":
+			instance.Version = value
+		case "zone":
+			instance.Zone = value
+		case "build":
+			instance.BuildSha = value
+		case "tag":
+			instance.Tags = append(instance.Tags, value)
+		default:
+			instance.Meta[key] = value
+		}
 	}
 
-	var instaces []string
+	return instance, nil
+}
+
+func splitHostPort(hostPort string) (string, int, error) {
+	host, portPart, err := net.SplitHostPort(hostPort)
 
-	for _, entry := range enteries {
-		instaces = append(instaces, fmt.Sprintf("%s:%d", entry.Service.Address, entry.Service.Port))
+	if err != nil || host == "" {
+		return "", 0, fmt.Errorf("invalid host:port format provided %q. Eg. localhost:8081", hostPort)
 	}
 
-	return instaces, nil
+	port, err := strconv.Atoi(portPart)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, port, nil
+}
+
+// pollWatch implements Watch for registries that can only be asked for the current instances,
+// sending whenever the answer changes
+func pollWatch(ctx context.Context, serviceName string, interval time.Duration, discover func(context.Context, string, ...Selector) ([]Instance, error)) <-chan []Instance {
+	ch := make(chan []Instance, 1)
+
+	go func() {
+		defer close(ch)
+
+		var last []Instance
+		first := true
+
+		for {
+			instances, err := discover(ctx, serviceName)
+
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("Failed to discover %s: %s\n", serviceName, err)
+			} else if first || !sameInstances(last, instances) {
+				if instances == nil {
+					instances = []Instance{}
+				}
+
+				select {
+				case ch <- instances:
+				case <-ctx.Done():
+					return
+				}
+
+				last, first = instances, false
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return ch
 }
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DnsRegistry looks services up with DNS SRV records. Registering does nothing, the platform,
+// such as kubernetes, publishes the records and leaves unhealthy instances out of them. SRV
+// records carry no metadata so selectors on it match no instances.
+type DnsRegistry struct {
+	resolver     *net.Resolver
+	portName     string
+	domain       string
+	pollInterval time.Duration
+}
+
+func NewDnsRegistry(portName string, domain string, pollInterval time.Duration) *DnsRegistry {
+	return &DnsRegistry{
+		resolver:     net.DefaultResolver,
+		portName:     portName,
+		domain:       strings.Trim(domain, "."),
+		pollInterval: pollInterval,
+	}
+}
+
+func (registry *DnsRegistry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error {
+	return nil
+}
+
+func (registry *DnsRegistry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return nil
+}
+
+func (registry *DnsRegistry) HealthCheck(instanceID string, serviceName string, status Status, output string) error {
+	return nil
+}
+
+func (registry *DnsRegistry) Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error) {
+	name := serviceName
+
+	if registry.domain != "" {
+		name = serviceName + "." + registry.domain
+	}
+
+	protocol := ""
+
+	if registry.portName != "" {
+		protocol = "tcp"
+	}
+
+	_, records, err := registry.resolver.LookupSRV(ctx, registry.portName, protocol, name)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []Instance{}
+
+	for _, record := range records {
+		addr := fmt.Sprintf("%s:%d", strings.TrimSuffix(record.Target, "."), record.Port)
+		instances = append(instances, Instance{ID: addr, Address: addr})
+	}
+
+	instances = Select(instances, selectors...)
+
+	if len(instances) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return instances, nil
+}
+
+// Watch looks the records up again every poll interval, DNS has no way to be told of changes
+func (registry *DnsRegistry) Watch(ctx context.Context, serviceName string) (<-chan []Instance, error) {
+	return pollWatch(ctx, serviceName, registry.pollInterval, registry.Discover), nil
+}
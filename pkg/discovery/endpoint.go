@@ -0,0 +1,184 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Endpoint is where an infrastructure dependency, such as kafka or mongodb, can be reached. It is
+// either fixed by configuration or found through the registry and kept up to date with a watch.
+type Endpoint struct {
+	name       string
+	mu         sync.Mutex
+	addrs      []string
+	generation uint64
+	// connections made by DialContext, closed when their address leaves the endpoint
+	conns  map[*endpointConn]struct{}
+	dialer net.Dialer
+}
+
+// NewFixedEndpoint returns an endpoint for comma separated addresses that never change
+func NewFixedEndpoint(name string, addrs string) *Endpoint {
+	endpoint := &Endpoint{name: name, conns: map[*endpointConn]struct{}{}}
+
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			endpoint.addrs = append(endpoint.addrs, addr)
+		}
+	}
+
+	return endpoint
+}
+
+// WatchEndpoint finds a dependency registered as serviceName, waiting until it has instances,
+// and follows changes to them until the context is done
+func WatchEndpoint(ctx context.Context, registry Registry, serviceName string) (*Endpoint, error) {
+	updates, err := registry.Watch(ctx, serviceName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{name: serviceName, conns: map[*endpointConn]struct{}{}}
+
+	for instances := range updates {
+		if len(instances) == 0 {
+			log.Printf("Waiting for an instance of %s\n", serviceName)
+			continue
+		}
+
+		endpoint.update(Addresses(instances))
+
+		go func() {
+			for instances := range updates {
+				// a dependency with no instances left keeps its last addresses to retry
+				if len(instances) > 0 {
+					endpoint.update(Addresses(instances))
+				}
+			}
+		}()
+
+		return endpoint, nil
+	}
+
+	return nil, fmt.Errorf("stopped waiting for %s: %w", serviceName, ctx.Err())
+}
+
+func (e *Endpoint) Name() string {
+	return e.name
+}
+
+func (e *Endpoint) Addresses() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return slices.Clone(e.addrs)
+}
+
+// String returns the addresses comma separated, as kafka's bootstrap.servers takes them
+func (e *Endpoint) String() string {
+	return strings.Join(e.Addresses(), ",")
+}
+
+// Current returns the addresses along with the generation, which goes up each time they change
+// so clients that cannot follow changes themselves know to reconnect
+func (e *Endpoint) Current() (string, uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return strings.Join(e.addrs, ","), e.generation
+}
+
+// Generation goes up each time the addresses change
+func (e *Endpoint) Generation() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.generation
+}
+
+// update replaces the addresses, closing connections to ones that have gone so their clients
+// reconnect to the new ones
+func (e *Endpoint) update(addrs []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if sameStrings(e.addrs, addrs) {
+		return
+	}
+
+	log.Printf("Endpoint %s is now %s\n", e.name, strings.Join(addrs, ","))
+
+	e.addrs = slices.Clone(addrs)
+	e.generation++
+
+	for conn := range e.conns {
+		if !slices.Contains(addrs, conn.addr) {
+			conn.Conn.Close()
+			delete(e.conns, conn)
+		}
+	}
+}
+
+// DialContext connects to one of the addresses of the endpoint in place of the address the
+// client asked for, trying the others if it fails. It lets clients with a pluggable dialer,
+// such as the mongodb and redis ones, follow the endpoint.
+func (e *Endpoint) DialContext(ctx context.Context, network string, _ string) (net.Conn, error) {
+	addrs := e.Addresses()
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s: %w", e.name, ErrNotFound)
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+
+	var errs []error
+
+	for _, addr := range addrs {
+		conn, err := e.dialer.DialContext(ctx, network, addr)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		tracked := &endpointConn{Conn: conn, addr: addr, endpoint: e}
+
+		e.mu.Lock()
+		e.conns[tracked] = struct{}{}
+		e.mu.Unlock()
+
+		return tracked, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+type endpointConn struct {
+	net.Conn
+	addr     string
+	endpoint *Endpoint
+}
+
+func (c *endpointConn) Close() error {
+	c.endpoint.mu.Lock()
+	delete(c.endpoint.conns, c)
+	c.endpoint.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+func sameStrings(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+
+	return slices.Equal(a, b)
+}
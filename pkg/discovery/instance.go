@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// Metadata describes an instance beyond its address, used to choose between instances. The
+// config tags load a service's own metadata, SERVICE_TAGS as tag,tag and SERVICE_META as
+// key=value,key=value.
+type Metadata struct {
+	Version  string            `config:"SERVICE_VERSION"`
+	Zone     string            `config:"SERVICE_ZONE"`
+	BuildSha string            `config:"BUILD_SHA"`
+	Tags     []string          `config:"SERVICE_TAGS"`
+	Meta     map[string]string `config:"SERVICE_META"`
+}
+
+// Instance of a service found in discovery
+type Instance struct {
+	ID      string
+	Address string
+	Metadata
+}
+
+// Addresses returns the address of each instance
+func Addresses(instances []Instance) []string {
+	addrs := make([]string, 0, len(instances))
+
+	for _, instance := range instances {
+		addrs = append(addrs, instance.Address)
+	}
+
+	return addrs
+}
+
+func cloneInstances(instances []Instance) []Instance {
+	cloned := make([]Instance, 0, len(instances))
+
+	for _, instance := range instances {
+		instance.Tags = slices.Clone(instance.Tags)
+		instance.Meta = maps.Clone(instance.Meta)
+		cloned = append(cloned, instance)
+	}
+
+	return cloned
+}
+
+func sortInstances(instances []Instance) {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Address != instances[j].Address {
+			return instances[i].Address < instances[j].Address
+		}
+
+		return instances[i].ID < instances[j].ID
+	})
+}
+
+// sameInstances reports whether two sets of instances are the same, ignoring their order
+func sameInstances(a []Instance, b []Instance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a, b = cloneInstances(a), cloneInstances(b)
+	sortInstances(a)
+	sortInstances(b)
+
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,164 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// the registry NewRegistry returns for the memory backend
+var sharedMemoryRegistry = NewMemoryRegistry()
+
+// MemoryRegistry keeps services in the process so every service can run in one process, such as
+// a test, without a registry to talk to. Instances pass from when they register until they
+// report otherwise.
+type MemoryRegistry struct {
+	mu sync.Mutex
+	// instances by service then instance id
+	services map[string]map[string]*memoryInstance
+	watchers map[string][]chan []Instance
+}
+
+type memoryInstance struct {
+	instance Instance
+	status   Status
+	output   string
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: map[string]map[string]*memoryInstance{},
+		watchers: map[string][]chan []Instance{},
+	}
+}
+
+func (registry *MemoryRegistry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error {
+	if _, _, err := splitHostPort(hostPort); err != nil {
+		return err
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.services[serviceName] == nil {
+		registry.services[serviceName] = map[string]*memoryInstance{}
+	}
+
+	instance := Instance{ID: instanceID, Address: hostPort, Metadata: metadata}
+	// like a consul TTL check, an instance is not discovered until it reports that it is healthy
+	registry.services[serviceName][instanceID] = &memoryInstance{instance: cloneInstances([]Instance{instance})[0], status: Fail}
+	registry.notify(serviceName)
+
+	return nil
+}
+
+func (registry *MemoryRegistry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.services[serviceName][instanceID]; !ok {
+		return nil
+	}
+
+	delete(registry.services[serviceName], instanceID)
+	registry.notify(serviceName)
+
+	return nil
+}
+
+func (registry *MemoryRegistry) HealthCheck(instanceID string, serviceName string, status Status, output string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	instance, ok := registry.services[serviceName][instanceID]
+
+	if !ok {
+		return fmt.Errorf("instance %s of %s is not registered", instanceID, serviceName)
+	}
+
+	changed := instance.status != status
+	instance.status, instance.output = status, output
+
+	if changed {
+		registry.notify(serviceName)
+	}
+
+	return nil
+}
+
+func (registry *MemoryRegistry) Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	instances := Select(registry.instances(serviceName), selectors...)
+
+	if len(instances) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return instances, nil
+}
+
+func (registry *MemoryRegistry) Watch(ctx context.Context, serviceName string) (<-chan []Instance, error) {
+	if ctx.Err() != nil {
+		return nil, errors.New("discovery: watch context is already done")
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	ch := make(chan []Instance, 1)
+	ch <- registry.instances(serviceName)
+	registry.watchers[serviceName] = append(registry.watchers[serviceName], ch)
+
+	go func() {
+		<-ctx.Done()
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+
+		watchers := registry.watchers[serviceName]
+
+		for i, watcher := range watchers {
+			if watcher == ch {
+				registry.watchers[serviceName] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// instances returns copies of the instances of a service that are not failing sorted by address,
+// the lock must be held
+func (registry *MemoryRegistry) instances(serviceName string) []Instance {
+	instances := []Instance{}
+
+	for _, registered := range registry.services[serviceName] {
+		if registered.status != Fail {
+			instances = append(instances, registered.instance)
+		}
+	}
+
+	instances = cloneInstances(instances)
+	sortInstances(instances)
+
+	return instances
+}
+
+// notify sends the new instances to watchers of a service, the lock must be held. A watcher that
+// has not read the previous instances has them replaced so it only ever sees the latest.
+func (registry *MemoryRegistry) notify(serviceName string) {
+	for _, ch := range registry.watchers[serviceName] {
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- registry.instances(serviceName)
+	}
+}
@@ -0,0 +1,223 @@
+package discovery
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Selector narrows down the instances of a service
+type Selector interface {
+	Select(instances []Instance) []Instance
+}
+
+// SelectorFunc lets a function be used as a Selector
+type SelectorFunc func(instances []Instance) []Instance
+
+func (f SelectorFunc) Select(instances []Instance) []Instance {
+	return f(instances)
+}
+
+// Select applies the selectors in order
+func Select(instances []Instance, selectors ...Selector) []Instance {
+	for _, selector := range selectors {
+		if selector != nil {
+			instances = selector.Select(instances)
+		}
+	}
+
+	return instances
+}
+
+func filter(match func(Instance) bool) Selector {
+	return SelectorFunc(func(instances []Instance) []Instance {
+		selected := []Instance{}
+
+		for _, instance := range instances {
+			if match(instance) {
+				selected = append(selected, instance)
+			}
+		}
+
+		return selected
+	})
+}
+
+// Version selects instances of a version, "2" matches both 2 and 2.x
+func Version(version string) Selector {
+	return filter(func(instance Instance) bool {
+		return instance.Version == version || strings.HasPrefix(instance.Version, version+".")
+	})
+}
+
+func Tag(tag string) Selector {
+	return filter(func(instance Instance) bool {
+		return slices.Contains(instance.Tags, tag)
+	})
+}
+
+func Meta(key string, value string) Selector {
+	return filter(func(instance Instance) bool {
+		return instance.Meta[key] == value
+	})
+}
+
+// PreferZone selects the instances in a zone when there are any, otherwise all of them
+func PreferZone(zone string) Selector {
+	return SelectorFunc(func(instances []Instance) []Instance {
+		if zone == "" {
+			return instances
+		}
+
+		local := filter(func(instance Instance) bool { return instance.Zone == zone }).Select(instances)
+
+		if len(local) == 0 {
+			return instances
+		}
+
+		return local
+	})
+}
+
+// ParseSelectors reads comma separated selectors such as version=2,tag=canary. zone= prefers a
+// zone and any other key matches metadata.
+func ParseSelectors(value string) ([]Selector, error) {
+	selectors := []Selector{}
+
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		key, match, ok := strings.Cut(part, "=")
+		key, match = strings.TrimSpace(key), strings.TrimSpace(match)
+
+		if !ok || key == "" {
+			return nil, fmt.Errorf("selector %q is not in the form key=value", part)
+		}
+
+		switch key {
+		case "version":
+			selectors = append(selectors, Version(match))
+		case "zone":
+			selectors = append(selectors, PreferZone(match))
+		case "tag":
+			selectors = append(selectors, Tag(match))
+		default:
+			selectors = append(selectors, Meta(key, match))
+		}
+	}
+
+	return selectors, nil
+}
+
+// Split sends a share of the traffic to a service to the instances matching its selectors
+type Split struct {
+	Weight    int
+	Selectors []Selector
+	// the text the split was parsed from
+	source string
+}
+
+// Route splits the traffic to a service by weight, such as between the current version and a
+// canary. It is a Selector choosing one split each time it is used.
+type Route []Split
+
+// ParseRoute reads splits separated by semicolons, each selectors followed by a weight, such as
+// version=1:90;version=2:10
+func ParseRoute(value string) (Route, error) {
+	route := Route{}
+
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		selectorsPart, weightPart, ok := strings.Cut(part, ":")
+
+		if !ok {
+			return nil, fmt.Errorf("route split %q is not in the form selectors:weight", part)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(weightPart))
+
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("route split %q does not have a weight of 0 or more", part)
+		}
+
+		selectors, err := ParseSelectors(selectorsPart)
+
+		if err != nil {
+			return nil, err
+		}
+
+		route = append(route, Split{Weight: weight, Selectors: selectors, source: part})
+	}
+
+	return route, nil
+}
+
+// UnmarshalText parses a route with ParseRoute so it can be loaded as a setting
+func (r *Route) UnmarshalText(text []byte) error {
+	route, err := ParseRoute(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*r = route
+
+	return nil
+}
+
+// MarshalText returns the text the route was parsed from
+func (r Route) MarshalText() ([]byte, error) {
+	parts := make([]string, 0, len(r))
+
+	for _, split := range r {
+		parts = append(parts, split.source)
+	}
+
+	return []byte(strings.Join(parts, ";")), nil
+}
+
+// Select picks a split by weight and returns its instances. Splits without instances are left
+// out, so their share goes to the others, and all instances are returned when none match.
+func (r Route) Select(instances []Instance) []Instance {
+	if len(r) == 0 {
+		return instances
+	}
+
+	candidates := make([][]Instance, len(r))
+	total := 0
+
+	for i, split := range r {
+		candidates[i] = Select(instances, split.Selectors...)
+
+		if len(candidates[i]) > 0 {
+			total += split.Weight
+		}
+	}
+
+	if total == 0 {
+		return instances
+	}
+
+	pick := rand.Intn(total)
+
+	for i, split := range r {
+		if len(candidates[i]) == 0 {
+			continue
+		}
+
+		if pick < split.Weight {
+			return candidates[i]
+		}
+
+		pick -= split.Weight
+	}
+
+	return instances
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"math"
+	"testing"
+)
+
+func versioned(id string, version string) Instance {
+	return Instance{ID: id, Address: id + ":8080", Metadata: Metadata{Version: version}}
+}
+
+// shares selects from instances many times and returns how often each version was chosen
+func shares(t *testing.T, route Route, instances []Instance, rounds int) map[string]float64 {
+	t.Helper()
+
+	counts := map[string]int{}
+
+	for i := 0; i < rounds; i++ {
+		selected := route.Select(instances)
+
+		if len(selected) == 0 {
+			t.Fatal("no instances were selected")
+		}
+
+		counts[selected[0].Version]++
+	}
+
+	result := map[string]float64{}
+
+	for version, count := range counts {
+		result[version] = float64(count) / float64(rounds)
+	}
+
+	return result
+}
+
+func TestRouteSplitsByWeight(t *testing.T) {
+	route, err := ParseRoute("version=1:90; version=2:10")
+
+	if err != nil {
+		t.Fatalf("ParseRoute: %s", err)
+	}
+
+	instances := []Instance{versioned("a", "1.4"), versioned("b", "1.4"), versioned("c", "2.0")}
+
+	got := shares(t, route, instances, 20000)
+
+	if math.Abs(got["1.4"]-0.9) > 0.02 || math.Abs(got["2.0"]-0.1) > 0.02 {
+		t.Fatalf("expected a 90/10 split, got %v", got)
+	}
+
+	// each pick returns every instance of the chosen split
+	for i := 0; i < 100; i++ {
+		if selected := route.Select(instances); selected[0].Version == "1.4" && len(selected) != 2 {
+			t.Fatalf("expected both version 1 instances, got %v", selected)
+		}
+	}
+}
+
+func TestRouteGivesEmptySplitsShareToOthers(t *testing.T) {
+	route, err := ParseRoute("version=1:50;version=2:50")
+
+	if err != nil {
+		t.Fatalf("ParseRoute: %s", err)
+	}
+
+	// no version 2 instances are running, all traffic goes to version 1
+	got := shares(t, route, []Instance{versioned("a", "1.0")}, 1000)
+
+	if got["1.0"] != 1 {
+		t.Fatalf("expected all traffic on version 1, got %v", got)
+	}
+}
+
+func TestRouteReturnsAllInstancesWithoutAMatch(t *testing.T) {
+	instances := []Instance{versioned("a", "1.0"), versioned("b", "1.1")}
+
+	tests := []struct {
+		name  string
+		route string
+	}{
+		{name: "no split matches", route: "version=3:100"},
+		{name: "matching split has no weight", route: "version=1:0;version=3:100"},
+		{name: "no splits", route: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, err := ParseRoute(test.route)
+
+			if err != nil {
+				t.Fatalf("ParseRoute: %s", err)
+			}
+
+			if selected := route.Select(instances); len(selected) != len(instances) {
+				t.Fatalf("expected all instances, got %v", selected)
+			}
+		})
+	}
+}
+
+func TestParseRouteRejectsInvalidSplits(t *testing.T) {
+	for _, value := range []string{"version=1", "version=1:heavy", "version=1:-5", "version:10"} {
+		if _, err := ParseRoute(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"context"
+)
+
+// StaticRegistry serves a fixed list of instances per service, registering does nothing as the
+// instances are already known
+type StaticRegistry struct {
+	services map[string][]Instance
+}
+
+func NewStaticRegistry(services map[string][]Instance) *StaticRegistry {
+	copied := make(map[string][]Instance, len(services))
+
+	for name, instances := range services {
+		copied[name] = cloneInstances(instances)
+	}
+
+	return &StaticRegistry{services: copied}
+}
+
+func (registry *StaticRegistry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, metadata Metadata) error {
+	return nil
+}
+
+func (registry *StaticRegistry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return nil
+}
+
+func (registry *StaticRegistry) HealthCheck(instanceID string, serviceName string, status Status, output string) error {
+	return nil
+}
+
+func (registry *StaticRegistry) Discover(ctx context.Context, serviceName string, selectors ...Selector) ([]Instance, error) {
+	instances := Select(cloneInstances(registry.services[serviceName]), selectors...)
+
+	if len(instances) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return instances, nil
+}
+
+// Watch sends the instances once, they never change
+func (registry *StaticRegistry) Watch(ctx context.Context, serviceName string) (<-chan []Instance, error) {
+	ch := make(chan []Instance, 1)
+	ch <- cloneInstances(registry.services[serviceName])
+
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,26 @@
+package health
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Pinger is a mongo client, such as *mongo.Client
+type Pinger interface {
+	Ping(ctx context.Context, rp *readpref.ReadPref) error
+}
+
+// Mongo checks the primary can be reached
+func Mongo(client Pinger) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	})
+}
+
+func Redis(client *redis.Client) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
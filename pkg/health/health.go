@@ -0,0 +1,252 @@
+// Package health runs checks against the things a service depends on and reports the result to
+// discovery, the grpc health service and the /healthz and /readyz endpoints.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Status is reported to discovery as is
+type Status = discovery.Status
+
+const (
+	Pass = discovery.Pass
+	Warn = discovery.Warn
+	Fail = discovery.Fail
+)
+
+// DefaultTimeout bounds how long each check can take
+const DefaultTimeout time.Duration = 2 * time.Second
+
+// Checker is a dependency that can be checked, returning why it is unhealthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc lets a function be used as a Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type check struct {
+	name    string
+	checker Checker
+	// a failing required check fails the service, an optional one only warns
+	required bool
+}
+
+type CheckResult struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status    Status                 `json:"status"`
+	Checks    map[string]CheckResult `json:"checks"`
+	CheckedAt time.Time              `json:"checkedAt"`
+}
+
+// Output describes each check on a line, for the output of a discovery health check
+func (r Report) Output() string {
+	names := make([]string, 0, len(r.Checks))
+
+	for name := range r.Checks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+
+	for _, name := range names {
+		result := r.Checks[name]
+
+		if result.Error == "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, result.Status))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s: %s", name, result.Status, result.Error))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Health holds the checks of a service and the latest report from running them
+type Health struct {
+	mu      sync.RWMutex
+	checks  []check
+	timeout time.Duration
+	latest  *Report
+	// how long a report is trusted for before the service is no longer considered live
+	staleAfter time.Duration
+	grpcServer *grpcHealth.Server
+}
+
+func New(timeout time.Duration) *Health {
+	grpcServer := grpcHealth.NewServer()
+	// not serving until the checks have run once
+	grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return &Health{
+		timeout:    timeout,
+		staleAfter: 30 * time.Second,
+		grpcServer: grpcServer,
+	}
+}
+
+// Register adds a check the service cannot work without
+func (h *Health) Register(name string, checker Checker) {
+	h.add(check{name: name, checker: checker, required: true})
+}
+
+// RegisterOptional adds a check the service can work without, it only warns when failing
+func (h *Health) RegisterOptional(name string, checker Checker) {
+	h.add(check{name: name, checker: checker, required: false})
+}
+
+func (h *Health) add(c check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, c)
+}
+
+// Check runs every check at once and records the report
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, c)
+		}()
+	}
+
+	wg.Wait()
+
+	report := Report{
+		Status:    Pass,
+		Checks:    make(map[string]CheckResult, len(checks)),
+		CheckedAt: time.Now(),
+	}
+
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+
+		if results[i].Status == Fail {
+			report.Status = Fail
+		} else if results[i].Status == Warn && report.Status == Pass {
+			report.Status = Warn
+		}
+	}
+
+	h.mu.Lock()
+	h.latest = &report
+	h.mu.Unlock()
+
+	if report.Status == Fail {
+		h.grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	} else {
+		h.grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}
+
+	return report
+}
+
+// run runs a check, a check that does not return in time is failed without waiting for it
+func run(ctx context.Context, c check) CheckResult {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.checker.Check(ctx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out: %w", ctx.Err())
+	}
+
+	if err == nil {
+		return CheckResult{Status: Pass}
+	}
+
+	if c.required {
+		return CheckResult{Status: Fail, Error: err.Error()}
+	}
+
+	return CheckResult{Status: Warn, Error: err.Error()}
+}
+
+// Run checks every interval until the context is done, passing each report to publish
+func (h *Health) Run(ctx context.Context, interval time.Duration, publish func(Report)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		publish(h.Check(ctx))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Latest returns the last report, false when the checks have not run yet
+func (h *Health) Latest() (Report, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.latest == nil {
+		return Report{}, false
+	}
+
+	return *h.latest, true
+}
+
+// Live reports whether the checks are still running, a report that has gone stale means the
+// service is stuck. Failing dependencies do not make it not live, restarting would not help.
+func (h *Health) Live() (Report, bool) {
+	report, ok := h.Latest()
+
+	return report, ok && time.Since(report.CheckedAt) < h.staleAfter
+}
+
+// Ready reports whether the service can take traffic, which is when the checks are live and
+// none of the required ones fail
+func (h *Health) Ready() (Report, bool) {
+	report, ok := h.Live()
+
+	return report, ok && report.Status != Fail
+}
+
+// GrpcServer is the grpc.health.v1 service, serving while no required check fails
+func (h *Health) GrpcServer() *grpcHealth.Server {
+	return h.grpcServer
+}
@@ -0,0 +1,35 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves /healthz and /readyz for services without a http router of their own
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		report, ok := h.Live()
+		writeReport(w, report, ok)
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		report, ok := h.Ready()
+		writeReport(w, report, ok)
+	})
+
+	return mux
+}
+
+func writeReport(w http.ResponseWriter, report Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
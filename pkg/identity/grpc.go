@@ -0,0 +1,82 @@
+package identity
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey carries the assertion in grpc metadata
+const MetadataKey string = "x-identity-assertion"
+
+// OutgoingContext attaches a signed assertion to grpc calls and kafka messages made with the context
+func OutgoingContext(ctx context.Context, principal *Principal, token string) context.Context {
+	ctx = NewContext(ctx, principal, token)
+
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, token)
+}
+
+// verifyIncoming puts the principal of a call into its context. Calls without an assertion
+// are let through with no principal, handlers that need a user check for one.
+func (v *Verifier) verifyIncoming(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+
+	if !ok {
+		return ctx, nil
+	}
+
+	tokens := md.Get(MetadataKey)
+
+	if len(tokens) == 0 {
+		return ctx, nil
+	}
+
+	if len(tokens) > 1 {
+		return nil, status.Errorf(codes.Unauthenticated, "more than one identity assertion was sent")
+	}
+
+	principal, err := v.Verify(tokens[0])
+
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	return NewContext(ctx, principal, tokens[0]), nil
+}
+
+func (v *Verifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := v.verifyIncoming(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// principalStream replaces the context of a stream with one carrying the principal
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
+
+func (v *Verifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.verifyIncoming(stream.Context())
+
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &principalStream{ServerStream: stream, ctx: ctx})
+	}
+}
@@ -0,0 +1,177 @@
+// Package identity passes the user behind a request from the api to backend services. The api
+// signs an assertion naming the user, which travels in grpc metadata and kafka headers, and the
+// backends verify it before trusting it.
+package identity
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// audience of every assertion, so tokens meant for users are never accepted as one
+const audience string = "bookstore-services"
+
+// DefaultTtl is long enough for a request to fan out to the backends
+const DefaultTtl time.Duration = 5 * time.Minute
+
+var ErrInvalidAssertion = errors.New("identity assertion is not valid")
+
+// Principal is the user a call or event was made on behalf of
+type Principal struct {
+	UserID   string
+	Username string
+	Roles    []string
+	// set when an admin is impersonating the user
+	ImpersonatorID string
+	ServiceAccount bool
+	RequestID      string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type assertionClaims struct {
+	Username       string   `json:"username,omitempty"`
+	Roles          []string `json:"roles"`
+	ImpersonatorID string   `json:"act,omitempty"`
+	ServiceAccount bool     `json:"serviceAccount,omitempty"`
+	RequestID      string   `json:"rid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Signer issues assertions, only the api holds one
+type Signer struct {
+	key    []byte
+	issuer string
+	ttl    time.Duration
+}
+
+func NewSigner(key []byte, issuer string, ttl time.Duration) (*Signer, error) {
+	if len(key) == 0 {
+		return nil, errors.New("identity: a signing key is required")
+	}
+
+	return &Signer{
+		key:    key,
+		issuer: issuer,
+		ttl:    ttl,
+	}, nil
+}
+
+func (s *Signer) Sign(principal *Principal) (string, error) {
+	now := time.Now()
+
+	claims := assertionClaims{
+		Username:       principal.Username,
+		Roles:          principal.Roles,
+		ImpersonatorID: principal.ImpersonatorID,
+		ServiceAccount: principal.ServiceAccount,
+		RequestID:      principal.RequestID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   principal.UserID,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+}
+
+// Verifier checks assertions, every backend holds one
+type Verifier struct {
+	key []byte
+}
+
+func NewVerifier(key []byte) (*Verifier, error) {
+	if len(key) == 0 {
+		return nil, errors.New("identity: a verification key is required")
+	}
+
+	return &Verifier{
+		key: key,
+	}, nil
+}
+
+func (v *Verifier) Verify(token string) (*Principal, error) {
+	return v.VerifyAt(token, time.Now())
+}
+
+// VerifyAt checks the assertion was valid at a point in time, such as when a kafka message
+// carrying it was produced, so events waiting in a topic are not rejected for their age
+func (v *Verifier) VerifyAt(token string, at time.Time) (*Principal, error) {
+	claims := new(assertionClaims)
+
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return v.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return at }),
+	)
+
+	if err != nil || claims.Subject == "" {
+		return nil, ErrInvalidAssertion
+	}
+
+	return &Principal{
+		UserID:         claims.Subject,
+		Username:       claims.Username,
+		Roles:          claims.Roles,
+		ImpersonatorID: claims.ImpersonatorID,
+		ServiceAccount: claims.ServiceAccount,
+		RequestID:      claims.RequestID,
+	}, nil
+}
+
+type contextKey struct{}
+
+// asserted is what the context holds, the token is kept so it can be passed on unchanged
+type asserted struct {
+	principal *Principal
+	token     string
+}
+
+// NewContext returns a context carrying the principal and the assertion it was verified from
+func NewContext(ctx context.Context, principal *Principal, token string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &asserted{principal: principal, token: token})
+}
+
+// FromContext returns the principal a call or event was made on behalf of
+func FromContext(ctx context.Context) (*Principal, bool) {
+	value, ok := ctx.Value(contextKey{}).(*asserted)
+
+	if !ok {
+		return nil, false
+	}
+
+	return value.principal, true
+}
+
+// Describe names the user in the context for log lines
+func Describe(ctx context.Context) string {
+	principal, ok := FromContext(ctx)
+
+	if !ok {
+		return "an unknown user"
+	}
+
+	return "user " + principal.UserID
+}
+
+func tokenFromContext(ctx context.Context) string {
+	value, ok := ctx.Value(contextKey{}).(*asserted)
+
+	if !ok {
+		return ""
+	}
+
+	return value.token
+}
@@ -0,0 +1,44 @@
+package identity
+
+import (
+	"context"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// HeaderKey carries the assertion in kafka message headers
+const HeaderKey string = "identity-assertion"
+
+// KafkaHeaders returns the headers passing the context's assertion on with a message, nil when there is none
+func KafkaHeaders(ctx context.Context) []kafka.Header {
+	token := tokenFromContext(ctx)
+
+	if token == "" {
+		return nil
+	}
+
+	return []kafka.Header{{Key: HeaderKey, Value: []byte(token)}}
+}
+
+// ContextFromKafka puts the principal of a consumed message into the context. The assertion
+// must have been valid when the message was produced. Messages without one get no principal.
+func (v *Verifier) ContextFromKafka(ctx context.Context, headers []kafka.Header, producedAt time.Time) (context.Context, error) {
+	for _, header := range headers {
+		if header.Key != HeaderKey {
+			continue
+		}
+
+		token := string(header.Value)
+
+		principal, err := v.VerifyAt(token, producedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewContext(ctx, principal, token), nil
+	}
+
+	return ctx, nil
+}
@@ -0,0 +1,97 @@
+// Package infra finds the infrastructure a service depends on. Each dependency is found through
+// the service registry when <NAME>_SERVICE names it there, and otherwise at the fixed address in
+// <NAME>_URI. Dependencies found through the registry are followed as their instances change, so
+// a failover does not need a restart. Services embed the config of each dependency they use in
+// their own config.
+package infra
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+)
+
+type KafkaConfig struct {
+	// comma separated brokers
+	KafkaUri     string `config:"KAFKA_URI"`
+	KafkaService string `config:"KAFKA_SERVICE"`
+}
+
+type MongoConfig struct {
+	// may hold credentials
+	MongoUri     string `config:"MONGODB_URI" secret:"true"`
+	MongoService string `config:"MONGODB_SERVICE"`
+}
+
+type RedisConfig struct {
+	RedisUri     string `config:"REDIS_URI"`
+	RedisService string `config:"REDIS_SERVICE"`
+}
+
+// Kafka returns the brokers from KAFKA_SERVICE or KAFKA_URI
+func Kafka(ctx context.Context, registry discovery.Registry, config KafkaConfig) (*discovery.Endpoint, error) {
+	if config.KafkaService != "" {
+		return discovery.WatchEndpoint(ctx, registry, config.KafkaService)
+	}
+
+	if config.KafkaUri == "" {
+		return nil, errors.New("infra: KAFKA_URI or KAFKA_SERVICE is required")
+	}
+
+	return discovery.NewFixedEndpoint("kafka", config.KafkaUri), nil
+}
+
+// Mongo connects to MONGODB_SERVICE or MONGODB_URI. With MONGODB_SERVICE the URI is optional,
+// it can still carry credentials and options but its hosts are replaced by the instances
+// registered for the service.
+func Mongo(ctx context.Context, registry discovery.Registry, config MongoConfig) (*MongoClient, error) {
+	uri, service := config.MongoUri, config.MongoService
+
+	if service == "" {
+		if uri == "" {
+			return nil, errors.New("infra: MONGODB_URI or MONGODB_SERVICE is required")
+		}
+
+		return connectMongo(uri, nil)
+	}
+
+	if uri == "" {
+		uri = "mongodb://" + service
+	}
+
+	endpoint, err := discovery.WatchEndpoint(ctx, registry, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return connectMongo(uri, endpoint)
+}
+
+// Redis returns the client options for REDIS_SERVICE or REDIS_URI
+func Redis(ctx context.Context, registry discovery.Registry, config RedisConfig) (*redis.Options, error) {
+	if service := config.RedisService; service != "" {
+		endpoint, err := discovery.WatchEndpoint(ctx, registry, service)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &redis.Options{
+			Addr:   service,
+			Dialer: endpoint.DialContext,
+		}, nil
+	}
+
+	if config.RedisUri == "" {
+		return nil, errors.New("infra: REDIS_URI or REDIS_SERVICE is required")
+	}
+
+	return &redis.Options{
+		Addr:     config.RedisUri,
+		Password: "",
+		DB:       0,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package infra
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// how long a replaced client is kept for the operations still using it
+const replacedClientGrace time.Duration = 30 * time.Second
+
+// Database is the part of a mongo client repositories use. It is satisfied by *mongo.Client and
+// by MongoClient, so the client behind it can be replaced.
+type Database interface {
+	Database(name string, opts ...*options.DatabaseOptions) *mongo.Database
+}
+
+// MongoClient is a mongo client that follows a service found through the registry. The driver
+// is seeded with the instances registered for the service and follows the members of a replica
+// set itself, but only while one of the hosts it knows of is still there, so the client is
+// replaced when the instances change.
+type MongoClient struct {
+	uri string
+	// nil for a fixed address, the client is then never replaced
+	endpoint *discovery.Endpoint
+
+	mu         sync.Mutex
+	client     *mongo.Client
+	generation uint64
+}
+
+func connectMongo(uri string, endpoint *discovery.Endpoint) (*MongoClient, error) {
+	c := &MongoClient{uri: uri, endpoint: endpoint}
+	clientOptions := options.Client().ApplyURI(uri)
+
+	if endpoint != nil {
+		var addrs string
+		addrs, c.generation = endpoint.Current()
+		clientOptions.SetHosts(strings.Split(addrs, ","))
+	}
+
+	client, err := mongo.Connect(context.Background(), clientOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+
+	return c, nil
+}
+
+// Client returns the client for the current instances, replacing it if they have changed. The
+// driver connects in the background, so replacing it does not wait for the new instances.
+func (c *MongoClient) Client() *mongo.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.endpoint == nil {
+		return c.client
+	}
+
+	addrs, generation := c.endpoint.Current()
+
+	// with no instances registered the old client is kept, it may still reach one
+	if generation == c.generation || addrs == "" {
+		return c.client
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(c.uri).SetHosts(strings.Split(addrs, ",")))
+
+	if err != nil {
+		log.Printf("Failed to reconnect to mongodb at %s: %s\n", addrs, err)
+		return c.client
+	}
+
+	log.Printf("Reconnecting to mongodb at %s\n", addrs)
+
+	previous := c.client
+	c.client, c.generation = client, generation
+
+	go func() {
+		time.Sleep(replacedClientGrace)
+
+		ctx, cancel := context.WithTimeout(context.Background(), replacedClientGrace)
+		defer cancel()
+
+		if err := previous.Disconnect(ctx); err != nil {
+			log.Printf("Failed to disconnect the replaced mongodb client: %s\n", err)
+		}
+	}()
+
+	return client
+}
+
+func (c *MongoClient) Database(name string, opts ...*options.DatabaseOptions) *mongo.Database {
+	return c.Client().Database(name, opts...)
+}
+
+func (c *MongoClient) Ping(ctx context.Context, rp *readpref.ReadPref) error {
+	return c.Client().Ping(ctx, rp)
+}
+
+func (c *MongoClient) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client.Disconnect(ctx)
+}
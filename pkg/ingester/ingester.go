@@ -3,62 +3,307 @@ package ingester
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
 )
 
+// how long the consumer waits for a message before checking whether it should stop
+const pollTimeout time.Duration = time.Second
+
+// the longest the consumer waits between attempts to handle a message that failed
+const maxRetryDelay time.Duration = 30 * time.Second
+
+// StallAfter is how long the consumer can go without polling before its health check fails,
+// such as when the handler reading its messages is stuck
+const StallAfter time.Duration = 30 * time.Second
+
 // Define a kafka Ingester
 type Ingester[T any] struct {
+	brokers *discovery.Endpoint
+	groupID string
+	topic   string
+	state   *consumerState
+}
+
+// consumerState is shared by copies of an ingester so handlers holding one by value see the
+// state of the consumer loop
+type consumerState struct {
+	// replaced when the brokers change, under closeMu
 	consumer *kafka.Consumer
-	topic    string
+	// generation of the brokers the consumer was created for
+	generation uint64
+
+	mu       sync.Mutex
+	lastPoll time.Time
+	// the error from the last poll, cleared once a poll succeeds
+	lastErr error
+	// guards the consumer being closed while a check uses it
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// Message is a decoded event along with the kafka headers and time it was produced with
+type Message[T any] struct {
+	Event      T
+	Headers    []kafka.Header
+	ProducedAt time.Time
+}
+
+// consumerConfig commits offsets in the background as usual, but only those stored once a message
+// has been handled
+func consumerConfig(addrs string, groupID string) *kafka.ConfigMap {
+	return &kafka.ConfigMap{
+		"bootstrap.servers":        addrs,
+		"group.id":                 groupID,
+		"enable.auto.offset.store": false,
+	}
 }
 
 // create a new ingester
-func New[T any](addr string, groupID string, topic string) (*Ingester[T], error) {
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{"bootstrap.servers": addr, "group.id": groupID})
+func New[T any](brokers *discovery.Endpoint, groupID string, topic string) (*Ingester[T], error) {
+	addrs, generation := brokers.Current()
+	consumer, err := kafka.NewConsumer(consumerConfig(addrs, groupID))
 
 	if err != nil {
 		return nil, err
 	}
-	return &Ingester[T]{consumer, topic}, nil
+	return &Ingester[T]{brokers, groupID, topic, &consumerState{consumer: consumer, generation: generation}}, nil
 }
 
-func (i *Ingester[T]) Ingest(ctx context.Context) (<-chan T, error) {
+// reconnect replaces the consumer when the brokers have changed since it was created. Kafka
+// follows brokers joining and leaving a cluster itself, this is for when every broker it knew of
+// has been replaced.
+func (i *Ingester[T]) reconnect() error {
+	addrs, generation := i.brokers.Current()
 
-	fmt.Printf("Starting ingestion for %s\n", i.topic)
-	if err := i.consumer.SubscribeTopics([]string{i.topic}, nil); err != nil {
-		return nil, err
+	i.state.closeMu.Lock()
+	defer i.state.closeMu.Unlock()
+
+	if generation == i.state.generation {
+		return nil
 	}
 
-	ch := make(chan T, 1)
+	consumer, err := kafka.NewConsumer(consumerConfig(addrs, i.groupID))
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				close(ch)
-				i.consumer.Close()
-			default:
-			}
+	if err != nil {
+		return err
+	}
 
-			msg, err := i.consumer.ReadMessage(-1)
-			if err != nil {
-				log.Println("Consumer error:", err)
-				continue
-			}
+	if err := consumer.SubscribeTopics([]string{i.topic}, nil); err != nil {
+		consumer.Close()
+		return err
+	}
 
-			var event T
-			if err := json.Unmarshal(msg.Value, &event); err != nil {
-				log.Println("Failed to unmarshal event:", err)
-				continue
-			}
+	log.Printf("Reconnecting the consumer for %s to %s\n", i.topic, addrs)
+
+	i.state.consumer.Close()
+	i.state.consumer = consumer
+	i.state.generation = generation
+
+	return nil
+}
+
+func (i *Ingester[T]) Topic() string {
+	return i.topic
+}
+
+// Check fails when the consumer has not polled recently, its last poll failed or the brokers
+// cannot be reached. Polls time out the same way whether the topic is quiet or the brokers are
+// down, so the brokers are asked for the topic to tell them apart.
+func (i *Ingester[T]) Check(ctx context.Context) error {
+	i.state.closeMu.RLock()
+	defer i.state.closeMu.RUnlock()
+
+	// the consumer cannot be used once closed
+	if i.state.closed {
+		return fmt.Errorf("consumer for %s has stopped", i.topic)
+	}
+
+	i.state.mu.Lock()
+	lastPoll, lastErr := i.state.lastPoll, i.state.lastErr
+	i.state.mu.Unlock()
+
+	if lastPoll.IsZero() {
+		return fmt.Errorf("consumer for %s has not started", i.topic)
+	}
+
+	if since := time.Since(lastPoll); since > StallAfter {
+		return fmt.Errorf("consumer for %s has not polled for %s", i.topic, since.Round(time.Second))
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+
+	timeout := 2 * time.Second
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if _, err := i.state.consumer.GetMetadata(&i.topic, false, int(timeout.Milliseconds())); err != nil {
+		return fmt.Errorf("consumer for %s cannot reach kafka: %w", i.topic, err)
+	}
+
+	return nil
+}
+
+func (i *Ingester[T]) polled(err error) {
+	i.state.mu.Lock()
+	defer i.state.mu.Unlock()
+
+	i.state.lastPoll = time.Now()
+	i.state.lastErr = err
+}
+
+// Consume subscribes to the topic and calls handle with each message until the context is done,
+// then commits the offsets of the messages handled and closes the consumer. An offset is only
+// stored once handle returns, so a message being handled when the service stops is delivered
+// again rather than lost. handle gets a context that is not cancelled with ctx so it can finish.
+func (i *Ingester[T]) Consume(ctx context.Context, handle func(ctx context.Context, message Message[T])) error {
+	return i.consume(ctx, func(ctx context.Context, message Message[T]) error {
+		handle(ctx, message)
+		return nil
+	})
+}
+
+// ConsumeRetrying is Consume for handlers that can fail. A message the handler fails is handed to
+// it again, waiting longer each time, and nothing after it is handled until it succeeds. If the
+// context is done first the consumer closes without storing its offset, so the message is
+// delivered again once the service restarts. The consumer does not poll while it retries, so its
+// health check fails and kafka may hand its partitions to another consumer, which can deliver
+// messages already handled a second time.
+func (i *Ingester[T]) ConsumeRetrying(ctx context.Context, handle func(ctx context.Context, message Message[T]) error) error {
+	return i.consume(ctx, handle)
+}
+
+func (i *Ingester[T]) consume(ctx context.Context, handle func(ctx context.Context, message Message[T]) error) error {
+	log.Printf("Starting ingestion for %s\n", i.topic)
+
+	if err := i.state.consumer.SubscribeTopics([]string{i.topic}, nil); err != nil {
+		return err
+	}
+
+	handleCtx := context.WithoutCancel(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return i.close()
+		default:
+		}
+
+		if err := i.reconnect(); err != nil {
+			log.Printf("Failed to reconnect the consumer for %s: %s\n", i.topic, err)
+			i.polled(err)
+			time.Sleep(pollTimeout)
+			continue
+		}
+
+		msg, err := i.state.consumer.ReadMessage(pollTimeout)
+
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
+			i.polled(nil)
+			continue
+		}
 
-			ch <- event
+		i.polled(err)
+
+		if err != nil {
+			log.Println("Consumer error:", err)
+			continue
+		}
+
+		var event T
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			log.Println("Failed to unmarshal event:", err)
+		} else if !i.handleUntilDone(ctx, handleCtx, handle, Message[T]{Event: event, Headers: msg.Headers, ProducedAt: msg.Timestamp}) {
+			return i.close()
+		}
+
+		if _, err := i.state.consumer.StoreMessage(msg); err != nil {
+			log.Printf("Failed to store the offset for %s: %s\n", i.topic, err)
+		}
+	}
+}
+
+// handleUntilDone calls handle until it succeeds, returning false if ctx is done first
+func (i *Ingester[T]) handleUntilDone(ctx context.Context, handleCtx context.Context, handle func(ctx context.Context, message Message[T]) error, message Message[T]) bool {
+	delay := time.Second
+
+	for {
+		err := handle(handleCtx, message)
+
+		if err == nil {
+			return true
 		}
-	}()
 
-	return ch, nil
+		log.Printf("Failed to handle a message from %s, retrying in %s: %s\n", i.topic, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// close commits the offsets stored so far and closes the consumer
+func (i *Ingester[T]) close() error {
+	i.state.closeMu.Lock()
+	defer i.state.closeMu.Unlock()
+
+	i.state.closed = true
+
+	_, err := i.state.consumer.Commit()
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrNoOffset {
+		// nothing was handled since the last commit
+		err = nil
+	}
+
+	if closeErr := i.state.consumer.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+
+	log.Printf("Stopped ingestion for %s\n", i.topic)
+
+	return err
+}
+
+// RunAll runs consumers until the context is done, returning once each has stopped. When one
+// fails the rest are stopped and its error is returned.
+func RunAll(ctx context.Context, consumers ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(consumers))
+
+	var wg sync.WaitGroup
+
+	for i, consume := range consumers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if errs[i] = consume(ctx); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
 
+	return errors.Join(errs...)
 }
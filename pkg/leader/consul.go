@@ -0,0 +1,186 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// the lock of each key is kept under this prefix in the KV store
+	consulKeyPrefix string = "leader/"
+	// a leader that stops renewing its session loses its term after this long
+	consulSessionTtl time.Duration = 15 * time.Second
+	// how long consul keeps a key from being taken after its session ends, giving a leader that
+	// was cut off time to notice before another starts
+	consulLockDelay time.Duration = 5 * time.Second
+	// longest a blocking query on a lock waits for a change
+	consulWaitTime time.Duration = 30 * time.Second
+)
+
+// ConsulBackend elects leaders with consul sessions and KV locks. The session of a candidate
+// is tied to the node and to the health check of the instance it names, so a leader that fails
+// its health check loses its term. The lock index of the key is the fencing token.
+type ConsulBackend struct {
+	client *consul.Client
+}
+
+func NewConsulBackend(client *consul.Client) *ConsulBackend {
+	return &ConsulBackend{client: client}
+}
+
+func (b *ConsulBackend) Acquire(ctx context.Context, key string, candidate string) (*Term, error) {
+	lockKey := consulKeyPrefix + key
+
+	// an instance that is not passing yet cannot create a session tied to its check, so this
+	// waits until it is healthy
+	sessionID, _, err := b.client.Session().Create(&consul.SessionEntry{
+		Name:      lockKey,
+		Behavior:  consul.SessionBehaviorRelease,
+		TTL:       consulSessionTtl.String(),
+		LockDelay: consulLockDelay,
+		Checks:    []string{"serfHealth", candidate},
+	}, (&consul.WriteOptions{}).WithContext(ctx))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a session for %s: %w", lockKey, err)
+	}
+
+	renewCtx, stopRenewing := context.WithCancel(context.Background())
+	// closed if the session cannot be renewed, such as when consul has invalidated it
+	sessionLost := make(chan struct{})
+
+	go func() {
+		defer close(sessionLost)
+
+		if err := b.client.Session().RenewPeriodic(consulSessionTtl.String(), sessionID, nil, renewCtx.Done()); err != nil {
+			log.Printf("Session for %s ended: %s\n", lockKey, err)
+		}
+	}()
+
+	token, index, err := b.lock(ctx, lockKey, candidate, sessionID, sessionLost)
+
+	if err != nil {
+		stopRenewing()
+		b.destroy(sessionID)
+
+		return nil, err
+	}
+
+	lost := make(chan struct{})
+	term := &Term{
+		Key:       key,
+		Candidate: candidate,
+		Token:     token,
+		lost:      lost,
+		release: func(ctx context.Context) error {
+			stopRenewing()
+
+			_, _, err := b.client.KV().Release(&consul.KVPair{Key: lockKey, Session: sessionID}, (&consul.WriteOptions{}).WithContext(ctx))
+
+			return errors.Join(err, b.destroy(sessionID))
+		},
+	}
+
+	go func() {
+		defer close(lost)
+		b.watchLock(renewCtx, lockKey, sessionID, index, sessionLost)
+	}()
+
+	return term, nil
+}
+
+// lock takes the key for the session, waiting for it to be free, and returns the lock index
+func (b *ConsulBackend) lock(ctx context.Context, lockKey string, candidate string, sessionID string, sessionLost <-chan struct{}) (uint64, uint64, error) {
+	var waitIndex uint64
+
+	for {
+		acquired, _, err := b.client.KV().Acquire(&consul.KVPair{
+			Key:     lockKey,
+			Value:   []byte(candidate),
+			Session: sessionID,
+		}, (&consul.WriteOptions{}).WithContext(ctx))
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		pair, meta, err := b.client.KV().Get(lockKey, (&consul.QueryOptions{WaitIndex: waitIndex, WaitTime: consulWaitTime}).WithContext(ctx))
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if acquired && pair != nil && pair.Session == sessionID {
+			return pair.LockIndex, meta.LastIndex, nil
+		}
+
+		waitIndex = meta.LastIndex
+
+		select {
+		case <-sessionLost:
+			return 0, 0, fmt.Errorf("session for %s ended while waiting for the lock", lockKey)
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		default:
+		}
+	}
+}
+
+// watchLock returns once the session no longer holds the key, its session has ended or the
+// term has been released
+func (b *ConsulBackend) watchLock(ctx context.Context, lockKey string, sessionID string, index uint64, sessionLost <-chan struct{}) {
+	backoff := time.Second
+
+	for {
+		select {
+		case <-sessionLost:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		pair, meta, err := b.client.KV().Get(lockKey, (&consul.QueryOptions{WaitIndex: index, WaitTime: consulWaitTime}).WithContext(ctx))
+
+		if err != nil {
+			// consul cannot tell us the lock is still held, the session expiring will end the term
+			select {
+			case <-ctx.Done():
+			case <-sessionLost:
+			case <-time.After(backoff):
+			}
+
+			continue
+		}
+
+		if pair == nil || pair.Session != sessionID {
+			return
+		}
+
+		index = meta.LastIndex
+	}
+}
+
+func (b *ConsulBackend) destroy(sessionID string) error {
+	_, err := b.client.Session().Destroy(sessionID, nil)
+	return err
+}
+
+func (b *ConsulBackend) Leader(ctx context.Context, key string) (string, error) {
+	pair, _, err := b.client.KV().Get(consulKeyPrefix+key, (&consul.QueryOptions{}).WithContext(ctx))
+
+	if err != nil {
+		return "", err
+	}
+
+	if pair == nil || pair.Session == "" {
+		return "", nil
+	}
+
+	return string(pair.Value), nil
+}
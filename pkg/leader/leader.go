@@ -0,0 +1,215 @@
+// Package leader elects one instance of a service to run work that must not run in more than one
+// at a time, such as scheduled cleanups or relaying an outbox.
+package leader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+)
+
+// how long to wait before campaigning again after failing to reach the backend
+const retryInterval time.Duration = 5 * time.Second
+
+// how long giving up leadership can take when a term ends
+const releaseTimeout time.Duration = 5 * time.Second
+
+var ErrUnsupported = errors.New("leader election needs the consul or memory discovery backend")
+
+// Backend decides which candidate leads each key
+type Backend interface {
+	// Acquire blocks until candidate leads key or the context is done
+	Acquire(ctx context.Context, key string, candidate string) (*Term, error)
+	// Leader returns the candidate leading key, empty when there is none
+	Leader(ctx context.Context, key string) (string, error)
+}
+
+// Term is a period in which a candidate leads a key
+type Term struct {
+	Key       string
+	Candidate string
+	// Token goes up each time a new term starts for the key. Work done for a term can carry its
+	// token as a fence, so a store can refuse writes from a leader whose term has since ended.
+	Token uint64
+
+	lost    <-chan struct{}
+	release func(ctx context.Context) error
+	once    sync.Once
+	err     error
+}
+
+// Lost is closed when the term ends, whether it was released or taken away
+func (t *Term) Lost() <-chan struct{} {
+	return t.lost
+}
+
+// Release gives up leadership so another candidate can take over straight away
+func (t *Term) Release(ctx context.Context) error {
+	t.once.Do(func() {
+		t.err = t.release(ctx)
+	})
+
+	return t.err
+}
+
+// ForRegistry returns the backend that goes with a discovery registry: consul locks for the
+// consul registry and a backend held in the process for the memory registry. The other
+// registries have nothing to elect a leader with.
+func ForRegistry(registry discovery.Registry) (Backend, error) {
+	// look past wrappers such as the discovery cache
+	for {
+		wrapper, ok := registry.(interface{ Unwrap() discovery.Registry })
+
+		if !ok {
+			break
+		}
+
+		registry = wrapper.Unwrap()
+	}
+
+	switch registry := registry.(type) {
+	case *discovery.ConsulRegistry:
+		return NewConsulBackend(registry.Client()), nil
+	case *discovery.MemoryRegistry:
+		return sharedMemoryBackend, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Change is sent to listeners when a candidate is elected or its term ends
+type Change struct {
+	Key     string
+	Leading bool
+	Token   uint64
+}
+
+// Election campaigns for one key on behalf of one candidate
+type Election struct {
+	backend   Backend
+	key       string
+	candidate string
+
+	mu        sync.Mutex
+	term      *Term
+	listeners []func(Change)
+}
+
+// New returns an election for key, candidate is usually the instance ID the service registered
+// with so the consul backend can end its term when the instance stops being healthy
+func New(backend Backend, key string, candidate string) *Election {
+	return &Election{
+		backend:   backend,
+		key:       key,
+		candidate: candidate,
+	}
+}
+
+// OnChange calls listener each time the candidate is elected or its term ends
+func (e *Election) OnChange(listener func(Change)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.listeners = append(e.listeners, listener)
+}
+
+// Leading returns the token of the current term, false when the candidate is not leading
+func (e *Election) Leading() (uint64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.term == nil {
+		return 0, false
+	}
+
+	return e.term.Token, true
+}
+
+// Run campaigns until the context is done. Each time the candidate is elected job runs with a
+// context that is cancelled when the term ends, and the term is released once job returns.
+func (e *Election) Run(ctx context.Context, job func(ctx context.Context, term *Term)) {
+	for {
+		term, err := e.backend.Acquire(ctx, e.key, e.candidate)
+
+		if ctx.Err() != nil {
+			// the key can be taken just as the context is done
+			if term != nil {
+				e.release(term)
+			}
+
+			return
+		}
+
+		if err != nil {
+			log.Printf("Failed to campaign for %s: %s\n", e.key, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+
+			continue
+		}
+
+		e.lead(ctx, term, job)
+	}
+}
+
+// lead runs job for a term until the term ends or the context is done
+func (e *Election) lead(ctx context.Context, term *Term, job func(ctx context.Context, term *Term)) {
+	log.Printf("Elected leader of %s with token %d\n", e.key, term.Token)
+	e.change(term, true)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		job(jobCtx, term)
+	}()
+
+	select {
+	case <-term.Lost():
+	case <-ctx.Done():
+	}
+
+	cancel()
+	<-done
+
+	// listeners hear the term has ended before another candidate can be elected
+	log.Printf("No longer leader of %s\n", e.key)
+	e.change(term, false)
+	e.release(term)
+}
+
+func (e *Election) release(term *Term) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := term.Release(ctx); err != nil {
+		log.Printf("Failed to release leadership of %s: %s\n", e.key, err)
+	}
+}
+
+func (e *Election) change(term *Term, leading bool) {
+	e.mu.Lock()
+
+	if leading {
+		e.term = term
+	} else {
+		e.term = nil
+	}
+
+	listeners := e.listeners
+
+	e.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(Change{Key: e.key, Leading: leading, Token: term.Token})
+	}
+}
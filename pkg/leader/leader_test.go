@@ -0,0 +1,116 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// campaign runs an election for candidate in the background and sends each term it is elected for
+func campaign(t *testing.T, ctx context.Context, backend Backend, candidate string) (*Election, <-chan *Term) {
+	t.Helper()
+
+	election := New(backend, "test/job", candidate)
+	terms := make(chan *Term, 4)
+
+	go election.Run(ctx, func(ctx context.Context, term *Term) {
+		terms <- term
+		<-ctx.Done()
+	})
+
+	return election, terms
+}
+
+func elected(t *testing.T, terms <-chan *Term) *Term {
+	t.Helper()
+
+	select {
+	case term := <-terms:
+		return term
+	case <-time.After(5 * time.Second):
+		t.Fatal("candidate was not elected")
+		return nil
+	}
+}
+
+func TestOnlyOneCandidateLeads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewMemoryBackend()
+
+	_, firstTerms := campaign(t, ctx, backend, "first")
+	first := elected(t, firstTerms)
+
+	_, secondTerms := campaign(t, ctx, backend, "second")
+
+	select {
+	case term := <-secondTerms:
+		t.Fatalf("%s was elected while %s leads", term.Candidate, first.Candidate)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if leader, _ := backend.Leader(ctx, "test/job"); leader != "first" {
+		t.Fatalf("leader is %q, expected first", leader)
+	}
+}
+
+func TestDeposedLeaderStopsAndTokenIncreases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewMemoryBackend()
+
+	firstElection, firstTerms := campaign(t, ctx, backend, "first")
+	first := elected(t, firstTerms)
+
+	if token, leading := firstElection.Leading(); !leading || token != first.Token {
+		t.Fatalf("Leading returned %d %v, expected %d true", token, leading, first.Token)
+	}
+
+	_, secondTerms := campaign(t, ctx, backend, "second")
+
+	backend.Depose("test/job")
+
+	select {
+	case <-first.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deposed term was not ended")
+	}
+
+	// the first candidate campaigns again, whichever wins must have a later token
+	var next *Term
+
+	select {
+	case next = <-secondTerms:
+	case next = <-firstTerms:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no candidate was elected after the leader was deposed")
+	}
+
+	if next.Token <= first.Token {
+		t.Fatalf("the new term has token %d, expected more than %d", next.Token, first.Token)
+	}
+}
+
+func TestReleaseLetsAnotherCandidateLead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewMemoryBackend()
+
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	_, firstTerms := campaign(t, firstCtx, backend, "first")
+	first := elected(t, firstTerms)
+
+	_, secondTerms := campaign(t, ctx, backend, "second")
+
+	// stopping the first candidate releases its term
+	stopFirst()
+
+	second := elected(t, secondTerms)
+
+	if second.Candidate != "second" || second.Token <= first.Token {
+		t.Fatalf("expected second to lead with a later token than %d, got %+v", first.Token, second)
+	}
+}
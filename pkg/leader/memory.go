@@ -0,0 +1,112 @@
+package leader
+
+import (
+	"context"
+	"sync"
+)
+
+// shared by every service in the process, like the memory discovery registry
+var sharedMemoryBackend = NewMemoryBackend()
+
+// MemoryBackend elects leaders between candidates in one process
+type MemoryBackend struct {
+	mu   sync.Mutex
+	keys map[string]*memoryLock
+}
+
+type memoryLock struct {
+	holder string
+	token  uint64
+	// closed when the current term ends
+	lost chan struct{}
+	// closed when the key is free, for candidates waiting to take it
+	released chan struct{}
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{keys: map[string]*memoryLock{}}
+}
+
+func (b *MemoryBackend) Acquire(ctx context.Context, key string, candidate string) (*Term, error) {
+	for {
+		b.mu.Lock()
+
+		lock, ok := b.keys[key]
+
+		if !ok {
+			lock = &memoryLock{released: make(chan struct{})}
+			b.keys[key] = lock
+		}
+
+		if lock.holder == "" {
+			lock.holder = candidate
+			lock.token++
+			lock.lost = make(chan struct{})
+
+			token := lock.token
+			term := &Term{
+				Key:       key,
+				Candidate: candidate,
+				Token:     token,
+				lost:      lock.lost,
+				release: func(context.Context) error {
+					b.end(key, token)
+					return nil
+				},
+			}
+
+			b.mu.Unlock()
+
+			return term, nil
+		}
+
+		released := lock.released
+
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *MemoryBackend) Leader(_ context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lock, ok := b.keys[key]; ok {
+		return lock.holder, nil
+	}
+
+	return "", nil
+}
+
+// Depose ends the current term of key, as when the session of a consul leader expires
+func (b *MemoryBackend) Depose(key string) {
+	b.mu.Lock()
+	lock, ok := b.keys[key]
+	b.mu.Unlock()
+
+	if ok {
+		b.end(key, lock.token)
+	}
+}
+
+// end ends the term with token if it is still the current one
+func (b *MemoryBackend) end(key string, token uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lock, ok := b.keys[key]
+
+	if !ok || lock.holder == "" || lock.token != token {
+		return
+	}
+
+	lock.holder = ""
+	close(lock.lost)
+	close(lock.released)
+	lock.released = make(chan struct{})
+}
@@ -0,0 +1,212 @@
+// Package lifecycle starts and stops a service: it stops on SIGINT or SIGTERM, or when one of its
+// servers fails, and shuts down in order so no request or message is lost on the way out.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout is how long shutting down can take before the rest is skipped, kept under the
+// 30 seconds docker and kubernetes wait before killing a container
+const DefaultTimeout time.Duration = 25 * time.Second
+
+// Phase of shutting down, the phases run in the order below
+type Phase int
+
+const (
+	// leave discovery so callers stop sending new requests
+	Deregister Phase = iota
+	// stop accepting connections and wait for in-flight requests
+	StopServing
+	// stop background work such as kafka consumers, which commit what they have handled
+	StopWorkers
+	// close clients such as producers, databases and caches once nothing uses them
+	CloseClients
+)
+
+var phaseNames = []string{"deregister", "stop serving", "stop workers", "close clients"}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+type Lifecycle struct {
+	// cancelled as soon as the service starts stopping
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+
+	mu    sync.Mutex
+	hooks map[Phase][]hook
+	// the first server to fail, which stops the service
+	failed chan error
+
+	ready    atomic.Bool
+	stopping atomic.Bool
+}
+
+// New returns a lifecycle that stops on SIGINT or SIGTERM, giving shutdown timeout to finish
+func New(timeout time.Duration) *Lifecycle {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	return &Lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+		hooks:   map[Phase][]hook{},
+		failed:  make(chan error, 1),
+	}
+}
+
+// Context is cancelled when the service starts stopping, for work that can stop straight away
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// OnStop runs stop during a phase of shutting down. Hooks in the same phase run in the reverse
+// of the order they were added, like defers.
+func (l *Lifecycle) OnStop(phase Phase, name string, stop func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hooks[phase] = append(l.hooks[phase], hook{name: name, stop: stop})
+}
+
+// Go runs work in the background with a context that is cancelled when phase is reached, the
+// phase then waits for it to return
+func (l *Lifecycle) Go(phase Phase, name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	l.OnStop(phase, name, func(stopCtx context.Context) error {
+		cancel()
+
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Serve runs a server in the background, stopping the service if it returns before shutting
+// down has started. stop is called when the service stops serving and should wait for in-flight
+// requests.
+func (l *Lifecycle) Serve(name string, serve func() error, stop func(ctx context.Context) error) {
+	go func() {
+		err := serve()
+
+		// servers return once stopped, each in their own way
+		if l.stopping.Load() {
+			return
+		}
+
+		if err == nil {
+			err = errors.New("stopped serving")
+		}
+
+		l.fail(fmt.Errorf("%s: %w", name, err))
+	}()
+
+	l.OnStop(StopServing, name, stop)
+}
+
+func (l *Lifecycle) fail(err error) {
+	select {
+	case l.failed <- err:
+	default:
+	}
+
+	l.cancel()
+}
+
+// MarkReady is called once the service has started, it is not ready before then
+func (l *Lifecycle) MarkReady() {
+	l.ready.Store(true)
+}
+
+// Check is a health check that fails until the service has started and once it starts stopping,
+// so it is only discovered and sent traffic in between
+func (l *Lifecycle) Check(ctx context.Context) error {
+	if l.stopping.Load() {
+		return errors.New("shutting down")
+	}
+
+	if !l.ready.Load() {
+		return errors.New("starting")
+	}
+
+	return nil
+}
+
+// Wait blocks until the service is told to stop or a server fails, then shuts down. It returns
+// the error of the server that failed along with any from shutting down.
+func (l *Lifecycle) Wait() error {
+	<-l.ctx.Done()
+
+	var failed error
+
+	select {
+	case failed = <-l.failed:
+		log.Printf("Shutting down: %s\n", failed)
+	default:
+		log.Println("Shutting down")
+	}
+
+	return errors.Join(failed, l.shutdown())
+}
+
+// shutdown runs the hooks phase by phase, skipping what is left once the timeout is reached
+func (l *Lifecycle) shutdown() error {
+	l.stopping.Store(true)
+	l.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	l.mu.Lock()
+	hooks := l.hooks
+	l.hooks = map[Phase][]hook{}
+	l.mu.Unlock()
+
+	var errs []error
+
+	for phase := Deregister; phase <= CloseClients; phase++ {
+		for i := len(hooks[phase]) - 1; i >= 0; i-- {
+			hook := hooks[phase][i]
+
+			if ctx.Err() != nil {
+				errs = append(errs, fmt.Errorf("%s: skipped, shutdown timed out", hook.name))
+				continue
+			}
+
+			if err := hook.stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", hook.name, err))
+			}
+		}
+
+		log.Printf("Shutdown: %s done\n", phase)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// ServeGrpc serves a grpc server on lis. When the service stops serving it stops taking new
+// calls and waits for running ones, which are cancelled if the shutdown times out first.
+func (l *Lifecycle) ServeGrpc(name string, server *grpc.Server, lis net.Listener) {
+	l.Serve(name, func() error {
+		return server.Serve(lis)
+	}, func(ctx context.Context) error {
+		done := make(chan struct{})
+
+		go func() {
+			server.GracefulStop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		}
+	})
+}
+
+// ServeHttp serves an http server, letting running requests finish when the service stops serving
+func (l *Lifecycle) ServeHttp(name string, server *http.Server) {
+	l.Serve(name, server.ListenAndServe, server.Shutdown)
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// ListUsersParams filters the admin user list, every filter is optional
+type ListUsersParams struct {
+	Role string `query:"role"`
+	// active, disabled, unverified or pending_deletion
+	Status string `query:"status"`
+	// RFC 3339 times
+	CreatedAfter  string `query:"createdAfter"`
+	CreatedBefore string `query:"createdBefore"`
+	// part of the username or email
+	Search   string `query:"search"`
+	PageSize int32  `query:"pageSize"`
+	// nextPageToken from the previous page
+	PageToken string `query:"pageToken"`
+}
+
+type UserPage struct {
+	Users []*user.User `json:"users"`
+	// empty on the last page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+	Total         int64  `json:"total"`
+}
+
+type DisableUserRequest struct {
+	Reason string `json:"reason" form:"reason"`
+}
+
+type SetUserRolesRequest struct {
+	Roles []user.UserRole `json:"roles"`
+}
+
+type ImpersonateUserRequest struct {
+	// why the admin needs to act as the user, recorded in the admin action log
+	Reason string `json:"reason" form:"reason"`
+}
+
+// AdminAction is an entry in the log of admin changes to a user's account
+type AdminAction struct {
+	ID        string            `json:"id"`
+	ActorID   string            `json:"actorId"`
+	Action    string            `json:"action"`
+	TargetID  string            `json:"targetId"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+func ProtosToAdminActions(a []*gen.AdminAction) []*AdminAction {
+	actions := []*AdminAction{}
+
+	for i := range a {
+		actions = append(actions, &AdminAction{
+			ID:        a[i].Id,
+			ActorID:   a[i].ActorId,
+			Action:    a[i].Action,
+			TargetID:  a[i].TargetId,
+			Reason:    a[i].Reason,
+			Details:   a[i].Details,
+			CreatedAt: time.Unix(a[i].CreatedAt, 0),
+		})
+	}
+
+	return actions
+}
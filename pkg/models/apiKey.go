@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+type ApiKey struct {
+	ID     string          `json:"id"`
+	UserID string          `json:"userId"`
+	Name   string          `json:"name"`
+	Prefix string          `json:"prefix"`
+	Scopes []user.UserRole `json:"scopes"`
+	// times are omitted when unset
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type CreateApiKeyRequest struct {
+	Name string `json:"name"`
+	// roles the key may act with, empty for all of the user's roles
+	Scopes    []user.UserRole `json:"scopes,omitempty"`
+	ExpiresAt *time.Time      `json:"expiresAt,omitempty"`
+}
+
+type CreateApiKeyResponse struct {
+	Key ApiKey `json:"key"`
+	// the full key, it is only shown once
+	Secret string `json:"secret"`
+}
+
+type CreateServiceAccountRequest struct {
+	Name  string          `json:"name"`
+	Roles []user.UserRole `json:"roles,omitempty"`
+}
+
+func unixOrNil(seconds int64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+
+	t := time.Unix(seconds, 0)
+	return &t
+}
+
+func ProtoToApiKey(k *gen.ApiKey) *ApiKey {
+	return &ApiKey{
+		ID:         k.Id,
+		UserID:     k.UserId,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     user.StringsToRoles(k.Scopes),
+		CreatedAt:  time.Unix(k.CreatedAt, 0),
+		ExpiresAt:  unixOrNil(k.ExpiresAt),
+		LastUsedAt: unixOrNil(k.LastUsedAt),
+		RevokedAt:  unixOrNil(k.RevokedAt),
+	}
+}
+
+func ProtosToApiKeys(k []*gen.ApiKey) []*ApiKey {
+	keys := []*ApiKey{}
+
+	for i := range k {
+		keys = append(keys, ProtoToApiKey(k[i]))
+	}
+
+	return keys
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/audit"
+)
+
+// AuditLogParams filters the audit log, every filter is optional
+type AuditLogParams struct {
+	ActorID  string `query:"actorId"`
+	TargetID string `query:"targetId"`
+	Category string `query:"category"`
+	Action   string `query:"action"`
+	// success, failure or denied
+	Outcome string `query:"outcome"`
+	// RFC 3339 times
+	Since    string `query:"since"`
+	Until    string `query:"until"`
+	PageSize int32  `query:"pageSize"`
+	// nextPageToken from the previous page
+	PageToken string `query:"pageToken"`
+}
+
+type AuditLogPage struct {
+	Entries []*audit.Entry `json:"entries"`
+	// empty on the last page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// AuditLogVerification is the result of checking the hash chain of the audit log
+type AuditLogVerification struct {
+	Valid   bool  `json:"valid"`
+	Checked int64 `json:"checked"`
+	// sequence of the first entry that does not match the chain
+	FirstInvalid int64  `json:"firstInvalid,omitempty"`
+	Problem      string `json:"problem,omitempty"`
+}
+
+func ProtoToAuditLogPage(resp *gen.QueryAuditLogResponse) *AuditLogPage {
+	page := &AuditLogPage{
+		Entries:       []*audit.Entry{},
+		NextPageToken: resp.NextPageToken,
+	}
+
+	for i := range resp.Entries {
+		page.Entries = append(page.Entries, audit.ProtoToEntry(resp.Entries[i]))
+	}
+
+	return page
+}
@@ -1,39 +1,106 @@
 package models
 
 import (
-	"os"
 	"regexp"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/redact"
 )
 
 var EmailRegex *regexp.Regexp = regexp.MustCompile(`^[\w-\.]+@([\w-]+\.)+[\w-]{2,4}$`)
 
-func BuildJwt(username string, email string) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	claims := &JwtCustomClaims{
+// NewJwtClaims creates the claims for a user, the subject is the user id
+func NewJwtClaims(id string, username string, email string, roles []user.UserRole) *JwtCustomClaims {
+	return &JwtCustomClaims{
 		Username: username,
 		Email:    email,
+		Roles:    roles,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
+			Subject: id,
 		},
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	return token.SignedString([]byte(secret))
-
 }
 
 type JwtCustomClaims struct {
 	Username string          `json:"username"`
 	Email    string          `json:"email"`
 	Roles    []user.UserRole `json:"roles"`
+	// the user must enrol in mfa before the token can be used for anything else
+	MfaEnrollmentRequired bool `json:"mfaEnrollmentRequired,omitempty"`
+	// set when the request was authenticated with an api key rather than a token
+	ApiKeyID       string `json:"apiKeyId,omitempty"`
+	ServiceAccount bool   `json:"serviceAccount,omitempty"`
+	// set on tokens issued to third party oauth clients, which are limited to the routes their scopes allow
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// the login session the token belongs to, revoking it stops the token being refreshed
+	SessionID string `json:"sid,omitempty"`
+	// set when an admin is acting as the user, RFC 8693 section 4.1
+	Actor *ActorClaim `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ActorClaim identifies who is really making requests with an impersonation token
+type ActorClaim struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+}
+
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token string `json:"token,omitempty"`
+	// seconds until the token expires, renew it before then with the refresh token
+	ExpiresIn    int64  `json:"expiresIn,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// set instead of token when a second factor is required, exchange it at /auth/login/mfa
+	MfaRequired bool   `json:"mfaRequired,omitempty"`
+	MfaToken    string `json:"mfaToken,omitempty"`
+}
+
+// FederationProvidersResponse lists the external identity providers users can log in with
+type FederationProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+type VerifyMfaRequest struct {
+	MfaToken string `json:"mfaToken" form:"mfaToken"`
+	Code     string `json:"code" form:"code"`
+}
+
+type MfaCodeRequest struct {
+	Code string `json:"code" form:"code"`
+}
+
+type EnrollMfaResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioningUri"`
+}
+
+type ConfirmMfaResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type RoleMfaRequirementRequest struct {
+	Required bool `json:"required"`
+}
+
+type RegisterUserRequest struct {
+	Username  string        `json:"username"`
+	Password  redact.Secret `json:"password" swaggertype:"string"`
+	Email     string        `json:"email"`
+	FirstName string        `json:"firstName,omitempty"`
+	LastName  string        `json:"lastName,omitempty"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" form:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string        `json:"token" form:"token"`
+	Password redact.Secret `json:"password" form:"password" swaggertype:"string"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" query:"token" form:"token"`
 }
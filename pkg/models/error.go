@@ -1,3 +1,31 @@
 package models
 
-type ApiErrorResponse map[string]interface{}
+// ApiErrorResponse is the body of an error response on /v1 and the unversioned paths, which keep
+// the errors the api sent before problem details
+type ApiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Problem is the body of an error response, RFC 7807 problem details with the extensions below.
+// It is sent as application/problem+json.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// the path that was requested
+	Instance string `json:"instance,omitempty"`
+	// stable, machine readable code for the error, clients should match on it rather than the detail
+	Code string `json:"code"`
+	// the X-Request-Id of the request, to find it in the logs
+	RequestID string `json:"requestId,omitempty"`
+	// what is wrong with each field when the code is validation_failed
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a field of a request that is missing or not valid
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
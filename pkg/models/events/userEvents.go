@@ -1,12 +1,12 @@
 package events
 
-type CreateUserEvent struct {
-	ID        string `json:"_id,omitempty"`
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	Email     string `json:"email"`
-	FirstName string `json:"firstName,omitempty"`
-	LastName  string `json:"lastName,omitempty"`
+import "time"
+
+// Published once a user has been registered. Credentials are never part of a user event.
+type UserRegisteredEvent struct {
+	ID       string `json:"_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
 }
 
 type UpdateUserEventData struct {
@@ -19,3 +19,31 @@ type UpdateUserEvent struct {
 	ID   string              `json:"id"`
 	Data UpdateUserEventData `json:"data"`
 }
+
+// Published when repeated failed logins lock an account
+type AccountLockedEvent struct {
+	Username    string    `json:"username"`
+	ClientIP    string    `json:"clientIp"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// Published when a user logs in from a device they have not used before, so they can be told about it
+type NewDeviceLoginEvent struct {
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"sessionId"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"userAgent"`
+	ClientIP  string    `json:"clientIp"`
+	LoginAt   time.Time `json:"loginAt"`
+}
+
+// Published once an account has been erased, services holding data about the user must delete it
+type UserDeletedEvent struct {
+	ID        string    `json:"_id"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// the user id still exists as an anonymous tombstone rather than being removed
+	Anonymised bool `json:"anonymised"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+type AccountDeletionResponse struct {
+	// the account and everything held about it is erased after this time unless the deletion is cancelled
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+// FederatedIdentity is an external login linked to a user
+type FederatedIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	LinkedAt    time.Time `json:"linkedAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+// UserDataExport is everything held about a user, it is downloaded as a json archive
+type UserDataExport struct {
+	ExportedAt          time.Time            `json:"exportedAt"`
+	User                user.User            `json:"user"`
+	Sessions            []*Session           `json:"sessions"`
+	ApiKeys             []*ApiKey            `json:"apiKeys"`
+	FederatedIdentities []*FederatedIdentity `json:"federatedIdentities"`
+	OAuthConsents       []*OAuthConsent      `json:"oauthConsents"`
+	OAuthClients        []*OAuthClient       `json:"oauthClients"`
+}
+
+func ProtoToFederatedIdentity(f *gen.FederatedIdentity) *FederatedIdentity {
+	return &FederatedIdentity{
+		Provider:    f.Provider,
+		Subject:     f.Subject,
+		Email:       f.Email,
+		LinkedAt:    time.Unix(f.LinkedAt, 0),
+		LastLoginAt: time.Unix(f.LastLoginAt, 0),
+	}
+}
+
+// ProtoToUserDataExport maps the auth service's part of an export, oauth data is added by the caller
+func ProtoToUserDataExport(e *gen.ExportUserDataResponse) *UserDataExport {
+	export := &UserDataExport{
+		ExportedAt:          time.Now(),
+		User:                *user.ProtoToUser(e.User),
+		Sessions:            []*Session{},
+		ApiKeys:             ProtosToApiKeys(e.ApiKeys),
+		FederatedIdentities: []*FederatedIdentity{},
+		OAuthConsents:       []*OAuthConsent{},
+		OAuthClients:        []*OAuthClient{},
+	}
+
+	for _, session := range e.Sessions {
+		export.Sessions = append(export.Sessions, ProtoToSession(session))
+	}
+
+	for _, identity := range e.FederatedIdentities {
+		export.FederatedIdentities = append(export.FederatedIdentities, ProtoToFederatedIdentity(identity))
+	}
+
+	return export
+}
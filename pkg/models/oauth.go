@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+)
+
+type OAuthClient struct {
+	ClientId     string    `json:"clientId"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirectUris"`
+	GrantTypes   []string  `json:"grantTypes"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	OwnerId      string    `json:"ownerId"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type RegisterClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	// authorization_code (the default) and client_credentials, which is admin only
+	GrantTypes []string `json:"grantTypes,omitempty"`
+	Scopes     []string `json:"scopes"`
+	// public clients such as mobile apps cannot keep a secret and must use PKCE
+	Public bool `json:"public"`
+}
+
+type RegisterClientResponse struct {
+	Client OAuthClient `json:"client"`
+	// only shown once, empty for public clients
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+type OAuthConsent struct {
+	ClientId   string    `json:"clientId"`
+	ClientName string    `json:"clientName"`
+	Scopes     []string  `json:"scopes"`
+	GrantedAt  time.Time `json:"grantedAt"`
+}
+
+// AuthorizeParams are the parameters of an authorization request, RFC 6749 section 4.1.1 and RFC 7636
+type AuthorizeParams struct {
+	ResponseType        string `query:"response_type" form:"response_type"`
+	ClientId            string `query:"client_id" form:"client_id"`
+	RedirectUri         string `query:"redirect_uri" form:"redirect_uri"`
+	Scope               string `query:"scope" form:"scope"`
+	State               string `query:"state" form:"state"`
+	CodeChallenge       string `query:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" form:"code_challenge_method"`
+	Nonce               string `query:"nonce" form:"nonce"`
+	// the decision from the consent screen, only read on POST
+	Approve bool `form:"approve"`
+}
+
+func ProtoToOAuthClient(c *gen.OAuthClient) *OAuthClient {
+	return &OAuthClient{
+		ClientId:     c.ClientId,
+		Name:         c.Name,
+		RedirectUris: c.RedirectUris,
+		GrantTypes:   c.GrantTypes,
+		Scopes:       c.Scopes,
+		Public:       c.Public,
+		OwnerId:      c.OwnerId,
+		CreatedAt:    time.Unix(c.CreatedAt, 0),
+	}
+}
+
+func ProtosToOAuthClients(c []*gen.OAuthClient) []*OAuthClient {
+	clients := []*OAuthClient{}
+
+	for i := range c {
+		clients = append(clients, ProtoToOAuthClient(c[i]))
+	}
+
+	return clients
+}
+
+func ProtosToOAuthConsents(c []*gen.OAuthConsent) []*OAuthConsent {
+	consents := []*OAuthConsent{}
+
+	for i := range c {
+		consents = append(consents, &OAuthConsent{
+			ClientId:   c[i].ClientId,
+			ClientName: c[i].ClientName,
+			Scopes:     c[i].Scopes,
+			GrantedAt:  time.Unix(c[i].GrantedAt, 0),
+		})
+	}
+
+	return consents
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/will-kerwin/go-microservice-bookstore/gen"
+)
+
+// Session is a device a user is logged in on
+type Session struct {
+	ID        string    `json:"id"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	// updated each time the session's access token is refreshed
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// set on the session the request was made with
+	Current bool `json:"current"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" form:"refreshToken"`
+}
+
+func ProtoToSession(s *gen.Session) *Session {
+	return &Session{
+		ID:         s.Id,
+		Device:     s.Device,
+		UserAgent:  s.UserAgent,
+		IP:         s.Ip,
+		CreatedAt:  time.Unix(s.CreatedAt, 0),
+		LastSeenAt: time.Unix(s.LastSeenAt, 0),
+		ExpiresAt:  time.Unix(s.ExpiresAt, 0),
+	}
+}
@@ -1,16 +1,31 @@
 package user
 
 import (
+	"strings"
+	"time"
+
 	"github.com/will-kerwin/go-microservice-bookstore/gen"
+	"golang.org/x/text/unicode/norm"
 )
 
 type User struct {
-	ID        string     `json:"_id,omitempty"`
-	Username  string     `json:"username"`
-	Email     string     `json:"email"`
-	FirstName string     `json:"firstName"`
-	LastName  string     `json:"lastName"`
-	Roles     []UserRole `json:"roles"`
+	ID            string     `json:"_id,omitempty"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	FirstName     string     `json:"firstName"`
+	LastName      string     `json:"lastName"`
+	Roles         []UserRole `json:"roles"`
+	EmailVerified bool       `json:"emailVerified"`
+	MfaEnabled    bool       `json:"mfaEnabled"`
+	// service accounts are non-human principals that authenticate with api keys
+	ServiceAccount bool      `json:"serviceAccount"`
+	CreatedAt      time.Time `json:"createdAt"`
+	// set while the account is scheduled for deletion
+	DeleteAfter *time.Time `json:"deleteAfter,omitempty"`
+	// disabled users cannot log in and their tokens are rejected
+	Disabled bool `json:"disabled"`
+	// the user must reset their password before they can log in again
+	PasswordResetRequired bool `json:"passwordResetRequired"`
 }
 
 type UserRole string
@@ -19,22 +34,81 @@ const (
 	Admin UserRole = "admin"
 )
 
+func RolesToStrings(roles []UserRole) []string {
+	var values []string = []string{}
+
+	for i := range roles {
+		values = append(values, string(roles[i]))
+	}
+
+	return values
+}
+
+func StringsToRoles(values []string) []UserRole {
+	var roles []UserRole = []UserRole{}
+
+	for i := range values {
+		roles = append(roles, UserRole(values[i]))
+	}
+
+	return roles
+}
+
+// NormaliseUsername folds a username into the form it is stored and compared in.
+// Case is preserved, case-insensitive matching is left to the database collation.
+func NormaliseUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+// NormaliseEmail folds an email address into the form it is stored and compared in
+func NormaliseEmail(email string) string {
+	return strings.ToLower(norm.NFKC.String(strings.TrimSpace(email)))
+}
+
 func UserToProto(user *User) *gen.User {
-	return &gen.User{
-		Id:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+	proto := &gen.User{
+		Id:                    user.ID,
+		Username:              user.Username,
+		Email:                 user.Email,
+		FirstName:             user.FirstName,
+		LastName:              user.LastName,
+		EmailVerified:         user.EmailVerified,
+		CreatedAt:             user.CreatedAt.Unix(),
+		Roles:                 RolesToStrings(user.Roles),
+		MfaEnabled:            user.MfaEnabled,
+		ServiceAccount:        user.ServiceAccount,
+		Disabled:              user.Disabled,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+
+	if user.DeleteAfter != nil {
+		proto.DeleteAfter = user.DeleteAfter.Unix()
 	}
+
+	return proto
 }
 
 func ProtoToUser(user *gen.User) *User {
+	var deleteAfter *time.Time
+
+	if user.DeleteAfter != 0 {
+		t := time.Unix(user.DeleteAfter, 0)
+		deleteAfter = &t
+	}
+
 	return &User{
-		ID:        user.Id,
-		Username:  user.Username,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+		ID:                    user.Id,
+		Username:              user.Username,
+		Email:                 user.Email,
+		FirstName:             user.FirstName,
+		LastName:              user.LastName,
+		EmailVerified:         user.EmailVerified,
+		CreatedAt:             time.Unix(user.CreatedAt, 0),
+		Roles:                 StringsToRoles(user.Roles),
+		MfaEnabled:            user.MfaEnabled,
+		ServiceAccount:        user.ServiceAccount,
+		DeleteAfter:           deleteAfter,
+		Disabled:              user.Disabled,
+		PasswordResetRequired: user.PasswordResetRequired,
 	}
 }
@@ -0,0 +1,29 @@
+// Package v2 holds the models of version 2 of the public api that differ from version 1
+package v2
+
+import (
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models"
+)
+
+// Book with its author embedded, author is null if the author no longer exists
+type Book struct {
+	ID       string         `json:"_id,omitempty"`
+	Title    string         `json:"title"`
+	AuthorId string         `json:"authorId"`
+	Author   *models.Author `json:"author"`
+	Synopsis string         `json:"synopsis"`
+	ImageUrl string         `json:"imageUrl"`
+	Genre    string         `json:"genre"`
+}
+
+func NewBook(b *models.Book, author *models.Author) *Book {
+	return &Book{
+		ID:       b.ID,
+		Title:    b.Title,
+		AuthorId: b.AuthorId,
+		Author:   author,
+		Synopsis: b.Synopsis,
+		ImageUrl: b.ImageUrl,
+		Genre:    b.Genre,
+	}
+}
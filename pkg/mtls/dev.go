@@ -0,0 +1,262 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	devCAValidity   = 5 * 365 * 24 * time.Hour
+	devLeafValidity = 90 * 24 * time.Hour
+	// leaf certificates this close to expiring are replaced on startup
+	devLeafRenewBefore = 7 * 24 * time.Hour
+)
+
+type devFiles struct {
+	cert string
+	key  string
+	ca   string
+}
+
+// ensureDevCertificates creates a CA shared by every service in the directory, and a leaf
+// certificate for the service signed by it. Each is kept as one PEM file holding both the
+// certificate and its key, so services starting together never see half of one.
+func ensureDevCertificates(dir string, service string) (*devFiles, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca, caKey, err := loadOrCreateDevCA(caFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	leafFile := filepath.Join(dir, service+".pem")
+
+	if devLeafValid(leafFile, ca) {
+		return &devFiles{cert: leafFile, key: leafFile, ca: caFile}, nil
+	}
+
+	leafPem, err := newDevLeaf(service, ca, caKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeReplacing(leafFile, leafPem); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Generated development certificate for %s in %s\n", service, dir)
+
+	return &devFiles{cert: leafFile, key: leafFile, ca: caFile}, nil
+}
+
+func loadOrCreateDevCA(file string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if _, err := os.Stat(file); err == nil {
+		return loadDevCA(file)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerial()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Bookstore development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caPem, err := encodePair(der, key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// another service may have created the CA first, theirs is used so everyone shares one
+	if err := writeExclusive(file, caPem); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return loadDevCA(file)
+		}
+
+		return nil, nil, err
+	}
+
+	log.Printf("Generated development CA in %s\n", file)
+
+	cert, err := x509.ParseCertificate(der)
+
+	return cert, key, err
+}
+
+func loadDevCA(file string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(file, file)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("mtls: loading development CA: %w", err)
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+
+	if !ok {
+		return nil, nil, errors.New("mtls: development CA key is not an ECDSA key")
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+
+	return cert, key, err
+}
+
+// devLeafValid reports whether an existing leaf certificate was signed by the CA and is not about to expire
+func devLeafValid(file string, ca *x509.Certificate) bool {
+	pair, err := tls.LoadX509KeyPair(file, file)
+
+	if err != nil {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+
+	if err != nil || cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+
+	return time.Now().Add(devLeafRenewBefore).Before(cert.NotAfter)
+}
+
+func newDevLeaf(service string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: service},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// services are both servers and clients of each other
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:    []string{service, "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		URIs:        []*url.URL{{Scheme: "spiffe", Host: TrustDomain, Path: "/" + service}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePair(der, key)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodePair(der []byte, key *ecdsa.PrivateKey) ([]byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return append(encoded, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...), nil
+}
+
+// writeTemp writes data to a new file next to path, the caller moves it into place
+func writeTemp(path string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// writeExclusive creates path with data in one step, failing with os.ErrExist if it is already there
+func writeExclusive(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp)
+
+	return os.Link(tmp, path)
+}
+
+// writeReplacing replaces path with data in one step
+func writeReplacing(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,359 @@
+// Package mtls secures the grpc links between services with mutual TLS. Every service has a
+// certificate naming it in its SANs, servers only accept clients signed by the pinned CA and
+// clients check they reached the service they asked for.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+)
+
+type Mode string
+
+const (
+	// plain text, the default
+	Off Mode = "off"
+	// certificates and the CA are read from files
+	Files Mode = "files"
+	// a local CA and certificates are generated on first run, for development only
+	Dev Mode = "dev"
+)
+
+// TrustDomain names services in the URI SAN of their certificates, spiffe://bookstore/<service>
+const TrustDomain string = "bookstore"
+
+// Config of the grpc links, mtls is off when GRPC_TLS_MODE is not set
+type Config struct {
+	Mode     Mode   `config:"GRPC_TLS_MODE" default:"off"`
+	CertFile string `config:"GRPC_TLS_CERT"`
+	KeyFile  string `config:"GRPC_TLS_KEY"`
+	// the only CA peer certificates are accepted from
+	CAFile string `config:"GRPC_TLS_CA"`
+	// where dev mode keeps the generated CA and certificates, shared by every service
+	DevDir string `config:"GRPC_TLS_DEV_DIR" default:"certs"`
+	// identities a server accepts calls from, empty accepts any certificate signed by the CA
+	AllowedClients []string `config:"GRPC_TLS_ALLOWED_CLIENTS"`
+	// how often the files are checked for changes
+	ReloadInterval time.Duration `config:"GRPC_TLS_RELOAD_INTERVAL" default:"30s"`
+}
+
+// validate checks the settings that cannot be checked as they are loaded
+func (c Config) validate() error {
+	switch c.Mode {
+	case Off, Files, Dev:
+	default:
+		return fmt.Errorf("mtls: unknown GRPC_TLS_MODE %q, expected off, files or dev", c.Mode)
+	}
+
+	if c.Mode != Off && c.ReloadInterval <= 0 {
+		return fmt.Errorf("mtls: GRPC_TLS_RELOAD_INTERVAL %s is not a positive duration", c.ReloadInterval)
+	}
+
+	return nil
+}
+
+// Credentials hold the certificate of a service and the CA it trusts, both are replaced when
+// their files change so certificates can be rotated without a restart
+type Credentials struct {
+	service string
+	config  Config
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	// modification times of the files when they were last loaded
+	loaded map[string]time.Time
+}
+
+func New(service string, config Config) (*Credentials, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Credentials{
+		service: service,
+		config:  config,
+	}
+
+	if config.Mode == Off {
+		return c, nil
+	}
+
+	if config.Mode == Dev {
+		files, err := ensureDevCertificates(config.DevDir, service)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.config.CertFile, c.config.KeyFile, c.config.CAFile = files.cert, files.key, files.ca
+	}
+
+	if c.config.CertFile == "" || c.config.KeyFile == "" || c.config.CAFile == "" {
+		return nil, errors.New("mtls: a certificate, key and CA file are required")
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Credentials) Enabled() bool {
+	return c.config.Mode != Off
+}
+
+func (c *Credentials) files() []string {
+	return []string{c.config.CertFile, c.config.KeyFile, c.config.CAFile}
+}
+
+func (c *Credentials) load() error {
+	loaded := map[string]time.Time{}
+
+	// times are taken first so a write during the load is picked up by the next check
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return err
+		}
+
+		loaded[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+
+	if err != nil {
+		return fmt.Errorf("mtls: loading key pair: %w", err)
+	}
+
+	caPem, err := os.ReadFile(c.config.CAFile)
+
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+
+	if !roots.AppendCertsFromPEM(caPem) {
+		return fmt.Errorf("mtls: no certificates found in %s", c.config.CAFile)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert = &cert
+	c.roots = roots
+	c.loaded = loaded
+
+	return nil
+}
+
+// changed reports whether any of the files has been written since it was loaded
+func (c *Credentials) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+
+		// a file being replaced may be missing for a moment
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(c.loaded[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch reloads the certificate and CA when their files change until the context is done. A
+// failed reload keeps the previous certificate so a half written file does not break the service.
+func (c *Credentials) Watch(ctx context.Context) {
+	if !c.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+
+			if err := c.load(); err != nil {
+				log.Printf("Failed to reload tls certificates: %s\n", err)
+				continue
+			}
+
+			log.Printf("Reloaded tls certificates for %s\n", c.service)
+		}
+	}
+}
+
+func (c *Credentials) certificate() *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert
+}
+
+// verifyPeer checks the peer's certificate chains to the current CA and names one of the
+// allowed identities. Go's own verification cannot be used as the CA changes on reload.
+func (c *Credentials) verifyPeer(rawCerts [][]byte, usage x509.ExtKeyUsage, allowed []string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("mtls: peer did not send a certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+
+		if err != nil {
+			return fmt.Errorf("mtls: %w", err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	c.mu.RLock()
+	roots := c.roots
+	c.mu.RUnlock()
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+
+	if err != nil {
+		return fmt.Errorf("mtls: %w", err)
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	identities := Identities(certs[0])
+
+	for _, identity := range identities {
+		if slices.Contains(allowed, identity) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("mtls: peer %v is not one of %v", identities, allowed)
+}
+
+// ServerOption returns the credentials a grpc server should use, plain text when mtls is off
+func (c *Credentials) ServerOption() grpc.ServerOption {
+	if !c.Enabled() {
+		return grpc.Creds(insecure.NewCredentials())
+	}
+
+	return grpc.Creds(credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the chain is checked against the current CA in VerifyPeerCertificate
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return c.verifyPeer(rawCerts, x509.ExtKeyUsageClientAuth, c.config.AllowedClients)
+		},
+	}))
+}
+
+// DialOption returns the credentials for connecting to a service, the server must present a
+// certificate naming that service
+func (c *Credentials) DialOption(service string) grpc.DialOption {
+	if !c.Enabled() {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// addresses come from discovery and may not match the certificate, the server is
+		// checked against the current CA and the service name in VerifyPeerCertificate instead
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return c.verifyPeer(rawCerts, x509.ExtKeyUsageServerAuth, []string{service})
+		},
+	}))
+}
+
+// Identities returns the service names a certificate was issued to, the spiffe URI SAN first
+// followed by its DNS SANs
+func Identities(cert *x509.Certificate) []string {
+	identities := []string{}
+
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" && uri.Host == TrustDomain {
+			identities = append(identities, strings.TrimPrefix(uri.Path, "/"))
+		}
+	}
+
+	for _, name := range cert.DNSNames {
+		if !slices.Contains(identities, name) {
+			identities = append(identities, name)
+		}
+	}
+
+	return identities
+}
+
+// PeerIdentity returns the identity of the service that made a grpc call, empty when the call
+// was not made over mtls
+func PeerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+
+	if !ok {
+		return ""
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return ""
+	}
+
+	identities := Identities(tlsInfo.State.PeerCertificates[0])
+
+	if len(identities) == 0 {
+		return ""
+	}
+
+	return identities[0]
+}
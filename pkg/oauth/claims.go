@@ -0,0 +1,31 @@
+package oauth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/models/user"
+)
+
+// UserClaims returns the OIDC claims about a user that the granted scopes allow,
+// they are used in id tokens and by the userinfo endpoint
+func UserClaims(u *user.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub": u.ID,
+	}
+
+	if slices.Contains(scopes, ScopeProfile) {
+		claims["preferred_username"] = u.Username
+		claims["given_name"] = u.FirstName
+		claims["family_name"] = u.LastName
+		claims["name"] = strings.TrimSpace(u.FirstName + " " + u.LastName)
+		claims["updated_at"] = u.CreatedAt.Unix()
+	}
+
+	if slices.Contains(scopes, ScopeEmail) {
+		claims["email"] = u.Email
+		claims["email_verified"] = u.EmailVerified
+	}
+
+	return claims
+}
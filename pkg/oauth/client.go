@@ -0,0 +1,197 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// Client is a minimal OAuth 2 client for the bookstore api. It is used by integrations and
+// can drive the provider end to end in process by using HandlerTransport.
+type Client struct {
+	// base url of the api, eg http://localhost:8080
+	BaseUrl      string
+	ClientId     string
+	ClientSecret string
+	RedirectUri  string
+	// defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// HandlerTransport serves requests with an http.Handler in the same process instead of over the network
+type HandlerTransport struct {
+	Handler http.Handler
+}
+
+func (t HandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.Handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+
+	return c.HTTPClient
+}
+
+// AuthorizeParams returns the query of an authorization request for the code flow with PKCE
+func (c *Client) AuthorizeParams(state string, codeChallenge string, nonce string, scopes []string) url.Values {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientId},
+		"redirect_uri":          {c.RedirectUri},
+		"scope":                 {FormatScope(scopes)},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {CodeChallengeS256},
+	}
+
+	if nonce != "" {
+		params.Set("nonce", nonce)
+	}
+
+	return params
+}
+
+// Authorize sends an authorization request as the signed in user with their access token and
+// approves it, returning the code from the redirect. It stands in for the consent screen.
+func (c *Client) Authorize(ctx context.Context, userToken string, params url.Values) (string, error) {
+	params = cloneValues(params)
+	params.Set("approve", "true")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseUrl+"/oauth/authorize", strings.NewReader(params.Encode()))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+userToken)
+
+	var resp AuthorizeResponse
+
+	if err := c.do(req, &resp); err != nil {
+		return "", err
+	}
+
+	redirect, err := url.Parse(resp.RedirectTo)
+
+	if err != nil {
+		return "", err
+	}
+
+	query := redirect.Query()
+
+	if code := query.Get("error"); code != "" {
+		return "", &ErrorResponse{Code: code, Description: query.Get("error_description")}
+	}
+
+	if query.Get("state") != params.Get("state") {
+		return "", fmt.Errorf("state mismatch in redirect")
+	}
+
+	return query.Get("code"), nil
+}
+
+// ExchangeCode redeems an authorization code and the PKCE verifier for tokens
+func (c *Client) ExchangeCode(ctx context.Context, code string, codeVerifier string) (*TokenResponse, error) {
+	return c.token(ctx, url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectUri},
+		"code_verifier": {codeVerifier},
+	})
+}
+
+// ClientCredentials requests a token for the client itself
+func (c *Client) ClientCredentials(ctx context.Context, scopes []string) (*TokenResponse, error) {
+	return c.token(ctx, url.Values{
+		"grant_type": {GrantClientCredentials},
+		"scope":      {FormatScope(scopes)},
+	})
+}
+
+// UserInfo fetches the claims of the user an access token was issued for
+func (c *Client) UserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseUrl+"/oauth/userinfo", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	claims := map[string]any{}
+
+	if err := c.do(req, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (c *Client) token(ctx context.Context, form url.Values) (*TokenResponse, error) {
+	if c.ClientSecret == "" {
+		// public clients identify themselves in the body
+		form.Set("client_id", c.ClientId)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseUrl+"/oauth/token", strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientId), url.QueryEscape(c.ClientSecret))
+	}
+
+	var token TokenResponse
+
+	if err := c.do(req, &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.httpClient().Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var oauthErr ErrorResponse
+
+		if err := json.NewDecoder(resp.Body).Decode(&oauthErr); err != nil || oauthErr.Code == "" {
+			return fmt.Errorf("%s %s: unexpected status %d", req.Method, req.URL.Path, resp.StatusCode)
+		}
+
+		return &oauthErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+
+	return clone
+}
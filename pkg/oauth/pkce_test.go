@@ -0,0 +1,76 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeChallengeMatchesRfc7636(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := CodeChallenge(verifier); got != challenge {
+		t.Fatalf("challenge is %q, expected %q", got, challenge)
+	}
+
+	if !VerifyCodeChallenge(challenge, verifier) {
+		t.Fatal("the verifier was rejected")
+	}
+}
+
+func TestNewCodeVerifier(t *testing.T) {
+	first, err := NewCodeVerifier()
+
+	if err != nil {
+		t.Fatalf("NewCodeVerifier: %s", err)
+	}
+
+	second, err := NewCodeVerifier()
+
+	if err != nil {
+		t.Fatalf("NewCodeVerifier: %s", err)
+	}
+
+	if len(first) != 43 {
+		t.Fatalf("verifier has %d characters, expected 43", len(first))
+	}
+	if first == second {
+		t.Fatal("two verifiers were the same")
+	}
+	if !VerifyCodeChallenge(CodeChallenge(first), first) {
+		t.Fatal("the verifier was rejected")
+	}
+}
+
+func TestVerifyCodeChallengeRejects(t *testing.T) {
+	verifier, err := NewCodeVerifier()
+
+	if err != nil {
+		t.Fatalf("NewCodeVerifier: %s", err)
+	}
+
+	challenge := CodeChallenge(verifier)
+	short := verifier[:42]
+	long := strings.Repeat("a", 129)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+	}{
+		{name: "other verifier", challenge: challenge, verifier: strings.Repeat("a", 43)},
+		{name: "plain method", challenge: verifier, verifier: verifier},
+		{name: "too short", challenge: CodeChallenge(short), verifier: short},
+		{name: "too long", challenge: CodeChallenge(long), verifier: long},
+		{name: "empty", challenge: challenge, verifier: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if VerifyCodeChallenge(test.challenge, test.verifier) {
+				t.Fatal("the verifier was accepted")
+			}
+		})
+	}
+}
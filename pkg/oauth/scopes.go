@@ -0,0 +1,87 @@
+package oauth
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	// OIDC scopes
+	ScopeOpenId  string = "openid"
+	ScopeProfile string = "profile"
+	ScopeEmail   string = "email"
+
+	// api scopes, each maps to a set of routes in the api service
+	ScopeBooksRead    string = "books:read"
+	ScopeBooksWrite   string = "books:write"
+	ScopeAuthorsRead  string = "authors:read"
+	ScopeAuthorsWrite string = "authors:write"
+	ScopeUserRead     string = "user:read"
+	ScopeUserWrite    string = "user:write"
+)
+
+// SupportedScopes are the scopes a client may register for and request
+var SupportedScopes = []string{
+	ScopeOpenId,
+	ScopeProfile,
+	ScopeEmail,
+	ScopeBooksRead,
+	ScopeBooksWrite,
+	ScopeAuthorsRead,
+	ScopeAuthorsWrite,
+	ScopeUserRead,
+	ScopeUserWrite,
+}
+
+const (
+	GrantAuthorizationCode string = "authorization_code"
+	GrantClientCredentials string = "client_credentials"
+)
+
+var SupportedGrantTypes = []string{GrantAuthorizationCode, GrantClientCredentials}
+
+// error codes from RFC 6749 section 4.1.2.1 and 5.2
+const (
+	ErrInvalidRequest       string = "invalid_request"
+	ErrInvalidClient        string = "invalid_client"
+	ErrInvalidGrant         string = "invalid_grant"
+	ErrInvalidScope         string = "invalid_scope"
+	ErrUnauthorizedClient   string = "unauthorized_client"
+	ErrUnsupportedGrantType string = "unsupported_grant_type"
+	ErrUnsupportedResponse  string = "unsupported_response_type"
+	ErrAccessDenied         string = "access_denied"
+)
+
+// ParseScope splits a space separated scope parameter, dropping duplicates
+func ParseScope(scope string) []string {
+	scopes := []string{}
+
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// FormatScope joins scopes into a scope parameter
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// HasScope reports whether a space separated scope parameter contains a scope
+func HasScope(scope string, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// ContainsAll reports whether every scope in want is in have
+func ContainsAll(have []string, want []string) bool {
+	for _, s := range want {
+		if !slices.Contains(have, s) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package oauth
+
+// TokenResponse is returned by the token endpoint, RFC 6749 section 5.1
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IdToken     string `json:"id_token,omitempty"`
+}
+
+// ErrorResponse is an OAuth error, RFC 6749 section 5.2
+type ErrorResponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// AuthorizeResponse is returned to the signed in user's app by the authorize endpoint.
+// The app shows a consent screen when consent is required, otherwise it sends the user to RedirectTo.
+type AuthorizeResponse struct {
+	ConsentRequired bool     `json:"consentRequired"`
+	ClientId        string   `json:"clientId"`
+	ClientName      string   `json:"clientName"`
+	Scopes          []string `json:"scopes"`
+	// the client redirect uri with either a code or an error, set once the request is decided
+	RedirectTo string `json:"redirectTo,omitempty"`
+}
+
+// DiscoveryDocument is the OpenID provider metadata served at /.well-known/openid-configuration
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	RegistrationEndpoint              string   `json:"registration_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Jwk is a public RSA signing key, RFC 7517
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
@@ -0,0 +1,116 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/discovery"
+	"github.com/will-kerwin/go-microservice-bookstore/pkg/identity"
+)
+
+// Define a kafka Producer
+type Producer[T any] struct {
+	brokers *discovery.Endpoint
+	topic   string
+	mu      sync.Mutex
+	// replaced when the brokers change
+	producer *kafka.Producer
+	// generation of the brokers the producer was created for
+	generation uint64
+}
+
+// create a new producer for the given topic
+func New[T any](brokers *discovery.Endpoint, topic string) (*Producer[T], error) {
+	addrs, generation := brokers.Current()
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": addrs})
+
+	if err != nil {
+		return nil, err
+	}
+	return &Producer[T]{brokers: brokers, topic: topic, producer: producer, generation: generation}, nil
+}
+
+// current returns the producer, replacing it first when the brokers have changed since it was
+// created. The old one is flushed and closed in the background.
+func (p *Producer[T]) current() (*kafka.Producer, error) {
+	addrs, generation := p.brokers.Current()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if generation == p.generation {
+		return p.producer, nil
+	}
+
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": addrs})
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reconnecting the producer for %s to %s\n", p.topic, addrs)
+
+	old := p.producer
+	p.producer, p.generation = producer, generation
+
+	go func() {
+		old.Flush(int((5 * time.Second).Milliseconds()))
+		old.Close()
+	}()
+
+	return producer, nil
+}
+
+// Produce encodes the event and publishes it to the topic, waiting for delivery. The identity
+// assertion in the context, if any, is passed on in the message headers.
+func (p *Producer[T]) Produce(ctx context.Context, event T) error {
+	encodedEvent, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	producer, err := p.current()
+
+	if err != nil {
+		return err
+	}
+
+	deliveryChan := make(chan kafka.Event, 1)
+
+	err = producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Value:          encodedEvent,
+		// events produced while handling a user's request carry who it was for
+		Headers: identity.KafkaHeaders(ctx),
+	}, deliveryChan)
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case e := <-deliveryChan:
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			return m.TopicPartition.Error
+		}
+		return nil
+	case <-time.After(5 * time.Second):
+		return kafka.NewError(kafka.ErrTimedOut, "timed out waiting for delivery", false)
+	}
+}
+
+// Close flushes any outstanding messages and closes the producer
+func (p *Producer[T]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.producer.Flush(int((1 * time.Second).Milliseconds()))
+	p.producer.Close()
+}
@@ -0,0 +1,54 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const Placeholder string = "[REDACTED]"
+
+// Secret holds a sensitive value such as a password. It can be decoded from a
+// request body as normal but never prints or encodes its value, so it cannot
+// leak into logs or events by accident. Use Reveal to read the value.
+type Secret string
+
+// Reveal returns the underlying value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	return Placeholder
+}
+
+func (s Secret) GoString() string {
+	return Placeholder
+}
+
+func (s Secret) Format(f fmt.State, _ rune) {
+	f.Write([]byte(Placeholder))
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Placeholder)
+}
+
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*s = Secret(value)
+	return nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(Placeholder), nil
+}
+
+func (s *Secret) UnmarshalText(data []byte) error {
+	*s = Secret(data)
+	return nil
+}